@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"file-storage/internal/config"
+	"file-storage/internal/storage/filesystemstorage"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runCommand runs a maintenance command given as the first positional
+// argument instead of the server.
+func runCommand(command string, args []string, cfg *config.Config, log *slog.Logger) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	switch command {
+	case "backup", "restore":
+		if cfg.App.Storage != config.StorageFileSystem {
+			return fmt.Errorf("%s requires file system storage, got %s", command, cfg.App.Storage)
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s <archive path>", command)
+		}
+		if command == "backup" {
+			return runBackup(ctx, cfg, args[0], log)
+		}
+		return runRestore(ctx, cfg, args[0], log)
+	case "resync":
+		if len(args) != 0 {
+			return fmt.Errorf("usage: %s", command)
+		}
+		return runResync(ctx, cfg, log)
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+// runBackup writes a backup of the file system storage to archivePath. The
+// service may keep running meanwhile. The archive appears at archivePath only
+// when it is complete.
+func runBackup(ctx context.Context, cfg *config.Config, archivePath string, log *slog.Logger) error {
+	tempPath := archivePath + ".tmp"
+	f, err := os.OpenFile(tempPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("create archive error: %w", err)
+	}
+
+	count, err := filesystemstorage.Backup(ctx, cfg.Storage.FileSystem.Path, f)
+	if err == nil {
+		err = f.Sync()
+	}
+	err = errors.Join(err, f.Close())
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	err = os.Rename(tempPath, archivePath)
+	if err != nil {
+		return fmt.Errorf("rename archive error: %w", err)
+	}
+
+	log.Info("backup completed", "files", count, "archive", archivePath)
+
+	return nil
+}
+
+// runRestore restores files from the archive into the file system storage.
+// The service must be stopped.
+func runRestore(ctx context.Context, cfg *config.Config, archivePath string, log *slog.Logger) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("open archive error: %w", err)
+	}
+	defer f.Close()
+
+	count, err := filesystemstorage.RestoreBackup(ctx, &cfg.Storage.FileSystem, f)
+	if err != nil {
+		return err
+	}
+
+	log.Info("restore completed", "files", count, "archive", archivePath)
+
+	return nil
+}
@@ -16,5 +16,12 @@ func logConfig(log *slog.Logger, cfg *config.Config) {
 	cfgCopy := *cfg
 	cfgCopy.App.Security.ReadToken = "***"
 	cfgCopy.App.Security.WriteToken = "***"
+	if cfgCopy.Storage.S3.SecretKey != "" {
+		cfgCopy.Storage.S3.SecretKey = "***"
+	}
+	// the connection string may contain a password
+	if cfgCopy.Storage.Postgres.DSN != "" {
+		cfgCopy.Storage.Postgres.DSN = "***"
+	}
 	log.Info("config", "config", cfgCopy)
 }
@@ -2,14 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
 	"file-storage/internal/config"
+	"file-storage/internal/contextkeys"
+	"file-storage/internal/errs"
 	"file-storage/internal/files"
 	"file-storage/internal/logger"
 	"file-storage/internal/server"
 	"file-storage/internal/storage/filesystemstorage"
-	"file-storage/internal/storage/inmemory"
 	"file-storage/internal/storage/metricsstorage"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
@@ -23,8 +27,10 @@ var version = "dev"
 func main() {
 
 	var showVersion bool
+	var rebuildIndex bool
 	configPathFlag := pflag.String("config", "", "config file path")
 	pflag.BoolVar(&showVersion, "version", false, "print version and exit")
+	pflag.BoolVar(&rebuildIndex, "rebuild-index", false, "rebuild the file system storage metadata index and exit")
 	pflag.Int("port", 0, "application port")
 	pflag.String("host", "", "application host")
 	pflag.String("max-header-bytes", "", "maximum size of HTTP request headers in bytes")
@@ -32,6 +38,9 @@ func main() {
 	pflag.String("log-type", "json", "log type")
 	pflag.String("read-token", "", "read token")
 	pflag.String("write-token", "", "write token")
+	pflag.String("signing-key", "", "key of signed urls")
+	pflag.Duration("presign-max-age", 0, "maximum validity of presigned urls")
+	pflag.StringSlice("trusted-proxies", nil, "addresses or networks of proxies trusted to set X-Forwarded-For")
 	pflag.Duration("handler-timeout", 0, "request timeout")
 	pflag.Duration("read-header-timeout", 0, "maximum time to read HTTP request headers")
 	pflag.Duration("write-timeout", 0, "maximum time to write HTTP response to client")
@@ -42,11 +51,62 @@ func main() {
 	pflag.Int("concurrency-limit", 0, "how many in flight requests are allowed")
 	pflag.String("image-ext", "", "stored image format")
 	pflag.Int("image-max-dimension", 0, "max stored image dimension")
+	pflag.Bool("image-rendition-cache", false, "cache transformed images")
+	pflag.Int("image-jpeg-quality", 0, "default jpeg quality from 1 to 100")
+	pflag.Int("image-webp-quality", 0, "default webp quality from 1 to 100")
+	pflag.String("image-png-compression", "", "default png compression: default, none, speed or best")
+	pflag.Bool("image-keep-original", false, "keep original uploads next to stored images")
+	pflag.String("image-presets", "", "image presets as semicolon separated name=spec pairs")
+	pflag.StringSlice("image-eager-presets", nil, "presets rendered on upload")
+	pflag.Bool("image-presets-only", false, "allow only presets as image transformations")
+	pflag.Bool("image-signed-transforms", false, "require signed urls for transformations without read access")
+	pflag.StringSlice("image-unsigned-presets", nil, "presets allowed without signed urls")
+	pflag.Bool("image-exif-strip", false, "strip exif metadata from uploaded images")
+	pflag.StringSlice("image-exif-keep-tags", nil, "exif tags kept when metadata is stripped")
+	pflag.Bool("image-exif-record-metadata", false, "record camera and capture time into file metadata")
+	pflag.Int("image-max-pixels", 0, "maximum number of pixels of processed images")
+	pflag.Int("image-max-frames", 0, "maximum number of frames of processed images")
+	pflag.Int("image-max-decode-memory", 0, "maximum memory in bytes to decode an image")
+	pflag.Int("image-workers", 0, "how many images are processed at the same time")
+	pflag.Int("image-queue-size", 0, "how many images may wait for a worker")
 	pflag.String("storage", "", "storage")
 	pflag.String("fs-storage-path", "", "file system storage path")
 	pflag.Bool("fs-gc-enabled", false, "file system garbage collector enabled")
 	pflag.Int("fs-gc-workers-count", 0, "file system garbage collector workers count")
 	pflag.Duration("fs-gc-interval", 0, "file system garbage collector interval")
+	pflag.Bool("fs-scrub-enabled", false, "file system integrity scrubber enabled")
+	pflag.Duration("fs-scrub-interval", 0, "interval between file system integrity scrubber passes")
+	pflag.Int("fs-scrub-workers-count", 0, "file system integrity scrubber workers count")
+	pflag.Bool("fs-scrub-quarantine", false, "move corrupted files to quarantine")
+	pflag.Int("fs-versions-keep", 0, "number of previous file versions retained")
+	pflag.Duration("fs-versions-max-age", 0, "how long previous file versions are retained")
+	pflag.Bool("fs-trash-enabled", false, "move deleted files to the trash")
+	pflag.Duration("fs-trash-purge-after", 0, "how long deleted files are kept in the trash")
+	pflag.Bool("fs-dedup", false, "store identical file content once")
+	pflag.String("s3-endpoint", "", "s3 storage endpoint url")
+	pflag.String("s3-region", "", "s3 storage region")
+	pflag.String("s3-bucket", "", "s3 storage bucket")
+	pflag.String("s3-prefix", "", "s3 storage object key prefix")
+	pflag.String("s3-access-key", "", "s3 storage access key")
+	pflag.String("s3-secret-key", "", "s3 storage secret key")
+	pflag.Bool("s3-use-path-style", false, "address s3 buckets by path instead of host name")
+	pflag.Duration("s3-sweep-interval", 0, "s3 storage unreferenced content sweep interval")
+	pflag.Duration("s3-grace-period", 0, "s3 storage age of unreferenced content before removal")
+	pflag.String("pg-dsn", "", "postgres storage connection string")
+	pflag.Int("pg-max-conns", 0, "postgres storage maximum open connections")
+	pflag.Duration("pg-sweep-interval", 0, "postgres storage replaced content sweep interval")
+	pflag.Duration("pg-grace-period", 0, "postgres storage age of replaced content before removal")
+	pflag.String("tier-hot", "", "tiered storage hot tier: inmemory or filesystem")
+	pflag.String("tier-hot-path", "", "tiered storage hot tier filesystem path")
+	pflag.String("tier-cold", "", "tiered storage cold tier: filesystem, s3 or postgres")
+	pflag.Int("tier-max-bytes", 0, "tiered storage hot tier size limit in bytes")
+	pflag.String("tier-mode", "", "tiered storage write mode: write-through or write-back")
+	pflag.Duration("tier-flush-interval", 0, "tiered storage write-back flush interval")
+	pflag.Bool("replication-enabled", false, "enable replication of writes to replicas")
+	pflag.String("replication-replicas", "", "comma-separated replicas as storage or storage:path, e.g. filesystem:/mnt/disk2/files,s3")
+	pflag.String("replication-mode", "", "replication mode: sync or async")
+	pflag.String("replication-queue-path", "", "replication retry queue journal path")
+	pflag.Duration("replication-retry-interval", 0, "replication retry interval")
 	pflag.Parse()
 
 	bootstrapLogger := logger.NewBootstrap().With("service", "file-storage")
@@ -64,6 +124,29 @@ func main() {
 
 	log := logger.New(&cfg.Log).With("service", "file-storage")
 
+	if command := pflag.Arg(0); command != "" {
+		err = runCommand(command, pflag.Args()[1:], cfg, log)
+		if err != nil {
+			log.Error("command failed", "command", command, "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if rebuildIndex {
+		if cfg.App.Storage != config.StorageFileSystem {
+			log.Error("index rebuild requires file system storage", "storage", cfg.App.Storage)
+			os.Exit(1)
+		}
+		count, err := filesystemstorage.RebuildIndex(cfg.Storage.FileSystem.Path)
+		if err != nil {
+			log.Error("index rebuild failed", "error", err)
+			os.Exit(1)
+		}
+		log.Info("index rebuilt", "files", count)
+		return
+	}
+
 	log.Info("starting file-storage", "version", version)
 	logConfig(log, cfg)
 
@@ -71,22 +154,9 @@ func main() {
 	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	var storage files.Storage
-
-	switch cfg.App.Storage {
-	case config.StorageInmemory:
-		storage = inmemory.New()
-	case config.StorageFileSystem:
-		fss, err := filesystemstorage.New(&cfg.Storage.FileSystem, log)
-		if err != nil {
-			log.Error("filesystem storage init failed", "error", err)
-			os.Exit(1)
-		}
-		fss.StartGC(ctx)
-		storage = fss
-
-	default:
-		log.Error("unknown storage type", "storage", cfg.App.Storage)
+	storage, err := newStorage(ctx, cfg, log)
+	if err != nil {
+		log.Error("storage init failed", "storage", cfg.App.Storage, "error", err)
 		os.Exit(1)
 	}
 
@@ -94,6 +164,10 @@ func main() {
 	svc := files.NewService(&cfg.Image, metricStorage)
 	srv := server.NewServer(&cfg.App, svc, log)
 
+	if cfg.Image.KeepOriginal {
+		go renormalizeImages(ctx, svc, log)
+	}
+
 	errCh := make(chan error, 1)
 	go func() {
 		errCh <- srv.Run(ctx, cfg.App.Security)
@@ -105,6 +179,33 @@ func main() {
 	case err := <-errCh:
 		shutdown(srv, err)
 	}
+
+	if closer, ok := storage.(io.Closer); ok {
+		err := closer.Close()
+		if err != nil {
+			log.Error("storage close error", logger.LogFieldError, err)
+		}
+	}
+}
+
+// renormalizeImages regenerates stored images from their original uploads
+// when they were stored with other image settings.
+func renormalizeImages(ctx context.Context, svc *files.Service, log *slog.Logger) {
+	log = logger.WithComponent(log, logger.ComponentImages)
+	ctx = context.WithValue(ctx, contextkeys.ContextKeyLogger, log)
+
+	count, err := svc.RenormalizeAll(ctx)
+	if errors.Is(err, errs.ErrOriginalsNotSupported) {
+		log.Warn("original uploads are not kept, storage does not support them")
+		return
+	}
+	if err != nil && ctx.Err() == nil {
+		log.Error("images regeneration error", slog.Any(logger.LogFieldError, err))
+		return
+	}
+	if count > 0 {
+		log.Info("images regenerated from originals", "files", count)
+	}
 }
 
 func shutdown(srv *server.Server, runErr error) {
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"file-storage/internal/config"
+	"file-storage/internal/storage/replicatedstorage"
+	"log/slog"
+)
+
+// runResync copies files the primary storage is missing from the replicas.
+// The service must be stopped.
+func runResync(ctx context.Context, cfg *config.Config, log *slog.Logger) error {
+	if !cfg.Storage.Replication.Enabled {
+		return errors.New("resync requires replication")
+	}
+
+	storage, err := newStorage(ctx, cfg, log)
+	if err != nil {
+		return err
+	}
+	rs, ok := storage.(*replicatedstorage.ReplicatedStorage)
+	if !ok {
+		return errors.New("resync requires replicated storage")
+	}
+	defer rs.Close()
+
+	count, err := rs.Resync(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Info("resync completed", "files", count)
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"file-storage/internal/config"
+	"file-storage/internal/files"
+	"file-storage/internal/storage/filesystemstorage"
+	"file-storage/internal/storage/inmemory"
+	"file-storage/internal/storage/postgresstorage"
+	"file-storage/internal/storage/replicatedstorage"
+	"file-storage/internal/storage/s3storage"
+	"file-storage/internal/storage/tieredstorage"
+	"fmt"
+	"log/slog"
+)
+
+// newStorage creates the storage selected by app.storage, wraps it with
+// replication when enabled and starts its background work.
+func newStorage(ctx context.Context, cfg *config.Config, log *slog.Logger) (files.Storage, error) {
+	primary, err := newPrimary(ctx, cfg, log)
+	if err != nil || !cfg.Storage.Replication.Enabled {
+		return primary, err
+	}
+
+	replicas := make([]replicatedstorage.Replica, 0, len(cfg.Storage.Replication.Replicas))
+	for _, r := range cfg.Storage.Replication.Replicas {
+		name := r.Storage
+		replicaCfg := cfg
+		if r.Storage == config.StorageFileSystem {
+			name += ":" + r.Path
+			// replicas keep only current versions of files, without the
+			// trash, deduplication and the scrubber of the primary storage
+			c := *cfg
+			c.Storage.FileSystem = config.FileSystem{Path: r.Path, GarbageCollector: cfg.Storage.FileSystem.GarbageCollector}
+			replicaCfg = &c
+		}
+
+		s, err := newBackend(ctx, r.Storage, replicaCfg, log.With("replica", name))
+		if err != nil {
+			return nil, fmt.Errorf("replica %s init error: %w", name, err)
+		}
+		replicas = append(replicas, replicatedstorage.Replica{Name: name, Storage: s})
+	}
+
+	rs, err := replicatedstorage.New(primary, replicas, &cfg.Storage.Replication, log)
+	if err != nil {
+		return nil, err
+	}
+	rs.Start(ctx)
+
+	return rs, nil
+}
+
+// newPrimary creates the storage selected by app.storage.
+func newPrimary(ctx context.Context, cfg *config.Config, log *slog.Logger) (files.Storage, error) {
+	if cfg.App.Storage != config.StorageTiered {
+		return newBackend(ctx, cfg.App.Storage, cfg, log)
+	}
+
+	tiered := cfg.Storage.Tiered
+
+	var hot files.Storage
+	if tiered.Hot == config.StorageFileSystem {
+		// the hot tier keeps only current versions of files
+		hotCfg := config.FileSystem{Path: tiered.HotPath, GarbageCollector: cfg.Storage.FileSystem.GarbageCollector}
+		fss, err := filesystemstorage.New(&hotCfg, log.With("tier", "hot"))
+		if err != nil {
+			return nil, fmt.Errorf("hot storage init error: %w", err)
+		}
+		fss.StartGC(ctx)
+		hot = fss
+	} else {
+		hot = inmemory.New()
+	}
+
+	cold, err := newBackend(ctx, tiered.Cold, cfg, log)
+	if err != nil {
+		return nil, fmt.Errorf("cold storage init error: %w", err)
+	}
+
+	ts, err := tieredstorage.New(ctx, hot, cold, &tiered, log)
+	if err != nil {
+		return nil, err
+	}
+	ts.StartFlusher(ctx)
+
+	return ts, nil
+}
+
+func newBackend(ctx context.Context, backend string, cfg *config.Config, log *slog.Logger) (files.Storage, error) {
+	switch backend {
+	case config.StorageInmemory:
+		return inmemory.New(), nil
+	case config.StorageFileSystem:
+		fss, err := filesystemstorage.New(&cfg.Storage.FileSystem, log)
+		if err != nil {
+			return nil, err
+		}
+		fss.StartGC(ctx)
+		fss.StartScrubber(ctx)
+		return fss, nil
+	case config.StorageS3:
+		s3s, err := s3storage.New(ctx, &cfg.Storage.S3, log)
+		if err != nil {
+			return nil, err
+		}
+		s3s.StartSweeper(ctx)
+		return s3s, nil
+	case config.StoragePostgres:
+		pgs, err := postgresstorage.New(ctx, &cfg.Storage.Postgres, log)
+		if err != nil {
+			return nil, err
+		}
+		pgs.StartSweeper(ctx)
+		return pgs, nil
+	default:
+		return nil, fmt.Errorf("unknown storage type %q", backend)
+	}
+}
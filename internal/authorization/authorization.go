@@ -7,7 +7,14 @@ package authorization
 // The structure is populated by authorization middleware and stored in context.
 // It does not contain user identity, subject, claims or token metadata.
 // It does not perform authentication or permission checks itself.
+//
+// Signed is set when the request URL carries a valid signature. Presigned is
+// set when the signature also limits the validity of the URL with an
+// expiration time; only such URLs grant read access to private content, and
+// only to the content the URL names.
 type Auth struct {
-	Read  bool
-	Write bool
+	Read      bool
+	Write     bool
+	Signed    bool
+	Presigned bool
 }
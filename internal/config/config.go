@@ -4,7 +4,9 @@ import (
 	"file-storage/internal/errs"
 	"file-storage/internal/imgproc"
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -28,6 +30,19 @@ const (
 const (
 	StorageFileSystem = "filesystem"
 	StorageInmemory   = "inmemory"
+	StorageS3         = "s3"
+	StoragePostgres   = "postgres"
+	StorageTiered     = "tiered"
+)
+
+const (
+	TierModeWriteThrough = "write-through"
+	TierModeWriteBack    = "write-back"
+)
+
+const (
+	ReplicationModeSync  = "sync"
+	ReplicationModeAsync = "async"
 )
 
 // App groups top-level application settings used to build and run the service.
@@ -68,9 +83,16 @@ type Log struct {
 }
 
 // Security defines static read and write tokens accepted by the service.
+// SigningKey signs and verifies signed URLs; they are not accepted when it is empty.
+// PresignMaxAge limits the validity of presigned content URLs.
+// TrustedProxies lists addresses or CIDR networks of reverse proxies whose
+// X-Forwarded-For header identifies the client of signed URLs bound to an IP.
 type Security struct {
-	ReadToken  string `json:"read_token" yaml:"read_token"`
-	WriteToken string `json:"write_token" yaml:"write_token"`
+	ReadToken      string        `json:"read_token" yaml:"read_token"`
+	WriteToken     string        `json:"write_token" yaml:"write_token"`
+	SigningKey     string        `json:"signing_key" yaml:"signing_key"`
+	PresignMaxAge  time.Duration `json:"presign_max_age" yaml:"presign_max_age"`
+	TrustedProxies []string      `json:"trusted_proxies" yaml:"trusted_proxies"`
 }
 
 // RateLimiter defines token bucket settings used to throttle incoming requests.
@@ -80,9 +102,50 @@ type RateLimiter struct {
 }
 
 // Image defines how uploaded images are resized and which format they are stored in.
+// RenditionCache enables caching of transformed images in storages that support it.
+// JPEGQuality, WebPQuality and PNGCompression are default encoder settings; zero values mean encoder defaults.
+// KeepOriginal keeps original uploads next to stored images in storages that support it.
+// Presets maps names of content transformation presets to their specs, EagerPresets are rendered
+// into the rendition cache on upload and PresetsOnly rejects transformations other than presets.
+// SignedTransforms requires signed URLs for transformations without the read permission,
+// except for UnsignedPresets.
 type Image struct {
-	Ext          string `json:"ext" yaml:"ext"`
-	MaxDimension int    `json:"max_dimension" yaml:"max_dimension"`
+	Ext              string            `json:"ext" yaml:"ext"`
+	MaxDimension     int               `json:"max_dimension" yaml:"max_dimension"`
+	RenditionCache   bool              `json:"rendition_cache" yaml:"rendition_cache"`
+	JPEGQuality      int               `json:"jpeg_quality" yaml:"jpeg_quality"`
+	WebPQuality      int               `json:"webp_quality" yaml:"webp_quality"`
+	PNGCompression   string            `json:"png_compression" yaml:"png_compression"`
+	KeepOriginal     bool              `json:"keep_original" yaml:"keep_original"`
+	Presets          map[string]string `json:"presets" yaml:"presets"`
+	EagerPresets     []string          `json:"eager_presets" yaml:"eager_presets"`
+	PresetsOnly      bool              `json:"presets_only" yaml:"presets_only"`
+	SignedTransforms bool              `json:"signed_transforms" yaml:"signed_transforms"`
+	UnsignedPresets  []string          `json:"unsigned_presets" yaml:"unsigned_presets"`
+	Exif             Exif              `json:"exif" yaml:"exif"`
+	Processing       ImageProcessing   `json:"processing" yaml:"processing"`
+}
+
+// ImageProcessing bounds the resources taken by image processing. Images with
+// more than MaxPixels pixels or MaxFrames frames or needing more than
+// MaxDecodeMemory bytes to decode are rejected before decoding. Workers
+// images are processed at the same time and up to QueueSize more wait for a
+// worker. Zero values disable a limit.
+type ImageProcessing struct {
+	MaxPixels       int `json:"max_pixels" yaml:"max_pixels"`
+	MaxFrames       int `json:"max_frames" yaml:"max_frames"`
+	MaxDecodeMemory int `json:"max_decode_memory" yaml:"max_decode_memory"`
+	Workers         int `json:"workers" yaml:"workers"`
+	QueueSize       int `json:"queue_size" yaml:"queue_size"`
+}
+
+// Exif defines handling of EXIF metadata of uploaded images.
+// Strip removes embedded metadata except KeepTags from stored images.
+// RecordMetadata records camera and capture time into file metadata.
+type Exif struct {
+	Strip          bool     `json:"strip" yaml:"strip"`
+	KeepTags       []string `json:"keep_tags" yaml:"keep_tags"`
+	RecordMetadata bool     `json:"record_metadata" yaml:"record_metadata"`
 }
 
 // GarbageCollector defines cleanup settings for obsolete and incomplete
@@ -93,15 +156,118 @@ type GarbageCollector struct {
 	WorkersCount int           `json:"workers_count" yaml:"workers_count"`
 }
 
+// Versions defines retention of previous file versions. A version is kept
+// while it is among the Keep most recently replaced ones and was replaced less
+// than MaxAge ago. A zero value disables the limit, and versions are not
+// retained at all when both are zero.
+type Versions struct {
+	Keep   int           `json:"keep" yaml:"keep"`
+	MaxAge time.Duration `json:"max_age" yaml:"max_age"`
+}
+
+// Enabled reports whether previous versions are retained.
+func (v Versions) Enabled() bool {
+	return v.Keep > 0 || v.MaxAge > 0
+}
+
+// Trash defines soft deletion. When enabled, deleted files are moved to the
+// trash and the garbage collector purges them PurgeAfter after deletion.
+// A zero PurgeAfter keeps deleted files until they are restored.
+type Trash struct {
+	Enabled    bool          `json:"enabled" yaml:"enabled"`
+	PurgeAfter time.Duration `json:"purge_after" yaml:"purge_after"`
+}
+
+// Scrubber defines periodic verification of stored content against its hash.
+// A full pass over all files starts Interval after the previous one completed.
+// Corrupted files are moved to quarantine when Quarantine is set and are only
+// reported otherwise.
+type Scrubber struct {
+	Enabled      bool          `json:"enabled" yaml:"enabled"`
+	Interval     time.Duration `json:"interval" yaml:"interval"`
+	WorkersCount int           `json:"workers_count" yaml:"workers_count"`
+	Quarantine   bool          `json:"quarantine" yaml:"quarantine"`
+}
+
 // FileSystem defines filesystem storage settings, including garbage collector configuration.
+// When Dedup is set, identical content of different files is stored once.
 type FileSystem struct {
 	Path             string           `json:"path" yaml:"path"`
 	GarbageCollector GarbageCollector `json:"garbage_collector" yaml:"garbage_collector"`
+	Scrubber         Scrubber         `json:"scrubber" yaml:"scrubber"`
+	Versions         Versions         `json:"versions" yaml:"versions"`
+	Trash            Trash            `json:"trash" yaml:"trash"`
+	Dedup            bool             `json:"dedup" yaml:"dedup"`
+}
+
+// S3 defines S3-compatible object storage settings. An empty Endpoint means
+// the AWS endpoint of the Region. Anonymous access is used when no keys are set.
+// Every SweepInterval content objects that are no longer referenced and are
+// older than GracePeriod are removed; a zero SweepInterval disables the sweep.
+type S3 struct {
+	Endpoint      string        `json:"endpoint" yaml:"endpoint"`
+	Region        string        `json:"region" yaml:"region"`
+	Bucket        string        `json:"bucket" yaml:"bucket"`
+	Prefix        string        `json:"prefix" yaml:"prefix"`
+	AccessKey     string        `json:"access_key" yaml:"access_key"`
+	SecretKey     string        `json:"secret_key" yaml:"secret_key"`
+	UsePathStyle  bool          `json:"use_path_style" yaml:"use_path_style"`
+	SweepInterval time.Duration `json:"sweep_interval" yaml:"sweep_interval"`
+	GracePeriod   time.Duration `json:"grace_period" yaml:"grace_period"`
+}
+
+// Postgres defines PostgreSQL storage settings. DSN is a connection string in
+// URL or key=value form. Every SweepInterval content that was replaced or
+// deleted more than GracePeriod ago is removed; a zero SweepInterval disables
+// the sweep.
+type Postgres struct {
+	DSN           string        `json:"dsn" yaml:"dsn"`
+	MaxConns      int           `json:"max_conns" yaml:"max_conns"`
+	SweepInterval time.Duration `json:"sweep_interval" yaml:"sweep_interval"`
+	GracePeriod   time.Duration `json:"grace_period" yaml:"grace_period"`
+}
+
+// Tiered defines the tiered storage that keeps recently read files in a hot
+// storage bounded by MaxBytes in front of a cold storage. Hot is inmemory or
+// filesystem with its own HotPath; Cold is any other storage backend
+// configured in its own section. In write-back mode written files reach the
+// cold storage every FlushInterval or when they are evicted from the hot one.
+type Tiered struct {
+	Hot           string        `json:"hot" yaml:"hot"`
+	HotPath       string        `json:"hot_path" yaml:"hot_path"`
+	Cold          string        `json:"cold" yaml:"cold"`
+	MaxBytes      int           `json:"max_bytes" yaml:"max_bytes"`
+	Mode          string        `json:"mode" yaml:"mode"`
+	FlushInterval time.Duration `json:"flush_interval" yaml:"flush_interval"`
+}
+
+// Replica defines a secondary storage receiving copies of written files.
+// Path is the storage path of a filesystem replica; s3 and postgres replicas
+// use their own configuration sections.
+type Replica struct {
+	Storage string `json:"storage" yaml:"storage"`
+	Path    string `json:"path" yaml:"path"`
+}
+
+// Replication defines copying of writes to replicas. In sync mode a write
+// returns after it reaches the replicas, in async mode after it is queued.
+// Failed and queued copies are kept in the journal at QueuePath and retried
+// every RetryInterval.
+type Replication struct {
+	Enabled       bool          `json:"enabled" yaml:"enabled"`
+	Replicas      []Replica     `json:"replicas" yaml:"replicas"`
+	Mode          string        `json:"mode" yaml:"mode"`
+	QueuePath     string        `json:"queue_path" yaml:"queue_path"`
+	RetryInterval time.Duration `json:"retry_interval" yaml:"retry_interval"`
 }
 
 // Storage groups configuration for supported storage backends.
 type Storage struct {
-	FileSystem FileSystem `json:"filesystem" yaml:"filesystem"`
+	FileSystem  FileSystem  `json:"filesystem" yaml:"filesystem"`
+	S3          S3          `json:"s3" yaml:"s3"`
+	Postgres    Postgres    `json:"postgres" yaml:"postgres"`
+	Tiered      Tiered      `json:"tiered" yaml:"tiered"`
+	Replication Replication `json:"replication" yaml:"replication"`
 }
 
 // Config is the root application configuration assembled from file, environment and flags.
@@ -168,13 +334,34 @@ func defaults() Config {
 				},
 			},
 			Security: Security{
-				ReadToken:  "default token",
-				WriteToken: "default token",
+				ReadToken:      "default token",
+				WriteToken:     "default token",
+				PresignMaxAge:  24 * time.Hour,
+				TrustedProxies: []string{},
 			},
 		},
 		Image: Image{
-			Ext:          "jpeg",
-			MaxDimension: 2000},
+			Ext:             "jpeg",
+			MaxDimension:    2000,
+			RenditionCache:  true,
+			JPEGQuality:     85,
+			WebPQuality:     80,
+			PNGCompression:  imgproc.PNGCompressionDefault,
+			Presets:         map[string]string{},
+			EagerPresets:    []string{},
+			UnsignedPresets: []string{},
+			Exif: Exif{
+				Strip:    true,
+				KeepTags: []string{},
+			},
+			Processing: ImageProcessing{
+				MaxPixels:       50_000_000,
+				MaxFrames:       100,
+				MaxDecodeMemory: 512 * 1024 * 1024,
+				Workers:         4,
+				QueueSize:       64,
+			},
+		},
 		Storage: Storage{
 			FileSystem: FileSystem{
 				GarbageCollector: GarbageCollector{
@@ -182,6 +369,36 @@ func defaults() Config {
 					WorkersCount: 5,
 					Interval:     60 * time.Minute,
 				},
+				Scrubber: Scrubber{
+					WorkersCount: 2,
+					Interval:     7 * 24 * time.Hour,
+				},
+				Trash: Trash{
+					PurgeAfter: 30 * 24 * time.Hour,
+				},
+			},
+			S3: S3{
+				Region:        "us-east-1",
+				UsePathStyle:  true,
+				SweepInterval: 10 * time.Minute,
+				GracePeriod:   time.Hour,
+			},
+			Postgres: Postgres{
+				MaxConns:      10,
+				SweepInterval: 10 * time.Minute,
+				GracePeriod:   time.Hour,
+			},
+			Tiered: Tiered{
+				Hot:           StorageInmemory,
+				Cold:          StorageFileSystem,
+				MaxBytes:      1 << 30,
+				Mode:          TierModeWriteThrough,
+				FlushInterval: 30 * time.Second,
+			},
+			Replication: Replication{
+				Replicas:      []Replica{},
+				Mode:          ReplicationModeAsync,
+				RetryInterval: 10 * time.Second,
 			},
 		},
 	}
@@ -308,6 +525,24 @@ func applyEnv(cfg *Config) error {
 		cfg.App.Security.WriteToken = sWriteToken
 	}
 
+	sSigningKey := os.Getenv("FILE_STORAGE_SIGNING_KEY")
+	if sSigningKey != "" {
+		cfg.App.Security.SigningKey = sSigningKey
+	}
+
+	d, ok, err = readDurationEnv("FILE_STORAGE_PRESIGN_MAX_AGE")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.App.Security.PresignMaxAge = d
+	}
+
+	sTrustedProxies := os.Getenv("FILE_STORAGE_TRUSTED_PROXIES")
+	if sTrustedProxies != "" {
+		cfg.App.Security.TrustedProxies = splitList(sTrustedProxies)
+	}
+
 	sLogLevel := os.Getenv("FILE_STORAGE_LOG_LEVEL")
 	if sLogLevel != "" {
 		cfg.Log.Level = sLogLevel
@@ -331,305 +566,1078 @@ func applyEnv(cfg *Config) error {
 		cfg.Image.MaxDimension = v
 	}
 
-	sStorage := os.Getenv("FILE_STORAGE_STORAGE")
-	if sStorage != "" {
-		cfg.App.Storage = sStorage
+	b, ok, err := readBoolEnv("FILE_STORAGE_IMAGE_RENDITION_CACHE")
+	if err != nil {
+		return err
 	}
-
-	sFsPath := os.Getenv("FILE_STORAGE_FS_PATH")
-	if sFsPath != "" {
-		cfg.Storage.FileSystem.Path = sFsPath
+	if ok {
+		cfg.Image.RenditionCache = b
 	}
 
-	b, ok, err := readBoolEnv("FILE_STORAGE_FS_GC_ENABLED")
+	v, ok, err = readIntEnv("FILE_STORAGE_IMAGE_JPEG_QUALITY")
 	if err != nil {
 		return err
 	}
 	if ok {
-		cfg.Storage.FileSystem.GarbageCollector.Enabled = b
+		cfg.Image.JPEGQuality = v
 	}
 
-	d, ok, err = readDurationEnv("FILE_STORAGE_FS_GC_INTERVAL")
+	v, ok, err = readIntEnv("FILE_STORAGE_IMAGE_WEBP_QUALITY")
 	if err != nil {
 		return err
 	}
 	if ok {
-		cfg.Storage.FileSystem.GarbageCollector.Interval = d
+		cfg.Image.WebPQuality = v
 	}
 
-	v, ok, err = readIntEnv("FILE_STORAGE_FS_GC_WORKERS_COUNT")
+	sPNGCompression := os.Getenv("FILE_STORAGE_IMAGE_PNG_COMPRESSION")
+	if sPNGCompression != "" {
+		cfg.Image.PNGCompression = sPNGCompression
+	}
+
+	b, ok, err = readBoolEnv("FILE_STORAGE_IMAGE_KEEP_ORIGINAL")
 	if err != nil {
 		return err
 	}
 	if ok {
-		cfg.Storage.FileSystem.GarbageCollector.WorkersCount = v
+		cfg.Image.KeepOriginal = b
 	}
 
-	return nil
-}
-
-func readIntEnv(name string) (int, bool, error) {
-	sValue := os.Getenv(name)
-	if sValue != "" {
-		v, err := strconv.Atoi(sValue)
-		if err != nil {
-			return 0, false, fmt.Errorf("invalid %s=%q: %w", name, sValue, err)
-		}
-		return v, true, nil
+	sPresets := os.Getenv("FILE_STORAGE_IMAGE_PRESETS")
+	if sPresets != "" {
+		cfg.Image.Presets = parsePresets(sPresets)
 	}
-	return 0, false, nil
-}
 
-func readDurationEnv(name string) (time.Duration, bool, error) {
-	sValue := os.Getenv(name)
-	if sValue != "" {
-		v, err := time.ParseDuration(sValue)
-		if err != nil {
-			return 0, false, fmt.Errorf("invalid %s=%q: %w", name, sValue, err)
-		}
-		return v, true, nil
+	sEagerPresets := os.Getenv("FILE_STORAGE_IMAGE_EAGER_PRESETS")
+	if sEagerPresets != "" {
+		cfg.Image.EagerPresets = splitList(sEagerPresets)
 	}
-	return 0, false, nil
-}
 
-func readBoolEnv(name string) (bool, bool, error) {
-	sValue := os.Getenv(name)
-	if sValue != "" {
-		v, err := strconv.ParseBool(sValue)
-		if err != nil {
-			return false, false, fmt.Errorf("invalid %s=%q: %w", name, sValue, err)
-		}
-		return v, true, nil
+	b, ok, err = readBoolEnv("FILE_STORAGE_IMAGE_PRESETS_ONLY")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Image.PresetsOnly = b
 	}
-	return false, false, nil
-}
 
-func applyFlags(cfg *Config) error {
+	b, ok, err = readBoolEnv("FILE_STORAGE_IMAGE_SIGNED_TRANSFORMS")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Image.SignedTransforms = b
+	}
 
-	if !pflag.Parsed() {
-		return errs.ErrConfigFlagsNotParsed
+	sUnsignedPresets := os.Getenv("FILE_STORAGE_IMAGE_UNSIGNED_PRESETS")
+	if sUnsignedPresets != "" {
+		cfg.Image.UnsignedPresets = splitList(sUnsignedPresets)
 	}
 
-	v, ok, err := readIntFlag("port")
+	b, ok, err = readBoolEnv("FILE_STORAGE_IMAGE_EXIF_STRIP")
 	if err != nil {
 		return err
 	}
 	if ok {
-		cfg.App.Server.Port = v
+		cfg.Image.Exif.Strip = b
 	}
 
-	fHost := pflag.Lookup("host")
-	if fHost != nil && fHost.Changed {
-		cfg.App.Server.Host = fHost.Value.String()
+	sKeepTags := os.Getenv("FILE_STORAGE_IMAGE_EXIF_KEEP_TAGS")
+	if sKeepTags != "" {
+		cfg.Image.Exif.KeepTags = splitList(sKeepTags)
 	}
 
-	v, ok, err = readIntFlag("max-header-bytes")
+	b, ok, err = readBoolEnv("FILE_STORAGE_IMAGE_EXIF_RECORD_METADATA")
 	if err != nil {
 		return err
 	}
 	if ok {
-		cfg.App.Server.MaxHeaderBytes = v
+		cfg.Image.Exif.RecordMetadata = b
 	}
 
-	d, ok, err := readDurationFlag("handler-timeout")
+	v, ok, err = readIntEnv("FILE_STORAGE_IMAGE_MAX_PIXELS")
 	if err != nil {
 		return err
 	}
 	if ok {
-		cfg.App.Timeouts.HandlerTimeout = d
+		cfg.Image.Processing.MaxPixels = v
 	}
 
-	d, ok, err = readDurationFlag("read-header-timeout")
+	v, ok, err = readIntEnv("FILE_STORAGE_IMAGE_MAX_FRAMES")
 	if err != nil {
 		return err
 	}
 	if ok {
-		cfg.App.Timeouts.ReadHeaderTimeout = d
+		cfg.Image.Processing.MaxFrames = v
 	}
 
-	d, ok, err = readDurationFlag("write-timeout")
+	v, ok, err = readIntEnv("FILE_STORAGE_IMAGE_MAX_DECODE_MEMORY")
 	if err != nil {
 		return err
 	}
 	if ok {
-		cfg.App.Timeouts.WriteTimeout = d
+		cfg.Image.Processing.MaxDecodeMemory = v
 	}
 
-	d, ok, err = readDurationFlag("idle-timeout")
+	v, ok, err = readIntEnv("FILE_STORAGE_IMAGE_WORKERS")
 	if err != nil {
 		return err
 	}
 	if ok {
-		cfg.App.Timeouts.IdleTimeout = d
+		cfg.Image.Processing.Workers = v
 	}
 
-	v, ok, err = readIntFlag("size-limit")
+	v, ok, err = readIntEnv("FILE_STORAGE_IMAGE_QUEUE_SIZE")
 	if err != nil {
 		return err
 	}
 	if ok {
-		cfg.App.Limits.SizeLimit = v
+		cfg.Image.Processing.QueueSize = v
 	}
 
-	v, ok, err = readIntFlag("rate-capacity")
+	sStorage := os.Getenv("FILE_STORAGE_STORAGE")
+	if sStorage != "" {
+		cfg.App.Storage = sStorage
+	}
+
+	sFsPath := os.Getenv("FILE_STORAGE_FS_PATH")
+	if sFsPath != "" {
+		cfg.Storage.FileSystem.Path = sFsPath
+	}
+
+	b, ok, err = readBoolEnv("FILE_STORAGE_FS_GC_ENABLED")
 	if err != nil {
 		return err
 	}
 	if ok {
-		cfg.App.Limits.RateLimiter.Capacity = v
+		cfg.Storage.FileSystem.GarbageCollector.Enabled = b
 	}
 
-	v, ok, err = readIntFlag("rate-refill")
+	d, ok, err = readDurationEnv("FILE_STORAGE_FS_GC_INTERVAL")
 	if err != nil {
 		return err
 	}
 	if ok {
-		cfg.App.Limits.RateLimiter.RefillRate = v
+		cfg.Storage.FileSystem.GarbageCollector.Interval = d
 	}
 
-	v, ok, err = readIntFlag("concurrency-limit")
+	v, ok, err = readIntEnv("FILE_STORAGE_FS_GC_WORKERS_COUNT")
 	if err != nil {
 		return err
 	}
 	if ok {
-		cfg.App.Limits.ConcurrencyLimit = v
+		cfg.Storage.FileSystem.GarbageCollector.WorkersCount = v
 	}
 
-	fReadToken := pflag.Lookup("read-token")
-	if fReadToken != nil && fReadToken.Changed {
-		cfg.App.Security.ReadToken = fReadToken.Value.String()
+	b, ok, err = readBoolEnv("FILE_STORAGE_FS_SCRUB_ENABLED")
+	if err != nil {
+		return err
 	}
-
-	fWriteToken := pflag.Lookup("write-token")
-	if fWriteToken != nil && fWriteToken.Changed {
-		cfg.App.Security.WriteToken = fWriteToken.Value.String()
+	if ok {
+		cfg.Storage.FileSystem.Scrubber.Enabled = b
 	}
 
-	fLogLevel := pflag.Lookup("log-level")
-	if fLogLevel != nil && fLogLevel.Changed {
-		cfg.Log.Level = fLogLevel.Value.String()
+	d, ok, err = readDurationEnv("FILE_STORAGE_FS_SCRUB_INTERVAL")
+	if err != nil {
+		return err
 	}
-
-	fLogType := pflag.Lookup("log-type")
-	if fLogType != nil && fLogType.Changed {
-		cfg.Log.Type = fLogType.Value.String()
+	if ok {
+		cfg.Storage.FileSystem.Scrubber.Interval = d
 	}
 
-	fImageExt := pflag.Lookup("image-ext")
-	if fImageExt != nil && fImageExt.Changed {
-		cfg.Image.Ext = fImageExt.Value.String()
+	v, ok, err = readIntEnv("FILE_STORAGE_FS_SCRUB_WORKERS_COUNT")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.FileSystem.Scrubber.WorkersCount = v
 	}
 
-	v, ok, err = readIntFlag("image-max-dimension")
+	b, ok, err = readBoolEnv("FILE_STORAGE_FS_SCRUB_QUARANTINE")
 	if err != nil {
 		return err
 	}
 	if ok {
-		cfg.Image.MaxDimension = v
+		cfg.Storage.FileSystem.Scrubber.Quarantine = b
 	}
 
-	fStorage := pflag.Lookup("storage")
-	if fStorage != nil && fStorage.Changed {
-		cfg.App.Storage = fStorage.Value.String()
+	v, ok, err = readIntEnv("FILE_STORAGE_FS_VERSIONS_KEEP")
+	if err != nil {
+		return err
 	}
-
-	fFsstoragepath := pflag.Lookup("fs-storage-path")
-	if fFsstoragepath != nil && fFsstoragepath.Changed {
-		cfg.Storage.FileSystem.Path = fFsstoragepath.Value.String()
+	if ok {
+		cfg.Storage.FileSystem.Versions.Keep = v
 	}
 
-	b, ok, err := readBoolFlag("fs-gc-enabled")
+	d, ok, err = readDurationEnv("FILE_STORAGE_FS_VERSIONS_MAX_AGE")
 	if err != nil {
 		return err
 	}
 	if ok {
-		cfg.Storage.FileSystem.GarbageCollector.Enabled = b
+		cfg.Storage.FileSystem.Versions.MaxAge = d
 	}
 
-	v, ok, err = readIntFlag("fs-gc-workers-count")
+	b, ok, err = readBoolEnv("FILE_STORAGE_FS_TRASH_ENABLED")
 	if err != nil {
 		return err
 	}
 	if ok {
-		cfg.Storage.FileSystem.GarbageCollector.WorkersCount = v
+		cfg.Storage.FileSystem.Trash.Enabled = b
 	}
 
-	d, ok, err = readDurationFlag("fs-gc-interval")
+	d, ok, err = readDurationEnv("FILE_STORAGE_FS_TRASH_PURGE_AFTER")
 	if err != nil {
 		return err
 	}
 	if ok {
-		cfg.Storage.FileSystem.GarbageCollector.Interval = d
+		cfg.Storage.FileSystem.Trash.PurgeAfter = d
 	}
 
-	return nil
-}
-
-func readIntFlag(name string) (int, bool, error) {
-	fValue := pflag.Lookup(name)
-	if fValue != nil && fValue.Changed {
-		raw := fValue.Value.String()
-		v, err := strconv.Atoi(raw)
-		if err != nil {
-			return 0, false, fmt.Errorf("invalid flag %s=%q: %w", name, raw, err)
-		}
-		return v, true, nil
+	b, ok, err = readBoolEnv("FILE_STORAGE_FS_DEDUP")
+	if err != nil {
+		return err
 	}
-	return 0, false, nil
-}
-
-func readDurationFlag(name string) (time.Duration, bool, error) {
-	fValue := pflag.Lookup(name)
-	if fValue != nil && fValue.Changed {
-		raw := fValue.Value.String()
-		v, err := time.ParseDuration(raw)
-		if err != nil {
-			return 0, false, fmt.Errorf("invalid flag %s=%q: %w", name, raw, err)
-		}
-		return v, true, nil
+	if ok {
+		cfg.Storage.FileSystem.Dedup = b
 	}
-	return 0, false, nil
-}
 
-func readBoolFlag(name string) (bool, bool, error) {
-	fValue := pflag.Lookup(name)
-	if fValue != nil && fValue.Changed {
-		raw := fValue.Value.String()
-		v, err := strconv.ParseBool(raw)
-		if err != nil {
-			return false, false, fmt.Errorf("invalid flag %s=%q: %w", name, raw, err)
-		}
-		return v, true, nil
+	sS3Endpoint := os.Getenv("FILE_STORAGE_S3_ENDPOINT")
+	if sS3Endpoint != "" {
+		cfg.Storage.S3.Endpoint = sS3Endpoint
 	}
-	return false, false, nil
-}
 
-func normalize(cfg *Config) {
-	cfg.Log.Type = strings.ToLower(cfg.Log.Type)
-	cfg.Log.Level = strings.ToLower(cfg.Log.Level)
-}
+	sS3Region := os.Getenv("FILE_STORAGE_S3_REGION")
+	if sS3Region != "" {
+		cfg.Storage.S3.Region = sS3Region
+	}
 
-func validate(cfg *Config) error {
-	if cfg.App.Server.Host == "" {
-		return errs.ErrConfigHostNotSet
+	sS3Bucket := os.Getenv("FILE_STORAGE_S3_BUCKET")
+	if sS3Bucket != "" {
+		cfg.Storage.S3.Bucket = sS3Bucket
 	}
 
-	if cfg.App.Server.Port < 1 || cfg.App.Server.Port > 65535 {
-		return errs.ErrConfigPortOutOfRange
+	sS3Prefix := os.Getenv("FILE_STORAGE_S3_PREFIX")
+	if sS3Prefix != "" {
+		cfg.Storage.S3.Prefix = sS3Prefix
 	}
 
-	if cfg.App.Server.MaxHeaderBytes < 0 || cfg.App.Server.MaxHeaderBytes > 1024*1024 {
-		return errs.ErrConfigMaxHeaderBytesOutOfRange
+	sS3AccessKey := os.Getenv("FILE_STORAGE_S3_ACCESS_KEY")
+	if sS3AccessKey != "" {
+		cfg.Storage.S3.AccessKey = sS3AccessKey
 	}
 
-	if cfg.App.Timeouts.HandlerTimeout <= 0 {
-		return fmt.Errorf("handler timeout: %w", errs.ErrConfigInvalidTimeout)
+	sS3SecretKey := os.Getenv("FILE_STORAGE_S3_SECRET_KEY")
+	if sS3SecretKey != "" {
+		cfg.Storage.S3.SecretKey = sS3SecretKey
 	}
-	if cfg.App.Timeouts.ReadHeaderTimeout <= 0 {
-		return fmt.Errorf("read header timeout: %w", errs.ErrConfigInvalidTimeout)
+
+	b, ok, err = readBoolEnv("FILE_STORAGE_S3_USE_PATH_STYLE")
+	if err != nil {
+		return err
 	}
-	if cfg.App.Timeouts.WriteTimeout <= 0 {
-		return fmt.Errorf("write timeout: %w", errs.ErrConfigInvalidTimeout)
+	if ok {
+		cfg.Storage.S3.UsePathStyle = b
+	}
+
+	d, ok, err = readDurationEnv("FILE_STORAGE_S3_SWEEP_INTERVAL")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.S3.SweepInterval = d
+	}
+
+	d, ok, err = readDurationEnv("FILE_STORAGE_S3_GRACE_PERIOD")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.S3.GracePeriod = d
+	}
+
+	sPgDSN := os.Getenv("FILE_STORAGE_PG_DSN")
+	if sPgDSN != "" {
+		cfg.Storage.Postgres.DSN = sPgDSN
+	}
+
+	v, ok, err = readIntEnv("FILE_STORAGE_PG_MAX_CONNS")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.Postgres.MaxConns = v
+	}
+
+	d, ok, err = readDurationEnv("FILE_STORAGE_PG_SWEEP_INTERVAL")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.Postgres.SweepInterval = d
+	}
+
+	d, ok, err = readDurationEnv("FILE_STORAGE_PG_GRACE_PERIOD")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.Postgres.GracePeriod = d
+	}
+
+	sTierHot := os.Getenv("FILE_STORAGE_TIER_HOT")
+	if sTierHot != "" {
+		cfg.Storage.Tiered.Hot = sTierHot
+	}
+
+	sTierHotPath := os.Getenv("FILE_STORAGE_TIER_HOT_PATH")
+	if sTierHotPath != "" {
+		cfg.Storage.Tiered.HotPath = sTierHotPath
+	}
+
+	sTierCold := os.Getenv("FILE_STORAGE_TIER_COLD")
+	if sTierCold != "" {
+		cfg.Storage.Tiered.Cold = sTierCold
+	}
+
+	v, ok, err = readIntEnv("FILE_STORAGE_TIER_MAX_BYTES")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.Tiered.MaxBytes = v
+	}
+
+	sTierMode := os.Getenv("FILE_STORAGE_TIER_MODE")
+	if sTierMode != "" {
+		cfg.Storage.Tiered.Mode = sTierMode
+	}
+
+	d, ok, err = readDurationEnv("FILE_STORAGE_TIER_FLUSH_INTERVAL")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.Tiered.FlushInterval = d
+	}
+
+	b, ok, err = readBoolEnv("FILE_STORAGE_REPLICATION_ENABLED")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.Replication.Enabled = b
+	}
+
+	sReplicas := os.Getenv("FILE_STORAGE_REPLICATION_REPLICAS")
+	if sReplicas != "" {
+		cfg.Storage.Replication.Replicas = parseReplicas(sReplicas)
+	}
+
+	sReplicationMode := os.Getenv("FILE_STORAGE_REPLICATION_MODE")
+	if sReplicationMode != "" {
+		cfg.Storage.Replication.Mode = sReplicationMode
+	}
+
+	sReplicationQueuePath := os.Getenv("FILE_STORAGE_REPLICATION_QUEUE_PATH")
+	if sReplicationQueuePath != "" {
+		cfg.Storage.Replication.QueuePath = sReplicationQueuePath
+	}
+
+	d, ok, err = readDurationEnv("FILE_STORAGE_REPLICATION_RETRY_INTERVAL")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.Replication.RetryInterval = d
+	}
+
+	return nil
+}
+
+func readIntEnv(name string) (int, bool, error) {
+	sValue := os.Getenv(name)
+	if sValue != "" {
+		v, err := strconv.Atoi(sValue)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid %s=%q: %w", name, sValue, err)
+		}
+		return v, true, nil
+	}
+	return 0, false, nil
+}
+
+func readDurationEnv(name string) (time.Duration, bool, error) {
+	sValue := os.Getenv(name)
+	if sValue != "" {
+		v, err := time.ParseDuration(sValue)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid %s=%q: %w", name, sValue, err)
+		}
+		return v, true, nil
+	}
+	return 0, false, nil
+}
+
+func readBoolEnv(name string) (bool, bool, error) {
+	sValue := os.Getenv(name)
+	if sValue != "" {
+		v, err := strconv.ParseBool(sValue)
+		if err != nil {
+			return false, false, fmt.Errorf("invalid %s=%q: %w", name, sValue, err)
+		}
+		return v, true, nil
+	}
+	return false, false, nil
+}
+
+func applyFlags(cfg *Config) error {
+
+	if !pflag.Parsed() {
+		return errs.ErrConfigFlagsNotParsed
+	}
+
+	v, ok, err := readIntFlag("port")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.App.Server.Port = v
+	}
+
+	fHost := pflag.Lookup("host")
+	if fHost != nil && fHost.Changed {
+		cfg.App.Server.Host = fHost.Value.String()
+	}
+
+	v, ok, err = readIntFlag("max-header-bytes")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.App.Server.MaxHeaderBytes = v
+	}
+
+	d, ok, err := readDurationFlag("handler-timeout")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.App.Timeouts.HandlerTimeout = d
+	}
+
+	d, ok, err = readDurationFlag("read-header-timeout")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.App.Timeouts.ReadHeaderTimeout = d
+	}
+
+	d, ok, err = readDurationFlag("write-timeout")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.App.Timeouts.WriteTimeout = d
+	}
+
+	d, ok, err = readDurationFlag("idle-timeout")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.App.Timeouts.IdleTimeout = d
+	}
+
+	v, ok, err = readIntFlag("size-limit")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.App.Limits.SizeLimit = v
+	}
+
+	v, ok, err = readIntFlag("rate-capacity")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.App.Limits.RateLimiter.Capacity = v
+	}
+
+	v, ok, err = readIntFlag("rate-refill")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.App.Limits.RateLimiter.RefillRate = v
+	}
+
+	v, ok, err = readIntFlag("concurrency-limit")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.App.Limits.ConcurrencyLimit = v
+	}
+
+	fReadToken := pflag.Lookup("read-token")
+	if fReadToken != nil && fReadToken.Changed {
+		cfg.App.Security.ReadToken = fReadToken.Value.String()
+	}
+
+	fWriteToken := pflag.Lookup("write-token")
+	if fWriteToken != nil && fWriteToken.Changed {
+		cfg.App.Security.WriteToken = fWriteToken.Value.String()
+	}
+
+	fSigningKey := pflag.Lookup("signing-key")
+	if fSigningKey != nil && fSigningKey.Changed {
+		cfg.App.Security.SigningKey = fSigningKey.Value.String()
+	}
+
+	d, ok, err = readDurationFlag("presign-max-age")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.App.Security.PresignMaxAge = d
+	}
+
+	fTrustedProxies := pflag.Lookup("trusted-proxies")
+	if fTrustedProxies != nil && fTrustedProxies.Changed {
+		cfg.App.Security.TrustedProxies = splitList(strings.Trim(fTrustedProxies.Value.String(), "[]"))
+	}
+
+	fLogLevel := pflag.Lookup("log-level")
+	if fLogLevel != nil && fLogLevel.Changed {
+		cfg.Log.Level = fLogLevel.Value.String()
+	}
+
+	fLogType := pflag.Lookup("log-type")
+	if fLogType != nil && fLogType.Changed {
+		cfg.Log.Type = fLogType.Value.String()
+	}
+
+	fImageExt := pflag.Lookup("image-ext")
+	if fImageExt != nil && fImageExt.Changed {
+		cfg.Image.Ext = fImageExt.Value.String()
+	}
+
+	v, ok, err = readIntFlag("image-max-dimension")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Image.MaxDimension = v
+	}
+
+	b, ok, err := readBoolFlag("image-rendition-cache")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Image.RenditionCache = b
+	}
+
+	v, ok, err = readIntFlag("image-jpeg-quality")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Image.JPEGQuality = v
+	}
+
+	v, ok, err = readIntFlag("image-webp-quality")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Image.WebPQuality = v
+	}
+
+	fPNGCompression := pflag.Lookup("image-png-compression")
+	if fPNGCompression != nil && fPNGCompression.Changed {
+		cfg.Image.PNGCompression = fPNGCompression.Value.String()
+	}
+
+	b, ok, err = readBoolFlag("image-keep-original")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Image.KeepOriginal = b
+	}
+
+	fPresets := pflag.Lookup("image-presets")
+	if fPresets != nil && fPresets.Changed {
+		cfg.Image.Presets = parsePresets(fPresets.Value.String())
+	}
+
+	fEagerPresets := pflag.Lookup("image-eager-presets")
+	if fEagerPresets != nil && fEagerPresets.Changed {
+		cfg.Image.EagerPresets = splitList(strings.Trim(fEagerPresets.Value.String(), "[]"))
+	}
+
+	b, ok, err = readBoolFlag("image-presets-only")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Image.PresetsOnly = b
+	}
+
+	b, ok, err = readBoolFlag("image-signed-transforms")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Image.SignedTransforms = b
+	}
+
+	fUnsignedPresets := pflag.Lookup("image-unsigned-presets")
+	if fUnsignedPresets != nil && fUnsignedPresets.Changed {
+		cfg.Image.UnsignedPresets = splitList(strings.Trim(fUnsignedPresets.Value.String(), "[]"))
+	}
+
+	b, ok, err = readBoolFlag("image-exif-strip")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Image.Exif.Strip = b
+	}
+
+	fKeepTags := pflag.Lookup("image-exif-keep-tags")
+	if fKeepTags != nil && fKeepTags.Changed {
+		cfg.Image.Exif.KeepTags = splitList(strings.Trim(fKeepTags.Value.String(), "[]"))
+	}
+
+	b, ok, err = readBoolFlag("image-exif-record-metadata")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Image.Exif.RecordMetadata = b
+	}
+
+	v, ok, err = readIntFlag("image-max-pixels")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Image.Processing.MaxPixels = v
+	}
+
+	v, ok, err = readIntFlag("image-max-frames")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Image.Processing.MaxFrames = v
+	}
+
+	v, ok, err = readIntFlag("image-max-decode-memory")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Image.Processing.MaxDecodeMemory = v
+	}
+
+	v, ok, err = readIntFlag("image-workers")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Image.Processing.Workers = v
+	}
+
+	v, ok, err = readIntFlag("image-queue-size")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Image.Processing.QueueSize = v
+	}
+
+	fStorage := pflag.Lookup("storage")
+	if fStorage != nil && fStorage.Changed {
+		cfg.App.Storage = fStorage.Value.String()
+	}
+
+	fFsstoragepath := pflag.Lookup("fs-storage-path")
+	if fFsstoragepath != nil && fFsstoragepath.Changed {
+		cfg.Storage.FileSystem.Path = fFsstoragepath.Value.String()
+	}
+
+	b, ok, err = readBoolFlag("fs-gc-enabled")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.FileSystem.GarbageCollector.Enabled = b
+	}
+
+	v, ok, err = readIntFlag("fs-gc-workers-count")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.FileSystem.GarbageCollector.WorkersCount = v
+	}
+
+	d, ok, err = readDurationFlag("fs-gc-interval")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.FileSystem.GarbageCollector.Interval = d
+	}
+
+	b, ok, err = readBoolFlag("fs-scrub-enabled")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.FileSystem.Scrubber.Enabled = b
+	}
+
+	d, ok, err = readDurationFlag("fs-scrub-interval")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.FileSystem.Scrubber.Interval = d
+	}
+
+	v, ok, err = readIntFlag("fs-scrub-workers-count")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.FileSystem.Scrubber.WorkersCount = v
+	}
+
+	b, ok, err = readBoolFlag("fs-scrub-quarantine")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.FileSystem.Scrubber.Quarantine = b
+	}
+
+	v, ok, err = readIntFlag("fs-versions-keep")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.FileSystem.Versions.Keep = v
+	}
+
+	d, ok, err = readDurationFlag("fs-versions-max-age")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.FileSystem.Versions.MaxAge = d
+	}
+
+	b, ok, err = readBoolFlag("fs-trash-enabled")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.FileSystem.Trash.Enabled = b
+	}
+
+	d, ok, err = readDurationFlag("fs-trash-purge-after")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.FileSystem.Trash.PurgeAfter = d
+	}
+
+	b, ok, err = readBoolFlag("fs-dedup")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.FileSystem.Dedup = b
+	}
+
+	fS3Endpoint := pflag.Lookup("s3-endpoint")
+	if fS3Endpoint != nil && fS3Endpoint.Changed {
+		cfg.Storage.S3.Endpoint = fS3Endpoint.Value.String()
+	}
+
+	fS3Region := pflag.Lookup("s3-region")
+	if fS3Region != nil && fS3Region.Changed {
+		cfg.Storage.S3.Region = fS3Region.Value.String()
+	}
+
+	fS3Bucket := pflag.Lookup("s3-bucket")
+	if fS3Bucket != nil && fS3Bucket.Changed {
+		cfg.Storage.S3.Bucket = fS3Bucket.Value.String()
+	}
+
+	fS3Prefix := pflag.Lookup("s3-prefix")
+	if fS3Prefix != nil && fS3Prefix.Changed {
+		cfg.Storage.S3.Prefix = fS3Prefix.Value.String()
+	}
+
+	fS3AccessKey := pflag.Lookup("s3-access-key")
+	if fS3AccessKey != nil && fS3AccessKey.Changed {
+		cfg.Storage.S3.AccessKey = fS3AccessKey.Value.String()
+	}
+
+	fS3SecretKey := pflag.Lookup("s3-secret-key")
+	if fS3SecretKey != nil && fS3SecretKey.Changed {
+		cfg.Storage.S3.SecretKey = fS3SecretKey.Value.String()
+	}
+
+	b, ok, err = readBoolFlag("s3-use-path-style")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.S3.UsePathStyle = b
+	}
+
+	d, ok, err = readDurationFlag("s3-sweep-interval")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.S3.SweepInterval = d
+	}
+
+	d, ok, err = readDurationFlag("s3-grace-period")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.S3.GracePeriod = d
+	}
+
+	fPgDSN := pflag.Lookup("pg-dsn")
+	if fPgDSN != nil && fPgDSN.Changed {
+		cfg.Storage.Postgres.DSN = fPgDSN.Value.String()
+	}
+
+	v, ok, err = readIntFlag("pg-max-conns")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.Postgres.MaxConns = v
+	}
+
+	d, ok, err = readDurationFlag("pg-sweep-interval")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.Postgres.SweepInterval = d
+	}
+
+	d, ok, err = readDurationFlag("pg-grace-period")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.Postgres.GracePeriod = d
+	}
+
+	fTierHot := pflag.Lookup("tier-hot")
+	if fTierHot != nil && fTierHot.Changed {
+		cfg.Storage.Tiered.Hot = fTierHot.Value.String()
+	}
+
+	fTierHotPath := pflag.Lookup("tier-hot-path")
+	if fTierHotPath != nil && fTierHotPath.Changed {
+		cfg.Storage.Tiered.HotPath = fTierHotPath.Value.String()
+	}
+
+	fTierCold := pflag.Lookup("tier-cold")
+	if fTierCold != nil && fTierCold.Changed {
+		cfg.Storage.Tiered.Cold = fTierCold.Value.String()
+	}
+
+	v, ok, err = readIntFlag("tier-max-bytes")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.Tiered.MaxBytes = v
+	}
+
+	fTierMode := pflag.Lookup("tier-mode")
+	if fTierMode != nil && fTierMode.Changed {
+		cfg.Storage.Tiered.Mode = fTierMode.Value.String()
+	}
+
+	d, ok, err = readDurationFlag("tier-flush-interval")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.Tiered.FlushInterval = d
+	}
+
+	b, ok, err = readBoolFlag("replication-enabled")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.Replication.Enabled = b
+	}
+
+	fReplicas := pflag.Lookup("replication-replicas")
+	if fReplicas != nil && fReplicas.Changed {
+		cfg.Storage.Replication.Replicas = parseReplicas(fReplicas.Value.String())
+	}
+
+	fReplicationMode := pflag.Lookup("replication-mode")
+	if fReplicationMode != nil && fReplicationMode.Changed {
+		cfg.Storage.Replication.Mode = fReplicationMode.Value.String()
+	}
+
+	fReplicationQueuePath := pflag.Lookup("replication-queue-path")
+	if fReplicationQueuePath != nil && fReplicationQueuePath.Changed {
+		cfg.Storage.Replication.QueuePath = fReplicationQueuePath.Value.String()
+	}
+
+	d, ok, err = readDurationFlag("replication-retry-interval")
+	if err != nil {
+		return err
+	}
+	if ok {
+		cfg.Storage.Replication.RetryInterval = d
+	}
+
+	return nil
+}
+
+func readIntFlag(name string) (int, bool, error) {
+	fValue := pflag.Lookup(name)
+	if fValue != nil && fValue.Changed {
+		raw := fValue.Value.String()
+		v, err := strconv.Atoi(raw)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid flag %s=%q: %w", name, raw, err)
+		}
+		return v, true, nil
+	}
+	return 0, false, nil
+}
+
+func readDurationFlag(name string) (time.Duration, bool, error) {
+	fValue := pflag.Lookup(name)
+	if fValue != nil && fValue.Changed {
+		raw := fValue.Value.String()
+		v, err := time.ParseDuration(raw)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid flag %s=%q: %w", name, raw, err)
+		}
+		return v, true, nil
+	}
+	return 0, false, nil
+}
+
+func readBoolFlag(name string) (bool, bool, error) {
+	fValue := pflag.Lookup(name)
+	if fValue != nil && fValue.Changed {
+		raw := fValue.Value.String()
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return false, false, fmt.Errorf("invalid flag %s=%q: %w", name, raw, err)
+		}
+		return v, true, nil
+	}
+	return false, false, nil
+}
+
+// splitList splits a comma-separated value and drops empty items.
+func splitList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// parseReplicas parses a comma-separated list of replicas in the form
+// storage or storage:path, for example filesystem:/mnt/disk2/files,s3.
+func parseReplicas(value string) []Replica {
+	replicas := []Replica{}
+	for _, item := range splitList(value) {
+		storage, path, _ := strings.Cut(item, ":")
+		replicas = append(replicas, Replica{Storage: storage, Path: path})
+	}
+	return replicas
+}
+
+// parsePresets parses semicolon separated name=spec pairs, for example
+// thumb=200x200 fill webp q70;card=800x600 fill.
+func parsePresets(value string) map[string]string {
+	presets := map[string]string{}
+	for _, item := range strings.Split(value, ";") {
+		name, spec, _ := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		presets[name] = strings.TrimSpace(spec)
+	}
+	return presets
+}
+
+// validPresetName reports whether the preset name consists of lowercase
+// letters, digits, '-' and '_' only, so it can be used in URLs as is.
+func validPresetName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+func normalize(cfg *Config) {
+	cfg.Log.Type = strings.ToLower(cfg.Log.Type)
+	cfg.Log.Level = strings.ToLower(cfg.Log.Level)
+	cfg.Image.PNGCompression = strings.ToLower(cfg.Image.PNGCompression)
+}
+
+func validate(cfg *Config) error {
+	if cfg.App.Server.Host == "" {
+		return errs.ErrConfigHostNotSet
+	}
+
+	if cfg.App.Server.Port < 1 || cfg.App.Server.Port > 65535 {
+		return errs.ErrConfigPortOutOfRange
+	}
+
+	if cfg.App.Server.MaxHeaderBytes < 0 || cfg.App.Server.MaxHeaderBytes > 1024*1024 {
+		return errs.ErrConfigMaxHeaderBytesOutOfRange
+	}
+
+	if cfg.App.Timeouts.HandlerTimeout <= 0 {
+		return fmt.Errorf("handler timeout: %w", errs.ErrConfigInvalidTimeout)
+	}
+	if cfg.App.Timeouts.ReadHeaderTimeout <= 0 {
+		return fmt.Errorf("read header timeout: %w", errs.ErrConfigInvalidTimeout)
+	}
+	if cfg.App.Timeouts.WriteTimeout <= 0 {
+		return fmt.Errorf("write timeout: %w", errs.ErrConfigInvalidTimeout)
 	}
 	if cfg.App.Timeouts.IdleTimeout <= 0 {
 		return fmt.Errorf("idle timeout: %w", errs.ErrConfigInvalidTimeout)
@@ -657,6 +1665,71 @@ func validate(cfg *Config) error {
 		return errs.ErrConfigImageDimensionOutOfRange
 	}
 
+	if cfg.Image.JPEGQuality < 0 || cfg.Image.JPEGQuality > 100 {
+		return errs.ErrConfigInvalidJPEGQuality
+	}
+
+	if cfg.Image.WebPQuality < 0 || cfg.Image.WebPQuality > 100 {
+		return errs.ErrConfigInvalidWebPQuality
+	}
+
+	if cfg.Image.PNGCompression != "" {
+		if _, ok := imgproc.PNGCompressionLevel(cfg.Image.PNGCompression); !ok {
+			return errs.ErrConfigInvalidPNGCompression
+		}
+	}
+
+	for _, tag := range cfg.Image.Exif.KeepTags {
+		if !imgproc.IsKeepableExifTag(tag) {
+			return fmt.Errorf("tag %q: %w", tag, errs.ErrConfigInvalidExifTag)
+		}
+	}
+
+	processing := cfg.Image.Processing
+	if processing.MaxPixels < 0 || processing.MaxFrames < 0 || processing.MaxDecodeMemory < 0 ||
+		processing.Workers < 0 || processing.QueueSize < 0 {
+		return errs.ErrConfigInvalidImageProcessing
+	}
+
+	for name, spec := range cfg.Image.Presets {
+		if !validPresetName(name) {
+			return fmt.Errorf("preset name %q: %w", name, errs.ErrConfigInvalidPreset)
+		}
+		if _, err := imgproc.ParsePreset(spec); err != nil {
+			return fmt.Errorf("preset %q: %w: %v", name, errs.ErrConfigInvalidPreset, err)
+		}
+	}
+
+	for _, name := range cfg.Image.EagerPresets {
+		if _, ok := cfg.Image.Presets[name]; !ok {
+			return fmt.Errorf("eager preset %q is not defined: %w", name, errs.ErrConfigInvalidPreset)
+		}
+	}
+	if len(cfg.Image.EagerPresets) > 0 && !cfg.Image.RenditionCache {
+		return fmt.Errorf("eager presets require the rendition cache: %w", errs.ErrConfigInvalidPreset)
+	}
+
+	for _, name := range cfg.Image.UnsignedPresets {
+		if _, ok := cfg.Image.Presets[name]; !ok {
+			return fmt.Errorf("unsigned preset %q is not defined: %w", name, errs.ErrConfigInvalidPreset)
+		}
+	}
+	if cfg.Image.SignedTransforms && cfg.App.Security.SigningKey == "" {
+		return fmt.Errorf("signed transforms: %w", errs.ErrConfigSigningKeyNotSet)
+	}
+
+	for _, proxy := range cfg.App.Security.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return fmt.Errorf("trusted proxy %q: %w", proxy, errs.ErrConfigInvalidTrustedProxy)
+			}
+		}
+	}
+
+	if cfg.App.Security.SigningKey != "" && cfg.App.Security.PresignMaxAge <= 0 {
+		return fmt.Errorf("presign max age: %w", errs.ErrConfigInvalidTimeout)
+	}
+
 	if cfg.App.Security.ReadToken == "" {
 		return fmt.Errorf("read token not set : %w", errs.ErrTokenNotSet)
 	}
@@ -665,11 +1738,26 @@ func validate(cfg *Config) error {
 		return fmt.Errorf("write token not set : %w", errs.ErrTokenNotSet)
 	}
 
-	if cfg.App.Storage != StorageFileSystem && cfg.App.Storage != StorageInmemory {
+	if cfg.App.Storage != StorageFileSystem && cfg.App.Storage != StorageInmemory && cfg.App.Storage != StorageS3 &&
+		cfg.App.Storage != StoragePostgres && cfg.App.Storage != StorageTiered {
 		return errs.ErrConfigInvalidStorage
 	}
 
-	if cfg.App.Storage == StorageFileSystem {
+	if cfg.App.Storage == StorageTiered {
+		err := validateTiered(cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.Storage.Replication.Enabled {
+		err := validateReplication(cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	if usesBackend(cfg, StorageFileSystem) {
 		if cfg.Storage.FileSystem.Path == "" {
 			return fmt.Errorf("%w: filesystem.path is required", errs.ErrConfigInvalidStorage)
 		}
@@ -682,6 +1770,168 @@ func validate(cfg *Config) error {
 				return fmt.Errorf("%w: invalid gc interval", errs.ErrConfigInvalidStorage)
 			}
 		}
+
+		if cfg.Storage.FileSystem.Scrubber.Enabled {
+			if cfg.Storage.FileSystem.Scrubber.WorkersCount < 1 {
+				return fmt.Errorf("%w: invalid scrubber workers count", errs.ErrConfigInvalidStorage)
+			}
+			if cfg.Storage.FileSystem.Scrubber.Interval <= 0 {
+				return fmt.Errorf("%w: invalid scrubber interval", errs.ErrConfigInvalidStorage)
+			}
+		}
+
+		if cfg.Storage.FileSystem.Versions.Keep < 0 {
+			return fmt.Errorf("%w: invalid versions keep count", errs.ErrConfigInvalidStorage)
+		}
+		if cfg.Storage.FileSystem.Versions.MaxAge < 0 {
+			return fmt.Errorf("%w: invalid versions max age", errs.ErrConfigInvalidStorage)
+		}
+		if cfg.Storage.FileSystem.Trash.PurgeAfter < 0 {
+			return fmt.Errorf("%w: invalid trash purge age", errs.ErrConfigInvalidStorage)
+		}
+	}
+
+	if usesBackend(cfg, StorageS3) {
+		if cfg.Storage.S3.Bucket == "" {
+			return fmt.Errorf("%w: s3.bucket is required", errs.ErrConfigInvalidStorage)
+		}
+		if cfg.Storage.S3.Region == "" {
+			return fmt.Errorf("%w: s3.region is required", errs.ErrConfigInvalidStorage)
+		}
+		if (cfg.Storage.S3.AccessKey == "") != (cfg.Storage.S3.SecretKey == "") {
+			return fmt.Errorf("%w: s3 access and secret keys must be set together", errs.ErrConfigInvalidStorage)
+		}
+		if cfg.Storage.S3.SweepInterval < 0 || cfg.Storage.S3.GracePeriod < 0 {
+			return fmt.Errorf("%w: s3 sweep interval and grace period must not be negative", errs.ErrConfigInvalidStorage)
+		}
+	}
+
+	if usesBackend(cfg, StoragePostgres) {
+		if cfg.Storage.Postgres.DSN == "" {
+			return fmt.Errorf("%w: postgres.dsn is required", errs.ErrConfigInvalidStorage)
+		}
+		if cfg.Storage.Postgres.MaxConns < 1 {
+			return fmt.Errorf("%w: invalid postgres max conns", errs.ErrConfigInvalidStorage)
+		}
+		if cfg.Storage.Postgres.SweepInterval < 0 || cfg.Storage.Postgres.GracePeriod < 0 {
+			return fmt.Errorf("%w: postgres sweep interval and grace period must not be negative", errs.ErrConfigInvalidStorage)
+		}
+	}
+
+	return nil
+}
+
+// usesBackend reports whether the storage backend is used on its own, as the
+// cold storage of the tiered storage or as a replica. Filesystem replicas have
+// their own paths and do not use the filesystem section.
+func usesBackend(cfg *Config, backend string) bool {
+	if primaryBackend(cfg) == backend {
+		return true
+	}
+	if backend == StorageFileSystem || !cfg.Storage.Replication.Enabled {
+		return false
+	}
+	for _, r := range cfg.Storage.Replication.Replicas {
+		if r.Storage == backend {
+			return true
+		}
+	}
+	return false
+}
+
+// primaryBackend returns the backend keeping files of the primary storage.
+func primaryBackend(cfg *Config) string {
+	if cfg.App.Storage == StorageTiered {
+		return cfg.Storage.Tiered.Cold
+	}
+	return cfg.App.Storage
+}
+
+func validateTiered(cfg *Config) error {
+	t := cfg.Storage.Tiered
+
+	switch t.Hot {
+	case StorageInmemory:
+	case StorageFileSystem:
+		if t.HotPath == "" {
+			return fmt.Errorf("%w: tiered.hot_path is required for filesystem hot storage", errs.ErrConfigInvalidStorage)
+		}
+		if t.Cold == StorageFileSystem && filepath.Clean(t.HotPath) == filepath.Clean(cfg.Storage.FileSystem.Path) {
+			return fmt.Errorf("%w: tiered.hot_path must differ from filesystem.path", errs.ErrConfigInvalidStorage)
+		}
+	default:
+		return fmt.Errorf("%w: tiered.hot should be inmemory or filesystem", errs.ErrConfigInvalidStorage)
+	}
+
+	if t.Cold != StorageFileSystem && t.Cold != StorageS3 && t.Cold != StoragePostgres {
+		return fmt.Errorf("%w: tiered.cold should be filesystem, s3 or postgres", errs.ErrConfigInvalidStorage)
+	}
+
+	if t.MaxBytes < 1 {
+		return fmt.Errorf("%w: invalid tiered max bytes", errs.ErrConfigInvalidStorage)
+	}
+
+	switch t.Mode {
+	case TierModeWriteThrough:
+	case TierModeWriteBack:
+		if t.FlushInterval <= 0 {
+			return fmt.Errorf("%w: invalid tiered flush interval", errs.ErrConfigInvalidStorage)
+		}
+	default:
+		return fmt.Errorf("%w: tiered.mode should be write-through or write-back", errs.ErrConfigInvalidStorage)
+	}
+
+	return nil
+}
+
+func validateReplication(cfg *Config) error {
+	r := cfg.Storage.Replication
+
+	if cfg.App.Storage == StorageInmemory {
+		return fmt.Errorf("%w: replication of inmemory storage is not supported", errs.ErrConfigInvalidStorage)
+	}
+	if len(r.Replicas) == 0 {
+		return fmt.Errorf("%w: replication.replicas is required", errs.ErrConfigInvalidStorage)
+	}
+
+	used := map[string]bool{primaryBackend(cfg): true}
+	paths := map[string]bool{}
+	if primaryBackend(cfg) == StorageFileSystem {
+		paths[filepath.Clean(cfg.Storage.FileSystem.Path)] = true
+	}
+	if cfg.App.Storage == StorageTiered && cfg.Storage.Tiered.Hot == StorageFileSystem {
+		paths[filepath.Clean(cfg.Storage.Tiered.HotPath)] = true
+	}
+
+	for _, replica := range r.Replicas {
+		switch replica.Storage {
+		case StorageFileSystem:
+			if replica.Path == "" {
+				return fmt.Errorf("%w: filesystem replica path is required", errs.ErrConfigInvalidStorage)
+			}
+			path := filepath.Clean(replica.Path)
+			if paths[path] {
+				return fmt.Errorf("%w: replica path %s is used by another storage", errs.ErrConfigInvalidStorage, replica.Path)
+			}
+			paths[path] = true
+		case StorageS3, StoragePostgres:
+			if used[replica.Storage] {
+				return fmt.Errorf("%w: %s is used by another storage", errs.ErrConfigInvalidStorage, replica.Storage)
+			}
+			used[replica.Storage] = true
+		default:
+			return fmt.Errorf("%w: replica storage should be filesystem, s3 or postgres", errs.ErrConfigInvalidStorage)
+		}
+	}
+
+	if r.Mode != ReplicationModeSync && r.Mode != ReplicationModeAsync {
+		return fmt.Errorf("%w: replication.mode should be sync or async", errs.ErrConfigInvalidStorage)
+	}
+	if r.QueuePath == "" {
+		return fmt.Errorf("%w: replication.queue_path is required", errs.ErrConfigInvalidStorage)
+	}
+	if r.RetryInterval <= 0 {
+		return fmt.Errorf("%w: invalid replication retry interval", errs.ErrConfigInvalidStorage)
 	}
 
 	return nil
@@ -201,6 +201,24 @@ func TestApplyEnv(t *testing.T) {
 	}
 	defer os.Unsetenv("FILE_STORAGE_FS_GC_ENABLED")
 
+	err = os.Setenv("FILE_STORAGE_REPLICATION_REPLICAS", "filesystem:/mnt/disk2/files, s3")
+	if err != nil {
+		t.Fatalf("set FILE_STORAGE_REPLICATION_REPLICAS error: %s", err)
+	}
+	defer os.Unsetenv("FILE_STORAGE_REPLICATION_REPLICAS")
+
+	err = os.Setenv("FILE_STORAGE_IMAGE_PRESETS", "thumb=200x200 fill webp q70; card = 800x600")
+	if err != nil {
+		t.Fatalf("set FILE_STORAGE_IMAGE_PRESETS error: %s", err)
+	}
+	defer os.Unsetenv("FILE_STORAGE_IMAGE_PRESETS")
+
+	err = os.Setenv("FILE_STORAGE_IMAGE_MAX_PIXELS", "1000000")
+	if err != nil {
+		t.Fatalf("set FILE_STORAGE_IMAGE_MAX_PIXELS error: %s", err)
+	}
+	defer os.Unsetenv("FILE_STORAGE_IMAGE_MAX_PIXELS")
+
 	err = applyEnv(&cfg)
 	if err != nil {
 		t.Fatalf("applyEnv error: %s", err)
@@ -224,6 +242,17 @@ func TestApplyEnv(t *testing.T) {
 	if !cfg.Storage.FileSystem.GarbageCollector.Enabled {
 		t.Errorf("expect gc enabled  got %v", cfg.Storage.FileSystem.GarbageCollector.Enabled)
 	}
+	wantPresets := map[string]string{"thumb": "200x200 fill webp q70", "card": "800x600"}
+	if !reflect.DeepEqual(cfg.Image.Presets, wantPresets) {
+		t.Errorf("expect presets %v got %v", wantPresets, cfg.Image.Presets)
+	}
+	if cfg.Image.Processing.MaxPixels != 1000000 {
+		t.Errorf("expect max pixels 1000000 got %d", cfg.Image.Processing.MaxPixels)
+	}
+	wantReplicas := []Replica{{Storage: StorageFileSystem, Path: "/mnt/disk2/files"}, {Storage: StorageS3}}
+	if !reflect.DeepEqual(cfg.Storage.Replication.Replicas, wantReplicas) {
+		t.Errorf("expect replicas %v got %v", wantReplicas, cfg.Storage.Replication.Replicas)
+	}
 }
 
 func TestApplyFlags(t *testing.T) {
@@ -396,6 +425,232 @@ func TestValidate(t *testing.T) {
 			},
 			want: errs.ErrConfigInvalidImageFormat,
 		},
+		{
+			name: "invalid jpeg quality",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageInmemory,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:   Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image: Image{Ext: "jpeg", MaxDimension: 2000, JPEGQuality: 101},
+			},
+			want: errs.ErrConfigInvalidJPEGQuality,
+		},
+		{
+			name: "invalid webp quality",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageInmemory,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:   Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image: Image{Ext: "jpeg", MaxDimension: 2000, WebPQuality: -1},
+			},
+			want: errs.ErrConfigInvalidWebPQuality,
+		},
+		{
+			name: "invalid png compression",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageInmemory,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:   Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image: Image{Ext: "jpeg", MaxDimension: 2000, PNGCompression: "fast"},
+			},
+			want: errs.ErrConfigInvalidPNGCompression,
+		},
+		{
+			name: "invalid exif keep tag",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageInmemory,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:   Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image: Image{Ext: "jpeg", MaxDimension: 2000, Exif: Exif{KeepTags: []string{"Make", "GPSLatitude"}}},
+			},
+			want: errs.ErrConfigInvalidExifTag,
+		},
+		{
+			name: "invalid trusted proxy",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageInmemory,
+					Security: Security{ReadToken: "1", WriteToken: "2", TrustedProxies: []string{"10.0.0.0/8", "proxy.local"}}},
+				Log:   Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image: Image{Ext: "jpeg", MaxDimension: 2000},
+			},
+			want: errs.ErrConfigInvalidTrustedProxy,
+		},
+		{
+			name: "negative image processing limit",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageInmemory,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:   Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image: Image{Ext: "jpeg", MaxDimension: 2000, Processing: ImageProcessing{MaxPixels: -1}},
+			},
+			want: errs.ErrConfigInvalidImageProcessing,
+		},
+		{
+			name: "invalid preset",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageInmemory,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:   Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image: Image{Ext: "jpeg", MaxDimension: 2000, Presets: map[string]string{"thumb": "200x200 stretch"}},
+			},
+			want: errs.ErrConfigInvalidPreset,
+		},
+		{
+			name: "invalid preset name",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageInmemory,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:   Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image: Image{Ext: "jpeg", MaxDimension: 2000, Presets: map[string]string{"Thumb 1": "200x200"}},
+			},
+			want: errs.ErrConfigInvalidPreset,
+		},
+		{
+			name: "preset mode without height",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageInmemory,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:   Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image: Image{Ext: "jpeg", MaxDimension: 2000, Presets: map[string]string{"thumb": "200x fill"}},
+			},
+			want: errs.ErrConfigInvalidPreset,
+		},
+		{
+			name: "undefined eager preset",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageInmemory,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:   Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image: Image{Ext: "jpeg", MaxDimension: 2000, RenditionCache: true, Presets: map[string]string{"thumb": "200x200"}, EagerPresets: []string{"card"}},
+			},
+			want: errs.ErrConfigInvalidPreset,
+		},
+		{
+			name: "eager presets without rendition cache",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageInmemory,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:   Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image: Image{Ext: "jpeg", MaxDimension: 2000, Presets: map[string]string{"thumb": "200x200"}, EagerPresets: []string{"thumb"}},
+			},
+			want: errs.ErrConfigInvalidPreset,
+		},
+		{
+			name: "ok presets",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageInmemory,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log: Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image: Image{Ext: "jpeg", MaxDimension: 2000, RenditionCache: true,
+					Presets:      map[string]string{"thumb": "200x200 fill smart webp", "card_2x": "x1200 jpeg q70", "pad": "300x300 pad bg=000000"},
+					EagerPresets: []string{"thumb"}},
+			},
+			want: nil,
+		},
+		{
+			name: "undefined unsigned preset",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageInmemory,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:   Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image: Image{Ext: "jpeg", MaxDimension: 2000, Presets: map[string]string{"thumb": "200x200"}, UnsignedPresets: []string{"card"}},
+			},
+			want: errs.ErrConfigInvalidPreset,
+		},
+		{
+			name: "signing key not set",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageInmemory,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:   Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image: Image{Ext: "jpeg", MaxDimension: 2000, SignedTransforms: true},
+			},
+			want: errs.ErrConfigSigningKeyNotSet,
+		},
 		{
 			name: "token not set",
 			cfg: Config{
@@ -500,6 +755,451 @@ func TestValidate(t *testing.T) {
 			},
 			want: errs.ErrConfigInvalidStorage,
 		},
+		{
+			name: "invalid FS storage, scrubber interval",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageFileSystem,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:   Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image: Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{FileSystem: FileSystem{Path: "./path",
+					Scrubber: Scrubber{Enabled: true, WorkersCount: 1}}},
+			},
+			want: errs.ErrConfigInvalidStorage,
+		},
+		{
+			name: "invalid FS storage, versions keep",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageFileSystem,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:   Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image: Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{FileSystem: FileSystem{Path: "./path",
+					Versions: Versions{Keep: -1}}},
+			},
+			want: errs.ErrConfigInvalidStorage,
+		},
+		{
+			name: "invalid FS storage, trash purge age",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageFileSystem,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:   Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image: Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{FileSystem: FileSystem{Path: "./path",
+					Trash: Trash{Enabled: true, PurgeAfter: -time.Hour}}},
+			},
+			want: errs.ErrConfigInvalidStorage,
+		},
+		{
+			name: "invalid S3 storage, bucket",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageS3,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{S3: S3{Region: "us-east-1"}},
+			},
+			want: errs.ErrConfigInvalidStorage,
+		},
+		{
+			name: "invalid S3 storage, secret key",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageS3,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{S3: S3{Region: "us-east-1", Bucket: "files", AccessKey: "key"}},
+			},
+			want: errs.ErrConfigInvalidStorage,
+		},
+		{
+			name: "invalid S3 storage, grace period",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageS3,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{S3: S3{Region: "us-east-1", Bucket: "files", GracePeriod: -time.Minute}},
+			},
+			want: errs.ErrConfigInvalidStorage,
+		},
+		{
+			name: "ok S3",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageS3,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{S3: S3{Region: "us-east-1", Bucket: "files"}},
+			},
+			want: nil,
+		},
+		{
+			name: "invalid postgres storage, dsn",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StoragePostgres,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{Postgres: Postgres{MaxConns: 10}},
+			},
+			want: errs.ErrConfigInvalidStorage,
+		},
+		{
+			name: "invalid postgres storage, max conns",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StoragePostgres,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{Postgres: Postgres{DSN: "postgres://localhost/files"}},
+			},
+			want: errs.ErrConfigInvalidStorage,
+		},
+		{
+			name: "invalid postgres storage, sweep interval",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StoragePostgres,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{Postgres: Postgres{DSN: "postgres://localhost/files", MaxConns: 10, SweepInterval: -time.Minute}},
+			},
+			want: errs.ErrConfigInvalidStorage,
+		},
+		{
+			name: "ok postgres",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StoragePostgres,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{Postgres: Postgres{DSN: "postgres://localhost/files", MaxConns: 10}},
+			},
+			want: nil,
+		},
+		{
+			name: "invalid tiered storage, hot",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageTiered,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{FileSystem: FileSystem{Path: "./path"}, Tiered: Tiered{Hot: StorageS3, Cold: StorageFileSystem, MaxBytes: 1, Mode: TierModeWriteThrough}},
+			},
+			want: errs.ErrConfigInvalidStorage,
+		},
+		{
+			name: "invalid tiered storage, hot path",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageTiered,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{FileSystem: FileSystem{Path: "./path"}, Tiered: Tiered{Hot: StorageFileSystem, HotPath: "./path/", Cold: StorageFileSystem, MaxBytes: 1, Mode: TierModeWriteThrough}},
+			},
+			want: errs.ErrConfigInvalidStorage,
+		},
+		{
+			name: "invalid tiered storage, cold",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageTiered,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{Tiered: Tiered{Hot: StorageInmemory, Cold: StorageInmemory, MaxBytes: 1, Mode: TierModeWriteThrough}},
+			},
+			want: errs.ErrConfigInvalidStorage,
+		},
+		{
+			name: "invalid tiered storage, cold config",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageTiered,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{Tiered: Tiered{Hot: StorageInmemory, Cold: StorageFileSystem, MaxBytes: 1, Mode: TierModeWriteThrough}},
+			},
+			want: errs.ErrConfigInvalidStorage,
+		},
+		{
+			name: "invalid tiered storage, max bytes",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageTiered,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{FileSystem: FileSystem{Path: "./path"}, Tiered: Tiered{Hot: StorageInmemory, Cold: StorageFileSystem, Mode: TierModeWriteThrough}},
+			},
+			want: errs.ErrConfigInvalidStorage,
+		},
+		{
+			name: "invalid tiered storage, flush interval",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageTiered,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{FileSystem: FileSystem{Path: "./path"}, Tiered: Tiered{Hot: StorageInmemory, Cold: StorageFileSystem, MaxBytes: 1, Mode: TierModeWriteBack}},
+			},
+			want: errs.ErrConfigInvalidStorage,
+		},
+		{
+			name: "ok tiered",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageTiered,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{FileSystem: FileSystem{Path: "./path"}, Tiered: Tiered{Hot: StorageFileSystem, HotPath: "./hot", Cold: StorageFileSystem, MaxBytes: 1, Mode: TierModeWriteBack, FlushInterval: time.Second}},
+			},
+			want: nil,
+		},
+		{
+			name: "invalid replication, inmemory primary",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageInmemory,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{Replication: Replication{Enabled: true, Replicas: []Replica{{Storage: StorageFileSystem, Path: "./replica"}}, Mode: ReplicationModeAsync, QueuePath: "./queue.log", RetryInterval: time.Second}},
+			},
+			want: errs.ErrConfigInvalidStorage,
+		},
+		{
+			name: "invalid replication, no replicas",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageFileSystem,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{FileSystem: FileSystem{Path: "./path"}, Replication: Replication{Enabled: true, Replicas: []Replica{}, Mode: ReplicationModeAsync, QueuePath: "./queue.log", RetryInterval: time.Second}},
+			},
+			want: errs.ErrConfigInvalidStorage,
+		},
+		{
+			name: "invalid replication, replica storage",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageFileSystem,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{FileSystem: FileSystem{Path: "./path"}, Replication: Replication{Enabled: true, Replicas: []Replica{{Storage: StorageInmemory}}, Mode: ReplicationModeAsync, QueuePath: "./queue.log", RetryInterval: time.Second}},
+			},
+			want: errs.ErrConfigInvalidStorage,
+		},
+		{
+			name: "invalid replication, replica path",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageFileSystem,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{FileSystem: FileSystem{Path: "./path"}, Replication: Replication{Enabled: true, Replicas: []Replica{{Storage: StorageFileSystem, Path: "./path/"}}, Mode: ReplicationModeAsync, QueuePath: "./queue.log", RetryInterval: time.Second}},
+			},
+			want: errs.ErrConfigInvalidStorage,
+		},
+		{
+			name: "invalid replication, replica config",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageFileSystem,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{FileSystem: FileSystem{Path: "./path"}, Replication: Replication{Enabled: true, Replicas: []Replica{{Storage: StoragePostgres}}, Mode: ReplicationModeAsync, QueuePath: "./queue.log", RetryInterval: time.Second}},
+			},
+			want: errs.ErrConfigInvalidStorage,
+		},
+		{
+			name: "invalid replication, mode",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageFileSystem,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{FileSystem: FileSystem{Path: "./path"}, Replication: Replication{Enabled: true, Replicas: []Replica{{Storage: StorageFileSystem, Path: "./replica"}}, Mode: "lazy", QueuePath: "./queue.log", RetryInterval: time.Second}},
+			},
+			want: errs.ErrConfigInvalidStorage,
+		},
+		{
+			name: "invalid replication, queue path",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageFileSystem,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{FileSystem: FileSystem{Path: "./path"}, Replication: Replication{Enabled: true, Replicas: []Replica{{Storage: StorageFileSystem, Path: "./replica"}}, Mode: ReplicationModeSync, RetryInterval: time.Second}},
+			},
+			want: errs.ErrConfigInvalidStorage,
+		},
+		{
+			name: "ok replication",
+			cfg: Config{
+				App: App{
+					Server: Server{
+						Host: "127.0.0.1",
+						Port: 2,
+					},
+					Timeouts: timeouts,
+					Storage:  StorageFileSystem,
+					Security: Security{ReadToken: "1", WriteToken: "2"}},
+				Log:     Log{Level: LogLevelDebug, Type: LogTypeJSON},
+				Image:   Image{Ext: "jpeg", MaxDimension: 2000},
+				Storage: Storage{FileSystem: FileSystem{Path: "./path"}, Postgres: Postgres{DSN: "postgres://localhost/files", MaxConns: 1}, Replication: Replication{Enabled: true, Replicas: []Replica{{Storage: StorageFileSystem, Path: "./replica"}, {Storage: StoragePostgres}}, Mode: ReplicationModeAsync, QueuePath: "./queue.log", RetryInterval: time.Second}},
+			},
+			want: nil,
+		},
 		{
 			name: "ok inmemory",
 			cfg: Config{
@@ -1,9 +1,16 @@
 package errs
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var ErrConcurrencyLimiterBelow0 = errors.New("concurrency limiter below 0")
 var ErrNotFound = errors.New("not found")
+
+// ErrFileDeleted is returned for files moved to the trash. It matches ErrNotFound.
+var ErrFileDeleted = fmt.Errorf("file deleted: %w", ErrNotFound)
+var ErrFileExists = errors.New("file already exists")
 var ErrInvalidID = errors.New("invalid id")
 
 var ErrInvalidFileData = errors.New("invalid file data")
@@ -15,14 +22,34 @@ var ErrUnsupportedTypeInMetadata = errors.New("unsupported type in metadata")
 var ErrWrongIDLength = errors.New("wrong ID length")
 var ErrMultipleIDsInQuery = errors.New("multiple id parameters are not allowed")
 var ErrWrongUrlParameter = errors.New("wrong url parameter")
+var ErrInvalidRequestPayload = errors.New("invalid request payload")
 
 var ErrContextValueError = errors.New("context value error")
 var ErrAccessDenied = errors.New("access denied")
 
+// ErrSignatureRequired is returned for transformations requested without the
+// read permission by an unsigned URL. It matches ErrAccessDenied.
+var ErrSignatureRequired = fmt.Errorf("signed url required: %w", ErrAccessDenied)
+
 var ErrUnsupportedImageFormat = errors.New("unsupported image format")
 var ErrInvalidImage = errors.New("invalid image")
+var ErrImageTooLarge = errors.New("image exceeds processing limits")
+var ErrImageQueueFull = errors.New("image processing queue is full")
+
+var ErrVersionsNotSupported = errors.New("file versions are not supported by storage")
+var ErrTrashNotSupported = errors.New("trash is not supported by storage")
+var ErrBackupNotSupported = errors.New("backup is not supported by storage")
+var ErrScrubNotSupported = errors.New("integrity check is not supported by storage")
+var ErrOriginalsNotSupported = errors.New("original uploads are not supported by storage")
+var ErrSigningNotConfigured = errors.New("url signing key is not configured")
+
+// ErrOriginalChanged is returned when a write keeping the original of the file
+// finds that the original was replaced since it was read.
+var ErrOriginalChanged = errors.New("original of the file has changed")
 
 var ErrStorageFileIsLocked = errors.New("file is locked")
+var ErrStorageInUse = errors.New("storage is used by another process")
+var ErrStorageClosed = errors.New("storage is closed")
 
 var ErrFlockSupportTestError = errors.New("flock support test error")
 var ErrIDNotLocked = errors.New("ID not locked")
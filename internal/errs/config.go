@@ -10,7 +10,15 @@ var ErrConfigInvalidTimeout = errors.New("invalid timeout")
 var ErrConfigInvalidRateLimiter = errors.New("invalid rate limiter")
 var ErrConfigWrongLogLevel = errors.New("invalid config log level. should be debug or info or warn or error")
 var ErrConfigWrongLogType = errors.New("invalid config log type. should be json or text")
-var ErrConfigInvalidImageFormat = errors.New("invalid image format. Only bmp, jpg, jpeg, png and gif are supported as output formats")
+var ErrConfigInvalidImageFormat = errors.New("invalid image format. Only bmp, jpg, jpeg, png, gif, tiff and webp are supported as output formats")
 var ErrConfigImageDimensionOutOfRange = errors.New("Stored image dimension out of range 1000 - 10000")
+var ErrConfigInvalidJPEGQuality = errors.New("invalid jpeg quality. should be between 1 and 100")
+var ErrConfigInvalidWebPQuality = errors.New("invalid webp quality. should be between 1 and 100")
+var ErrConfigInvalidPNGCompression = errors.New("invalid png compression. should be default or none or speed or best")
+var ErrConfigInvalidExifTag = errors.New("invalid exif keep tag. Only Make, Model, Software, DateTime, Artist, Copyright, DateTimeOriginal and DateTimeDigitized can be kept")
+var ErrConfigInvalidPreset = errors.New("invalid image preset")
+var ErrConfigInvalidImageProcessing = errors.New("invalid image processing limits. should not be negative")
 var ErrConfigInvalidStorage = errors.New("invalid storage")
+var ErrConfigSigningKeyNotSet = errors.New("signing key not set")
+var ErrConfigInvalidTrustedProxy = errors.New("invalid trusted proxy. should be an ip address or a cidr network")
 var ErrTokenNotSet = errors.New("token not set")
@@ -2,44 +2,78 @@ package filedata
 
 import (
 	"file-storage/internal/imgproc"
+	"fmt"
+	"image/color"
 	"io"
 	"maps"
 	"time"
 )
 
 // UploadCommand contains input required to create a new file or update an existing one.
+//
+// Data is consumed at most once. Hash is the expected SHA-256 of the data and
+// may be empty when the client did not provide it. MimeType and Filename are
+// optional client provided values.
 type UploadCommand struct {
 	ID       string
-	Data     []byte
+	Data     io.Reader
 	Hash     string
 	Public   bool
 	IsImage  bool
+	MimeType string
+	Filename string
 	Metadata map[string]any
 }
 
+// FormatAuto requests the output image format to be negotiated from the accepted MIME types.
+const FormatAuto = "auto"
+
 // ContentCommand describes a content read request, including optional image transformation parameters.
+// Empty Mode and Gravity and nil Background and Quality mean service defaults.
+// Accept lists MIME types explicitly accepted by the client and is used with FormatAuto.
+// Preset names a configured transformation used instead of the transformation fields.
 type ContentCommand struct {
-	ID     string
-	Width  *int
-	Height *int
-	Format *string
+	ID         string
+	Preset     string
+	Width      *int
+	Height     *int
+	Format     *string
+	Mode       imgproc.Mode
+	Gravity    imgproc.Gravity
+	Background *color.NRGBA
+	Quality    *int
+	Accept     []string
 }
 
-// FileData contains file bytes together with system metadata used by business logic and storage.
+// FileData contains a file content stream together with system metadata used by business logic and storage.
+//
+// Data is nil when only metadata is updated and the current content is kept.
+// Storage consumes Data to EOF before it reads the remaining fields, so a reader
+// may complete HashSource, HashStored and FileSize when the stream ends.
+//
+// Original is the original upload of an image stored next to Data by storages
+// that keep originals, and OriginalInfo describes it. The current original is
+// kept when Data is nil or KeepOriginal is set and dropped otherwise.
+// KeepOriginal writes Data only if the current original still has HashSource.
 type FileData struct {
-	ID         string
-	Data       []byte
-	HashSource string
-	HashStored string
-	Public     bool
-	FileSize   int
-	IsImage    bool
-	Format     imgproc.ImgFormat
-	Width      int
-	Height     int
-	Metadata   map[string]any
-	CreatedAt  time.Time
-	UpdatedAt  time.Time
+	ID           string
+	Data         io.Reader
+	HashSource   string
+	HashStored   string
+	Public       bool
+	FileSize     int
+	IsImage      bool
+	Format       imgproc.ImgFormat
+	Width        int
+	Height       int
+	MimeType     string
+	Filename     string
+	Metadata     map[string]any
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Original     io.Reader
+	OriginalInfo *OriginalInfo
+	KeepOriginal bool
 }
 
 // FileInfo contains file metadata without file content.
@@ -53,15 +87,71 @@ type FileInfo struct {
 	Format     imgproc.ImgFormat `json:"format"`
 	Width      int               `json:"width"`
 	Height     int               `json:"height"`
+	MimeType   string            `json:"mime_type"`
+	Filename   string            `json:"filename"`
 	Metadata   map[string]any    `json:"metadata"`
 	CreatedAt  time.Time         `json:"created_at"`
 	UpdatedAt  time.Time         `json:"updated_at"`
+	Original   *OriginalInfo     `json:"original,omitempty"`
+}
+
+// OriginalInfo describes the original upload of an image kept next to the
+// stored image. Its hash is HashSource of the file. Settings identifies the
+// image settings the stored image was produced from the original with.
+type OriginalInfo struct {
+	Size     int               `json:"size"`
+	Format   imgproc.ImgFormat `json:"format"`
+	Width    int               `json:"width"`
+	Height   int               `json:"height"`
+	Settings string            `json:"settings"`
 }
 
-// ContentData contains a file content stream and metadata required to build an HTTP response.
+// ContentData contains a seekable file content stream and metadata of the version it belongs to.
 type ContentData struct {
-	Data    io.ReadCloser
-	IsImage bool
+	Data io.ReadSeekCloser
+	Info *FileInfo
+}
+
+// Content contains file content prepared for delivery to a client together with
+// validators used for conditional and range requests.
+type Content struct {
+	Data        io.ReadSeekCloser
+	ETag        string
+	ModTime     time.Time
+	ContentType string
+	Filename    string
+}
+
+// RenditionKey identifies a transformed image rendition of a stored file version.
+// Width and Height are the requested bounds, not the dimensions of the result.
+// Background is a hex color and is set only for padded renditions.
+// Quality is set only for lossy formats.
+type RenditionKey struct {
+	ID         string
+	Hash       string
+	Width      int
+	Height     int
+	Mode       imgproc.Mode
+	Gravity    imgproc.Gravity
+	Background string
+	Quality    int
+	Format     imgproc.ImgFormat
+}
+
+// Name returns the key without the file ID in a form usable as a file name part.
+func (k RenditionKey) Name() string {
+	transform := string(k.Mode)
+	if k.Gravity != "" {
+		transform += "-" + string(k.Gravity)
+	}
+	if k.Background != "" {
+		transform += "-" + k.Background
+	}
+	if k.Quality > 0 {
+		transform += fmt.Sprintf("-q%d", k.Quality)
+	}
+
+	return fmt.Sprintf("%s.%dx%d.%s.%s", k.Hash, k.Width, k.Height, transform, k.Format)
 }
 
 // ImageInfo describes detected or stored image format and dimensions.
@@ -71,6 +161,14 @@ type ImageInfo struct {
 	Height int
 }
 
+// ContentHash returns the hash identifying stored content of the file version.
+func (fi *FileInfo) ContentHash() string {
+	if fi.HashStored != "" {
+		return fi.HashStored
+	}
+	return fi.HashSource
+}
+
 // FileInfoFromFileData builds FileInfo from FileData by copying metadata fields and omitting file content.
 func FileInfoFromFileData(fd *FileData) *FileInfo {
 	fi := FileInfo{
@@ -83,10 +181,17 @@ func FileInfoFromFileData(fd *FileData) *FileInfo {
 		Format:     fd.Format,
 		Width:      fd.Width,
 		Height:     fd.Height,
+		MimeType:   fd.MimeType,
+		Filename:   fd.Filename,
 		CreatedAt:  fd.CreatedAt,
 		UpdatedAt:  fd.UpdatedAt,
 	}
 
+	if fd.OriginalInfo != nil {
+		original := *fd.OriginalInfo
+		fi.Original = &original
+	}
+
 	if fd.Metadata != nil {
 		metadata := make(map[string]any, len(fd.Metadata))
 		maps.Copy(metadata, fd.Metadata)
@@ -95,3 +200,14 @@ func FileInfoFromFileData(fd *FileData) *FileInfo {
 
 	return &fi
 }
+
+// NopSeekCloser returns an io.ReadSeekCloser with a no-op Close method wrapping the provided io.ReadSeeker.
+func NopSeekCloser(rs io.ReadSeeker) io.ReadSeekCloser {
+	return nopSeekCloser{rs}
+}
+
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error { return nil }
@@ -0,0 +1,98 @@
+package filedata
+
+import (
+	"encoding/json"
+	"file-storage/internal/imgproc"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ListQuery describes a file listing request. Files are listed in ascending ID
+// order starting after Cursor. Nil and zero filters are not applied. Time ranges
+// include From and exclude To.
+type ListQuery struct {
+	Cursor      string
+	Limit       int
+	Public      *bool
+	IsImage     *bool
+	Format      imgproc.ImgFormat
+	MinSize     *int
+	MaxSize     *int
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	UpdatedFrom *time.Time
+	UpdatedTo   *time.Time
+	// Metadata holds user metadata values the files must have, compared in text form
+	// with numbers written without an exponent.
+	Metadata map[string]string
+}
+
+// FileList is a page of listed files. NextCursor is empty on the last page.
+type FileList struct {
+	Files      []*FileInfo `json:"files"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// Match reports whether the file satisfies all filters of the query. Cursor and Limit are not checked.
+func (q *ListQuery) Match(fi *FileInfo) bool {
+	if q.Public != nil && fi.Public != *q.Public {
+		return false
+	}
+	if q.IsImage != nil && fi.IsImage != *q.IsImage {
+		return false
+	}
+	if q.Format != "" && fi.Format != q.Format {
+		return false
+	}
+	if q.MinSize != nil && fi.FileSize < *q.MinSize {
+		return false
+	}
+	if q.MaxSize != nil && fi.FileSize > *q.MaxSize {
+		return false
+	}
+	if !inRange(fi.CreatedAt, q.CreatedFrom, q.CreatedTo) || !inRange(fi.UpdatedAt, q.UpdatedFrom, q.UpdatedTo) {
+		return false
+	}
+
+	for key, want := range q.Metadata {
+		v, ok := fi.Metadata[key]
+		if !ok || metadataText(v) != want {
+			return false
+		}
+	}
+
+	return true
+}
+
+// metadataText returns the text form of a metadata value. Numbers are written
+// in full without an exponent, so 1000000 matches "1000000" rather than
+// "1e+06".
+func metadataText(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return v.String()
+		}
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func inRange(t time.Time, from, to *time.Time) bool {
+	if from != nil && t.Before(*from) {
+		return false
+	}
+	if to != nil && !t.Before(*to) {
+		return false
+	}
+	return true
+}
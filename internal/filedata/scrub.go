@@ -0,0 +1,27 @@
+package filedata
+
+import "time"
+
+// ScrubStatus is the outcome of an integrity check of stored content.
+type ScrubStatus string
+
+const (
+	// ScrubStatusOK means the content matches its hash.
+	ScrubStatusOK ScrubStatus = "ok"
+	// ScrubStatusCorrupted means the content does not match its hash.
+	ScrubStatusCorrupted ScrubStatus = "corrupted"
+	// ScrubStatusUnverified means the file has no content hash to check against.
+	ScrubStatusUnverified ScrubStatus = "unverified"
+)
+
+// ScrubResult describes an integrity check of the current version of a file.
+// Quarantined is set when the corrupted version was moved out of the storage.
+type ScrubResult struct {
+	ID           string      `json:"id"`
+	Status       ScrubStatus `json:"status"`
+	ExpectedHash string      `json:"expected_hash"`
+	ActualHash   string      `json:"actual_hash"`
+	FileSize     int64       `json:"file_size"`
+	Quarantined  bool        `json:"quarantined"`
+	CheckedAt    time.Time   `json:"checked_at"`
+}
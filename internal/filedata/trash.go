@@ -0,0 +1,15 @@
+package filedata
+
+import "time"
+
+// DeletedFile describes a file kept in the trash after deletion.
+type DeletedFile struct {
+	DeletedAt time.Time `json:"deleted_at"`
+	Info      *FileInfo `json:"info"`
+}
+
+// TrashList is a page of deleted files in ascending ID order. NextCursor is empty on the last page.
+type TrashList struct {
+	Files      []*DeletedFile `json:"files"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
@@ -0,0 +1,16 @@
+package filedata
+
+import "time"
+
+// VersionInfo describes a retained previous version of a file. ArchivedAt is
+// the time the version was replaced.
+type VersionInfo struct {
+	Version    int       `json:"version"`
+	ArchivedAt time.Time `json:"archived_at"`
+	Info       *FileInfo `json:"info"`
+}
+
+// VersionList lists retained versions of a file, newest first.
+type VersionList struct {
+	Versions []*VersionInfo `json:"versions"`
+}
@@ -0,0 +1,162 @@
+package files
+
+import (
+	"encoding/binary"
+	"file-storage/internal/imgproc"
+	"image/color"
+	"reflect"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// testExifSegment returns an APP1 segment with Make "Cam", the orientation
+// and a GPS IFD holding the latitude reference.
+func testExifSegment(orientation uint16) []byte {
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8}
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)
+	tiff = append(tiff, 0x01, 0x0f, 0, 2, 0, 0, 0, 4, 'C', 'a', 'm', 0)
+	tiff = append(tiff, 0x01, 0x12, 0, 3, 0, 0, 0, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0)
+	tiff = append(tiff, 0x88, 0x25, 0, 4, 0, 0, 0, 1, 0, 0, 0, 50)
+	tiff = append(tiff, 0, 0, 0, 0)
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)
+	tiff = append(tiff, 0x00, 0x01, 0, 2, 0, 0, 0, 2, 'N', 0, 0, 0)
+	tiff = append(tiff, 0, 0, 0, 0)
+
+	segment := []byte{0xff, 0xe1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(2+6+len(tiff)))
+	segment = append(segment, "Exif\x00\x00"...)
+	return append(segment, tiff...)
+}
+
+func testExifJPEG(t *testing.T, w, h int, orientation uint16) []byte {
+	b, err := imgproc.Encode(imaging.New(w, h, color.Black), imaging.JPEG)
+	if err != nil {
+		t.Fatalf("test image creation error: %v", err)
+	}
+
+	result := append([]byte{}, b[:2]...)
+	result = append(result, testExifSegment(orientation)...)
+	return append(result, b[2:]...)
+}
+
+func TestProcessImageExif(t *testing.T) {
+	w := 100
+	h := 50
+
+	table := []struct {
+		name         string
+		b            []byte
+		opts         imgproc.EncodeOptions
+		keepTags     []string
+		wantWidth    int
+		wantHeight   int
+		wantExif     bool
+		wantMake     string
+		wantSameSize bool
+	}{
+		{
+			name:       "rotated",
+			b:          testExifJPEG(t, w, h, 6),
+			wantWidth:  h,
+			wantHeight: w,
+		},
+		{
+			name:         "kept as is",
+			b:            testExifJPEG(t, w, h, 1),
+			wantWidth:    w,
+			wantHeight:   h,
+			wantExif:     true,
+			wantMake:     "Cam",
+			wantSameSize: true,
+		},
+		{
+			name:       "stripped without re-encoding",
+			b:          testExifJPEG(t, w, h, 1),
+			opts:       imgproc.EncodeOptions{StripMetadata: true},
+			wantWidth:  w,
+			wantHeight: h,
+		},
+		{
+			name:       "rotated and stripped with kept tags",
+			b:          testExifJPEG(t, w, h, 8),
+			opts:       imgproc.EncodeOptions{StripMetadata: true},
+			keepTags:   []string{"Make", "Model"},
+			wantWidth:  h,
+			wantHeight: w,
+			wantExif:   true,
+			wantMake:   "Cam",
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.keepTags != nil {
+				tt.opts.Exif = imgproc.ReadExif(tt.b).Segment(tt.keepTags)
+			}
+			transform := imgproc.Transform{Width: 1000, Height: 1000, Mode: imgproc.ModeFit}
+
+			result, imageInfo, err := ProcessImage(tt.b, "jpeg", transform, tt.opts, imgproc.Limits{})
+			if err != nil {
+				t.Fatalf("process image error: %v", err)
+			}
+
+			if imageInfo.Width != tt.wantWidth || imageInfo.Height != tt.wantHeight {
+				t.Errorf("dimensions mismatch got %dx%d want %dx%d", imageInfo.Width, imageInfo.Height, tt.wantWidth, tt.wantHeight)
+			}
+			if tt.wantSameSize && len(result) != len(tt.b) {
+				t.Errorf("image changed got %d bytes want %d", len(result), len(tt.b))
+			}
+
+			x := imgproc.ReadExif(result)
+			if (x != nil) != tt.wantExif {
+				t.Fatalf("exif presence mismatch got %v want %v", x != nil, tt.wantExif)
+			}
+			if x == nil {
+				return
+			}
+			if x.Orientation() != 1 && !tt.wantSameSize {
+				t.Errorf("orientation kept in rotated image")
+			}
+			if cameraMake, _ := x.Camera(); cameraMake != tt.wantMake {
+				t.Errorf("make mismatch got %q want %q", cameraMake, tt.wantMake)
+			}
+		})
+	}
+}
+
+func TestWithExifMetadata(t *testing.T) {
+	x := imgproc.ReadExif(testExifJPEG(t, 10, 10, 1))
+
+	table := []struct {
+		name     string
+		metadata map[string]any
+		want     map[string]any
+	}{
+		{
+			name: "recorded",
+			want: map[string]any{"exif_camera_make": "Cam"},
+		},
+		{
+			name:     "merged",
+			metadata: map[string]any{"album": "trip"},
+			want:     map[string]any{"album": "trip", "exif_camera_make": "Cam"},
+		},
+		{
+			name:     "client value kept",
+			metadata: map[string]any{"exif_camera_make": "custom"},
+			want:     map[string]any{"exif_camera_make": "custom"},
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			got := withExifMetadata(tt.metadata, x)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("metadata mismatch got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
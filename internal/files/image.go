@@ -10,8 +10,13 @@ import (
 	"github.com/disintegration/imaging"
 )
 
-// ProcessImage converts image data to requested format and size
-func ProcessImage(b []byte, targetExt string, targetWidth int, targetHeight int) ([]byte, *filedata.ImageInfo, error) {
+// ProcessImage converts image data to requested format and fits it into the
+// transformation bounds according to the transformation mode. The image is
+// rotated upright according to its EXIF orientation. Images that already match
+// are returned without re-encoding unless opts force it or metadata can not be
+// stripped from them otherwise. Images exceeding limits, and transformations
+// whose result would exceed them, are rejected before images are decoded.
+func ProcessImage(b []byte, targetExt string, t imgproc.Transform, opts imgproc.EncodeOptions, limits imgproc.Limits) ([]byte, *filedata.ImageInfo, error) {
 	targetFormat, ok := imgproc.SupportedOutputFormat(targetExt)
 	if !ok {
 		return nil, nil, fmt.Errorf("unsupported target image format %s: %w", targetExt, errs.ErrUnsupportedImageFormat)
@@ -26,44 +31,57 @@ func ProcessImage(b []byte, targetExt string, targetWidth int, targetHeight int)
 		return nil, nil, fmt.Errorf("invalid image dimensions: %w", errs.ErrInvalidImage)
 	}
 
-	if targetWidth <= 0 || targetHeight <= 0 {
+	if t.Width <= 0 || t.Height <= 0 {
 		return nil, nil, fmt.Errorf("invalid target image dimensions: %w", errs.ErrInvalidImage)
 	}
 
-	multiplierW := float64(targetWidth) / float64(width)
-	multiplierH := float64(targetHeight) / float64(height)
-	multiplier := min(multiplierW, multiplierH)
+	err = imgproc.CheckLimits(b, t, limits)
+	if err != nil {
+		return nil, nil, fmt.Errorf("image limits error: %w", err)
+	}
 
-	if format == targetFormat && multiplier >= 1 {
-		imageInfo := filedata.ImageInfo{
-			Format: format,
-			Width:  width,
-			Height: height,
+	upright := imgproc.ReadExif(b).Orientation() == 1
+	if format == targetFormat && t.IsNoop(width, height) && upright && !opts.Reencode {
+		result := b
+		stripped := true
+		if opts.StripMetadata {
+			result, stripped, err = imgproc.StripMetadata(b, format, opts.Exif)
+			if err != nil {
+				return nil, nil, fmt.Errorf("strip metadata error: %w", err)
+			}
 		}
 
-		return b, &imageInfo, nil
-	}
+		if stripped {
+			imageInfo := filedata.ImageInfo{
+				Format: format,
+				Width:  width,
+				Height: height,
+			}
 
-	imagingFormat, err := imgproc.ImagingOutputFormat(targetFormat)
-	if err != nil {
-		return nil, nil, fmt.Errorf("output format error: %w", err)
+			return result, &imageInfo, nil
+		}
 	}
 
 	reader := bytes.NewReader(b)
-	img, err := imaging.Decode(reader)
+	img, err := imaging.Decode(reader, imaging.AutoOrientation(true))
 	if err != nil {
 		return nil, nil, fmt.Errorf("decode image error: %w", err)
 	}
 
-	if multiplier < 1 {
-		img = imgproc.Resize(img, multiplier)
-	}
+	img = imgproc.Apply(img, t)
 
-	result, err := imgproc.Encode(img, imagingFormat)
+	result, err := imgproc.EncodeImage(img, targetFormat, opts)
 	if err != nil {
 		return nil, nil, fmt.Errorf("encode image error: %w", err)
 	}
 
+	if opts.StripMetadata && opts.Exif != nil && targetFormat == imgproc.ImgFormatJPEG {
+		result, err = imgproc.StripJPEGMetadata(result, opts.Exif)
+		if err != nil {
+			return nil, nil, fmt.Errorf("write metadata error: %w", err)
+		}
+	}
+
 	imageInfo := filedata.ImageInfo{
 		Format: targetFormat,
 		Width:  img.Bounds().Dx(),
@@ -2,11 +2,16 @@ package files
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"file-storage/internal/errs"
 	"file-storage/internal/filedata"
 	"file-storage/internal/imgproc"
+	"hash/crc32"
+	"image"
 	"image/color"
+	"image/color/palette"
+	"image/gif"
 	"reflect"
 	"testing"
 
@@ -31,6 +36,8 @@ func TestProcessImage(t *testing.T) {
 
 	bBadConfig := []byte("not an image")
 	bBadBody := b[:len(b)-2]
+	bBomb := pngHeader(t, 30000, 30000)
+	bAnimated := animatedGIF(t, 5)
 
 	table := []struct {
 		name          string
@@ -38,6 +45,9 @@ func TestProcessImage(t *testing.T) {
 		targetExt     string
 		targetWidth   int
 		targetHeight  int
+		mode          imgproc.Mode
+		opts          imgproc.EncodeOptions
+		limits        imgproc.Limits
 		wantb         []byte
 		wantImageInfo *filedata.ImageInfo
 		checkErrType  bool
@@ -46,7 +56,7 @@ func TestProcessImage(t *testing.T) {
 		{
 			name:          "unsupportet target format",
 			b:             b,
-			targetExt:     "webp",
+			targetExt:     "avif",
 			targetWidth:   1,
 			targetHeight:  1,
 			wantb:         nil,
@@ -87,6 +97,102 @@ func TestProcessImage(t *testing.T) {
 			checkErrType:  false,
 			wantErr:       errors.New(""),
 		},
+		{
+			name:         "decompression bomb",
+			b:            bBomb,
+			targetExt:    format,
+			targetWidth:  w,
+			targetHeight: h,
+			limits:       imgproc.Limits{MaxPixels: 50_000_000},
+			checkErrType: true,
+			wantErr:      errs.ErrImageTooLarge,
+		},
+		{
+			name:         "pixels limit",
+			b:            b,
+			targetExt:    format,
+			targetWidth:  w,
+			targetHeight: h,
+			limits:       imgproc.Limits{MaxPixels: w*h - 1},
+			checkErrType: true,
+			wantErr:      errs.ErrImageTooLarge,
+		},
+		{
+			name:         "decode memory limit",
+			b:            bBomb,
+			targetExt:    format,
+			targetWidth:  w,
+			targetHeight: h,
+			limits:       imgproc.Limits{MaxDecodeMemory: 512 << 20},
+			checkErrType: true,
+			wantErr:      errs.ErrImageTooLarge,
+		},
+		{
+			name:         "pad above pixels limit",
+			b:            b,
+			targetExt:    format,
+			targetWidth:  10000,
+			targetHeight: 10000,
+			mode:         imgproc.ModePad,
+			limits:       imgproc.Limits{MaxPixels: 50_000_000},
+			checkErrType: true,
+			wantErr:      errs.ErrImageTooLarge,
+		},
+		{
+			name:         "fill above pixels limit",
+			b:            b,
+			targetExt:    format,
+			targetWidth:  10000,
+			targetHeight: 10,
+			mode:         imgproc.ModeFill,
+			limits:       imgproc.Limits{MaxPixels: 50_000_000},
+			checkErrType: true,
+			wantErr:      errs.ErrImageTooLarge,
+		},
+		{
+			name:         "decode memory limit of source and transformed image",
+			b:            b,
+			targetExt:    format,
+			targetWidth:  1000,
+			targetHeight: 1000,
+			mode:         imgproc.ModePad,
+			limits:       imgproc.Limits{MaxDecodeMemory: 1000 * 1000 * 4},
+			checkErrType: true,
+			wantErr:      errs.ErrImageTooLarge,
+		},
+		{
+			name:          "decode memory of source and transformed image within limit",
+			b:             b,
+			targetExt:     format,
+			targetWidth:   1000,
+			targetHeight:  1000,
+			mode:          imgproc.ModePad,
+			limits:        imgproc.Limits{MaxDecodeMemory: 1000*1000*4 + w*h*7},
+			wantImageInfo: &filedata.ImageInfo{Format: imgproc.ImgFormat(format), Width: 1000, Height: 1000},
+			checkErrType:  true,
+			wantErr:       nil,
+		},
+		{
+			name:         "frames limit",
+			b:            bAnimated,
+			targetExt:    "gif",
+			targetWidth:  w,
+			targetHeight: h,
+			limits:       imgproc.Limits{MaxFrames: 4},
+			checkErrType: true,
+			wantErr:      errs.ErrImageTooLarge,
+		},
+		{
+			name:          "within limits",
+			b:             bAnimated,
+			targetExt:     "png",
+			targetWidth:   w,
+			targetHeight:  h,
+			limits:        imgproc.Limits{MaxPixels: w * h, MaxFrames: 5, MaxDecodeMemory: w * h * 9},
+			wantImageInfo: &filedata.ImageInfo{Format: imgproc.ImgFormat("png"), Width: w, Height: h},
+			checkErrType:  true,
+			wantErr:       nil,
+		},
 		{
 			name:          "want same image",
 			b:             b,
@@ -109,14 +215,103 @@ func TestProcessImage(t *testing.T) {
 			checkErrType:  true,
 			wantErr:       nil,
 		},
+		{
+			name:          "fit does not upscale",
+			b:             b,
+			targetExt:     "bmp",
+			targetWidth:   w * 2,
+			targetHeight:  h,
+			mode:          imgproc.ModeFit,
+			wantImageInfo: &filedata.ImageInfo{Format: imgproc.ImgFormat("bmp"), Width: w, Height: h},
+			checkErrType:  true,
+			wantErr:       nil,
+		},
+		{
+			name:          "fill",
+			b:             b,
+			targetExt:     format,
+			targetWidth:   w * 2,
+			targetHeight:  h / 2,
+			mode:          imgproc.ModeFill,
+			wantImageInfo: &filedata.ImageInfo{Format: imgproc.ImgFormat(format), Width: w * 2, Height: h / 2},
+			checkErrType:  true,
+			wantErr:       nil,
+		},
+		{
+			name:          "crop",
+			b:             b,
+			targetExt:     format,
+			targetWidth:   w / 2,
+			targetHeight:  h * 2,
+			mode:          imgproc.ModeCrop,
+			wantImageInfo: &filedata.ImageInfo{Format: imgproc.ImgFormat(format), Width: w / 2, Height: h},
+			checkErrType:  true,
+			wantErr:       nil,
+		},
+		{
+			name:          "pad",
+			b:             b,
+			targetExt:     format,
+			targetWidth:   w * 2,
+			targetHeight:  h / 2,
+			mode:          imgproc.ModePad,
+			wantImageInfo: &filedata.ImageInfo{Format: imgproc.ImgFormat(format), Width: w * 2, Height: h / 2},
+			checkErrType:  true,
+			wantErr:       nil,
+		},
+		{
+			name:          "reencode same image",
+			b:             b,
+			targetExt:     format,
+			targetWidth:   w,
+			targetHeight:  h,
+			opts:          imgproc.EncodeOptions{Quality: 10, Reencode: true},
+			wantImageInfo: &filedata.ImageInfo{Format: imgproc.ImgFormat(format), Width: w, Height: h},
+			checkErrType:  true,
+			wantErr:       nil,
+		},
+		{
+			name:          "webp",
+			b:             b,
+			targetExt:     "webp",
+			targetWidth:   w / 2,
+			targetHeight:  h / 2,
+			wantImageInfo: &filedata.ImageInfo{Format: imgproc.ImgFormat("webp"), Width: w / 2, Height: h / 2},
+			checkErrType:  true,
+			wantErr:       nil,
+		},
+		{
+			name:          "tiff",
+			b:             b,
+			targetExt:     "tiff",
+			targetWidth:   w,
+			targetHeight:  h,
+			wantImageInfo: &filedata.ImageInfo{Format: imgproc.ImgFormat("tiff"), Width: w, Height: h},
+			checkErrType:  true,
+			wantErr:       nil,
+		},
 	}
 
 	for _, tt := range table {
 		t.Run(tt.name, func(t *testing.T) {
-			bresult, imgInfo, err := ProcessImage(tt.b, tt.targetExt, tt.targetWidth, tt.targetHeight)
+			transform := imgproc.Transform{Width: tt.targetWidth, Height: tt.targetHeight, Mode: tt.mode}
+			bresult, imgInfo, err := ProcessImage(tt.b, tt.targetExt, transform, tt.opts, tt.limits)
 			if tt.wantb != nil && !bytes.Equal(tt.wantb, bresult) {
 				t.Errorf("bytes mismatch")
 			}
+			if tt.opts.Reencode && bytes.Equal(tt.b, bresult) {
+				t.Errorf("image was not re-encoded")
+			}
+			if tt.wantImageInfo != nil && err == nil {
+				format, width, height, err := imgproc.ImageConfig(bresult)
+				if err != nil {
+					t.Fatalf("result decoding error: %v", err)
+				}
+				got := &filedata.ImageInfo{Format: format, Width: width, Height: height}
+				if !reflect.DeepEqual(got, tt.wantImageInfo) {
+					t.Errorf("result image mismatch got %v want %v", got, tt.wantImageInfo)
+				}
+			}
 			if tt.wantImageInfo != nil {
 				if !reflect.DeepEqual(imgInfo, tt.wantImageInfo) {
 					t.Errorf("image info mismatch got %v want %v", imgInfo, tt.wantImageInfo)
@@ -137,3 +332,82 @@ func TestProcessImage(t *testing.T) {
 	}
 
 }
+
+func TestWebPQuality(t *testing.T) {
+	// a gradient with a pattern, closer to a photo than a flat color
+	img := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+	for y := range 256 {
+		for x := range 256 {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: uint8(x * y), A: 0xff})
+		}
+	}
+	b, err := imgproc.Encode(img, imaging.PNG)
+	if err != nil {
+		t.Fatalf("test image creation error: %v", err)
+	}
+
+	encode := func(quality int) []byte {
+		t.Helper()
+
+		transform := imgproc.Transform{Width: 256, Height: 256}
+		opts := imgproc.EncodeOptions{Quality: quality}
+		result, _, err := ProcessImage(b, "webp", transform, opts, imgproc.Limits{})
+		if err != nil {
+			t.Fatalf("process image error: %v", err)
+		}
+		return result
+	}
+
+	high := encode(90)
+	low := encode(20)
+
+	// the first chunk of a lossy WebP image is VP8, of a lossless one VP8L
+	if chunk := string(high[12:16]); chunk != "VP8 " {
+		t.Errorf("webp chunk got %q want %q", chunk, "VP8 ")
+	}
+	if len(low) >= len(high) {
+		t.Errorf("quality 20 size %d is not below quality 90 size %d", len(low), len(high))
+	}
+	if len(high) >= len(b) {
+		t.Errorf("quality 90 size %d is not below png size %d", len(high), len(b))
+	}
+}
+
+// pngHeader returns the beginning of a PNG image of the size, enough to read
+// its configuration.
+func pngHeader(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[8:], uint32(height))
+	ihdr[12] = 8 // bit depth
+	ihdr[13] = 6 // truecolor with alpha
+
+	b := []byte("\x89PNG\r\n\x1a\n")
+	b = binary.BigEndian.AppendUint32(b, uint32(len(ihdr)-4))
+	b = append(b, ihdr...)
+	b = binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(ihdr))
+
+	return b
+}
+
+// animatedGIF returns a 100x100 GIF image of the number of frames.
+func animatedGIF(t *testing.T, frames int) []byte {
+	t.Helper()
+
+	anim := gif.GIF{}
+	for range frames {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 100, 100), palette.Plan9))
+		anim.Delay = append(anim.Delay, 10)
+	}
+
+	var buf bytes.Buffer
+	err := gif.EncodeAll(&buf, &anim)
+	if err != nil {
+		t.Fatalf("test image creation error: %v", err)
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,101 @@
+package files
+
+import (
+	"context"
+	"file-storage/internal/errs"
+	"file-storage/internal/metrics"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// job results counted by metrics
+const (
+	jobResultOK       = "ok"
+	jobResultError    = "error"
+	jobResultRejected = "rejected"
+	jobResultCanceled = "canceled"
+)
+
+// imagePool bounds the number of images processed at the same time
+// independently of the number of HTTP requests. Up to queueSize jobs wait for
+// a free worker, further jobs are rejected with errs.ErrImageQueueFull. A pool
+// without workers does not limit processing.
+type imagePool struct {
+	workers   chan struct{}
+	queueSize int64
+	queued    atomic.Int64
+}
+
+func newImagePool(workers, queueSize int) *imagePool {
+	p := &imagePool{queueSize: int64(queueSize)}
+	if workers > 0 {
+		p.workers = make(chan struct{}, workers)
+	}
+
+	return p
+}
+
+// run runs the job on a worker of the pool, waiting for a free worker while
+// the context is not done.
+func (p *imagePool) run(ctx context.Context, job func() error) error {
+	start := time.Now()
+
+	err := p.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer p.release()
+
+	metrics.ImageJobWaitSeconds.Observe(time.Since(start).Seconds())
+	metrics.ImageJobsInProgress.Inc()
+	defer metrics.ImageJobsInProgress.Dec()
+
+	start = time.Now()
+	err = job()
+	metrics.ImageJobDurationSeconds.Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.ImageJobsTotal.WithLabelValues(jobResultError).Inc()
+		return err
+	}
+	metrics.ImageJobsTotal.WithLabelValues(jobResultOK).Inc()
+
+	return nil
+}
+
+func (p *imagePool) acquire(ctx context.Context) error {
+	if p.workers == nil {
+		return nil
+	}
+
+	select {
+	case p.workers <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if p.queued.Add(1) > p.queueSize {
+		p.queued.Add(-1)
+		metrics.ImageJobsTotal.WithLabelValues(jobResultRejected).Inc()
+		return errs.ErrImageQueueFull
+	}
+	metrics.ImageJobsQueued.Inc()
+	defer func() {
+		p.queued.Add(-1)
+		metrics.ImageJobsQueued.Dec()
+	}()
+
+	select {
+	case p.workers <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		metrics.ImageJobsTotal.WithLabelValues(jobResultCanceled).Inc()
+		return fmt.Errorf("waiting for image worker: %w", ctx.Err())
+	}
+}
+
+func (p *imagePool) release() {
+	if p.workers != nil {
+		<-p.workers
+	}
+}
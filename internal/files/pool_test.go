@@ -0,0 +1,114 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"file-storage/internal/errs"
+	"testing"
+	"time"
+)
+
+func TestImagePool(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	table := []struct {
+		name      string
+		workers   int
+		queueSize int
+		busy      int
+		ctx       context.Context
+		wantErr   error
+	}{
+		{
+			name:      "free worker",
+			workers:   1,
+			queueSize: 0,
+			ctx:       context.Background(),
+		},
+		{
+			name:      "not limited",
+			workers:   0,
+			queueSize: 0,
+			busy:      3,
+			ctx:       context.Background(),
+		},
+		{
+			name:      "queue full",
+			workers:   1,
+			queueSize: 0,
+			busy:      1,
+			ctx:       context.Background(),
+			wantErr:   errs.ErrImageQueueFull,
+		},
+		{
+			name:      "canceled while queued",
+			workers:   1,
+			queueSize: 1,
+			busy:      1,
+			ctx:       canceled,
+			wantErr:   context.Canceled,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newImagePool(tt.workers, tt.queueSize)
+
+			// busy jobs hold their workers until the test job is done
+			done := make(chan struct{})
+			defer close(done)
+			started := make(chan struct{})
+			for range tt.busy {
+				go p.run(context.Background(), func() error {
+					started <- struct{}{}
+					<-done
+					return nil
+				})
+				<-started
+			}
+
+			ran := false
+			err := p.run(tt.ctx, func() error {
+				ran = true
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("errors mismatch got %v want %v", err, tt.wantErr)
+			}
+			if ran != (tt.wantErr == nil) {
+				t.Errorf("job ran %v", ran)
+			}
+		})
+	}
+}
+
+func TestImagePoolQueue(t *testing.T) {
+	p := newImagePool(1, 1)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go p.run(context.Background(), func() error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+
+	result := make(chan error)
+	go func() {
+		result <- p.run(context.Background(), func() error { return nil })
+	}()
+
+	select {
+	case err := <-result:
+		t.Fatalf("queued job finished while the worker was busy: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	err := <-result
+	if err != nil {
+		t.Errorf("queued job error: %v", err)
+	}
+}
@@ -1,6 +1,8 @@
 package files
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
@@ -10,31 +12,98 @@ import (
 	"file-storage/internal/contextkeys"
 	"file-storage/internal/errs"
 	"file-storage/internal/filedata"
+	"file-storage/internal/imgproc"
+	"file-storage/internal/logger"
 	"fmt"
+	"hash"
+	"image/color"
 	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
 	"time"
+
+	"golang.org/x/sync/singleflight"
 )
 
 const (
 	minContentDimension = 10
 	maxContentDimension = 10000
+
+	defaultListLimit = 100
+	maxListLimit     = 1000
+
+	// metadata keys of fields extracted from EXIF
+	metadataKeyCameraMake  = "exif_camera_make"
+	metadataKeyCameraModel = "exif_camera_model"
+	metadataKeyTakenAt     = "exif_taken_at"
+
+	// sniffLen is the number of leading bytes used for content type detection.
+	sniffLen = 512
 )
 
+// autoFormats are output formats chosen by format negotiation, most preferred
+// first. The configured format is used when the client accepts none of them.
+var autoFormats = []imgproc.ImgFormat{imgproc.ImgFormatWEBP}
+
+// defaultBackground is the padding color used when a request does not specify one.
+var defaultBackground = color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+
 // Service implements file business logic on top of storage.
 type Service struct {
-	cfg     *config.Image
-	storage Storage
+	cfg        *config.Image
+	storage    Storage
+	renditions RenditionCache
+	versions   VersionStorage
+	trash      TrashStorage
+	backup     BackupStorage
+	scrub      ScrubStorage
+	originals  OriginalStorage
+	processing singleflight.Group
+	images     *imagePool
 }
 
 // NewService creates a Service with image processing settings and a storage implementation.
+// Transformed images are cached when the storage implements RenditionCache and
+// the cache is enabled in configuration. Previous versions are available when
+// the storage implements VersionStorage, deleted files when it implements
+// TrashStorage, backups when it implements BackupStorage, integrity checks
+// when it implements ScrubStorage and original uploads of images when it
+// implements OriginalStorage and supports them. Originals are kept for new uploads only when
+// enabled in configuration. Images are processed by a pool of workers bounded
+// by the processing settings.
 func NewService(cfg *config.Image, storage Storage) *Service {
-	return &Service{cfg: cfg, storage: storage}
+	s := &Service{cfg: cfg, storage: storage}
+	s.images = newImagePool(cfg.Processing.Workers, cfg.Processing.QueueSize)
+
+	if rc, ok := storage.(RenditionCache); ok && cfg.RenditionCache {
+		s.renditions = rc
+	}
+	if vs, ok := storage.(VersionStorage); ok {
+		s.versions = vs
+	}
+	if ts, ok := storage.(TrashStorage); ok {
+		s.trash = ts
+	}
+	if bs, ok := storage.(BackupStorage); ok {
+		s.backup = bs
+	}
+	if ss, ok := storage.(ScrubStorage); ok {
+		s.scrub = ss
+	}
+	if ost, ok := storage.(OriginalStorage); ok && ost.OriginalsSupported() {
+		s.originals = ost
+	}
+
+	return s
 }
 
 // Update validates input data and stores file content and metadata.
-// The operation is idempotent for the same file ID.
+// The operation is idempotent for the same file ID. Eager presets of new
+// image content are rendered before it returns.
 func (s *Service) Update(ctx context.Context, uc *filedata.UploadCommand) (string, error) {
-
 	updateData := true
 	createdAt := time.Now()
 
@@ -48,21 +117,42 @@ func (s *Service) Update(ctx context.Context, uc *filedata.UploadCommand) (strin
 	}
 
 	if fi != nil {
-		updateData = (uc.Hash != fi.HashSource && uc.Hash != fi.HashStored)
+		if uc.Hash != "" {
+			updateData = (uc.Hash != fi.HashSource && uc.Hash != fi.HashStored)
+		}
 		createdAt = fi.CreatedAt
 	}
 
 	var fd filedata.FileData
 	var imageInfo *filedata.ImageInfo
+	var exifInfo *imgproc.Exif
+	var data []byte
+	var original []byte
+	hashSource := uc.Hash
 	newHashStored := ""
 
-	data := uc.Data
+	// images are decoded as a whole, so only they are read into memory
+	if updateData && uc.IsImage {
+		var err error
+		data, hashSource, err = readUploadData(uc.Data)
+		if err != nil {
+			return "", fmt.Errorf("upload data error: %w", err)
+		}
+		if fi != nil && uc.Hash == "" {
+			updateData = (hashSource != fi.HashSource && hashSource != fi.HashStored)
+		}
+	}
+
 	if updateData {
 		if uc.IsImage {
+			if s.originals != nil && s.cfg.KeepOriginal {
+				original = data
+			}
+
 			var err error
-			data, imageInfo, err = ProcessImage(data, s.cfg.Ext, s.cfg.MaxDimension, s.cfg.MaxDimension)
+			data, imageInfo, exifInfo, err = s.normalizeImage(ctx, data)
 			if err != nil {
-				return "", fmt.Errorf("image processing error: %w", err)
+				return "", err
 			}
 
 			sum := sha256.Sum256(data)
@@ -77,8 +167,8 @@ func (s *Service) Update(ctx context.Context, uc *filedata.UploadCommand) (strin
 	if updateData {
 		fd = filedata.FileData{
 			ID:         uc.ID,
-			Data:       data,
-			HashSource: uc.Hash,
+			Data:       bytes.NewReader(data),
+			HashSource: hashSource,
 			HashStored: newHashStored,
 			Public:     uc.Public,
 			IsImage:    uc.IsImage,
@@ -92,52 +182,139 @@ func (s *Service) Update(ctx context.Context, uc *filedata.UploadCommand) (strin
 			fd.Format = imageInfo.Format
 			fd.Width = imageInfo.Width
 			fd.Height = imageInfo.Height
+			fd.MimeType = imgproc.MimeType(imageInfo.Format)
+		}
+
+		if original != nil {
+			fd.Original = bytes.NewReader(original)
+			fd.OriginalInfo = s.originalInfo(original)
+		}
+
+		if s.cfg.Exif.RecordMetadata {
+			fd.Metadata = withExifMetadata(fd.Metadata, exifInfo)
+		}
+
+		if !uc.IsImage {
+			if uc.Data == nil {
+				return "", errs.ErrNoDataToUpload
+			}
+			stream, detectedMimeType, err := sniffMimeType(uc.Data)
+			if err != nil {
+				return "", fmt.Errorf("upload data error: %w", err)
+			}
+			fd.Data = newDigestReader(stream, &fd)
+			fd.MimeType = detectedMimeType
+			if uc.MimeType != "" {
+				fd.MimeType = uc.MimeType
+			}
 		}
 	} else {
 		fd = filedata.FileData{
-			ID:         uc.ID,
-			Data:       nil,
-			HashSource: fi.HashSource,
-			HashStored: fi.HashStored,
-			Public:     uc.Public,
-			IsImage:    fi.IsImage,
-			FileSize:   fi.FileSize,
-			Metadata:   uc.Metadata,
-			UpdatedAt:  time.Now(),
-			CreatedAt:  createdAt,
-			Format:     fi.Format,
-			Width:      fi.Width,
-			Height:     fi.Height,
+			ID:           uc.ID,
+			Data:         nil,
+			HashSource:   fi.HashSource,
+			HashStored:   fi.HashStored,
+			Public:       uc.Public,
+			IsImage:      fi.IsImage,
+			FileSize:     fi.FileSize,
+			Metadata:     uc.Metadata,
+			UpdatedAt:    time.Now(),
+			CreatedAt:    createdAt,
+			Format:       fi.Format,
+			Width:        fi.Width,
+			Height:       fi.Height,
+			MimeType:     fi.MimeType,
+			OriginalInfo: fi.Original,
+		}
+
+		if uc.MimeType != "" && !fi.IsImage {
+			fd.MimeType = uc.MimeType
 		}
 	}
 
+	fd.Filename = uc.Filename
+	if fd.Filename == "" && fi != nil {
+		fd.Filename = fi.Filename
+	}
+
 	ID, err := s.storage.Upsert(ctx, &fd)
 	if err != nil {
 		return "", fmt.Errorf("storage error: %w", err)
 	}
 
+	if updateData && imageInfo != nil {
+		stored := filedata.FileInfoFromFileData(&fd)
+		stored.ID = ID
+		s.renderPresets(ctx, stored, data)
+	}
+
 	return ID, nil
 }
 
-// Content returns file content by ID with optional image transformations.
-// The method returns only file bytes.
-func (s *Service) Content(ctx context.Context, cc *filedata.ContentCommand) ([]byte, error) {
+// Content returns file content by ID with optional image transformations or
+// a configured preset. The stored stream is returned as is when no
+// transformation is required.
+// The caller must close the returned content.
+func (s *Service) Content(ctx context.Context, cc *filedata.ContentCommand) (*filedata.Content, error) {
+	err := s.checkReadAccess(ctx, cc.ID)
+	if err != nil {
+		return nil, err
+	}
 
-	auth, ok := ctx.Value(contextkeys.ContextKeyAuth).(*authorization.Auth)
-	if !ok {
-		return nil, fmt.Errorf("failed to get Auth structure out of context: %w", errs.ErrContextValueError)
+	err = s.checkSignature(ctx, cc)
+	if err != nil {
+		return nil, err
 	}
 
-	if !auth.Read {
-		fi, err := s.Info(ctx, cc.ID)
+	if cc.Preset != "" {
+		cc, err = s.presetCommand(cc.ID, cc.Preset)
 		if err != nil {
-			return nil, fmt.Errorf("storage info error: %w", err)
-		}
-		if !fi.Public {
-			return nil, errs.ErrAccessDenied
+			return nil, err
 		}
+	} else if s.cfg.PresetsOnly && hasTransform(cc) {
+		return nil, fmt.Errorf("only presets are allowed: %w", errs.ErrWrongUrlParameter)
+	}
+
+	format, transform, err := s.contentRendition(cc)
+	if err != nil {
+		return nil, err
+	}
+
+	cd, err := s.storage.Content(ctx, cc.ID)
+	if err != nil {
+		return nil, fmt.Errorf("storage error: %w", err)
+	}
+
+	fi := cd.Info
+	content := storedContent(cd)
+
+	if !fi.IsImage || !needsProcessing(fi, format, transform, cc.Quality) {
+		return &content, nil
+	}
+	defer cd.Data.Close()
+
+	targetFormat, ok := imgproc.SupportedOutputFormat(format)
+	if !ok {
+		return nil, fmt.Errorf("unsupported target image format %s: %w", format, errs.ErrUnsupportedImageFormat)
+	}
+
+	opts := s.encodeOptions(targetFormat, cc.Quality)
+	key := renditionKey(fi, targetFormat, transform, opts)
+
+	content.Data, err = s.rendition(ctx, key, transform, opts, cd.Data)
+	if err != nil {
+		return nil, err
 	}
+	content.ETag = key.Name()
+	content.ContentType = imgproc.MimeType(targetFormat)
+	content.Filename = replaceExt(fi.Filename, targetFormat)
 
+	return &content, nil
+}
+
+// contentRendition resolves the output format and the transformation of a
+// content command with service defaults.
+func (s *Service) contentRendition(cc *filedata.ContentCommand) (string, imgproc.Transform, error) {
 	var format string
 	var width int
 	var height int
@@ -147,11 +324,14 @@ func (s *Service) Content(ctx context.Context, cc *filedata.ContentCommand) ([]b
 	} else {
 		format = s.cfg.Ext
 	}
+	if format == filedata.FormatAuto {
+		format = s.negotiateFormat(cc.Accept)
+	}
 
 	if cc.Width != nil {
 		width = *cc.Width
 		if width < minContentDimension || width > maxContentDimension {
-			return nil, fmt.Errorf("width must be between %d and %d: %w", minContentDimension, maxContentDimension, errs.ErrWrongUrlParameter)
+			return "", imgproc.Transform{}, fmt.Errorf("width must be between %d and %d: %w", minContentDimension, maxContentDimension, errs.ErrWrongUrlParameter)
 		}
 	} else {
 		width = s.cfg.MaxDimension
@@ -160,31 +340,525 @@ func (s *Service) Content(ctx context.Context, cc *filedata.ContentCommand) ([]b
 	if cc.Height != nil {
 		height = *cc.Height
 		if height < minContentDimension || height > maxContentDimension {
-			return nil, fmt.Errorf("height must be between %d and %d: %w", minContentDimension, maxContentDimension, errs.ErrWrongUrlParameter)
+			return "", imgproc.Transform{}, fmt.Errorf("height must be between %d and %d: %w", minContentDimension, maxContentDimension, errs.ErrWrongUrlParameter)
 		}
 	} else {
 		height = s.cfg.MaxDimension
 	}
 
-	cd, err := s.storage.Content(ctx, cc.ID)
+	if cc.Quality != nil && (*cc.Quality < 1 || *cc.Quality > 100) {
+		return "", imgproc.Transform{}, fmt.Errorf("quality must be between 1 and 100: %w", errs.ErrWrongUrlParameter)
+	}
+
+	transform, err := contentTransform(cc, width, height)
 	if err != nil {
-		return nil, fmt.Errorf("storage error: %w", err)
+		return "", imgproc.Transform{}, err
 	}
-	defer cd.Data.Close()
 
-	b, err := io.ReadAll(cd.Data)
+	return format, transform, nil
+}
+
+// checkSignature requires a signed URL for transformations requested without
+// the read permission when configured. Unsigned presets are exempt.
+func (s *Service) checkSignature(ctx context.Context, cc *filedata.ContentCommand) error {
+	if !s.cfg.SignedTransforms {
+		return nil
+	}
+
+	auth, ok := ctx.Value(contextkeys.ContextKeyAuth).(*authorization.Auth)
+	if !ok {
+		return fmt.Errorf("failed to get Auth structure out of context: %w", errs.ErrContextValueError)
+	}
+	if auth.Read || auth.Signed {
+		return nil
+	}
+
+	if cc.Preset != "" {
+		if slices.Contains(s.cfg.UnsignedPresets, cc.Preset) {
+			return nil
+		}
+		return errs.ErrSignatureRequired
+	}
+	if hasTransform(cc) {
+		return errs.ErrSignatureRequired
+	}
+
+	return nil
+}
+
+// presetCommand builds the content command of a configured preset.
+func (s *Service) presetCommand(ID, name string) (*filedata.ContentCommand, error) {
+	spec, ok := s.cfg.Presets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown preset %q: %w", name, errs.ErrWrongUrlParameter)
+	}
+
+	p, err := imgproc.ParsePreset(spec)
 	if err != nil {
-		return nil, fmt.Errorf("data read error: %w: %v", errs.ErrInvalidFileData, err)
+		return nil, fmt.Errorf("preset %q: %w: %v", name, errs.ErrConfigInvalidPreset, err)
+	}
+
+	cc := filedata.ContentCommand{ID: ID, Mode: p.Mode, Gravity: p.Gravity, Background: p.Background}
+	if p.Width > 0 {
+		cc.Width = &p.Width
+	}
+	if p.Height > 0 {
+		cc.Height = &p.Height
+	}
+	if p.Format != "" {
+		format := string(p.Format)
+		cc.Format = &format
+	}
+	if p.Quality > 0 {
+		cc.Quality = &p.Quality
+	}
+
+	return &cc, nil
+}
+
+// hasTransform reports whether the content command sets any image
+// transformation parameter.
+func hasTransform(cc *filedata.ContentCommand) bool {
+	return cc.Width != nil || cc.Height != nil || cc.Format != nil || cc.Mode != "" ||
+		cc.Gravity != "" || cc.Background != nil || cc.Quality != nil
+}
+
+// renditionKey identifies the rendition of the stored version of the file.
+func renditionKey(fi *filedata.FileInfo, format imgproc.ImgFormat, transform imgproc.Transform, opts imgproc.EncodeOptions) filedata.RenditionKey {
+	key := filedata.RenditionKey{
+		ID:      fi.ID,
+		Hash:    fi.ContentHash(),
+		Width:   transform.Width,
+		Height:  transform.Height,
+		Mode:    transform.Mode,
+		Gravity: transform.Gravity,
+		Format:  format,
+	}
+	if transform.Mode == imgproc.ModePad {
+		key.Background = imgproc.FormatColor(transform.Background)
+	}
+	if imgproc.IsLossy(format) {
+		key.Quality = opts.Quality
+	}
+
+	return key
+}
+
+// renderPresets renders the eager presets of a stored image into the
+// rendition cache. Failures are logged, because renditions are rendered again
+// on request.
+func (s *Service) renderPresets(ctx context.Context, fi *filedata.FileInfo, data []byte) {
+	if s.renditions == nil || !fi.IsImage {
+		return
 	}
 
-	if cd.IsImage {
-		b, _, err = ProcessImage(b, format, width, height)
+	for _, name := range s.cfg.EagerPresets {
+		err := s.renderPreset(ctx, fi, data, name)
+		if err != nil {
+			logger.FromContext(ctx).Warn("preset rendering error", "id", fi.ID, "preset", name, slog.Any(logger.LogFieldError, err))
+		}
+	}
+}
+
+func (s *Service) renderPreset(ctx context.Context, fi *filedata.FileInfo, data []byte, name string) error {
+	cc, err := s.presetCommand(fi.ID, name)
+	if err != nil {
+		return err
+	}
+
+	format, transform, err := s.contentRendition(cc)
+	if err != nil {
+		return err
+	}
+	if !needsProcessing(fi, format, transform, cc.Quality) {
+		return nil
+	}
+
+	targetFormat, ok := imgproc.SupportedOutputFormat(format)
+	if !ok {
+		return fmt.Errorf("unsupported target image format %s: %w", format, errs.ErrUnsupportedImageFormat)
+	}
+
+	opts := s.encodeOptions(targetFormat, cc.Quality)
+	r, err := s.rendition(ctx, renditionKey(fi, targetFormat, transform, opts), transform, opts, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	return r.Close()
+}
+
+// Original returns the original upload of an image file as it was uploaded.
+// Access is checked the same way as for Content. The caller must close the
+// returned content.
+func (s *Service) Original(ctx context.Context, ID string) (*filedata.Content, error) {
+	if s.originals == nil {
+		return nil, errs.ErrOriginalsNotSupported
+	}
+
+	err := s.checkReadAccess(ctx, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	cd, err := s.originals.Original(ctx, ID)
+	if err != nil {
+		return nil, fmt.Errorf("storage error: %w", err)
+	}
+
+	fi := cd.Info
+	return &filedata.Content{
+		Data:        cd.Data,
+		ETag:        fi.HashSource,
+		ModTime:     fi.UpdatedAt,
+		ContentType: imgproc.MimeType(fi.Original.Format),
+		Filename:    fi.Filename,
+	}, nil
+}
+
+// checkReadAccess allows reading content of public files without the read
+// permission and of any file by a signed URL.
+func (s *Service) checkReadAccess(ctx context.Context, ID string) error {
+	auth, ok := ctx.Value(contextkeys.ContextKeyAuth).(*authorization.Auth)
+	if !ok {
+		return fmt.Errorf("failed to get Auth structure out of context: %w", errs.ErrContextValueError)
+	}
+
+	if !auth.Read && !auth.Presigned {
+		fi, err := s.Info(ctx, ID)
+		if err != nil {
+			return fmt.Errorf("storage info error: %w", err)
+		}
+		if !fi.Public {
+			return errs.ErrAccessDenied
+		}
+	}
+
+	return nil
+}
+
+// storedContent returns the stored content of a file version as is.
+func storedContent(cd *filedata.ContentData) filedata.Content {
+	fi := cd.Info
+	content := filedata.Content{
+		Data:        cd.Data,
+		ETag:        fi.ContentHash(),
+		ModTime:     fi.UpdatedAt,
+		ContentType: fi.MimeType,
+		Filename:    fi.Filename,
+	}
+	if content.ContentType == "" && fi.IsImage {
+		content.ContentType = imgproc.MimeType(fi.Format)
+	}
+
+	return content
+}
+
+// rendition returns the transformed image for the key, taking it from the
+// rendition cache when possible. Concurrent requests for the same rendition
+// share a single processing run.
+func (s *Service) rendition(ctx context.Context, key filedata.RenditionKey, transform imgproc.Transform, opts imgproc.EncodeOptions, source io.Reader) (io.ReadSeekCloser, error) {
+	if s.renditions != nil {
+		r, err := s.renditions.Rendition(ctx, key)
+		if err == nil {
+			return r, nil
+		}
+		if !errors.Is(err, errs.ErrNotFound) {
+			logger.FromContext(ctx).Warn("rendition cache read error", "id", key.ID, slog.Any(logger.LogFieldError, err))
+		}
+	}
+
+	v, err, _ := s.processing.Do(key.ID+"."+key.Name(), func() (any, error) {
+		b, err := io.ReadAll(source)
+		if err != nil {
+			return nil, fmt.Errorf("data read error: %w: %v", errs.ErrInvalidFileData, err)
+		}
+
+		err = s.images.run(ctx, func() error {
+			b, _, err = ProcessImage(b, string(key.Format), transform, opts, s.limits())
+			return err
+		})
 		if err != nil {
 			return nil, fmt.Errorf("processing image error: %w", err)
 		}
+
+		if s.renditions != nil {
+			err = s.renditions.PutRendition(ctx, key, b)
+			if err != nil {
+				logger.FromContext(ctx).Warn("rendition cache write error", "id", key.ID, slog.Any(logger.LogFieldError, err))
+			}
+		}
+
+		return b, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return filedata.NopSeekCloser(bytes.NewReader(v.([]byte))), nil
+}
+
+// normalizeImage converts an uploaded image into the stored form according to
+// configuration and returns it with its format and dimensions and the EXIF
+// metadata of the upload.
+func (s *Service) normalizeImage(ctx context.Context, b []byte) ([]byte, *filedata.ImageInfo, *imgproc.Exif, error) {
+	exifInfo := imgproc.ReadExif(b)
+	format, _ := imgproc.SupportedOutputFormat(s.cfg.Ext)
+	opts := s.encodeOptions(format, nil)
+	if opts.StripMetadata {
+		opts.Exif = exifInfo.Segment(s.cfg.Exif.KeepTags)
+	}
+	transform := imgproc.Transform{Width: s.cfg.MaxDimension, Height: s.cfg.MaxDimension, Mode: imgproc.ModeFit}
+
+	var imageInfo *filedata.ImageInfo
+	err := s.images.run(ctx, func() error {
+		var err error
+		b, imageInfo, err = ProcessImage(b, s.cfg.Ext, transform, opts, s.limits())
+		return err
+	})
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("image processing error: %w", err)
+	}
+
+	return b, imageInfo, exifInfo, nil
+}
+
+// originalInfo describes the original upload of an image that was stored with
+// the current image settings.
+func (s *Service) originalInfo(b []byte) *filedata.OriginalInfo {
+	oi := filedata.OriginalInfo{Size: len(b), Settings: s.imageSettings()}
+	oi.Format, oi.Width, oi.Height, _ = imgproc.ImageConfig(b)
+
+	return &oi
+}
+
+// imageSettings identifies the settings stored images are produced with.
+// Encoder settings are included only for the format they apply to, so
+// changing them does not affect images of other formats.
+func (s *Service) imageSettings() string {
+	format, _ := imgproc.SupportedOutputFormat(s.cfg.Ext)
+	settings := fmt.Sprintf("%s:%d", format, s.cfg.MaxDimension)
+
+	switch format {
+	case imgproc.ImgFormatJPEG:
+		settings += fmt.Sprintf(":q%d", s.cfg.JPEGQuality)
+	case imgproc.ImgFormatWEBP:
+		settings += fmt.Sprintf(":q%d", s.cfg.WebPQuality)
+	case imgproc.ImgFormatPNG:
+		compression := s.cfg.PNGCompression
+		if compression == "" {
+			compression = imgproc.PNGCompressionDefault
+		}
+		settings += ":" + compression
+	}
+	if s.cfg.Exif.Strip {
+		settings += ":strip"
+		if len(s.cfg.Exif.KeepTags) > 0 {
+			settings += "=" + strings.Join(s.cfg.Exif.KeepTags, ",")
+		}
+	}
+
+	return settings
+}
+
+// negotiateFormat returns the most preferred output format accepted by the
+// client or the configured format if none is accepted.
+func (s *Service) negotiateFormat(accept []string) string {
+	for _, mimeType := range accept {
+		for _, format := range autoFormats {
+			if mimeType == imgproc.MimeType(format) {
+				return string(format)
+			}
+		}
+	}
+
+	return s.cfg.Ext
+}
+
+// encodeOptions returns encoder settings of the output format from
+// configuration. An explicit quality overrides the configured one and forces
+// re-encoding.
+func (s *Service) encodeOptions(format imgproc.ImgFormat, quality *int) imgproc.EncodeOptions {
+	opts := imgproc.EncodeOptions{Quality: s.cfg.JPEGQuality, StripMetadata: s.cfg.Exif.Strip}
+	if format == imgproc.ImgFormatWEBP {
+		opts.Quality = s.cfg.WebPQuality
+	}
+	opts.Compression, _ = imgproc.PNGCompressionLevel(s.cfg.PNGCompression)
+
+	if quality != nil {
+		opts.Quality = *quality
+		opts.Reencode = true
+	}
+
+	return opts
+}
+
+// limits returns the limits of images accepted for processing.
+func (s *Service) limits() imgproc.Limits {
+	return imgproc.Limits{
+		MaxPixels:       s.cfg.Processing.MaxPixels,
+		MaxFrames:       s.cfg.Processing.MaxFrames,
+		MaxDecodeMemory: s.cfg.Processing.MaxDecodeMemory,
+	}
+}
+
+// contentTransform builds the image transformation of a content request.
+// Modes other than fit produce exact dimensions, so they require both of them.
+// Gravity and background are cleared when the mode does not use them, so that
+// equivalent requests share a rendition.
+func contentTransform(cc *filedata.ContentCommand, width, height int) (imgproc.Transform, error) {
+	t := imgproc.Transform{
+		Width:   width,
+		Height:  height,
+		Mode:    cc.Mode,
+		Gravity: cc.Gravity,
+	}
+
+	if t.Mode == "" {
+		t.Mode = imgproc.ModeFit
+	}
+	if t.Mode != imgproc.ModeFit && (cc.Width == nil || cc.Height == nil) {
+		return imgproc.Transform{}, fmt.Errorf("width and height are required for %s mode: %w", t.Mode, errs.ErrWrongUrlParameter)
+	}
+
+	switch t.Mode {
+	case imgproc.ModeFit:
+		t.Gravity = ""
+	case imgproc.ModePad:
+		t.Background = defaultBackground
+		if cc.Background != nil {
+			t.Background = *cc.Background
+		}
+		if t.Gravity == imgproc.GravitySmart {
+			t.Gravity = imgproc.GravityCenter
+		}
+	}
+	if t.Mode != imgproc.ModeFit && t.Gravity == "" {
+		t.Gravity = imgproc.GravityCenter
+	}
+
+	return t, nil
+}
+
+// needsProcessing reports whether the stored image differs from the requested
+// rendition according to its recorded format and dimensions. An explicit
+// quality for a lossy format always requires re-encoding.
+func needsProcessing(fi *filedata.FileInfo, format string, t imgproc.Transform, quality *int) bool {
+	targetFormat, ok := imgproc.SupportedOutputFormat(format)
+	if !ok || targetFormat != fi.Format {
+		return true
+	}
+
+	if quality != nil && imgproc.IsLossy(targetFormat) {
+		return true
+	}
+
+	if fi.Width <= 0 || fi.Height <= 0 {
+		return true
+	}
+
+	return !t.IsNoop(fi.Width, fi.Height)
+}
+
+// withExifMetadata returns a copy of the metadata with camera and capture time
+// of the image recorded. Values supplied by the client under the same keys are kept.
+func withExifMetadata(metadata map[string]any, x *imgproc.Exif) map[string]any {
+	fields := make(map[string]any, 3)
+	cameraMake, cameraModel := x.Camera()
+	if cameraMake != "" {
+		fields[metadataKeyCameraMake] = cameraMake
+	}
+	if cameraModel != "" {
+		fields[metadataKeyCameraModel] = cameraModel
+	}
+	if takenAt := x.TakenAt(); !takenAt.IsZero() {
+		// EXIF does not record the time zone, so the camera local time is kept
+		fields[metadataKeyTakenAt] = takenAt.Format("2006-01-02T15:04:05")
+	}
+	if len(fields) == 0 {
+		return metadata
+	}
+
+	result := make(map[string]any, len(metadata)+len(fields))
+	for k, v := range fields {
+		result[k] = v
+	}
+	for k, v := range metadata {
+		result[k] = v
+	}
+
+	return result
+}
+
+// replaceExt replaces the extension of a file name with the one of the image format.
+func replaceExt(filename string, format imgproc.ImgFormat) string {
+	if filename == "" {
+		return ""
+	}
+
+	return strings.TrimSuffix(filename, path.Ext(filename)) + "." + string(format)
+}
+
+// sniffMimeType detects the MIME type of a stream from its leading bytes and
+// returns a reader that still yields the whole stream.
+func sniffMimeType(r io.Reader) (io.Reader, string, error) {
+	br := bufio.NewReaderSize(r, sniffLen)
+
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, "", fmt.Errorf("data read error: %w", err)
+	}
+
+	return br, http.DetectContentType(head), nil
+}
+
+// readUploadData reads upload data while computing its SHA-256 on the fly.
+func readUploadData(r io.Reader) ([]byte, string, error) {
+	if r == nil {
+		return nil, "", errs.ErrNoDataToUpload
+	}
+
+	h := sha256.New()
+	b, err := io.ReadAll(io.TeeReader(r, h))
+	if err != nil {
+		return nil, "", fmt.Errorf("data read error: %w", err)
+	}
+	if len(b) == 0 {
+		return nil, "", errs.ErrNoDataToUpload
+	}
+
+	return b, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// digestReader computes SHA-256 and size of the stream passed to storage and
+// completes HashSource and FileSize of the file data when the stream ends.
+type digestReader struct {
+	r    io.Reader
+	h    hash.Hash
+	n    int
+	fd   *filedata.FileData
+	done bool
+}
+
+func newDigestReader(r io.Reader, fd *filedata.FileData) *digestReader {
+	return &digestReader{r: r, h: sha256.New(), fd: fd}
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.h.Write(p[:n])
+	d.n += n
+
+	if err == io.EOF && !d.done {
+		if d.n == 0 {
+			return n, errs.ErrNoDataToUpload
+		}
+		d.done = true
+		d.fd.HashSource = hex.EncodeToString(d.h.Sum(nil))
+		d.fd.FileSize = d.n
 	}
 
-	return b, nil
+	return n, err
 }
 
 // Info returns file metadata by ID.
@@ -199,6 +873,244 @@ func (s *Service) Info(ctx context.Context, ID string) (*filedata.FileInfo, erro
 	return fi, nil
 }
 
+// List returns a page of files matching the query. A zero limit means defaultListLimit.
+func (s *Service) List(ctx context.Context, q *filedata.ListQuery) (*filedata.FileList, error) {
+	if q.Limit == 0 {
+		q.Limit = defaultListLimit
+	}
+	if q.Limit < 0 || q.Limit > maxListLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %d: %w", maxListLimit, errs.ErrWrongUrlParameter)
+	}
+
+	fl, err := s.storage.List(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("storage error: %w", err)
+	}
+
+	return fl, nil
+}
+
+// Versions returns retained previous versions of a file, newest first.
+func (s *Service) Versions(ctx context.Context, ID string) (*filedata.VersionList, error) {
+	if s.versions == nil {
+		return nil, errs.ErrVersionsNotSupported
+	}
+
+	versions, err := s.versions.Versions(ctx, ID)
+	if err != nil {
+		return nil, fmt.Errorf("storage error: %w", err)
+	}
+
+	return &filedata.VersionList{Versions: versions}, nil
+}
+
+// VersionContent returns content of a retained version of a file as stored,
+// without image transformations. The caller must close the returned content.
+func (s *Service) VersionContent(ctx context.Context, ID string, version int) (*filedata.Content, error) {
+	if s.versions == nil {
+		return nil, errs.ErrVersionsNotSupported
+	}
+
+	cd, err := s.versions.VersionContent(ctx, ID, version)
+	if err != nil {
+		return nil, fmt.Errorf("storage error: %w", err)
+	}
+
+	content := storedContent(cd)
+	return &content, nil
+}
+
+// RestoreVersion makes a retained version of a file the current one and
+// returns its metadata. The replaced version is retained like on any update.
+func (s *Service) RestoreVersion(ctx context.Context, ID string, version int) (*filedata.FileInfo, error) {
+	if s.versions == nil {
+		return nil, errs.ErrVersionsNotSupported
+	}
+
+	fi, err := s.versions.RestoreVersion(ctx, ID, version)
+	if err != nil {
+		return nil, fmt.Errorf("storage error: %w", err)
+	}
+
+	return fi, nil
+}
+
+// Trash returns a page of deleted files in ascending ID order. A zero limit
+// means defaultListLimit.
+func (s *Service) Trash(ctx context.Context, cursor string, limit int) (*filedata.TrashList, error) {
+	if s.trash == nil {
+		return nil, errs.ErrTrashNotSupported
+	}
+	if limit == 0 {
+		limit = defaultListLimit
+	}
+	if limit < 0 || limit > maxListLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %d: %w", maxListLimit, errs.ErrWrongUrlParameter)
+	}
+
+	tl, err := s.trash.Trash(ctx, cursor, limit)
+	if err != nil {
+		return nil, fmt.Errorf("storage error: %w", err)
+	}
+
+	return tl, nil
+}
+
+// Restore moves a deleted file back from the trash and returns its metadata.
+func (s *Service) Restore(ctx context.Context, ID string) (*filedata.FileInfo, error) {
+	if s.trash == nil {
+		return nil, errs.ErrTrashNotSupported
+	}
+
+	fi, err := s.trash.Restore(ctx, ID)
+	if err != nil {
+		return nil, fmt.Errorf("storage error: %w", err)
+	}
+
+	return fi, nil
+}
+
+// Backup writes an archive of all current files to w.
+func (s *Service) Backup(ctx context.Context, w io.Writer) error {
+	if s.backup == nil {
+		return errs.ErrBackupNotSupported
+	}
+
+	err := s.backup.Backup(ctx, w)
+	if err != nil {
+		return fmt.Errorf("storage error: %w", err)
+	}
+
+	return nil
+}
+
+// Scrub verifies stored content of the file against its content hash.
+func (s *Service) Scrub(ctx context.Context, ID string) (*filedata.ScrubResult, error) {
+	if s.scrub == nil {
+		return nil, errs.ErrScrubNotSupported
+	}
+
+	sr, err := s.scrub.Scrub(ctx, ID)
+	if err != nil {
+		return nil, fmt.Errorf("storage error: %w", err)
+	}
+
+	return sr, nil
+}
+
+// Renormalize regenerates the stored image of the file from its original when
+// the image settings changed since it was stored and reports whether it was
+// regenerated. The file is left as is when it was replaced meanwhile.
+func (s *Service) Renormalize(ctx context.Context, ID string) (bool, error) {
+	if s.originals == nil {
+		return false, errs.ErrOriginalsNotSupported
+	}
+
+	cd, err := s.originals.Original(ctx, ID)
+	if err != nil {
+		return false, fmt.Errorf("storage error: %w", err)
+	}
+	defer cd.Data.Close()
+
+	fi := cd.Info
+	settings := s.imageSettings()
+	if fi.Original.Settings == settings {
+		return false, nil
+	}
+
+	b, err := io.ReadAll(cd.Data)
+	if err != nil {
+		return false, fmt.Errorf("original read error: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	if hex.EncodeToString(sum[:]) != fi.HashSource {
+		return false, fmt.Errorf("original of file %s: %w", ID, errs.ErrHashMismatch)
+	}
+
+	data, imageInfo, _, err := s.normalizeImage(ctx, b)
+	if err != nil {
+		return false, err
+	}
+	sum = sha256.Sum256(data)
+
+	original := *fi.Original
+	original.Settings = settings
+	fd := filedata.FileData{
+		ID:           ID,
+		Data:         bytes.NewReader(data),
+		HashSource:   fi.HashSource,
+		HashStored:   hex.EncodeToString(sum[:]),
+		Public:       fi.Public,
+		FileSize:     len(data),
+		IsImage:      true,
+		Format:       imageInfo.Format,
+		Width:        imageInfo.Width,
+		Height:       imageInfo.Height,
+		MimeType:     imgproc.MimeType(imageInfo.Format),
+		Filename:     fi.Filename,
+		Metadata:     fi.Metadata,
+		CreatedAt:    fi.CreatedAt,
+		UpdatedAt:    time.Now(),
+		OriginalInfo: &original,
+		KeepOriginal: true,
+	}
+
+	_, err = s.storage.Upsert(ctx, &fd)
+	if errors.Is(err, errs.ErrOriginalChanged) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("storage error: %w", err)
+	}
+	s.renderPresets(ctx, filedata.FileInfoFromFileData(&fd), data)
+
+	return true, nil
+}
+
+// RenormalizeAll regenerates stored images of all files with originals that
+// were stored with other image settings and returns the number of regenerated
+// files. Files that fail are logged and skipped.
+func (s *Service) RenormalizeAll(ctx context.Context) (int, error) {
+	if s.originals == nil {
+		return 0, errs.ErrOriginalsNotSupported
+	}
+
+	log := logger.FromContext(ctx)
+	settings := s.imageSettings()
+	isImage := true
+	q := filedata.ListQuery{Limit: maxListLimit, IsImage: &isImage}
+	count := 0
+	for {
+		fl, err := s.List(ctx, &q)
+		if err != nil {
+			return count, err
+		}
+
+		for _, fi := range fl.Files {
+			if ctx.Err() != nil {
+				return count, ctx.Err()
+			}
+			if fi.Original == nil || fi.Original.Settings == settings {
+				continue
+			}
+
+			ok, err := s.Renormalize(ctx, fi.ID)
+			if err != nil {
+				log.Warn("image regeneration failed", "id", fi.ID, slog.Any(logger.LogFieldError, err))
+				continue
+			}
+			if ok {
+				count++
+			}
+		}
+
+		if fl.NextCursor == "" {
+			return count, nil
+		}
+		q.Cursor = fl.NextCursor
+	}
+}
+
 // Delete removes a file by ID.
 // The operation is idempotent for the same file ID.
 func (s *Service) Delete(ctx context.Context, ID string) error {
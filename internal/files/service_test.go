@@ -3,6 +3,8 @@ package files_test
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"file-storage/internal/authorization"
 	"file-storage/internal/config"
@@ -26,6 +28,7 @@ type mockStorage struct {
 	fnInfo    func(ctx context.Context, ID string) (*filedata.FileInfo, error)
 	fnContent func(ctx context.Context, ID string) (*filedata.ContentData, error)
 	fnDelete  func(ctx context.Context, ID string) error
+	fnList    func(ctx context.Context, q *filedata.ListQuery) (*filedata.FileList, error)
 }
 
 func (m *mockStorage) Upsert(ctx context.Context, fd *filedata.FileData) (string, error) {
@@ -40,6 +43,49 @@ func (m *mockStorage) Content(ctx context.Context, ID string) (*filedata.Content
 func (m *mockStorage) Delete(ctx context.Context, ID string) error {
 	return m.fnDelete(ctx, ID)
 }
+func (m *mockStorage) List(ctx context.Context, q *filedata.ListQuery) (*filedata.FileList, error) {
+	return m.fnList(ctx, q)
+}
+
+type mockVersionStorage struct {
+	mockStorage
+	fnVersionContent func(ctx context.Context, ID string, version int) (*filedata.ContentData, error)
+}
+
+func (m *mockVersionStorage) Versions(ctx context.Context, ID string) ([]*filedata.VersionInfo, error) {
+	return []*filedata.VersionInfo{}, nil
+}
+func (m *mockVersionStorage) VersionContent(ctx context.Context, ID string, version int) (*filedata.ContentData, error) {
+	return m.fnVersionContent(ctx, ID, version)
+}
+func (m *mockVersionStorage) RestoreVersion(ctx context.Context, ID string, version int) (*filedata.FileInfo, error) {
+	return &filedata.FileInfo{ID: ID}, nil
+}
+
+type mockTrashStorage struct {
+	mockStorage
+	fnTrash func(ctx context.Context, cursor string, limit int) (*filedata.TrashList, error)
+}
+
+func (m *mockTrashStorage) Trash(ctx context.Context, cursor string, limit int) (*filedata.TrashList, error) {
+	return m.fnTrash(ctx, cursor, limit)
+}
+func (m *mockTrashStorage) Restore(ctx context.Context, ID string) (*filedata.FileInfo, error) {
+	return &filedata.FileInfo{ID: ID}, nil
+}
+
+type mockCacheStorage struct {
+	mockStorage
+	fnRendition    func(ctx context.Context, key filedata.RenditionKey) (io.ReadSeekCloser, error)
+	fnPutRendition func(ctx context.Context, key filedata.RenditionKey, data []byte) error
+}
+
+func (m *mockCacheStorage) Rendition(ctx context.Context, key filedata.RenditionKey) (io.ReadSeekCloser, error) {
+	return m.fnRendition(ctx, key)
+}
+func (m *mockCacheStorage) PutRendition(ctx context.Context, key filedata.RenditionKey, data []byte) error {
+	return m.fnPutRendition(ctx, key, data)
+}
 
 func TestUpdate(t *testing.T) {
 
@@ -83,7 +129,7 @@ func TestUpdate(t *testing.T) {
 				ID:      "12345",
 				Hash:    "22",
 				IsImage: true,
-				Data:    []byte("not an image")},
+				Data:    bytes.NewReader([]byte("not an image"))},
 			wantErr:        errs.ErrInvalidImage,
 			wantID:         "",
 			wantCallUpsert: false,
@@ -103,7 +149,7 @@ func TestUpdate(t *testing.T) {
 				ID:      "",
 				Hash:    "22",
 				IsImage: false,
-				Data:    []byte("not an image")},
+				Data:    bytes.NewReader([]byte("not an image"))},
 			wantErr:        storageError,
 			wantID:         "",
 			wantCallUpsert: true,
@@ -123,7 +169,7 @@ func TestUpdate(t *testing.T) {
 				ID:      "12345",
 				Hash:    "22",
 				IsImage: true,
-				Data:    b},
+				Data:    bytes.NewReader(b)},
 			wantErr:        nil,
 			wantID:         "12345",
 			wantCallUpsert: true,
@@ -151,10 +197,115 @@ func TestUpdate(t *testing.T) {
 	}
 }
 
+func TestUpdateStream(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Image{Ext: "jpeg", MaxDimension: 1000}
+	data := []byte("not an image")
+	sum := sha256.Sum256(data)
+
+	var stored []byte
+	var storedFd *filedata.FileData
+	storage := &mockStorage{
+		fnUpsert: func(ctx context.Context, fd *filedata.FileData) (string, error) {
+			var err error
+			stored, err = io.ReadAll(fd.Data)
+			storedFd = fd
+			return fd.ID, err
+		},
+		fnInfo: func(ctx context.Context, ID string) (*filedata.FileInfo, error) {
+			return nil, errs.ErrNotFound
+		},
+	}
+
+	s := files.NewService(cfg, storage)
+	_, err := s.Update(ctx, &filedata.UploadCommand{ID: "12345", Data: bytes.NewReader(data)})
+	if err != nil {
+		t.Fatalf("update error: %v", err)
+	}
+
+	if !bytes.Equal(stored, data) {
+		t.Errorf("stored data mismatch got %q want %q", stored, data)
+	}
+	if storedFd.HashSource != hex.EncodeToString(sum[:]) {
+		t.Errorf("hash mismatch got %s want %s", storedFd.HashSource, hex.EncodeToString(sum[:]))
+	}
+	if storedFd.FileSize != len(data) {
+		t.Errorf("file size mismatch got %d want %d", storedFd.FileSize, len(data))
+	}
+}
+
+func TestUpdateMimeType(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Image{Ext: "jpeg", MaxDimension: 1000}
+
+	table := []struct {
+		name          string
+		info          *filedata.FileInfo
+		uploadCommand *filedata.UploadCommand
+		wantMimeType  string
+		wantFilename  string
+	}{
+		{
+			name:          "detected",
+			uploadCommand: &filedata.UploadCommand{ID: "12345", Data: bytes.NewReader([]byte("plain text")), Filename: "notes.txt"},
+			wantMimeType:  "text/plain; charset=utf-8",
+			wantFilename:  "notes.txt",
+		},
+		{
+			name:          "client override",
+			uploadCommand: &filedata.UploadCommand{ID: "12345", Data: bytes.NewReader([]byte("a,b")), MimeType: "text/csv"},
+			wantMimeType:  "text/csv",
+		},
+		{
+			name:          "kept from previous version",
+			info:          &filedata.FileInfo{ID: "12345", HashSource: "22", MimeType: "text/csv", Filename: "table.csv"},
+			uploadCommand: &filedata.UploadCommand{ID: "12345", Hash: "22", Data: bytes.NewReader([]byte("a,b"))},
+			wantMimeType:  "text/csv",
+			wantFilename:  "table.csv",
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			var storedFd *filedata.FileData
+			storage := &mockStorage{
+				fnUpsert: func(ctx context.Context, fd *filedata.FileData) (string, error) {
+					storedFd = fd
+					if fd.Data != nil {
+						_, err := io.Copy(io.Discard, fd.Data)
+						return fd.ID, err
+					}
+					return fd.ID, nil
+				},
+				fnInfo: func(ctx context.Context, ID string) (*filedata.FileInfo, error) {
+					if tt.info == nil {
+						return nil, errs.ErrNotFound
+					}
+					return tt.info, nil
+				},
+			}
+
+			s := files.NewService(cfg, storage)
+			_, err := s.Update(ctx, tt.uploadCommand)
+			if err != nil {
+				t.Fatalf("update error: %v", err)
+			}
+
+			if storedFd.MimeType != tt.wantMimeType {
+				t.Errorf("mime type mismatch got %q want %q", storedFd.MimeType, tt.wantMimeType)
+			}
+			if storedFd.Filename != tt.wantFilename {
+				t.Errorf("filename mismatch got %q want %q", storedFd.Filename, tt.wantFilename)
+			}
+		})
+	}
+}
+
 func TestContent(t *testing.T) {
 
 	var call bool
 	zero := 0
+	width := 100
 	storageError := fmt.Errorf("storage error")
 
 	cfg := &config.Image{Ext: "jpeg", MaxDimension: 1000}
@@ -238,6 +389,24 @@ func TestContent(t *testing.T) {
 			wantCall:  false,
 			wantBytes: nil,
 		},
+		{
+			name: "mode without dimensions",
+			storage: &mockStorage{
+				fnContent: func(ctx context.Context, ID string) (*filedata.ContentData, error) {
+					call = true
+					return nil, nil
+				},
+			},
+			contentCommand: &filedata.ContentCommand{
+				ID:    "1",
+				Width: &width,
+				Mode:  imgproc.ModeFill,
+			},
+			ctx:       newContext(&authorization.Auth{Read: true}),
+			wantErr:   errs.ErrWrongUrlParameter,
+			wantCall:  false,
+			wantBytes: nil,
+		},
 		{
 			name: "storage error",
 			storage: &mockStorage{
@@ -263,8 +432,8 @@ func TestContent(t *testing.T) {
 				fnContent: func(ctx context.Context, ID string) (*filedata.ContentData, error) {
 					call = true
 					b := []byte("not an image")
-					data := io.NopCloser(bytes.NewReader(b))
-					return &filedata.ContentData{Data: data, IsImage: true}, nil
+					data := filedata.NopSeekCloser(bytes.NewReader(b))
+					return &filedata.ContentData{Data: data, Info: &filedata.FileInfo{IsImage: true}}, nil
 				},
 				fnInfo: func(ctx context.Context, ID string) (*filedata.FileInfo, error) {
 					return &filedata.FileInfo{Public: true}, nil
@@ -283,8 +452,8 @@ func TestContent(t *testing.T) {
 			storage: &mockStorage{
 				fnContent: func(ctx context.Context, ID string) (*filedata.ContentData, error) {
 					call = true
-					data := io.NopCloser(bytes.NewReader(imgBytes))
-					return &filedata.ContentData{Data: data, IsImage: true}, nil
+					data := filedata.NopSeekCloser(bytes.NewReader(imgBytes))
+					return &filedata.ContentData{Data: data, Info: &filedata.FileInfo{IsImage: true}}, nil
 				},
 				fnInfo: func(ctx context.Context, ID string) (*filedata.FileInfo, error) {
 					return &filedata.FileInfo{Public: false}, nil
@@ -298,13 +467,41 @@ func TestContent(t *testing.T) {
 			wantCall:  true,
 			wantBytes: imgBytes,
 		},
+		{
+			name: "stored rendition passthrough",
+			storage: &mockStorage{
+				fnContent: func(ctx context.Context, ID string) (*filedata.ContentData, error) {
+					call = true
+					data := filedata.NopSeekCloser(bytes.NewReader([]byte("stored rendition")))
+					fi := &filedata.FileInfo{IsImage: true, Format: imgproc.ImgFormatJPEG, Width: 500, Height: 300}
+					return &filedata.ContentData{Data: data, Info: fi}, nil
+				},
+			},
+			contentCommand: &filedata.ContentCommand{
+				ID: "1",
+			},
+			ctx:       newContext(&authorization.Auth{Read: true}),
+			wantErr:   nil,
+			wantCall:  true,
+			wantBytes: []byte("stored rendition"),
+		},
 	}
 
 	for _, tt := range table {
 		t.Run(tt.name, func(t *testing.T) {
 			call = false
 			s := files.NewService(cfg, tt.storage)
-			b, err := s.Content(tt.ctx, tt.contentCommand)
+			content, err := s.Content(tt.ctx, tt.contentCommand)
+
+			var b []byte
+			if content != nil {
+				defer content.Data.Close()
+				var readErr error
+				b, readErr = io.ReadAll(content.Data)
+				if readErr != nil {
+					t.Errorf("content read error: %v", readErr)
+				}
+			}
 
 			if call != tt.wantCall {
 				t.Errorf("call mismatch got %v want %v", call, tt.wantCall)
@@ -325,6 +522,407 @@ func TestContent(t *testing.T) {
 	}
 }
 
+func TestContentRenditionCache(t *testing.T) {
+	cfg := &config.Image{Ext: "jpeg", MaxDimension: 1000, RenditionCache: true}
+	width := 100
+
+	img := imaging.New(cfg.MaxDimension, cfg.MaxDimension, color.Black)
+	imgBytes, err := imgproc.Encode(img, imaging.JPEG)
+	if err != nil {
+		t.Fatalf("test image encode error: %v", err)
+	}
+
+	cache := make(map[filedata.RenditionKey][]byte)
+	storage := &mockCacheStorage{
+		mockStorage: mockStorage{
+			fnContent: func(ctx context.Context, ID string) (*filedata.ContentData, error) {
+				data := filedata.NopSeekCloser(bytes.NewReader(imgBytes))
+				fi := &filedata.FileInfo{ID: ID, HashStored: "hash", IsImage: true, Format: imgproc.ImgFormatJPEG, Width: 1000, Height: 1000}
+				return &filedata.ContentData{Data: data, Info: fi}, nil
+			},
+		},
+		fnRendition: func(ctx context.Context, key filedata.RenditionKey) (io.ReadSeekCloser, error) {
+			b, ok := cache[key]
+			if !ok {
+				return nil, errs.ErrNotFound
+			}
+			return filedata.NopSeekCloser(bytes.NewReader(b)), nil
+		},
+		fnPutRendition: func(ctx context.Context, key filedata.RenditionKey, data []byte) error {
+			cache[key] = data
+			return nil
+		},
+	}
+
+	s := files.NewService(cfg, storage)
+	cc := &filedata.ContentCommand{ID: "1", Width: &width}
+	ctx := newContext(&authorization.Auth{Read: true})
+
+	content, err := s.Content(ctx, cc)
+	if err != nil {
+		t.Fatalf("content error: %v", err)
+	}
+	processed, err := io.ReadAll(content.Data)
+	if err != nil {
+		t.Fatalf("content read error: %v", err)
+	}
+
+	wantKey := filedata.RenditionKey{ID: "1", Hash: "hash", Width: width, Height: cfg.MaxDimension, Mode: imgproc.ModeFit, Format: imgproc.ImgFormatJPEG}
+	if !bytes.Equal(cache[wantKey], processed) {
+		t.Fatalf("rendition not cached with key %v", wantKey)
+	}
+
+	cache[wantKey] = []byte("cached rendition")
+	content, err = s.Content(ctx, cc)
+	if err != nil {
+		t.Fatalf("content error: %v", err)
+	}
+	b, err := io.ReadAll(content.Data)
+	if err != nil {
+		t.Fatalf("content read error: %v", err)
+	}
+	if string(b) != "cached rendition" {
+		t.Errorf("cached rendition not used got %d bytes", len(b))
+	}
+	if content.ContentType != "image/jpeg" {
+		t.Errorf("content type mismatch got %q want %q", content.ContentType, "image/jpeg")
+	}
+
+	quality := 50
+	cc.Quality = &quality
+	_, err = s.Content(ctx, cc)
+	if err != nil {
+		t.Fatalf("content error: %v", err)
+	}
+	wantKey.Quality = quality
+	if _, ok := cache[wantKey]; !ok {
+		t.Errorf("rendition not cached with key %v", wantKey)
+	}
+}
+
+func TestContentFormatNegotiation(t *testing.T) {
+	cfg := &config.Image{Ext: "jpeg", MaxDimension: 1000}
+
+	img := imaging.New(cfg.MaxDimension, cfg.MaxDimension, color.Black)
+	imgBytes, err := imgproc.Encode(img, imaging.JPEG)
+	if err != nil {
+		t.Fatalf("test image encode error: %v", err)
+	}
+
+	storage := &mockStorage{
+		fnContent: func(ctx context.Context, ID string) (*filedata.ContentData, error) {
+			data := filedata.NopSeekCloser(bytes.NewReader(imgBytes))
+			fi := &filedata.FileInfo{ID: ID, HashStored: "hash", IsImage: true, Format: imgproc.ImgFormatJPEG, Width: 1000, Height: 1000}
+			return &filedata.ContentData{Data: data, Info: fi}, nil
+		},
+	}
+
+	table := []struct {
+		name            string
+		accept          []string
+		wantContentType string
+	}{
+		{
+			name:            "webp accepted",
+			accept:          []string{"image/png", "image/webp"},
+			wantContentType: "image/webp",
+		},
+		{
+			name:            "fallback to configured format",
+			accept:          []string{"image/png", "text/html"},
+			wantContentType: "image/jpeg",
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			s := files.NewService(cfg, storage)
+			format := filedata.FormatAuto
+			cc := &filedata.ContentCommand{ID: "1", Format: &format, Accept: tt.accept}
+
+			content, err := s.Content(newContext(&authorization.Auth{Read: true}), cc)
+			if err != nil {
+				t.Fatalf("content error: %v", err)
+			}
+			defer content.Data.Close()
+
+			if content.ContentType != tt.wantContentType {
+				t.Errorf("content type mismatch got %q want %q", content.ContentType, tt.wantContentType)
+			}
+		})
+	}
+}
+
+func TestContentPreset(t *testing.T) {
+	img := imaging.New(1000, 1000, color.Black)
+	imgBytes, err := imgproc.Encode(img, imaging.JPEG)
+	if err != nil {
+		t.Fatalf("test image encode error: %v", err)
+	}
+
+	width := 100
+	table := []struct {
+		name        string
+		presetsOnly bool
+		cc          *filedata.ContentCommand
+		wantErr     error
+		wantKey     *filedata.RenditionKey
+	}{
+		{
+			name:    "preset",
+			cc:      &filedata.ContentCommand{ID: "1", Preset: "thumb"},
+			wantKey: &filedata.RenditionKey{ID: "1", Hash: "hash", Width: 200, Height: 200, Mode: imgproc.ModeFill, Gravity: imgproc.GravitySmart, Format: imgproc.ImgFormatPNG},
+		},
+		{
+			name:    "unknown preset",
+			cc:      &filedata.ContentCommand{ID: "1", Preset: "card"},
+			wantErr: errs.ErrWrongUrlParameter,
+		},
+		{
+			name:        "presets only",
+			presetsOnly: true,
+			cc:          &filedata.ContentCommand{ID: "1", Width: &width},
+			wantErr:     errs.ErrWrongUrlParameter,
+		},
+		{
+			name:        "presets only with preset",
+			presetsOnly: true,
+			cc:          &filedata.ContentCommand{ID: "1", Preset: "thumb"},
+			wantKey:     &filedata.RenditionKey{ID: "1", Hash: "hash", Width: 200, Height: 200, Mode: imgproc.ModeFill, Gravity: imgproc.GravitySmart, Format: imgproc.ImgFormatPNG},
+		},
+		{
+			name:        "presets only without transformation",
+			presetsOnly: true,
+			cc:          &filedata.ContentCommand{ID: "1"},
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Image{
+				Ext:            "jpeg",
+				MaxDimension:   1000,
+				RenditionCache: true,
+				Presets:        map[string]string{"thumb": "200x200 fill smart png"},
+				PresetsOnly:    tt.presetsOnly,
+			}
+
+			cache := make(map[filedata.RenditionKey][]byte)
+			storage := &mockCacheStorage{
+				mockStorage: mockStorage{
+					fnContent: func(ctx context.Context, ID string) (*filedata.ContentData, error) {
+						data := filedata.NopSeekCloser(bytes.NewReader(imgBytes))
+						fi := &filedata.FileInfo{ID: ID, HashStored: "hash", IsImage: true, Format: imgproc.ImgFormatJPEG, Width: 1000, Height: 1000}
+						return &filedata.ContentData{Data: data, Info: fi}, nil
+					},
+				},
+				fnRendition: func(ctx context.Context, key filedata.RenditionKey) (io.ReadSeekCloser, error) {
+					return nil, errs.ErrNotFound
+				},
+				fnPutRendition: func(ctx context.Context, key filedata.RenditionKey, data []byte) error {
+					cache[key] = data
+					return nil
+				},
+			}
+
+			s := files.NewService(cfg, storage)
+			content, err := s.Content(newContext(&authorization.Auth{Read: true}), tt.cc)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error mismatch got %v want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer content.Data.Close()
+
+			if tt.wantKey == nil {
+				if len(cache) != 0 {
+					t.Errorf("unexpected renditions %v", cache)
+				}
+				return
+			}
+			if _, ok := cache[*tt.wantKey]; !ok {
+				t.Errorf("rendition not cached with key %v", *tt.wantKey)
+			}
+			if content.ETag != tt.wantKey.Name() {
+				t.Errorf("etag mismatch got %q want %q", content.ETag, tt.wantKey.Name())
+			}
+		})
+	}
+}
+
+func TestContentSignedURLs(t *testing.T) {
+	cfg := &config.Image{
+		Ext:              "jpeg",
+		MaxDimension:     1000,
+		Presets:          map[string]string{"thumb": "200x200 fill", "card": "400x300 fill"},
+		SignedTransforms: true,
+		UnsignedPresets:  []string{"thumb"},
+	}
+
+	img := imaging.New(cfg.MaxDimension, cfg.MaxDimension, color.Black)
+	imgBytes, err := imgproc.Encode(img, imaging.JPEG)
+	if err != nil {
+		t.Fatalf("test image encode error: %v", err)
+	}
+
+	storage := &mockStorage{
+		fnInfo: func(ctx context.Context, ID string) (*filedata.FileInfo, error) {
+			return &filedata.FileInfo{ID: ID, Public: ID != "private"}, nil
+		},
+		fnContent: func(ctx context.Context, ID string) (*filedata.ContentData, error) {
+			data := filedata.NopSeekCloser(bytes.NewReader(imgBytes))
+			fi := &filedata.FileInfo{ID: ID, HashStored: "hash", Public: ID != "private", IsImage: true, Format: imgproc.ImgFormatJPEG, Width: 1000, Height: 1000}
+			return &filedata.ContentData{Data: data, Info: fi}, nil
+		},
+	}
+
+	width := 100
+	table := []struct {
+		name    string
+		auth    *authorization.Auth
+		cc      *filedata.ContentCommand
+		wantErr error
+	}{
+		{
+			name: "stored content",
+			auth: &authorization.Auth{},
+			cc:   &filedata.ContentCommand{ID: "1"},
+		},
+		{
+			name:    "unsigned transformation",
+			auth:    &authorization.Auth{},
+			cc:      &filedata.ContentCommand{ID: "1", Width: &width},
+			wantErr: errs.ErrSignatureRequired,
+		},
+		{
+			name: "signed transformation",
+			auth: &authorization.Auth{Signed: true},
+			cc:   &filedata.ContentCommand{ID: "1", Width: &width},
+		},
+		{
+			name: "transformation with read access",
+			auth: &authorization.Auth{Read: true},
+			cc:   &filedata.ContentCommand{ID: "1", Width: &width},
+		},
+		{
+			name: "presigned private content",
+			auth: &authorization.Auth{Signed: true, Presigned: true},
+			cc:   &filedata.ContentCommand{ID: "private"},
+		},
+		{
+			name:    "signed private content without expiration",
+			auth:    &authorization.Auth{Signed: true},
+			cc:      &filedata.ContentCommand{ID: "private"},
+			wantErr: errs.ErrAccessDenied,
+		},
+		{
+			name:    "unsigned private content",
+			auth:    &authorization.Auth{},
+			cc:      &filedata.ContentCommand{ID: "private"},
+			wantErr: errs.ErrAccessDenied,
+		},
+		{
+			name: "unsigned preset",
+			auth: &authorization.Auth{},
+			cc:   &filedata.ContentCommand{ID: "1", Preset: "thumb"},
+		},
+		{
+			name:    "preset requiring signature",
+			auth:    &authorization.Auth{},
+			cc:      &filedata.ContentCommand{ID: "1", Preset: "card"},
+			wantErr: errs.ErrAccessDenied,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			s := files.NewService(cfg, storage)
+
+			content, err := s.Content(newContext(tt.auth), tt.cc)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error mismatch got %v want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				content.Data.Close()
+			}
+		})
+	}
+}
+
+func TestUpdateEagerPresets(t *testing.T) {
+	cfg := &config.Image{
+		Ext:            "jpeg",
+		MaxDimension:   1000,
+		RenditionCache: true,
+		JPEGQuality:    85,
+		WebPQuality:    70,
+		Presets:        map[string]string{"thumb": "200x200 fill webp", "stored": "jpeg", "card": "400x300 pad"},
+		EagerPresets:   []string{"thumb", "stored"},
+	}
+
+	img := imaging.New(cfg.MaxDimension, cfg.MaxDimension, color.Black)
+	imgBytes, err := imgproc.Encode(img, imaging.JPEG)
+	if err != nil {
+		t.Fatalf("test image encode error: %v", err)
+	}
+
+	var stored *filedata.FileData
+	cache := make(map[filedata.RenditionKey][]byte)
+	storage := &mockCacheStorage{
+		mockStorage: mockStorage{
+			fnUpsert: func(ctx context.Context, fd *filedata.FileData) (string, error) {
+				stored = fd
+				return "12345", nil
+			},
+		},
+		fnRendition: func(ctx context.Context, key filedata.RenditionKey) (io.ReadSeekCloser, error) {
+			return nil, errs.ErrNotFound
+		},
+		fnPutRendition: func(ctx context.Context, key filedata.RenditionKey, data []byte) error {
+			cache[key] = data
+			return nil
+		},
+	}
+
+	s := files.NewService(cfg, storage)
+	_, err = s.Update(newContext(&authorization.Auth{Write: true}), &filedata.UploadCommand{IsImage: true, Data: bytes.NewReader(imgBytes)})
+	if err != nil {
+		t.Fatalf("update error: %v", err)
+	}
+
+	wantKey := filedata.RenditionKey{ID: "12345", Hash: stored.HashStored, Width: 200, Height: 200, Mode: imgproc.ModeFill, Gravity: imgproc.GravityCenter, Format: imgproc.ImgFormatWEBP, Quality: 70}
+	if len(cache) != 1 {
+		t.Fatalf("renditions got %d want 1", len(cache))
+	}
+	if _, ok := cache[wantKey]; !ok {
+		t.Errorf("rendition not cached with key %v", wantKey)
+	}
+}
+
+func TestUpdateImageLimits(t *testing.T) {
+	cfg := &config.Image{Ext: "jpeg", MaxDimension: 1000, Processing: config.ImageProcessing{MaxPixels: 500 * 500}}
+
+	img := imaging.New(600, 600, color.Black)
+	imgBytes, err := imgproc.Encode(img, imaging.JPEG)
+	if err != nil {
+		t.Fatalf("test image encode error: %v", err)
+	}
+
+	storage := &mockStorage{
+		fnUpsert: func(ctx context.Context, fd *filedata.FileData) (string, error) {
+			t.Errorf("image over limits stored")
+			return "12345", nil
+		},
+	}
+
+	s := files.NewService(cfg, storage)
+	_, err = s.Update(newContext(&authorization.Auth{Write: true}), &filedata.UploadCommand{IsImage: true, Data: bytes.NewReader(imgBytes)})
+	if !errors.Is(err, errs.ErrImageTooLarge) {
+		t.Errorf("errors mismatch got %v want %v", err, errs.ErrImageTooLarge)
+	}
+}
+
 func TestInfo(t *testing.T) {
 	cfg := config.Image{Ext: "jpeg", MaxDimension: 1000}
 	ctx := context.Background()
@@ -418,6 +1016,154 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Image{Ext: "jpeg", MaxDimension: 1000}
+
+	table := []struct {
+		name      string
+		limit     int
+		wantLimit int
+		wantErr   error
+	}{
+		{
+			name:      "default limit",
+			limit:     0,
+			wantLimit: 100,
+		},
+		{
+			name:      "limit",
+			limit:     5,
+			wantLimit: 5,
+		},
+		{
+			name:    "limit too large",
+			limit:   1001,
+			wantErr: errs.ErrWrongUrlParameter,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotLimit int
+			storage := &mockStorage{fnList: func(ctx context.Context, q *filedata.ListQuery) (*filedata.FileList, error) {
+				gotLimit = q.Limit
+				return &filedata.FileList{}, nil
+			}}
+
+			s := files.NewService(&cfg, storage)
+			_, err := s.List(ctx, &filedata.ListQuery{Limit: tt.limit})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("errors mismatch got %v want %v", err, tt.wantErr)
+			}
+			if gotLimit != tt.wantLimit {
+				t.Errorf("limit mismatch got %d want %d", gotLimit, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestTrash(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Image{Ext: "jpeg", MaxDimension: 1000}
+
+	var gotLimit int
+	trashStorage := &mockTrashStorage{fnTrash: func(ctx context.Context, cursor string, limit int) (*filedata.TrashList, error) {
+		gotLimit = limit
+		return &filedata.TrashList{}, nil
+	}}
+
+	table := []struct {
+		name      string
+		storage   files.Storage
+		limit     int
+		wantLimit int
+		wantErr   error
+	}{
+		{
+			name:    "not supported",
+			storage: &mockStorage{},
+			wantErr: errs.ErrTrashNotSupported,
+		},
+		{
+			name:      "default limit",
+			storage:   trashStorage,
+			limit:     0,
+			wantLimit: 100,
+		},
+		{
+			name:    "limit too large",
+			storage: trashStorage,
+			limit:   1001,
+			wantErr: errs.ErrWrongUrlParameter,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			gotLimit = 0
+			s := files.NewService(&cfg, tt.storage)
+
+			_, err := s.Trash(ctx, "", tt.limit)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("errors mismatch got %v want %v", err, tt.wantErr)
+			}
+			if gotLimit != tt.wantLimit {
+				t.Errorf("limit mismatch got %d want %d", gotLimit, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestVersionContent(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Image{Ext: "jpeg", MaxDimension: 1000}
+
+	versionStorage := &mockVersionStorage{fnVersionContent: func(ctx context.Context, ID string, version int) (*filedata.ContentData, error) {
+		info := &filedata.FileInfo{ID: ID, HashSource: "hash", IsImage: true, Format: imgproc.ImgFormatPNG}
+		return &filedata.ContentData{Data: filedata.NopSeekCloser(bytes.NewReader([]byte("data"))), Info: info}, nil
+	}}
+
+	table := []struct {
+		name            string
+		storage         files.Storage
+		wantErr         error
+		wantETag        string
+		wantContentType string
+	}{
+		{
+			name:    "not supported",
+			storage: &mockStorage{},
+			wantErr: errs.ErrVersionsNotSupported,
+		},
+		{
+			name:            "stored content",
+			storage:         versionStorage,
+			wantETag:        "hash",
+			wantContentType: "image/png",
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			s := files.NewService(&cfg, tt.storage)
+
+			content, err := s.VersionContent(ctx, "12345", 1)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("errors mismatch got %v want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer content.Data.Close()
+
+			if content.ETag != tt.wantETag || content.ContentType != tt.wantContentType {
+				t.Errorf("content mismatch got %q %q want %q %q", content.ETag, content.ContentType, tt.wantETag, tt.wantContentType)
+			}
+		})
+	}
+}
+
 func newContext(a *authorization.Auth) context.Context {
 	ctx := context.Background()
 	ctx = context.WithValue(ctx, contextkeys.ContextKeyLogger, logger.NewBootstrap())
@@ -428,3 +1174,236 @@ func newContext(a *authorization.Auth) context.Context {
 
 	return ctx
 }
+
+type mockOriginalStorage struct {
+	mockStorage
+	fnOriginal  func(ctx context.Context, ID string) (*filedata.ContentData, error)
+	unsupported bool
+}
+
+func (m *mockOriginalStorage) Original(ctx context.Context, ID string) (*filedata.ContentData, error) {
+	return m.fnOriginal(ctx, ID)
+}
+
+func (m *mockOriginalStorage) OriginalsSupported() bool {
+	return !m.unsupported
+}
+
+func TestUpdateKeepOriginal(t *testing.T) {
+	ctx := context.Background()
+
+	original, err := imgproc.Encode(imaging.New(1200, 600, color.Black), imaging.PNG)
+	if err != nil {
+		t.Fatalf("test image encode error: %v", err)
+	}
+
+	table := []struct {
+		name         string
+		keepOriginal bool
+		originals    bool
+		unsupported  bool
+		wantOriginal bool
+	}{
+		{name: "kept", keepOriginal: true, originals: true, wantOriginal: true},
+		{name: "disabled", keepOriginal: false, originals: true},
+		{name: "not supported", keepOriginal: true, originals: false},
+		{name: "not supported by wrapped storage", keepOriginal: true, originals: true, unsupported: true},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *filedata.FileData
+			ms := mockStorage{
+				fnInfo: func(ctx context.Context, ID string) (*filedata.FileInfo, error) {
+					return nil, errs.ErrNotFound
+				},
+				fnUpsert: func(ctx context.Context, fd *filedata.FileData) (string, error) {
+					got = fd
+					return fd.ID, nil
+				},
+			}
+			var storage files.Storage = &ms
+			if tt.originals {
+				storage = &mockOriginalStorage{mockStorage: ms, unsupported: tt.unsupported}
+			}
+
+			cfg := config.Image{Ext: "jpeg", MaxDimension: 1000, KeepOriginal: tt.keepOriginal}
+			s := files.NewService(&cfg, storage)
+			_, err := s.Update(ctx, &filedata.UploadCommand{ID: "12345", Data: bytes.NewReader(original), IsImage: true})
+			if err != nil {
+				t.Fatalf("update error: %v", err)
+			}
+
+			if got.Width != 1000 || got.Format != imgproc.ImgFormatJPEG {
+				t.Errorf("stored image got %s %dx%d want jpeg 1000x500", got.Format, got.Width, got.Height)
+			}
+			if (got.Original != nil) != tt.wantOriginal || (got.OriginalInfo != nil) != tt.wantOriginal {
+				t.Fatalf("original got %v want kept %v", got.OriginalInfo, tt.wantOriginal)
+			}
+			if !tt.wantOriginal {
+				return
+			}
+
+			b, _ := io.ReadAll(got.Original)
+			if !bytes.Equal(b, original) {
+				t.Errorf("original content differs from the upload")
+			}
+			want := filedata.OriginalInfo{Size: len(original), Format: imgproc.ImgFormatPNG, Width: 1200, Height: 600, Settings: "jpeg:1000:q0"}
+			if *got.OriginalInfo != want {
+				t.Errorf("original info got %+v want %+v", *got.OriginalInfo, want)
+			}
+		})
+	}
+}
+
+func TestOriginal(t *testing.T) {
+	cfg := config.Image{Ext: "jpeg", MaxDimension: 1000}
+	info := &filedata.FileInfo{
+		ID:         "12345",
+		HashSource: "source",
+		HashStored: "stored",
+		IsImage:    true,
+		Format:     imgproc.ImgFormatJPEG,
+		Original:   &filedata.OriginalInfo{Format: imgproc.ImgFormatPNG},
+	}
+	storage := &mockOriginalStorage{
+		mockStorage: mockStorage{fnInfo: func(ctx context.Context, ID string) (*filedata.FileInfo, error) {
+			return info, nil
+		}},
+		fnOriginal: func(ctx context.Context, ID string) (*filedata.ContentData, error) {
+			return &filedata.ContentData{Data: filedata.NopSeekCloser(bytes.NewReader([]byte("data"))), Info: info}, nil
+		},
+	}
+
+	table := []struct {
+		name    string
+		storage files.Storage
+		auth    *authorization.Auth
+		wantErr error
+	}{
+		{
+			name:    "not supported",
+			storage: &mockStorage{},
+			auth:    &authorization.Auth{Read: true},
+			wantErr: errs.ErrOriginalsNotSupported,
+		},
+		{
+			name:    "private file",
+			storage: storage,
+			auth:    &authorization.Auth{},
+			wantErr: errs.ErrAccessDenied,
+		},
+		{
+			name:    "ok",
+			storage: storage,
+			auth:    &authorization.Auth{Read: true},
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			s := files.NewService(&cfg, tt.storage)
+
+			content, err := s.Original(newContext(tt.auth), "12345")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("errors mismatch got %v want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer content.Data.Close()
+
+			if content.ETag != "source" || content.ContentType != "image/png" {
+				t.Errorf("content mismatch got %q %q want %q %q", content.ETag, content.ContentType, "source", "image/png")
+			}
+		})
+	}
+}
+
+func TestRenormalize(t *testing.T) {
+	ctx := newContext(nil)
+
+	original, err := imgproc.Encode(imaging.New(1200, 600, color.Black), imaging.PNG)
+	if err != nil {
+		t.Fatalf("test image encode error: %v", err)
+	}
+	sum := sha256.Sum256(original)
+	originalHash := hex.EncodeToString(sum[:])
+
+	table := []struct {
+		name        string
+		settings    string
+		hash        string
+		upsertErr   error
+		wantErr     error
+		wantUpdated bool
+	}{
+		{
+			name:     "settings unchanged",
+			settings: "png:500:default",
+			hash:     originalHash,
+		},
+		{
+			name:        "settings changed",
+			settings:    "jpeg:1000:q0",
+			hash:        originalHash,
+			wantUpdated: true,
+		},
+		{
+			name:      "replaced meanwhile",
+			settings:  "jpeg:1000:q0",
+			hash:      originalHash,
+			upsertErr: errs.ErrOriginalChanged,
+		},
+		{
+			name:     "corrupted original",
+			settings: "jpeg:1000:q0",
+			hash:     "other",
+			wantErr:  errs.ErrHashMismatch,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *filedata.FileData
+			storage := &mockOriginalStorage{
+				mockStorage: mockStorage{fnUpsert: func(ctx context.Context, fd *filedata.FileData) (string, error) {
+					got = fd
+					return fd.ID, tt.upsertErr
+				}},
+				fnOriginal: func(ctx context.Context, ID string) (*filedata.ContentData, error) {
+					info := &filedata.FileInfo{
+						ID:         ID,
+						HashSource: tt.hash,
+						IsImage:    true,
+						Original:   &filedata.OriginalInfo{Size: len(original), Format: imgproc.ImgFormatPNG, Settings: tt.settings},
+					}
+					return &filedata.ContentData{Data: filedata.NopSeekCloser(bytes.NewReader(original)), Info: info}, nil
+				},
+			}
+
+			cfg := config.Image{Ext: "png", MaxDimension: 500}
+			s := files.NewService(&cfg, storage)
+			updated, err := s.Renormalize(ctx, "12345")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("errors mismatch got %v want %v", err, tt.wantErr)
+			}
+			if updated != tt.wantUpdated {
+				t.Errorf("updated got %v want %v", updated, tt.wantUpdated)
+			}
+			if !tt.wantUpdated {
+				return
+			}
+
+			if !got.KeepOriginal || got.Original != nil || got.HashSource != originalHash {
+				t.Errorf("write must keep the original with hash %s, got %+v", originalHash, got)
+			}
+			if got.Format != imgproc.ImgFormatPNG || got.Width != 500 || got.Height != 250 {
+				t.Errorf("stored image got %s %dx%d want png 500x250", got.Format, got.Width, got.Height)
+			}
+			if got.OriginalInfo.Settings != "png:500:default" {
+				t.Errorf("settings got %q want %q", got.OriginalInfo.Settings, "png:500:default")
+			}
+		})
+	}
+}
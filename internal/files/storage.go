@@ -3,12 +3,92 @@ package files
 import (
 	"context"
 	"file-storage/internal/filedata"
+	"io"
 )
 
 // Storage defines persistence operations required by the business layer.
+//
+// Upsert copies fd.Data from the stream into the storage and must not buffer it
+// as a whole. A read error from fd.Data aborts the write and leaves the current
+// version intact. Content returns a seekable stream the caller must close.
+// List returns up to q.Limit files matching q in ascending ID order.
 type Storage interface {
 	Upsert(ctx context.Context, fd *filedata.FileData) (string, error)
 	Info(ctx context.Context, ID string) (*filedata.FileInfo, error)
 	Content(ctx context.Context, ID string) (*filedata.ContentData, error)
 	Delete(ctx context.Context, ID string) error
+	List(ctx context.Context, q *filedata.ListQuery) (*filedata.FileList, error)
+}
+
+// RenditionCache is an optional extension of Storage that keeps transformed
+// image renditions so that repeated requests skip image processing.
+//
+// Rendition returns errs.ErrNotFound on a cache miss. PutRendition may drop the
+// rendition silently, for example when key.Hash no longer matches the current
+// version of the file. Cached renditions of a file are invalidated when its
+// content is replaced or the file is deleted.
+type RenditionCache interface {
+	Rendition(ctx context.Context, key filedata.RenditionKey) (io.ReadSeekCloser, error)
+	PutRendition(ctx context.Context, key filedata.RenditionKey, data []byte) error
+}
+
+// VersionStorage is an optional extension of Storage that retains previous
+// versions of files according to its retention policy.
+//
+// Versions returns retained versions of the file, newest first, and
+// errs.ErrNotFound when the file does not exist. RestoreVersion makes a copy
+// of the version the current one, so the replaced content becomes a retained
+// version itself. Versions are removed together with the file.
+type VersionStorage interface {
+	Versions(ctx context.Context, ID string) ([]*filedata.VersionInfo, error)
+	VersionContent(ctx context.Context, ID string, version int) (*filedata.ContentData, error)
+	RestoreVersion(ctx context.Context, ID string, version int) (*filedata.FileInfo, error)
+}
+
+// TrashStorage is an optional extension of Storage that keeps deleted files in
+// a trash until they are purged.
+//
+// Info and Content return errs.ErrFileDeleted for files in the trash. Trash
+// returns up to limit deleted files in ascending ID order starting after
+// cursor. Restore returns errs.ErrNotFound when the file is not in the trash
+// and errs.ErrFileExists when a file with the same ID was stored since. Both
+// return errs.ErrTrashNotSupported when the trash is disabled.
+type TrashStorage interface {
+	Trash(ctx context.Context, cursor string, limit int) (*filedata.TrashList, error)
+	Restore(ctx context.Context, ID string) (*filedata.FileInfo, error)
+}
+
+// BackupStorage is an optional extension of Storage that writes a consistent
+// archive of all current files while the storage is in use.
+//
+// Backup returns errs.ErrBackupNotSupported before writing anything when the
+// storage cannot be backed up.
+type BackupStorage interface {
+	Backup(ctx context.Context, w io.Writer) error
+}
+
+// ScrubStorage is an optional extension of Storage that verifies stored
+// content of a file against its content hash.
+//
+// Scrub reads the current version of the file and returns errs.ErrNotFound
+// when the file does not exist. A mismatch is reported in the result, not as
+// an error; the storage may move the corrupted version out of use.
+type ScrubStorage interface {
+	Scrub(ctx context.Context, ID string) (*filedata.ScrubResult, error)
+}
+
+// OriginalStorage is an optional extension of Storage that keeps original
+// uploads of images next to the stored images, see filedata.FileData.
+//
+// Original returns the original of the current version of the file and
+// errs.ErrNotFound when the file does not exist or has no original kept.
+// Originals are removed together with the file and are not retained with
+// previous versions.
+//
+// OriginalsSupported reports whether originals are kept. Wrappers over other
+// storages implement the interface regardless of the wrapped storages and
+// report whether those keep originals.
+type OriginalStorage interface {
+	Original(ctx context.Context, ID string) (*filedata.ContentData, error)
+	OriginalsSupported() bool
 }
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"file-storage/internal/authorization"
+	"file-storage/internal/contextkeys"
+	"file-storage/internal/errs"
+	"file-storage/internal/logger"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// BackupHandler returns a handler that streams a backup archive of all files.
+// The archive may take longer than the server write timeout, so the timeout
+// is lifted for the response.
+func BackupHandler(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		log := logger.FromContext(ctx)
+		log = logger.WithHandler(log, logger.HandlerBackup)
+
+		auth, ok := ctx.Value(contextkeys.ContextKeyAuth).(*authorization.Auth)
+		if !ok {
+			err := fmt.Errorf("failed to get Auth structure out of context: %w", errs.ErrContextValueError)
+			handleTransportError(w, log, err)
+			return
+		}
+		// the archive holds private files of all clients
+		if !auth.Write {
+			err := fmt.Errorf("write access denied: %w", errs.ErrAccessDenied)
+			handleTransportError(w, log, err)
+			return
+		}
+
+		err := http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		if err != nil {
+			log.Warn("write deadline reset failed", slog.Any(logger.LogFieldError, err))
+		}
+
+		bw := &backupWriter{w: w}
+		err = svc.Backup(ctx, bw)
+		if err != nil && !bw.started {
+			handleBusinessError(w, log, err)
+			return
+		}
+		if err != nil {
+			// the status is sent already; the client sees an archive without
+			// the manifest
+			log.Error("backup error", slog.Any(logger.LogFieldError, err))
+		}
+	}
+}
+
+// backupWriter sends the response headers with the first archive bytes, so
+// an error before the archive starts is reported with its status.
+type backupWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (b *backupWriter) Write(p []byte) (int, error) {
+	if !b.started {
+		b.started = true
+		b.w.Header().Set("Content-Type", "application/zstd")
+		b.w.Header().Set("Content-Disposition", `attachment; filename="file-storage-`+time.Now().UTC().Format("20060102T150405Z")+`.tar.zst"`)
+		b.w.WriteHeader(http.StatusOK)
+	}
+	return b.w.Write(p)
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"file-storage/internal/authorization"
+	"file-storage/internal/errs"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBackupHandler(t *testing.T) {
+
+	table := []struct {
+		name            string
+		service         *mockService
+		ctx             context.Context
+		wantStatus      int
+		wantContentType string
+	}{
+		{
+			name:       "read token only",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Read: true}, nil),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "not supported",
+			service: &mockService{fnBackup: func(ctx context.Context, w io.Writer) error {
+				return errs.ErrBackupNotSupported
+			}},
+			ctx:        newContext(&authorization.Auth{Write: true}, nil),
+			wantStatus: http.StatusNotImplemented,
+		},
+		{
+			name: "ok",
+			service: &mockService{fnBackup: func(ctx context.Context, w io.Writer) error {
+				_, err := w.Write([]byte("archive"))
+				return err
+			}},
+			ctx:             newContext(&authorization.Auth{Write: true}, nil),
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/zstd",
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			handlerFunc := BackupHandler(tt.service)
+
+			w := httptest.NewRecorder()
+			r := newHttpTestRequest("GET", "/admin/backup", "").WithContext(tt.ctx)
+			handlerFunc.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %v want %v; responce %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantContentType != "" && w.Header().Get("Content-Type") != tt.wantContentType {
+				t.Errorf("got content type %q want %q", w.Header().Get("Content-Type"), tt.wantContentType)
+			}
+		})
+	}
+}
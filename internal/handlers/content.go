@@ -4,10 +4,13 @@ import (
 	"file-storage/internal/errs"
 	"file-storage/internal/filedata"
 	"file-storage/internal/handlers/httpdto"
+	"file-storage/internal/imgproc"
 	"file-storage/internal/logger"
 	"fmt"
 	"log/slog"
+	"mime"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -15,6 +18,7 @@ import (
 )
 
 // ContentHandler returns a handler that serves file content by ID and applies optional image transformation parameters from the request.
+// Range, If-Range, If-None-Match and If-Modified-Since requests are answered with 206 or 304 as appropriate.
 func ContentHandler(svc Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -31,10 +35,16 @@ func ContentHandler(svc Service) http.HandlerFunc {
 		}
 
 		cc := filedata.ContentCommand{
-			ID:     cr.ID,
-			Width:  cr.Width,
-			Height: cr.Height,
-			Format: cr.Format,
+			ID:         cr.ID,
+			Preset:     cr.Preset,
+			Width:      cr.Width,
+			Height:     cr.Height,
+			Format:     cr.Format,
+			Mode:       cr.Mode,
+			Gravity:    cr.Gravity,
+			Background: cr.Background,
+			Quality:    cr.Quality,
+			Accept:     cr.Accept,
 		}
 
 		content, err := svc.Content(ctx, &cc)
@@ -42,12 +52,21 @@ func ContentHandler(svc Service) http.HandlerFunc {
 			handleBusinessError(w, log, err)
 			return
 		}
+		defer func() {
+			if err := content.Data.Close(); err != nil {
+				log.Warn("content close error", slog.Any(logger.LogFieldError, err))
+			}
+		}()
 
-		w.WriteHeader(http.StatusOK)
-		_, err = w.Write(content)
-		if err != nil {
-			log.Error("write body error", slog.Any(logger.LogFieldError, err))
+		if cr.Format != nil && *cr.Format == filedata.FormatAuto {
+			w.Header().Set("Vary", "Accept")
+		}
+		if content.ETag != "" {
+			w.Header().Set("ETag", `"`+content.ETag+`"`)
 		}
+		setContentHeaders(w, content, cr.ID, cr.Download)
+
+		http.ServeContent(w, r, "", content.ModTime, content.Data)
 	}
 }
 
@@ -81,9 +100,178 @@ func parseContentRequest(r *http.Request, ID string) (*httpdto.ContentRequest, e
 	}
 
 	format := strings.TrimSpace(q.Get("format"))
+	if strings.EqualFold(format, filedata.FormatAuto) {
+		format = filedata.FormatAuto
+		contentRequest.Accept = acceptedMimeTypes(r.Header.Values("Accept"))
+	}
 	if format != "" {
 		contentRequest.Format = &format
 	}
 
+	modeParam := strings.TrimSpace(q.Get("mode"))
+	if modeParam != "" {
+		mode, ok := imgproc.ParseMode(modeParam)
+		if !ok {
+			return nil, fmt.Errorf("invalid mode param %q: %w", modeParam, errs.ErrWrongUrlParameter)
+		}
+		contentRequest.Mode = mode
+	}
+
+	gravityParam := strings.TrimSpace(q.Get("gravity"))
+	if gravityParam != "" {
+		gravity, ok := imgproc.ParseGravity(gravityParam)
+		if !ok {
+			return nil, fmt.Errorf("invalid gravity param %q: %w", gravityParam, errs.ErrWrongUrlParameter)
+		}
+		contentRequest.Gravity = gravity
+	}
+
+	backgroundParam := strings.TrimSpace(q.Get("background"))
+	if backgroundParam != "" {
+		background, ok := imgproc.ParseColor(backgroundParam)
+		if !ok {
+			return nil, fmt.Errorf("invalid background param %q: %w", backgroundParam, errs.ErrWrongUrlParameter)
+		}
+		contentRequest.Background = &background
+	}
+
+	qualityParam := strings.TrimSpace(q.Get("quality"))
+	if qualityParam != "" {
+		quality, err := strconv.Atoi(qualityParam)
+		if err != nil || quality < 1 || quality > 100 {
+			return nil, fmt.Errorf("invalid quality param %q: %w", qualityParam, errs.ErrWrongUrlParameter)
+		}
+		contentRequest.Quality = &quality
+	}
+
+	presetParam := strings.TrimSpace(q.Get("preset"))
+	if presetParam != "" {
+		if hasTransformParams(&contentRequest) {
+			return nil, fmt.Errorf("preset can not be combined with transformation params: %w", errs.ErrWrongUrlParameter)
+		}
+		contentRequest.Preset = presetParam
+	}
+
+	downloadParam := strings.TrimSpace(q.Get("download"))
+	if downloadParam != "" {
+		download, err := strconv.ParseBool(downloadParam)
+		if err != nil {
+			return nil, fmt.Errorf("invalid download param %q: %w", downloadParam, errs.ErrWrongUrlParameter)
+		}
+		contentRequest.Download = download
+	}
+
 	return &contentRequest, nil
 }
+
+// hasTransformParams reports whether the request sets any image transformation parameter.
+func hasTransformParams(cr *httpdto.ContentRequest) bool {
+	return cr.Width != nil || cr.Height != nil || cr.Format != nil || cr.Mode != "" ||
+		cr.Gravity != "" || cr.Background != nil || cr.Quality != nil
+}
+
+// acceptedMimeTypes returns MIME types listed in Accept headers with a non-zero
+// quality, most preferred first. Wildcards and malformed entries are skipped.
+func acceptedMimeTypes(headers []string) []string {
+	type accepted struct {
+		mimeType string
+		q        float64
+	}
+
+	var list []accepted
+	for _, header := range headers {
+		for _, entry := range strings.Split(header, ",") {
+			mimeType, params, err := mime.ParseMediaType(strings.TrimSpace(entry))
+			if err != nil || strings.Contains(mimeType, "*") {
+				continue
+			}
+
+			q := 1.0
+			if v, ok := params["q"]; ok {
+				q, err = strconv.ParseFloat(v, 64)
+				if err != nil {
+					continue
+				}
+			}
+			if q <= 0 {
+				continue
+			}
+
+			list = append(list, accepted{mimeType: mimeType, q: q})
+		}
+	}
+
+	sort.SliceStable(list, func(i, j int) bool { return list[i].q > list[j].q })
+
+	result := make([]string, 0, len(list))
+	for _, a := range list {
+		result = append(result, a.mimeType)
+	}
+
+	return result
+}
+
+// inlineMimeTypes lists the content types browsers display without running
+// scripts. Content of any other type is served as an attachment.
+var inlineMimeTypes = map[string]bool{
+	"image/jpeg":       true,
+	"image/png":        true,
+	"image/gif":        true,
+	"image/webp":       true,
+	"image/avif":       true,
+	"image/bmp":        true,
+	"audio/mpeg":       true,
+	"audio/ogg":        true,
+	"audio/wav":        true,
+	"audio/webm":       true,
+	"video/mp4":        true,
+	"video/ogg":        true,
+	"video/webm":       true,
+	"text/plain":       true,
+	"text/csv":         true,
+	"application/json": true,
+	"application/pdf":  true,
+}
+
+// contentSecurityPolicy forbids served content to load resources or run
+// scripts in case a browser renders it despite the headers.
+const contentSecurityPolicy = "default-src 'none'; sandbox"
+
+// setContentHeaders sets the type and the disposition of served file content.
+// The type is supplied by the uploading client, so content a browser could run
+// scripts from, such as text/html or image/svg+xml, is served as an attachment
+// and never rendered in the origin of the service. Content without a type is
+// served as application/octet-stream instead of being sniffed.
+func setContentHeaders(w http.ResponseWriter, content *filedata.Content, ID string, download bool) {
+	contentType := content.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !inlineMimeTypes[mediaType] {
+		download = true
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", contentSecurityPolicy)
+	if download {
+		w.Header().Set("Content-Disposition", contentDisposition(content.Filename, ID))
+	}
+}
+
+// contentDisposition builds an attachment disposition. The file ID is used as
+// the name when the original file name is unknown.
+func contentDisposition(filename, ID string) string {
+	if filename == "" {
+		filename = ID
+	}
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+	if disposition == "" {
+		return "attachment"
+	}
+
+	return disposition
+}
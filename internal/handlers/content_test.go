@@ -5,21 +5,31 @@ import (
 	"file-storage/internal/authorization"
 	"file-storage/internal/errs"
 	"file-storage/internal/filedata"
+	"file-storage/internal/imgproc"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestContentHandler(t *testing.T) {
 
 	correctID := "012345678901234567890123456789012345"
+	modTime := time.Date(2026, 5, 3, 10, 0, 0, 0, time.UTC)
+
+	okService := &mockService{fnContent: func(ctx context.Context, cc *filedata.ContentCommand) (*filedata.Content, error) {
+		data := filedata.NopSeekCloser(strings.NewReader("ok"))
+		return &filedata.Content{Data: data, ETag: "hash", ModTime: modTime, ContentType: "text/plain", Filename: "report.txt"}, nil
+	}}
 
 	table := []struct {
-		name       string
-		service    *mockService
-		ctx        context.Context
-		request    *http.Request
-		wantStatus int
+		name        string
+		service     *mockService
+		ctx         context.Context
+		request     *http.Request
+		wantStatus  int
+		wantHeaders map[string]string
 	}{
 		{
 			name:       "invalid id",
@@ -44,7 +54,7 @@ func TestContentHandler(t *testing.T) {
 		},
 		{
 			name: "invalid format",
-			service: &mockService{fnContent: func(ctx context.Context, cc *filedata.ContentCommand) ([]byte, error) {
+			service: &mockService{fnContent: func(ctx context.Context, cc *filedata.ContentCommand) (*filedata.Content, error) {
 				return nil, errs.ErrUnsupportedImageFormat
 			}},
 			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
@@ -52,13 +62,175 @@ func TestContentHandler(t *testing.T) {
 			wantStatus: http.StatusUnsupportedMediaType,
 		},
 		{
-			name: "ok",
-			service: &mockService{fnContent: func(ctx context.Context, cc *filedata.ContentCommand) ([]byte, error) {
-				return []byte("ok"), nil
-			}},
+			name:       "ok",
+			service:    okService,
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			request:    newHttpTestRequest("GET", "/method", ""),
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Content-Type":        "text/plain",
+				"Content-Disposition": "",
+			},
+		},
+		{
+			name:       "download",
+			service:    okService,
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			request:    newHttpTestRequest("GET", "/method?download=1", ""),
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Content-Type":        "text/plain",
+				"Content-Disposition": "attachment; filename=report.txt",
+			},
+		},
+		{
+			name:       "html as attachment",
+			service:    typedContentService("text/html; charset=utf-8"),
 			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
 			request:    newHttpTestRequest("GET", "/method", ""),
 			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Content-Type":            "text/html; charset=utf-8",
+				"Content-Disposition":     "attachment; filename=page.html",
+				"X-Content-Type-Options":  "nosniff",
+				"Content-Security-Policy": "default-src 'none'; sandbox",
+			},
+		},
+		{
+			name:       "svg as attachment",
+			service:    typedContentService("image/svg+xml"),
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			request:    newHttpTestRequest("GET", "/method", ""),
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Content-Type":        "image/svg+xml",
+				"Content-Disposition": "attachment; filename=page.html",
+			},
+		},
+		{
+			name:       "unknown type is not sniffed",
+			service:    typedContentService(""),
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			request:    newHttpTestRequest("GET", "/method", ""),
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Content-Type":        "application/octet-stream",
+				"Content-Disposition": "attachment; filename=page.html",
+			},
+		},
+		{
+			name:       "invalid mode",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			request:    newHttpTestRequest("GET", "/method?mode=stretch", ""),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid gravity",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			request:    newHttpTestRequest("GET", "/method?mode=fill&gravity=middle", ""),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid background",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			request:    newHttpTestRequest("GET", "/method?mode=pad&background=white", ""),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "transformation params",
+			service: &mockService{fnContent: func(ctx context.Context, cc *filedata.ContentCommand) (*filedata.Content, error) {
+				if cc.Mode != imgproc.ModePad || cc.Gravity != imgproc.GravityTop || cc.Background == nil || cc.Background.A != 0x80 {
+					return nil, errs.ErrWrongUrlParameter
+				}
+				return &filedata.Content{Data: filedata.NopSeekCloser(strings.NewReader("ok"))}, nil
+			}},
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			request:    newHttpTestRequest("GET", "/method?mode=pad&gravity=top&background=%23ffffff80", ""),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "preset",
+			service: &mockService{fnContent: func(ctx context.Context, cc *filedata.ContentCommand) (*filedata.Content, error) {
+				if cc.Preset != "thumb" {
+					return nil, errs.ErrWrongUrlParameter
+				}
+				return &filedata.Content{Data: filedata.NopSeekCloser(strings.NewReader("ok"))}, nil
+			}},
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			request:    newHttpTestRequest("GET", "/method?preset=thumb", ""),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "preset with transformation params",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			request:    newHttpTestRequest("GET", "/method?preset=thumb&width=100", ""),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid quality",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			request:    newHttpTestRequest("GET", "/method?quality=101", ""),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "auto format",
+			service: &mockService{fnContent: func(ctx context.Context, cc *filedata.ContentCommand) (*filedata.Content, error) {
+				if cc.Format == nil || *cc.Format != filedata.FormatAuto || len(cc.Accept) != 2 || cc.Accept[0] != "image/webp" {
+					return nil, errs.ErrWrongUrlParameter
+				}
+				return &filedata.Content{Data: filedata.NopSeekCloser(strings.NewReader("ok"))}, nil
+			}},
+			ctx:         newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			request:     withHeader(newHttpTestRequest("GET", "/method?format=AUTO", ""), "Accept", "image/avif;q=0, image/png;q=0.5, image/webp, */*;q=0.8"),
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"Vary": "Accept"},
+		},
+		{
+			name:       "invalid download",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			request:    newHttpTestRequest("GET", "/method?download=err", ""),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "range",
+			service:    okService,
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			request:    withHeader(newHttpTestRequest("GET", "/method", ""), "Range", "bytes=0-0"),
+			wantStatus: http.StatusPartialContent,
+		},
+		{
+			name:       "unsatisfiable range",
+			service:    okService,
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			request:    withHeader(newHttpTestRequest("GET", "/method", ""), "Range", "bytes=10-20"),
+			wantStatus: http.StatusRequestedRangeNotSatisfiable,
+		},
+		{
+			name:       "if-range mismatch",
+			service:    okService,
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			request:    withHeader(withHeader(newHttpTestRequest("GET", "/method", ""), "Range", "bytes=0-0"), "If-Range", `"other"`),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "if-none-match",
+			service:    okService,
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			request:    withHeader(newHttpTestRequest("GET", "/method", ""), "If-None-Match", `"hash"`),
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "if-modified-since",
+			service:    okService,
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			request:    withHeader(newHttpTestRequest("GET", "/method", ""), "If-Modified-Since", modTime.Add(time.Hour).Format(http.TimeFormat)),
+			wantStatus: http.StatusNotModified,
 		},
 	}
 
@@ -73,6 +245,24 @@ func TestContentHandler(t *testing.T) {
 			if w.Code != tt.wantStatus {
 				t.Errorf("got status %d want %d; responce %s", w.Code, tt.wantStatus, w.Body)
 			}
+			for key, want := range tt.wantHeaders {
+				if got := w.Header().Get(key); got != want {
+					t.Errorf("got header %s %q want %q", key, got, want)
+				}
+			}
 		})
 	}
 }
+
+func withHeader(r *http.Request, key, value string) *http.Request {
+	r.Header.Set(key, value)
+	return r
+}
+
+// typedContentService returns a service serving an HTML page with the content type.
+func typedContentService(contentType string) *mockService {
+	return &mockService{fnContent: func(ctx context.Context, cc *filedata.ContentCommand) (*filedata.Content, error) {
+		data := filedata.NopSeekCloser(strings.NewReader("<html><script>alert(1)</script></html>"))
+		return &filedata.Content{Data: data, ContentType: contentType, Filename: "page.html"}, nil
+	}}
+}
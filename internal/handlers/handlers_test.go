@@ -7,6 +7,7 @@ import (
 	"file-storage/internal/contextkeys"
 	"file-storage/internal/filedata"
 	"file-storage/internal/logger"
+	"io"
 	"net/http"
 	"net/http/httptest"
 
@@ -15,15 +16,25 @@ import (
 
 type mockService struct {
 	fnUpdate  func(ctx context.Context, uc *filedata.UploadCommand) (string, error)
-	fnContent func(ctx context.Context, cc *filedata.ContentCommand) ([]byte, error)
+	fnContent func(ctx context.Context, cc *filedata.ContentCommand) (*filedata.Content, error)
 	fnInfo    func(ctx context.Context, ID string) (*filedata.FileInfo, error)
 	fnDelete  func(ctx context.Context, ID string) error
+	fnList    func(ctx context.Context, q *filedata.ListQuery) (*filedata.FileList, error)
+
+	fnVersions       func(ctx context.Context, ID string) (*filedata.VersionList, error)
+	fnVersionContent func(ctx context.Context, ID string, version int) (*filedata.Content, error)
+	fnRestoreVersion func(ctx context.Context, ID string, version int) (*filedata.FileInfo, error)
+	fnTrash          func(ctx context.Context, cursor string, limit int) (*filedata.TrashList, error)
+	fnRestore        func(ctx context.Context, ID string) (*filedata.FileInfo, error)
+	fnBackup         func(ctx context.Context, w io.Writer) error
+	fnScrub          func(ctx context.Context, ID string) (*filedata.ScrubResult, error)
+	fnOriginal       func(ctx context.Context, ID string) (*filedata.Content, error)
 }
 
 func (s *mockService) Update(ctx context.Context, uc *filedata.UploadCommand) (string, error) {
 	return s.fnUpdate(ctx, uc)
 }
-func (s *mockService) Content(ctx context.Context, cc *filedata.ContentCommand) ([]byte, error) {
+func (s *mockService) Content(ctx context.Context, cc *filedata.ContentCommand) (*filedata.Content, error) {
 	return s.fnContent(ctx, cc)
 }
 func (s *mockService) Info(ctx context.Context, ID string) (*filedata.FileInfo, error) {
@@ -32,6 +43,33 @@ func (s *mockService) Info(ctx context.Context, ID string) (*filedata.FileInfo,
 func (s *mockService) Delete(ctx context.Context, ID string) error {
 	return s.fnDelete(ctx, ID)
 }
+func (s *mockService) List(ctx context.Context, q *filedata.ListQuery) (*filedata.FileList, error) {
+	return s.fnList(ctx, q)
+}
+func (s *mockService) Versions(ctx context.Context, ID string) (*filedata.VersionList, error) {
+	return s.fnVersions(ctx, ID)
+}
+func (s *mockService) VersionContent(ctx context.Context, ID string, version int) (*filedata.Content, error) {
+	return s.fnVersionContent(ctx, ID, version)
+}
+func (s *mockService) RestoreVersion(ctx context.Context, ID string, version int) (*filedata.FileInfo, error) {
+	return s.fnRestoreVersion(ctx, ID, version)
+}
+func (s *mockService) Trash(ctx context.Context, cursor string, limit int) (*filedata.TrashList, error) {
+	return s.fnTrash(ctx, cursor, limit)
+}
+func (s *mockService) Restore(ctx context.Context, ID string) (*filedata.FileInfo, error) {
+	return s.fnRestore(ctx, ID)
+}
+func (s *mockService) Backup(ctx context.Context, w io.Writer) error {
+	return s.fnBackup(ctx, w)
+}
+func (s *mockService) Scrub(ctx context.Context, ID string) (*filedata.ScrubResult, error) {
+	return s.fnScrub(ctx, ID)
+}
+func (s *mockService) Original(ctx context.Context, ID string) (*filedata.Content, error) {
+	return s.fnOriginal(ctx, ID)
+}
 
 func newHttpTestRequest(method, target, body string) *http.Request {
 	reader := bytes.NewReader([]byte(body))
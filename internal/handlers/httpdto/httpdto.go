@@ -1,5 +1,11 @@
 package httpdto
 
+import (
+	"file-storage/internal/imgproc"
+	"image/color"
+	"time"
+)
+
 // UploadRequest describes the JSON payload accepted by the upload endpoint.
 type UploadRequest struct {
 	ID       string         `json:"id"`
@@ -8,12 +14,66 @@ type UploadRequest struct {
 	Public   bool           `json:"public"`
 	IsImage  *bool          `json:"is_image"`
 	Metadata map[string]any `json:"metadata"`
+	MimeType string         `json:"mime_type"`
+	Filename string         `json:"filename"`
+}
+
+// UploadParams describes upload parameters passed alongside a streamed body,
+// either as multipart form fields or as query parameters and headers.
+type UploadParams struct {
+	ID       string
+	Hash     string
+	Public   bool
+	IsImage  *bool
+	Metadata map[string]any
+	MimeType string
+	Filename string
 }
 
 // ContentRequest describes path and query parameters accepted by the content
 type ContentRequest struct {
-	ID     string
-	Width  *int
-	Height *int
-	Format *string
+	ID         string
+	Preset     string
+	Width      *int
+	Height     *int
+	Format     *string
+	Mode       imgproc.Mode
+	Gravity    imgproc.Gravity
+	Background *color.NRGBA
+	Quality    *int
+	Accept     []string
+	Download   bool
+}
+
+// PresignRequest describes the JSON payload accepted by the presign endpoint.
+// ExpiresIn is the validity of the URL in seconds, Params are content query
+// parameters the URL is bound to and IP is the client IP it is bound to.
+type PresignRequest struct {
+	ExpiresIn int               `json:"expires_in"`
+	Params    map[string]string `json:"params"`
+	IP        string            `json:"ip"`
+}
+
+// PresignResponse describes a presigned content URL. URL is relative to the
+// service root.
+type PresignResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ListRequest describes query parameters accepted by the list endpoint.
+// Metadata holds values of metadata.<key> parameters by key.
+type ListRequest struct {
+	Cursor      string
+	Limit       int
+	Public      *bool
+	IsImage     *bool
+	Format      imgproc.ImgFormat
+	MinSize     *int
+	MaxSize     *int
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	UpdatedFrom *time.Time
+	UpdatedTo   *time.Time
+	Metadata    map[string]string
 }
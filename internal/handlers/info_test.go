@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"file-storage/internal/authorization"
+	"file-storage/internal/errs"
 	"file-storage/internal/filedata"
 	"fmt"
 	"net/http"
@@ -51,6 +52,15 @@ func TestInfoHandler(t *testing.T) {
 			request:    newHttpTestRequest("GET", "/", ""),
 			wantStatus: http.StatusInternalServerError,
 		},
+		{
+			name: "deleted",
+			service: &mockService{fnInfo: func(ctx context.Context, ID string) (*filedata.FileInfo, error) {
+				return nil, errs.ErrFileDeleted
+			}},
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			request:    newHttpTestRequest("GET", "/", ""),
+			wantStatus: http.StatusGone,
+		},
 		{
 			name: "ok",
 			service: &mockService{fnInfo: func(ctx context.Context, ID string) (*filedata.FileInfo, error) {
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"encoding/json"
+	"file-storage/internal/authorization"
+	"file-storage/internal/contextkeys"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"file-storage/internal/handlers/httpdto"
+	"file-storage/internal/imgproc"
+	"file-storage/internal/logger"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metadataParamPrefix is the prefix of query parameters filtering by user metadata.
+const metadataParamPrefix = "metadata."
+
+// ListHandler returns a handler that serves a page of file metadata matching the query filters.
+func ListHandler(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		log := logger.FromContext(ctx)
+		log = logger.WithHandler(log, logger.HandlerList)
+
+		auth, ok := ctx.Value(contextkeys.ContextKeyAuth).(*authorization.Auth)
+		if !ok {
+			err := fmt.Errorf("failed to get Auth structure out of context: %w", errs.ErrContextValueError)
+			handleTransportError(w, log, err)
+			return
+		}
+		if !auth.Read {
+			err := fmt.Errorf("read access denied: %w", errs.ErrAccessDenied)
+			handleTransportError(w, log, err)
+			return
+		}
+
+		lr, err := parseListRequest(r)
+		if err != nil {
+			handleTransportError(w, log, err)
+			return
+		}
+
+		q := filedata.ListQuery{
+			Cursor:      lr.Cursor,
+			Limit:       lr.Limit,
+			Public:      lr.Public,
+			IsImage:     lr.IsImage,
+			Format:      lr.Format,
+			MinSize:     lr.MinSize,
+			MaxSize:     lr.MaxSize,
+			CreatedFrom: lr.CreatedFrom,
+			CreatedTo:   lr.CreatedTo,
+			UpdatedFrom: lr.UpdatedFrom,
+			UpdatedTo:   lr.UpdatedTo,
+			Metadata:    lr.Metadata,
+		}
+
+		fl, err := svc.List(ctx, &q)
+		if err != nil {
+			handleBusinessError(w, log, err)
+			return
+		}
+
+		body, err := json.Marshal(fl)
+		if err != nil {
+			handleBusinessError(w, log, fmt.Errorf("marshalling error: %w", err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(body)
+		if err != nil {
+			log.Error("write body error", slog.Any(logger.LogFieldError, err))
+		}
+	}
+}
+
+func parseListRequest(r *http.Request) (*httpdto.ListRequest, error) {
+	listRequest := httpdto.ListRequest{}
+	q := r.URL.Query()
+
+	listRequest.Cursor = strings.TrimSpace(q.Get("cursor"))
+
+	limit, err := intParam(q, "limit")
+	if err != nil {
+		return nil, err
+	}
+	if limit != nil {
+		if *limit < 1 {
+			return nil, fmt.Errorf("invalid limit param %d: %w", *limit, errs.ErrWrongUrlParameter)
+		}
+		listRequest.Limit = *limit
+	}
+
+	listRequest.Public, err = boolParam(q, "public")
+	if err != nil {
+		return nil, err
+	}
+
+	listRequest.IsImage, err = boolParam(q, "is_image")
+	if err != nil {
+		return nil, err
+	}
+
+	formatParam := strings.TrimSpace(q.Get("format"))
+	if formatParam != "" {
+		format, ok := imgproc.SupportedInputFormat(strings.ToLower(formatParam))
+		if !ok {
+			return nil, fmt.Errorf("invalid format param %q: %w", formatParam, errs.ErrWrongUrlParameter)
+		}
+		listRequest.Format = format
+	}
+
+	listRequest.MinSize, err = intParam(q, "min_size")
+	if err != nil {
+		return nil, err
+	}
+
+	listRequest.MaxSize, err = intParam(q, "max_size")
+	if err != nil {
+		return nil, err
+	}
+
+	for name, target := range map[string]**time.Time{
+		"created_from": &listRequest.CreatedFrom,
+		"created_to":   &listRequest.CreatedTo,
+		"updated_from": &listRequest.UpdatedFrom,
+		"updated_to":   &listRequest.UpdatedTo,
+	} {
+		*target, err = timeParam(q, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for name, values := range q {
+		key, ok := strings.CutPrefix(name, metadataParamPrefix)
+		if !ok {
+			continue
+		}
+		if key == "" || len(values) != 1 {
+			return nil, fmt.Errorf("invalid metadata param %q: %w", name, errs.ErrWrongUrlParameter)
+		}
+		if listRequest.Metadata == nil {
+			listRequest.Metadata = make(map[string]string)
+		}
+		listRequest.Metadata[key] = values[0]
+	}
+
+	return &listRequest, nil
+}
+
+func intParam(q url.Values, name string) (*int, error) {
+	param := strings.TrimSpace(q.Get(name))
+	if param == "" {
+		return nil, nil
+	}
+
+	v, err := strconv.Atoi(param)
+	if err != nil || v < 0 {
+		return nil, fmt.Errorf("invalid %s param %q: %w", name, param, errs.ErrWrongUrlParameter)
+	}
+
+	return &v, nil
+}
+
+func boolParam(q url.Values, name string) (*bool, error) {
+	param := strings.TrimSpace(q.Get(name))
+	if param == "" {
+		return nil, nil
+	}
+
+	v, err := strconv.ParseBool(param)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s param %q: %w", name, param, errs.ErrWrongUrlParameter)
+	}
+
+	return &v, nil
+}
+
+func timeParam(q url.Values, name string) (*time.Time, error) {
+	param := strings.TrimSpace(q.Get(name))
+	if param == "" {
+		return nil, nil
+	}
+
+	v, err := time.Parse(time.RFC3339, param)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s param %q: %w", name, param, errs.ErrWrongUrlParameter)
+	}
+
+	return &v, nil
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"file-storage/internal/authorization"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"file-storage/internal/imgproc"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestListHandler(t *testing.T) {
+
+	table := []struct {
+		name       string
+		service    *mockService
+		ctx        context.Context
+		request    *http.Request
+		wantStatus int
+	}{
+		{
+			name:       "no auth structure in context",
+			service:    &mockService{},
+			ctx:        newContext(nil, nil),
+			request:    newHttpTestRequest("GET", "/files", ""),
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "no rights",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{}, nil),
+			request:    newHttpTestRequest("GET", "/files", ""),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "invalid limit",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Read: true}, nil),
+			request:    newHttpTestRequest("GET", "/files?limit=0", ""),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid public",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Read: true}, nil),
+			request:    newHttpTestRequest("GET", "/files?public=maybe", ""),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid format",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Read: true}, nil),
+			request:    newHttpTestRequest("GET", "/files?format=doc", ""),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid size",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Read: true}, nil),
+			request:    newHttpTestRequest("GET", "/files?min_size=-1", ""),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid time",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Read: true}, nil),
+			request:    newHttpTestRequest("GET", "/files?created_from=yesterday", ""),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "empty metadata key",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Read: true}, nil),
+			request:    newHttpTestRequest("GET", "/files?metadata.=1", ""),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "business error",
+			service: &mockService{fnList: func(ctx context.Context, q *filedata.ListQuery) (*filedata.FileList, error) {
+				return nil, errs.ErrWrongUrlParameter
+			}},
+			ctx:        newContext(&authorization.Auth{Read: true}, nil),
+			request:    newHttpTestRequest("GET", "/files?limit=5000", ""),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "filters",
+			service: &mockService{fnList: func(ctx context.Context, q *filedata.ListQuery) (*filedata.FileList, error) {
+				if q.Cursor != "abc" || q.Limit != 10 || q.Public == nil || !*q.Public || q.IsImage == nil || *q.IsImage ||
+					q.Format != imgproc.ImgFormatJPEG || q.MinSize == nil || *q.MinSize != 1 || q.MaxSize == nil || *q.MaxSize != 100 ||
+					q.CreatedFrom == nil || q.UpdatedTo == nil || q.Metadata["album"] != "trip" {
+					return nil, errs.ErrWrongUrlParameter
+				}
+				return &filedata.FileList{Files: []*filedata.FileInfo{}}, nil
+			}},
+			ctx: newContext(&authorization.Auth{Read: true}, nil),
+			request: newHttpTestRequest("GET", "/files?cursor=abc&limit=10&public=true&is_image=false&format=jpg"+
+				"&min_size=1&max_size=100&created_from=2026-01-01T00:00:00Z&updated_to=2026-02-01T00:00:00%2B03:00&metadata.album=trip", ""),
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			handlerFunc := ListHandler(tt.service)
+
+			w := httptest.NewRecorder()
+			r := tt.request.WithContext(tt.ctx)
+			handlerFunc.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %v want %v; responce %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"file-storage/internal/logger"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+// OriginalHandler returns a handler that serves the original upload of an image file as it was uploaded.
+// Public files are served without the read permission, the same way as their content.
+func OriginalHandler(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		log := logger.FromContext(ctx)
+		log = logger.WithHandler(log, logger.HandlerOriginal)
+
+		ID := strings.TrimSpace(chi.URLParam(r, "id"))
+
+		err := validateID(ID)
+		if err != nil {
+			handleTransportError(w, log, err)
+			return
+		}
+
+		content, err := svc.Original(ctx, ID)
+		if err != nil {
+			handleBusinessError(w, log, err)
+			return
+		}
+		defer func() {
+			if err := content.Data.Close(); err != nil {
+				log.Warn("content close error", slog.Any(logger.LogFieldError, err))
+			}
+		}()
+
+		if content.ETag != "" {
+			w.Header().Set("ETag", `"`+content.ETag+`"`)
+		}
+		setContentHeaders(w, content, ID, false)
+
+		http.ServeContent(w, r, "", content.ModTime, content.Data)
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"file-storage/internal/authorization"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOriginalHandler(t *testing.T) {
+
+	table := []struct {
+		name            string
+		service         *mockService
+		ID              string
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:       "invalid id",
+			service:    &mockService{},
+			ID:         "1",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "no original",
+			service: &mockService{fnOriginal: func(ctx context.Context, ID string) (*filedata.Content, error) {
+				return nil, errs.ErrNotFound
+			}},
+			ID:         testTrashID,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "private file",
+			service: &mockService{fnOriginal: func(ctx context.Context, ID string) (*filedata.Content, error) {
+				return nil, errs.ErrAccessDenied
+			}},
+			ID:         testTrashID,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "not supported",
+			service: &mockService{fnOriginal: func(ctx context.Context, ID string) (*filedata.Content, error) {
+				return nil, errs.ErrOriginalsNotSupported
+			}},
+			ID:         testTrashID,
+			wantStatus: http.StatusNotImplemented,
+		},
+		{
+			name: "ok",
+			service: &mockService{fnOriginal: func(ctx context.Context, ID string) (*filedata.Content, error) {
+				return &filedata.Content{Data: filedata.NopSeekCloser(strings.NewReader("original")), ETag: "hash", ContentType: "image/png"}, nil
+			}},
+			ID:              testTrashID,
+			wantStatus:      http.StatusOK,
+			wantContentType: "image/png",
+			wantBody:        "original",
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			handlerFunc := OriginalHandler(tt.service)
+
+			w := httptest.NewRecorder()
+			ctx := newContext(&authorization.Auth{}, map[string]string{"id": tt.ID})
+			r := newHttpTestRequest("GET", "/files/"+tt.ID+"/original", "").WithContext(ctx)
+			handlerFunc.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %v want %v; responce %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantContentType != "" && w.Header().Get("Content-Type") != tt.wantContentType {
+				t.Errorf("got content type %q want %q", w.Header().Get("Content-Type"), tt.wantContentType)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("got body %q want %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
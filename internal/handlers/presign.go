@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"file-storage/internal/authorization"
+	"file-storage/internal/config"
+	"file-storage/internal/contextkeys"
+	"file-storage/internal/errs"
+	"file-storage/internal/handlers/httpdto"
+	"file-storage/internal/logger"
+	"file-storage/internal/signing"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// defaultPresignExpiry is the validity of presigned URLs when a request does not set one.
+const defaultPresignExpiry = 15 * time.Minute
+
+// presignParams are the content query parameters a presigned URL may be bound to.
+var presignParams = map[string]struct{}{
+	"width":      {},
+	"height":     {},
+	"format":     {},
+	"mode":       {},
+	"gravity":    {},
+	"background": {},
+	"quality":    {},
+	"preset":     {},
+	"download":   {},
+}
+
+// PresignHandler returns a handler that creates a content URL of a file signed
+// with the signing key of the configuration. The URL grants read access to the
+// content until it expires, so creating it requires read access to the file.
+func PresignHandler(svc Service, security config.Security) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		log := logger.FromContext(ctx)
+		log = logger.WithHandler(log, logger.HandlerPresign)
+
+		auth, ok := ctx.Value(contextkeys.ContextKeyAuth).(*authorization.Auth)
+		if !ok {
+			err := fmt.Errorf("failed to get Auth structure out of context: %w", errs.ErrContextValueError)
+			handleTransportError(w, log, err)
+			return
+		}
+		if !auth.Read {
+			err := fmt.Errorf("read access denied: %w", errs.ErrAccessDenied)
+			handleTransportError(w, log, err)
+			return
+		}
+
+		if security.SigningKey == "" {
+			handleTransportError(w, log, errs.ErrSigningNotConfigured)
+			return
+		}
+
+		ID := strings.TrimSpace(chi.URLParam(r, "id"))
+
+		err := validateID(ID)
+		if err != nil {
+			handleTransportError(w, log, err)
+			return
+		}
+
+		query, expiresAt, err := parsePresignRequest(r, ID, security.PresignMaxAge, time.Now())
+		if err != nil {
+			handleTransportError(w, log, err)
+			return
+		}
+
+		// URLs are signed only for existing files
+		_, err = svc.Info(ctx, ID)
+		if err != nil {
+			handleBusinessError(w, log, err)
+			return
+		}
+
+		path := "/files/" + ID + "/content"
+		query.Set(signing.ParamSignature, signing.Sign(security.SigningKey, signing.Payload(path, query)))
+
+		body, err := json.Marshal(httpdto.PresignResponse{URL: path + "?" + query.Encode(), ExpiresAt: expiresAt})
+		if err != nil {
+			handleBusinessError(w, log, fmt.Errorf("marshalling error: %w", err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(body)
+		if err != nil {
+			log.Error("write body error", slog.Any(logger.LogFieldError, err))
+		}
+	}
+}
+
+// parsePresignRequest returns the query of the presigned URL without the
+// signature and its expiration time. An empty body requests a URL with
+// default validity and no bound parameters.
+func parsePresignRequest(r *http.Request, ID string, maxAge time.Duration, now time.Time) (url.Values, time.Time, error) {
+	var pr httpdto.PresignRequest
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&pr)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, time.Time{}, fmt.Errorf("invalid request payload: %w: %v", errs.ErrInvalidRequestPayload, err)
+	}
+
+	expiry := defaultPresignExpiry
+	if pr.ExpiresIn != 0 {
+		expiry = time.Duration(pr.ExpiresIn) * time.Second
+	}
+	if expiry <= 0 || expiry > maxAge {
+		return nil, time.Time{}, fmt.Errorf("expires_in must be between 1 and %d seconds: %w", int(maxAge.Seconds()), errs.ErrInvalidRequestPayload)
+	}
+
+	query := url.Values{}
+	for k, v := range pr.Params {
+		if _, ok := presignParams[k]; !ok {
+			return nil, time.Time{}, fmt.Errorf("unsupported param %q: %w", k, errs.ErrInvalidRequestPayload)
+		}
+		query.Set(k, v)
+	}
+
+	// the URL is checked the same way as it is checked on use
+	_, err = parseContentRequest(&http.Request{URL: &url.URL{RawQuery: query.Encode()}}, ID)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if pr.IP != "" {
+		ip := net.ParseIP(pr.IP)
+		if ip == nil {
+			return nil, time.Time{}, fmt.Errorf("invalid ip %q: %w", pr.IP, errs.ErrInvalidRequestPayload)
+		}
+		query.Set(signing.ParamIP, ip.String())
+	}
+
+	expiresAt := now.Add(expiry).Truncate(time.Second).UTC()
+	query.Set(signing.ParamExpires, strconv.FormatInt(expiresAt.Unix(), 10))
+
+	return query, expiresAt, nil
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"file-storage/internal/authorization"
+	"file-storage/internal/config"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"file-storage/internal/handlers/httpdto"
+	"file-storage/internal/signing"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestPresignHandler(t *testing.T) {
+
+	correctID := "012345678901234567890123456789012345"
+	security := config.Security{ReadToken: "1", WriteToken: "2", SigningKey: "key", PresignMaxAge: time.Hour}
+
+	okService := &mockService{fnInfo: func(ctx context.Context, ID string) (*filedata.FileInfo, error) {
+		return &filedata.FileInfo{ID: ID}, nil
+	}}
+
+	table := []struct {
+		name       string
+		service    *mockService
+		security   config.Security
+		ctx        context.Context
+		body       string
+		wantStatus int
+		wantQuery  url.Values
+	}{
+		{
+			name:       "no read access",
+			service:    okService,
+			security:   security,
+			ctx:        newContext(&authorization.Auth{Signed: true}, map[string]string{"id": correctID}),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "signing not configured",
+			service:    okService,
+			security:   config.Security{ReadToken: "1", WriteToken: "2"},
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			wantStatus: http.StatusNotImplemented,
+		},
+		{
+			name:       "invalid id",
+			service:    okService,
+			security:   security,
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": "12"}),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unsupported param",
+			service:    okService,
+			security:   security,
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			body:       `{"params": {"sig": "00"}}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid param",
+			service:    okService,
+			security:   security,
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			body:       `{"params": {"width": "wide"}}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "expiry above maximum",
+			service:    okService,
+			security:   security,
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			body:       `{"expires_in": 7200}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid ip",
+			service:    okService,
+			security:   security,
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			body:       `{"ip": "localhost"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "not found",
+			service: &mockService{fnInfo: func(ctx context.Context, ID string) (*filedata.FileInfo, error) {
+				return nil, errs.ErrNotFound
+			}},
+			security:   security,
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "default expiry",
+			service:    okService,
+			security:   security,
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			wantStatus: http.StatusOK,
+			wantQuery:  url.Values{},
+		},
+		{
+			name:       "bound to params and ip",
+			service:    okService,
+			security:   security,
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": correctID}),
+			body:       `{"expires_in": 600, "params": {"width": "200", "format": "webp"}, "ip": "203.0.113.7"}`,
+			wantStatus: http.StatusOK,
+			wantQuery:  url.Values{"width": {"200"}, "format": {"webp"}, "ip": {"203.0.113.7"}},
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			handlerFunc := PresignHandler(tt.service, tt.security)
+
+			w := httptest.NewRecorder()
+			r := newHttpTestRequest("POST", "/files/"+correctID+"/presign", tt.body).WithContext(tt.ctx)
+			handlerFunc.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %v want %v; responce %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantQuery == nil {
+				return
+			}
+
+			var resp httpdto.PresignResponse
+			err := json.Unmarshal(w.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatalf("unmarshal response error: %v", err)
+			}
+
+			u, err := url.Parse(resp.URL)
+			if err != nil {
+				t.Fatalf("parse url error: %v", err)
+			}
+			if u.Path != "/files/"+correctID+"/content" {
+				t.Errorf("path got %q", u.Path)
+			}
+			if !signing.Verify(tt.security.SigningKey, u, time.Now()) {
+				t.Errorf("url %q is not signed", resp.URL)
+			}
+			if signing.Verify(tt.security.SigningKey, u, resp.ExpiresAt.Add(time.Second)) {
+				t.Errorf("url %q is valid after %v", resp.URL, resp.ExpiresAt)
+			}
+
+			q := u.Query()
+			for k := range tt.wantQuery {
+				if q.Get(k) != tt.wantQuery.Get(k) {
+					t.Errorf("param %s got %q want %q", k, q.Get(k), tt.wantQuery.Get(k))
+				}
+			}
+		})
+	}
+}
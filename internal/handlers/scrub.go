@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"file-storage/internal/authorization"
+	"file-storage/internal/contextkeys"
+	"file-storage/internal/errs"
+	"file-storage/internal/logger"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+// ScrubHandler returns a handler that verifies stored content of a file
+// against its content hash and responds with the result of the check.
+func ScrubHandler(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		log := logger.FromContext(ctx)
+		log = logger.WithHandler(log, logger.HandlerScrub)
+
+		auth, ok := ctx.Value(contextkeys.ContextKeyAuth).(*authorization.Auth)
+		if !ok {
+			err := fmt.Errorf("failed to get Auth structure out of context: %w", errs.ErrContextValueError)
+			handleTransportError(w, log, err)
+			return
+		}
+		// a corrupted file may be moved to quarantine
+		if !auth.Write {
+			err := fmt.Errorf("write access denied: %w", errs.ErrAccessDenied)
+			handleTransportError(w, log, err)
+			return
+		}
+
+		ID := strings.TrimSpace(chi.URLParam(r, "id"))
+
+		err := validateID(ID)
+		if err != nil {
+			handleTransportError(w, log, err)
+			return
+		}
+
+		sr, err := svc.Scrub(ctx, ID)
+		if err != nil {
+			handleBusinessError(w, log, err)
+			return
+		}
+
+		body, err := json.Marshal(sr)
+		if err != nil {
+			handleBusinessError(w, log, fmt.Errorf("marshalling error: %w", err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(body)
+		if err != nil {
+			log.Error("write body error", slog.Any(logger.LogFieldError, err))
+		}
+	}
+}
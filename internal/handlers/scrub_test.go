@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"file-storage/internal/authorization"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestScrubHandler(t *testing.T) {
+
+	table := []struct {
+		name       string
+		service    *mockService
+		ctx        context.Context
+		wantStatus int
+	}{
+		{
+			name:       "read token only",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": testTrashID}),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "invalid id",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Write: true}, map[string]string{"id": "1"}),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "not found",
+			service: &mockService{fnScrub: func(ctx context.Context, ID string) (*filedata.ScrubResult, error) {
+				return nil, errs.ErrNotFound
+			}},
+			ctx:        newContext(&authorization.Auth{Write: true}, map[string]string{"id": testTrashID}),
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "not supported",
+			service: &mockService{fnScrub: func(ctx context.Context, ID string) (*filedata.ScrubResult, error) {
+				return nil, errs.ErrScrubNotSupported
+			}},
+			ctx:        newContext(&authorization.Auth{Write: true}, map[string]string{"id": testTrashID}),
+			wantStatus: http.StatusNotImplemented,
+		},
+		{
+			name: "corrupted",
+			service: &mockService{fnScrub: func(ctx context.Context, ID string) (*filedata.ScrubResult, error) {
+				return &filedata.ScrubResult{ID: ID, Status: filedata.ScrubStatusCorrupted}, nil
+			}},
+			ctx:        newContext(&authorization.Auth{Write: true}, map[string]string{"id": testTrashID}),
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			handlerFunc := ScrubHandler(tt.service)
+
+			w := httptest.NewRecorder()
+			r := newHttpTestRequest("POST", "/admin/scrub/"+testTrashID, "").WithContext(tt.ctx)
+			handlerFunc.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %v want %v; responce %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
@@ -3,12 +3,23 @@ package handlers
 import (
 	"context"
 	"file-storage/internal/filedata"
+	"io"
 )
 
-// Service defines the business operations required by HTTP handlers to upload files, read content and metadata, and delete files.
+// Service defines the business operations required by HTTP handlers to upload files, read content and metadata, list and delete files
+// to access previous file versions, deleted files and original uploads, to back up all files and to verify stored content.
 type Service interface {
 	Update(ctx context.Context, uc *filedata.UploadCommand) (string, error)
-	Content(ctx context.Context, cc *filedata.ContentCommand) ([]byte, error)
+	Content(ctx context.Context, cc *filedata.ContentCommand) (*filedata.Content, error)
 	Info(ctx context.Context, ID string) (*filedata.FileInfo, error)
 	Delete(ctx context.Context, ID string) error
+	List(ctx context.Context, q *filedata.ListQuery) (*filedata.FileList, error)
+	Versions(ctx context.Context, ID string) (*filedata.VersionList, error)
+	VersionContent(ctx context.Context, ID string, version int) (*filedata.Content, error)
+	RestoreVersion(ctx context.Context, ID string, version int) (*filedata.FileInfo, error)
+	Trash(ctx context.Context, cursor string, limit int) (*filedata.TrashList, error)
+	Restore(ctx context.Context, ID string) (*filedata.FileInfo, error)
+	Backup(ctx context.Context, w io.Writer) error
+	Scrub(ctx context.Context, ID string) (*filedata.ScrubResult, error)
+	Original(ctx context.Context, ID string) (*filedata.Content, error)
 }
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"file-storage/internal/authorization"
+	"file-storage/internal/contextkeys"
+	"file-storage/internal/errs"
+	"file-storage/internal/logger"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+// TrashHandler returns a handler that serves a page of deleted files kept in the trash.
+func TrashHandler(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		log := logger.FromContext(ctx)
+		log = logger.WithHandler(log, logger.HandlerTrash)
+
+		auth, ok := ctx.Value(contextkeys.ContextKeyAuth).(*authorization.Auth)
+		if !ok {
+			err := fmt.Errorf("failed to get Auth structure out of context: %w", errs.ErrContextValueError)
+			handleTransportError(w, log, err)
+			return
+		}
+		if !auth.Read {
+			err := fmt.Errorf("read access denied: %w", errs.ErrAccessDenied)
+			handleTransportError(w, log, err)
+			return
+		}
+
+		q := r.URL.Query()
+		cursor := strings.TrimSpace(q.Get("cursor"))
+
+		limit, err := intParam(q, "limit")
+		if err != nil {
+			handleTransportError(w, log, err)
+			return
+		}
+		if limit != nil && *limit < 1 {
+			err := fmt.Errorf("invalid limit param %d: %w", *limit, errs.ErrWrongUrlParameter)
+			handleTransportError(w, log, err)
+			return
+		}
+
+		// a zero limit means the default page size
+		pageSize := 0
+		if limit != nil {
+			pageSize = *limit
+		}
+
+		tl, err := svc.Trash(ctx, cursor, pageSize)
+		if err != nil {
+			handleBusinessError(w, log, err)
+			return
+		}
+
+		body, err := json.Marshal(tl)
+		if err != nil {
+			handleBusinessError(w, log, fmt.Errorf("marshalling error: %w", err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(body)
+		if err != nil {
+			log.Error("write body error", slog.Any(logger.LogFieldError, err))
+		}
+	}
+}
+
+// RestoreHandler returns a handler that moves a deleted file back from the trash
+// and responds with the restored file metadata.
+func RestoreHandler(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		log := logger.FromContext(ctx)
+		log = logger.WithHandler(log, logger.HandlerRestore)
+
+		auth, ok := ctx.Value(contextkeys.ContextKeyAuth).(*authorization.Auth)
+		if !ok {
+			err := fmt.Errorf("failed to get Auth structure out of context: %w", errs.ErrContextValueError)
+			handleTransportError(w, log, err)
+			return
+		}
+		if !auth.Write {
+			err := fmt.Errorf("write access denied: %w", errs.ErrAccessDenied)
+			handleTransportError(w, log, err)
+			return
+		}
+
+		ID := strings.TrimSpace(chi.URLParam(r, "id"))
+
+		err := validateID(ID)
+		if err != nil {
+			handleTransportError(w, log, err)
+			return
+		}
+
+		fi, err := svc.Restore(ctx, ID)
+		if err != nil {
+			handleBusinessError(w, log, err)
+			return
+		}
+
+		body, err := json.Marshal(fi)
+		if err != nil {
+			handleBusinessError(w, log, fmt.Errorf("marshalling error: %w", err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(body)
+		if err != nil {
+			log.Error("write body error", slog.Any(logger.LogFieldError, err))
+		}
+	}
+}
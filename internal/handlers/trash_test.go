@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"file-storage/internal/authorization"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testTrashID = "123456789012345678901234567890123456"
+
+func TestTrashHandler(t *testing.T) {
+
+	table := []struct {
+		name       string
+		service    *mockService
+		target     string
+		ctx        context.Context
+		wantStatus int
+	}{
+		{
+			name:       "no rights",
+			service:    &mockService{},
+			target:     "/files/trash",
+			ctx:        newContext(&authorization.Auth{}, nil),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "invalid limit",
+			service:    &mockService{},
+			target:     "/files/trash?limit=0",
+			ctx:        newContext(&authorization.Auth{Read: true}, nil),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "not supported",
+			service: &mockService{fnTrash: func(ctx context.Context, cursor string, limit int) (*filedata.TrashList, error) {
+				return nil, errs.ErrTrashNotSupported
+			}},
+			target:     "/files/trash",
+			ctx:        newContext(&authorization.Auth{Read: true}, nil),
+			wantStatus: http.StatusNotImplemented,
+		},
+		{
+			name: "ok",
+			service: &mockService{fnTrash: func(ctx context.Context, cursor string, limit int) (*filedata.TrashList, error) {
+				if cursor != "abc" || limit != 10 {
+					return nil, errs.ErrWrongUrlParameter
+				}
+				return &filedata.TrashList{Files: []*filedata.DeletedFile{}}, nil
+			}},
+			target:     "/files/trash?cursor=abc&limit=10",
+			ctx:        newContext(&authorization.Auth{Read: true}, nil),
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			handlerFunc := TrashHandler(tt.service)
+
+			w := httptest.NewRecorder()
+			r := newHttpTestRequest("GET", tt.target, "").WithContext(tt.ctx)
+			handlerFunc.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %v want %v; responce %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestRestoreHandler(t *testing.T) {
+
+	table := []struct {
+		name       string
+		service    *mockService
+		ctx        context.Context
+		wantStatus int
+	}{
+		{
+			name:       "read token only",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": testTrashID}),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "invalid id",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Write: true}, map[string]string{"id": "1"}),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "not in trash",
+			service: &mockService{fnRestore: func(ctx context.Context, ID string) (*filedata.FileInfo, error) {
+				return nil, errs.ErrNotFound
+			}},
+			ctx:        newContext(&authorization.Auth{Write: true}, map[string]string{"id": testTrashID}),
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "file exists",
+			service: &mockService{fnRestore: func(ctx context.Context, ID string) (*filedata.FileInfo, error) {
+				return nil, errs.ErrFileExists
+			}},
+			ctx:        newContext(&authorization.Auth{Write: true}, map[string]string{"id": testTrashID}),
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name: "ok",
+			service: &mockService{fnRestore: func(ctx context.Context, ID string) (*filedata.FileInfo, error) {
+				return &filedata.FileInfo{ID: ID}, nil
+			}},
+			ctx:        newContext(&authorization.Auth{Write: true}, map[string]string{"id": testTrashID}),
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			handlerFunc := RestoreHandler(tt.service)
+
+			w := httptest.NewRecorder()
+			r := newHttpTestRequest("POST", "/files/"+testTrashID+"/restore", "").WithContext(tt.ctx)
+			handlerFunc.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %v want %v; responce %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package handlers
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"file-storage/internal/authorization"
 	"file-storage/internal/contextkeys"
@@ -9,18 +13,48 @@ import (
 	"file-storage/internal/handlers/httpdto"
 	"file-storage/internal/logger"
 	"fmt"
+	"hash"
+	"io"
 	"log/slog"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"strconv"
 	"strings"
 
+	"github.com/go-chi/chi"
 	"github.com/google/uuid"
 )
 
-// UploadHandler returns a handler that creates a new file or updates an existing one from the JSON request body.
+const (
+	// HeaderContentHash carries the optional expected SHA-256 of a raw upload body.
+	HeaderContentHash = "X-Content-Hash"
+	// HeaderFileMetadata carries optional user metadata of a raw upload as a JSON object.
+	HeaderFileMetadata = "X-File-Metadata"
+)
+
+const (
+	multipartFieldID       = "id"
+	multipartFieldHash     = "hash"
+	multipartFieldPublic   = "public"
+	multipartFieldIsImage  = "is_image"
+	multipartFieldMetadata = "metadata"
+	multipartFieldMimeType = "mime_type"
+	multipartFieldFilename = "filename"
+	multipartFieldFile     = "file"
+
+	// maxMultipartFieldSize limits the size of a single non-file multipart field.
+	maxMultipartFieldSize = 64 * 1024
+
+	// sniffLen is the number of leading bytes used for content type detection.
+	sniffLen = 512
+)
+
+// UploadHandler returns a handler that creates a new file or updates an existing one.
+// The request body is either a JSON document with base64 encoded data or a
+// multipart/form-data stream whose file part is passed to the service without buffering.
 func UploadHandler(svc Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var ur httpdto.UploadRequest
-
 		ctx := r.Context()
 		log := logger.FromContext(ctx)
 		log = logger.WithHandler(log, logger.HandlerUpdate)
@@ -37,59 +71,314 @@ func UploadHandler(svc Service) http.HandlerFunc {
 			return
 		}
 
-		decoder := json.NewDecoder(r.Body)
 		defer r.Body.Close()
 
-		decoder.DisallowUnknownFields()
-		err := decoder.Decode(&ur)
+		var uc *filedata.UploadCommand
+		var err error
+		if isMultipartRequest(r) {
+			uc, err = parseMultipartUpload(r)
+		} else {
+			uc, err = parseJSONUpload(r)
+		}
 		if err != nil {
-			http.Error(w, "invalid request payload", http.StatusBadRequest)
-			log.Error("failed to read body", slog.Any(logger.LogFieldError, err))
+			handleTransportError(w, log, err)
 			return
 		}
 
-		ur.ID = strings.TrimSpace(ur.ID)
-		err = validateUploadRequest(&ur)
+		if uc.ID == "" {
+			uc.ID = uuid.New().String()
+		}
+
+		ID, err := svc.Update(ctx, uc)
 		if err != nil {
-			handleTransportError(w, log, err)
+			handleBusinessError(w, log, err)
 			return
 		}
 
-		if ur.ID == "" {
-			ur.ID = uuid.New().String()
+		writeUploadResponse(w, log, ID)
+	}
+}
+
+// PutHandler returns a handler that creates or replaces a file with the given ID
+// from the raw request body. The body is streamed to the service and hashed on the fly.
+func PutHandler(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+		log = logger.WithHandler(log, logger.HandlerPut)
+
+		auth, ok := ctx.Value(contextkeys.ContextKeyAuth).(*authorization.Auth)
+		if !ok {
+			err := fmt.Errorf("failed to get Auth structure out of context: %w", errs.ErrContextValueError)
+			handleTransportError(w, log, err)
+			return
+		}
+		if !auth.Write {
+			err := fmt.Errorf("write access denied: %w", errs.ErrAccessDenied)
+			handleTransportError(w, log, err)
+			return
 		}
 
-		var uc filedata.UploadCommand
-		uc.ID = ur.ID
-		uc.Hash = ur.Hash
-		uc.Public = ur.Public
-		uc.Data = ur.Data
-		uc.Metadata = ur.Metadata
-		if ur.IsImage == nil {
-			isImage := isImage(uc.Data)
-			uc.IsImage = isImage
-		} else {
-			uc.IsImage = *ur.IsImage
+		defer r.Body.Close()
+
+		uc, err := parsePutUpload(r)
+		if err != nil {
+			handleTransportError(w, log, err)
+			return
 		}
 
-		ID, err := svc.Update(ctx, &uc)
+		ID, err := svc.Update(ctx, uc)
 		if err != nil {
 			handleBusinessError(w, log, err)
 			return
 		}
 
-		body, err := json.Marshal(map[string]string{"id": ID})
+		writeUploadResponse(w, log, ID)
+	}
+}
+
+func parseJSONUpload(r *http.Request) (*filedata.UploadCommand, error) {
+	var ur httpdto.UploadRequest
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&ur)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request payload: %w: %v", errs.ErrInvalidRequestPayload, err)
+	}
+
+	ur.ID = strings.TrimSpace(ur.ID)
+	err = validateUploadRequest(&ur)
+	if err != nil {
+		return nil, err
+	}
+
+	uc := filedata.UploadCommand{
+		ID:       ur.ID,
+		Data:     bytes.NewReader(ur.Data),
+		Hash:     ur.Hash,
+		Public:   ur.Public,
+		Metadata: ur.Metadata,
+		MimeType: ur.MimeType,
+		Filename: ur.Filename,
+	}
+	if ur.IsImage == nil {
+		uc.IsImage = isImage(ur.Data)
+	} else {
+		uc.IsImage = *ur.IsImage
+	}
+
+	return &uc, nil
+}
+
+// parseMultipartUpload reads form fields up to the file part and returns a command
+// whose data reader is the file part itself. The file part must be the last one.
+func parseMultipartUpload(r *http.Request) (*filedata.UploadCommand, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("invalid multipart payload: %w: %v", errs.ErrInvalidRequestPayload, err)
+	}
+
+	var params httpdto.UploadParams
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, errs.ErrNoDataToUpload
+		}
 		if err != nil {
-			handleBusinessError(w, log, fmt.Errorf("marshalling error: %w", err))
-			return
+			return nil, fmt.Errorf("invalid multipart payload: %w: %v", errs.ErrInvalidRequestPayload, err)
+		}
+
+		name := part.FormName()
+		if name == multipartFieldFile {
+			if params.Filename == "" {
+				params.Filename = part.FileName()
+			}
+			if params.MimeType == "" {
+				params.MimeType = part.Header.Get("Content-Type")
+			}
+			return newStreamUploadCommand(&params, part)
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		_, err = w.Write([]byte(body))
+		value, err := readMultipartField(part)
 		if err != nil {
-			log.Error("write body error", slog.Any(logger.LogFieldError, err))
+			return nil, err
 		}
 
+		err = applyUploadParam(&params, name, value)
+		if err != nil {
+			return nil, err
+		}
 	}
 }
+
+func parsePutUpload(r *http.Request) (*filedata.UploadCommand, error) {
+	var params httpdto.UploadParams
+
+	params.ID = strings.TrimSpace(chi.URLParam(r, "id"))
+	err := validateID(params.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	q := r.URL.Query()
+	for _, name := range []string{multipartFieldPublic, multipartFieldIsImage, multipartFieldFilename} {
+		value := strings.TrimSpace(q.Get(name))
+		if value == "" {
+			continue
+		}
+		err := applyUploadParam(&params, name, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	params.Hash = strings.TrimSpace(r.Header.Get(HeaderContentHash))
+	params.MimeType = r.Header.Get("Content-Type")
+
+	metadata := strings.TrimSpace(r.Header.Get(HeaderFileMetadata))
+	if metadata != "" {
+		err := applyUploadParam(&params, multipartFieldMetadata, metadata)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newStreamUploadCommand(&params, r.Body)
+}
+
+func newStreamUploadCommand(params *httpdto.UploadParams, body io.Reader) (*filedata.UploadCommand, error) {
+	err := validateUploadParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReaderSize(body, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, fmt.Errorf("body read error: %w", err)
+	}
+	if len(head) == 0 {
+		return nil, errs.ErrNoDataToUpload
+	}
+
+	var data io.Reader = br
+	if params.Hash != "" {
+		data = newHashVerifyingReader(br, params.Hash)
+	}
+
+	uc := filedata.UploadCommand{
+		ID:       params.ID,
+		Data:     data,
+		Hash:     params.Hash,
+		Public:   params.Public,
+		Metadata: params.Metadata,
+		MimeType: params.MimeType,
+		Filename: params.Filename,
+	}
+	if params.IsImage == nil {
+		uc.IsImage = isImage(head)
+	} else {
+		if *params.IsImage && !isImage(head) {
+			return nil, errs.ErrNotSupportedImageType
+		}
+		uc.IsImage = *params.IsImage
+	}
+
+	return &uc, nil
+}
+
+func applyUploadParam(params *httpdto.UploadParams, name, value string) error {
+	switch name {
+	case multipartFieldID:
+		params.ID = strings.TrimSpace(value)
+	case multipartFieldHash:
+		params.Hash = strings.TrimSpace(value)
+	case multipartFieldPublic:
+		public, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s param %q: %w", name, value, errs.ErrWrongUrlParameter)
+		}
+		params.Public = public
+	case multipartFieldIsImage:
+		isImage, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s param %q: %w", name, value, errs.ErrWrongUrlParameter)
+		}
+		params.IsImage = &isImage
+	case multipartFieldMetadata:
+		var metadata map[string]any
+		err := json.Unmarshal([]byte(value), &metadata)
+		if err != nil {
+			return fmt.Errorf("invalid metadata: %w: %v", errs.ErrInvalidRequestPayload, err)
+		}
+		params.Metadata = metadata
+	case multipartFieldMimeType:
+		params.MimeType = value
+	case multipartFieldFilename:
+		params.Filename = value
+	default:
+		return fmt.Errorf("unknown field %q: %w", name, errs.ErrInvalidRequestPayload)
+	}
+
+	return nil
+}
+
+func readMultipartField(part *multipart.Part) (string, error) {
+	b, err := io.ReadAll(io.LimitReader(part, maxMultipartFieldSize+1))
+	if err != nil {
+		return "", fmt.Errorf("multipart field read error: %w", err)
+	}
+	if len(b) > maxMultipartFieldSize {
+		return "", fmt.Errorf("multipart field %q is too large: %w", part.FormName(), errs.ErrInvalidRequestPayload)
+	}
+
+	return string(b), nil
+}
+
+func isMultipartRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "multipart/form-data"
+}
+
+func writeUploadResponse(w http.ResponseWriter, log *slog.Logger, ID string) {
+	body, err := json.Marshal(map[string]string{"id": ID})
+	if err != nil {
+		handleBusinessError(w, log, fmt.Errorf("marshalling error: %w", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(body)
+	if err != nil {
+		log.Error("write body error", slog.Any(logger.LogFieldError, err))
+	}
+}
+
+// hashVerifyingReader computes SHA-256 of the data read through it and
+// returns ErrHashMismatch instead of io.EOF when the result differs from the expected hash.
+type hashVerifyingReader struct {
+	r        io.Reader
+	h        hash.Hash
+	expected string
+}
+
+func newHashVerifyingReader(r io.Reader, expected string) *hashVerifyingReader {
+	return &hashVerifyingReader{r: r, h: sha256.New(), expected: expected}
+}
+
+func (v *hashVerifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+
+	if err == io.EOF && hex.EncodeToString(v.h.Sum(nil)) != v.expected {
+		return n, errs.ErrHashMismatch
+	}
+
+	return n, err
+}
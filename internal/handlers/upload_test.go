@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
@@ -9,6 +10,8 @@ import (
 	"file-storage/internal/errs"
 	"file-storage/internal/filedata"
 	"file-storage/internal/handlers/httpdto"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -30,6 +33,10 @@ func TestUploadHandler(t *testing.T) {
 		t.Fatalf("upload request preparation fail: %v", err)
 	}
 
+	multipartBody, multipartContentType := newMultipartBody(t, map[string]string{"public": "true"}, []byte("123"))
+	multipartRequest := newHttpTestRequest("POST", "/", multipartBody)
+	multipartRequest.Header.Set("Content-Type", multipartContentType)
+
 	table := []struct {
 		name       string
 		service    *mockService
@@ -90,6 +97,19 @@ func TestUploadHandler(t *testing.T) {
 			request:    newHttpTestRequest("POST", "/", string(bodyOK)),
 			wantStatus: http.StatusOK,
 		},
+		{
+			name: "multipart ok",
+			service: &mockService{fnUpdate: func(ctx context.Context, uc *filedata.UploadCommand) (string, error) {
+				b, err := io.ReadAll(uc.Data)
+				if err != nil || !bytes.Equal(b, []byte("123")) || !uc.Public {
+					return "", errs.ErrInvalidFileData
+				}
+				return uc.ID, nil
+			}},
+			ctx:        newContext(&authorization.Auth{Write: true}, nil),
+			request:    multipartRequest,
+			wantStatus: http.StatusOK,
+		},
 	}
 
 	for _, tt := range table {
@@ -107,3 +127,166 @@ func TestUploadHandler(t *testing.T) {
 	}
 
 }
+
+func TestPutHandler(t *testing.T) {
+
+	correctID := "012345678901234567890123456789012345"
+	data := []byte("123")
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	readAll := &mockService{fnUpdate: func(ctx context.Context, uc *filedata.UploadCommand) (string, error) {
+		_, err := io.ReadAll(uc.Data)
+		return uc.ID, err
+	}}
+
+	table := []struct {
+		name       string
+		service    *mockService
+		ctx        context.Context
+		hash       string
+		metadata   string
+		body       string
+		wantStatus int
+	}{
+		{
+			name:       "no rights",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Write: false}, map[string]string{"id": correctID}),
+			body:       string(data),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "invalid id",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Write: true}, map[string]string{"id": "12"}),
+			body:       string(data),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "no body",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Write: true}, map[string]string{"id": correctID}),
+			body:       "",
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "invalid metadata",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Write: true}, map[string]string{"id": correctID}),
+			metadata:   `{"a":[1]}`,
+			body:       string(data),
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "hash mismatch",
+			service:    readAll,
+			ctx:        newContext(&authorization.Auth{Write: true}, map[string]string{"id": correctID}),
+			hash:       "22",
+			body:       string(data),
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "ok",
+			service:    readAll,
+			ctx:        newContext(&authorization.Auth{Write: true}, map[string]string{"id": correctID}),
+			hash:       hash,
+			metadata:   `{"a":"b"}`,
+			body:       string(data),
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := PutHandler(tt.service)
+
+			r := newHttpTestRequest("PUT", "/", tt.body).WithContext(tt.ctx)
+			if tt.hash != "" {
+				r.Header.Set(HeaderContentHash, tt.hash)
+			}
+			if tt.metadata != "" {
+				r.Header.Set(HeaderFileMetadata, tt.metadata)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d want %d; responce %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func newMultipartBody(t *testing.T, fields map[string]string, data []byte) (string, string) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	for k, v := range fields {
+		err := mw.WriteField(k, v)
+		if err != nil {
+			t.Fatalf("multipart field write error: %v", err)
+		}
+	}
+
+	fw, err := mw.CreateFormFile("file", "file.bin")
+	if err != nil {
+		t.Fatalf("multipart file creation error: %v", err)
+	}
+	_, err = fw.Write(data)
+	if err != nil {
+		t.Fatalf("multipart file write error: %v", err)
+	}
+
+	err = mw.Close()
+	if err != nil {
+		t.Fatalf("multipart close error: %v", err)
+	}
+
+	return buf.String(), mw.FormDataContentType()
+}
+
+func TestIsImage(t *testing.T) {
+	table := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{
+			name: "jpeg",
+			data: []byte("\xff\xd8\xff\xe0\x00\x10JFIF"),
+			want: true,
+		},
+		{
+			name: "tiff",
+			data: []byte("II*\x00\x08\x00\x00\x00"),
+			want: true,
+		},
+		{
+			name: "webp",
+			data: []byte("RIFF\x24\x00\x00\x00WEBPVP8 "),
+			want: true,
+		},
+		{
+			name: "icon",
+			data: []byte("\x00\x00\x01\x00\x01\x00\x10\x10"),
+		},
+		{
+			name: "svg",
+			data: []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`),
+		},
+		{
+			name: "truncated signature",
+			data: []byte("\x89PNG"),
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			got := isImage(tt.data)
+			if got != tt.want {
+				t.Errorf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,11 +6,15 @@ import (
 	"errors"
 	"file-storage/internal/errs"
 	"file-storage/internal/handlers/httpdto"
+	"file-storage/internal/imgproc"
 	"file-storage/internal/logger"
 	"fmt"
 	"log/slog"
+	"mime"
 	"net/http"
+	"path"
 	"strings"
+	"unicode"
 )
 
 func validateUploadRequest(r *httpdto.UploadRequest) error {
@@ -36,20 +40,99 @@ func validateUploadRequest(r *httpdto.UploadRequest) error {
 		}
 	}
 
-	if r.Metadata != nil {
-		for k, v := range r.Metadata {
-			if err := checkMetadataValue(v); err != nil {
-				return fmt.Errorf("field %s in metadata: %w", k, err)
-			}
+	var err error
+	r.MimeType, err = normalizeMimeType(r.MimeType)
+	if err != nil {
+		return err
+	}
+	r.Filename, err = normalizeFilename(r.Filename)
+	if err != nil {
+		return err
+	}
+
+	return validateMetadata(r.Metadata)
+}
+
+func validateUploadParams(p *httpdto.UploadParams) error {
+
+	if err := validateUploadID(p.ID); err != nil {
+		return err
+	}
+
+	var err error
+	p.MimeType, err = normalizeMimeType(p.MimeType)
+	if err != nil {
+		return err
+	}
+	p.Filename, err = normalizeFilename(p.Filename)
+	if err != nil {
+		return err
+	}
+
+	return validateMetadata(p.Metadata)
+}
+
+// normalizeMimeType validates a client supplied MIME type and returns it in canonical form.
+// Generic types that carry no information about the content are dropped so
+// that the service detects the type itself.
+func normalizeMimeType(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid mime type %q: %w: %v", value, errs.ErrInvalidRequestPayload, err)
+	}
+
+	switch mediaType {
+	case "application/octet-stream", "application/x-www-form-urlencoded":
+		return "", nil
+	}
+
+	return mime.FormatMediaType(mediaType, params), nil
+}
+
+// normalizeFilename strips directories from a client supplied file name.
+func normalizeFilename(name string) (string, error) {
+	const maxFilenameLength = 255
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil
+	}
+
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		return "", nil
+	}
+
+	if len(name) > maxFilenameLength {
+		return "", fmt.Errorf("filename must be at most %d bytes: %w", maxFilenameLength, errs.ErrInvalidRequestPayload)
+	}
+	if strings.ContainsFunc(name, unicode.IsControl) {
+		return "", fmt.Errorf("filename contains control characters: %w", errs.ErrInvalidRequestPayload)
+	}
+
+	return name, nil
+}
+
+func validateMetadata(metadata map[string]any) error {
+	for k, v := range metadata {
+		if err := checkMetadataValue(v); err != nil {
+			return fmt.Errorf("field %s in metadata: %w", k, err)
 		}
 	}
 
 	return nil
 }
 
+// isImage reports whether data starts like an image of a format the service
+// can decode. Other image types such as SVG or ICO are stored as plain files.
 func isImage(data []byte) bool {
-	contentType := http.DetectContentType(data)
-	return strings.HasPrefix(contentType, "image/")
+	_, ok := imgproc.DetectFormat(data)
+	return ok
 }
 
 func validateID(ID string) error {
@@ -86,6 +169,7 @@ func mapErrorToHttpStatus(err error) (int, bool) {
 	case errors.Is(err, errs.ErrHashMismatch),
 		errors.Is(err, errs.ErrNoDataToUpload),
 		errors.Is(err, errs.ErrInvalidImage),
+		errors.Is(err, errs.ErrImageTooLarge),
 		errors.Is(err, errs.ErrUnsupportedTypeInMetadata):
 		return http.StatusUnprocessableEntity, true
 
@@ -93,18 +177,39 @@ func mapErrorToHttpStatus(err error) (int, bool) {
 		errors.Is(err, errs.ErrUnsupportedImageFormat):
 		return http.StatusUnsupportedMediaType, true
 
+	case errors.As(err, new(*http.MaxBytesError)):
+		return http.StatusRequestEntityTooLarge, true
+
 	case errors.Is(err, errs.ErrWrongIDLength),
 		errors.Is(err, errs.ErrMultipleIDsInQuery),
+		errors.Is(err, errs.ErrInvalidRequestPayload),
 		errors.Is(err, errs.ErrWrongUrlParameter),
 		errors.Is(err, errs.ErrInvalidID):
 		return http.StatusBadRequest, true
 
+	case errors.Is(err, errs.ErrFileDeleted):
+		return http.StatusGone, true
+
+	case errors.Is(err, errs.ErrFileExists):
+		return http.StatusConflict, true
+
 	case errors.Is(err, errs.ErrNotFound):
 		return http.StatusNotFound, true
 
 	case errors.Is(err, errs.ErrAccessDenied):
 		return http.StatusForbidden, true
 
+	case errors.Is(err, errs.ErrVersionsNotSupported),
+		errors.Is(err, errs.ErrTrashNotSupported),
+		errors.Is(err, errs.ErrBackupNotSupported),
+		errors.Is(err, errs.ErrScrubNotSupported),
+		errors.Is(err, errs.ErrOriginalsNotSupported),
+		errors.Is(err, errs.ErrSigningNotConfigured):
+		return http.StatusNotImplemented, true
+
+	case errors.Is(err, errs.ErrImageQueueFull):
+		return http.StatusServiceUnavailable, true
+
 	default:
 		return http.StatusInternalServerError, false
 	}
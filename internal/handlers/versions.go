@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/json"
+	"file-storage/internal/authorization"
+	"file-storage/internal/contextkeys"
+	"file-storage/internal/errs"
+	"file-storage/internal/logger"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+// VersionsHandler returns a handler that lists retained previous versions of a file, newest first.
+func VersionsHandler(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		log := logger.FromContext(ctx)
+		log = logger.WithHandler(log, logger.HandlerVersions)
+
+		auth, ok := ctx.Value(contextkeys.ContextKeyAuth).(*authorization.Auth)
+		if !ok {
+			err := fmt.Errorf("failed to get Auth structure out of context: %w", errs.ErrContextValueError)
+			handleTransportError(w, log, err)
+			return
+		}
+		if !auth.Read {
+			err := fmt.Errorf("read access denied: %w", errs.ErrAccessDenied)
+			handleTransportError(w, log, err)
+			return
+		}
+
+		ID := strings.TrimSpace(chi.URLParam(r, "id"))
+
+		err := validateID(ID)
+		if err != nil {
+			handleTransportError(w, log, err)
+			return
+		}
+
+		vl, err := svc.Versions(ctx, ID)
+		if err != nil {
+			handleBusinessError(w, log, err)
+			return
+		}
+
+		body, err := json.Marshal(vl)
+		if err != nil {
+			handleBusinessError(w, log, fmt.Errorf("marshalling error: %w", err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(body)
+		if err != nil {
+			log.Error("write body error", slog.Any(logger.LogFieldError, err))
+		}
+	}
+}
+
+// VersionContentHandler returns a handler that serves content of a retained file version as stored.
+// Previous versions are never public, so the read token is required.
+func VersionContentHandler(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		log := logger.FromContext(ctx)
+		log = logger.WithHandler(log, logger.HandlerVersionContent)
+
+		auth, ok := ctx.Value(contextkeys.ContextKeyAuth).(*authorization.Auth)
+		if !ok {
+			err := fmt.Errorf("failed to get Auth structure out of context: %w", errs.ErrContextValueError)
+			handleTransportError(w, log, err)
+			return
+		}
+		if !auth.Read {
+			err := fmt.Errorf("read access denied: %w", errs.ErrAccessDenied)
+			handleTransportError(w, log, err)
+			return
+		}
+
+		ID, version, err := parseVersionRequest(r)
+		if err != nil {
+			handleTransportError(w, log, err)
+			return
+		}
+
+		content, err := svc.VersionContent(ctx, ID, version)
+		if err != nil {
+			handleBusinessError(w, log, err)
+			return
+		}
+		defer func() {
+			if err := content.Data.Close(); err != nil {
+				log.Warn("content close error", slog.Any(logger.LogFieldError, err))
+			}
+		}()
+
+		if content.ETag != "" {
+			w.Header().Set("ETag", `"`+content.ETag+`"`)
+		}
+		setContentHeaders(w, content, ID, false)
+
+		http.ServeContent(w, r, "", content.ModTime, content.Data)
+	}
+}
+
+// RestoreVersionHandler returns a handler that makes a retained version the current version of a file
+// and responds with the restored file metadata.
+func RestoreVersionHandler(svc Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		log := logger.FromContext(ctx)
+		log = logger.WithHandler(log, logger.HandlerRestoreVersion)
+
+		auth, ok := ctx.Value(contextkeys.ContextKeyAuth).(*authorization.Auth)
+		if !ok {
+			err := fmt.Errorf("failed to get Auth structure out of context: %w", errs.ErrContextValueError)
+			handleTransportError(w, log, err)
+			return
+		}
+		if !auth.Write {
+			err := fmt.Errorf("write access denied: %w", errs.ErrAccessDenied)
+			handleTransportError(w, log, err)
+			return
+		}
+
+		ID, version, err := parseVersionRequest(r)
+		if err != nil {
+			handleTransportError(w, log, err)
+			return
+		}
+
+		fi, err := svc.RestoreVersion(ctx, ID, version)
+		if err != nil {
+			handleBusinessError(w, log, err)
+			return
+		}
+
+		body, err := json.Marshal(fi)
+		if err != nil {
+			handleBusinessError(w, log, fmt.Errorf("marshalling error: %w", err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(body)
+		if err != nil {
+			log.Error("write body error", slog.Any(logger.LogFieldError, err))
+		}
+	}
+}
+
+func parseVersionRequest(r *http.Request) (string, int, error) {
+	ID := strings.TrimSpace(chi.URLParam(r, "id"))
+
+	err := validateID(ID)
+	if err != nil {
+		return "", 0, err
+	}
+
+	versionParam := strings.TrimSpace(chi.URLParam(r, "version"))
+	version, err := strconv.Atoi(versionParam)
+	if err != nil || version < 1 {
+		return "", 0, fmt.Errorf("invalid version param %q: %w", versionParam, errs.ErrWrongUrlParameter)
+	}
+
+	return ID, version, nil
+}
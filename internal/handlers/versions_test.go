@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"file-storage/internal/authorization"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testVersionsID = "123456789012345678901234567890123456"
+
+func TestVersionsHandler(t *testing.T) {
+
+	table := []struct {
+		name       string
+		service    *mockService
+		ctx        context.Context
+		wantStatus int
+	}{
+		{
+			name:       "no rights",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{}, map[string]string{"id": testVersionsID}),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "invalid id",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": "1"}),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "not supported",
+			service: &mockService{fnVersions: func(ctx context.Context, ID string) (*filedata.VersionList, error) {
+				return nil, errs.ErrVersionsNotSupported
+			}},
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": testVersionsID}),
+			wantStatus: http.StatusNotImplemented,
+		},
+		{
+			name: "ok",
+			service: &mockService{fnVersions: func(ctx context.Context, ID string) (*filedata.VersionList, error) {
+				return &filedata.VersionList{Versions: []*filedata.VersionInfo{}}, nil
+			}},
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": testVersionsID}),
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			handlerFunc := VersionsHandler(tt.service)
+
+			w := httptest.NewRecorder()
+			r := newHttpTestRequest("GET", "/files/"+testVersionsID+"/versions", "").WithContext(tt.ctx)
+			handlerFunc.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %v want %v; responce %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestVersionContentHandler(t *testing.T) {
+
+	table := []struct {
+		name       string
+		service    *mockService
+		ctx        context.Context
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "public access denied",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{}, map[string]string{"id": testVersionsID, "version": "1"}),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "invalid version",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": testVersionsID, "version": "0"}),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "not found",
+			service: &mockService{fnVersionContent: func(ctx context.Context, ID string, version int) (*filedata.Content, error) {
+				return nil, errs.ErrNotFound
+			}},
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": testVersionsID, "version": "7"}),
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "ok",
+			service: &mockService{fnVersionContent: func(ctx context.Context, ID string, version int) (*filedata.Content, error) {
+				if version != 2 {
+					return nil, errs.ErrNotFound
+				}
+				return &filedata.Content{Data: filedata.NopSeekCloser(bytes.NewReader([]byte("old"))), ETag: "hash"}, nil
+			}},
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": testVersionsID, "version": "2"}),
+			wantStatus: http.StatusOK,
+			wantBody:   "old",
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			handlerFunc := VersionContentHandler(tt.service)
+
+			w := httptest.NewRecorder()
+			r := newHttpTestRequest("GET", "/files/"+testVersionsID+"/versions/1/content", "").WithContext(tt.ctx)
+			handlerFunc.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %v want %v; responce %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("got body %q want %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRestoreVersionHandler(t *testing.T) {
+
+	table := []struct {
+		name       string
+		service    *mockService
+		ctx        context.Context
+		wantStatus int
+	}{
+		{
+			name:       "read token only",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Read: true}, map[string]string{"id": testVersionsID, "version": "1"}),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "invalid version",
+			service:    &mockService{},
+			ctx:        newContext(&authorization.Auth{Write: true}, map[string]string{"id": testVersionsID, "version": "last"}),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "ok",
+			service: &mockService{fnRestoreVersion: func(ctx context.Context, ID string, version int) (*filedata.FileInfo, error) {
+				return &filedata.FileInfo{ID: ID}, nil
+			}},
+			ctx:        newContext(&authorization.Auth{Write: true}, map[string]string{"id": testVersionsID, "version": "1"}),
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			handlerFunc := RestoreVersionHandler(tt.service)
+
+			w := httptest.NewRecorder()
+			r := newHttpTestRequest("POST", "/files/"+testVersionsID+"/versions/1/restore", "").WithContext(tt.ctx)
+			handlerFunc.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %v want %v; responce %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
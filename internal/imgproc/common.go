@@ -2,6 +2,7 @@ package imgproc
 
 import (
 	"file-storage/internal/errs"
+	"image/png"
 
 	"github.com/disintegration/imaging"
 )
@@ -35,6 +36,48 @@ var supportedOutputFormats = map[ImgFormat]ImgFormat{
 	ImgFormatJPEG: ImgFormatJPEG,
 	ImgFormatPNG:  ImgFormatPNG,
 	ImgFormatGIF:  ImgFormatGIF,
+	ImgFormatTIFF: ImgFormatTIFF,
+	ImgFormatWEBP: ImgFormatWEBP,
+}
+
+// PNG compression levels accepted in EncodeOptions.
+const (
+	PNGCompressionDefault = "default"
+	PNGCompressionNone    = "none"
+	PNGCompressionSpeed   = "speed"
+	PNGCompressionBest    = "best"
+)
+
+var pngCompressionLevels = map[string]png.CompressionLevel{
+	PNGCompressionDefault: png.DefaultCompression,
+	PNGCompressionNone:    png.NoCompression,
+	PNGCompressionSpeed:   png.BestSpeed,
+	PNGCompressionBest:    png.BestCompression,
+}
+
+// EncodeOptions defines encoder settings of output images.
+type EncodeOptions struct {
+	// Quality is the quality of lossy formats from 1 to 100; zero means the encoder default.
+	Quality int
+	// Compression is the compression level of PNG images.
+	Compression png.CompressionLevel
+	// Reencode forces encoding of an image that already has the target format and size.
+	Reencode bool
+	// StripMetadata removes embedded metadata such as EXIF and GPS location from the result.
+	StripMetadata bool
+	// Exif is an APP1 segment written into JPEG results when metadata is stripped.
+	Exif []byte
+}
+
+// PNGCompressionLevel reports whether the provided name is a supported PNG compression level.
+func PNGCompressionLevel(name string) (png.CompressionLevel, bool) {
+	level, ok := pngCompressionLevels[name]
+	return level, ok
+}
+
+// IsLossy reports whether the output format is encoded with quality loss.
+func IsLossy(imf ImgFormat) bool {
+	return imf == ImgFormatJPEG || imf == ImgFormatWEBP
 }
 
 // ImagingOutputFormat converts ImgFormat to the imaging package output format.
@@ -48,7 +91,24 @@ func ImagingOutputFormat(imf ImgFormat) (imaging.Format, error) {
 		return imaging.GIF, nil
 	case ImgFormatPNG:
 		return imaging.PNG, nil
+	case ImgFormatTIFF:
+		return imaging.TIFF, nil
 	default:
 		return 0, errs.ErrUnsupportedImageFormat
 	}
 }
+
+var mimeTypes = map[ImgFormat]string{
+	ImgFormatBMP:  "image/bmp",
+	ImgFormatJPG:  "image/jpeg",
+	ImgFormatJPEG: "image/jpeg",
+	ImgFormatPNG:  "image/png",
+	ImgFormatGIF:  "image/gif",
+	ImgFormatTIFF: "image/tiff",
+	ImgFormatWEBP: "image/webp",
+}
+
+// MimeType returns the MIME type of the image format or an empty string for unknown formats.
+func MimeType(imf ImgFormat) string {
+	return mimeTypes[imf]
+}
@@ -5,6 +5,7 @@ import (
 	"image"
 
 	"github.com/disintegration/imaging"
+	"github.com/gen2brain/webp"
 )
 
 // Resize scales an image by the given multiplier and returns the resized image.
@@ -16,12 +17,38 @@ func Resize(img image.Image, multiplier float64) image.Image {
 }
 
 // Encode writes an image to the provided buffer using the given output format.
-func Encode(img image.Image, format imaging.Format) ([]byte, error) {
+func Encode(img image.Image, format imaging.Format, options ...imaging.EncodeOption) ([]byte, error) {
 	buf := new(bytes.Buffer)
-	err := imaging.Encode(buf, img, format)
+	err := imaging.Encode(buf, img, format, options...)
 	if err != nil {
 		return nil, err
 	}
 
 	return buf.Bytes(), nil
 }
+
+// EncodeImage encodes an image in the given output format with the provided encoder settings.
+// JPEG and WebP images are encoded lossily with the quality setting.
+func EncodeImage(img image.Image, imf ImgFormat, opts EncodeOptions) ([]byte, error) {
+	if imf == ImgFormatWEBP {
+		buf := new(bytes.Buffer)
+		err := webp.Encode(buf, img, webp.Options{Quality: opts.Quality, Method: webp.DefaultMethod})
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	format, err := ImagingOutputFormat(imf)
+	if err != nil {
+		return nil, err
+	}
+
+	options := make([]imaging.EncodeOption, 0, 2)
+	if opts.Quality > 0 {
+		options = append(options, imaging.JPEGQuality(opts.Quality))
+	}
+	options = append(options, imaging.PNGCompressionLevel(opts.Compression))
+
+	return Encode(img, format, options...)
+}
@@ -34,6 +34,47 @@ func ImageConfig(data []byte) (format ImgFormat, w, h int, err error) {
 	return format, imgCfg.Width, imgCfg.Height, nil
 }
 
+// signatures of supported input formats; "?" matches any byte
+var signatures = []struct {
+	prefix string
+	format ImgFormat
+}{
+	{"\xff\xd8\xff", ImgFormatJPEG},
+	{"\x89PNG\r\n\x1a\n", ImgFormatPNG},
+	{"GIF87a", ImgFormatGIF},
+	{"GIF89a", ImgFormatGIF},
+	{"BM", ImgFormatBMP},
+	{"II*\x00", ImgFormatTIFF},
+	{"MM\x00*", ImgFormatTIFF},
+	{"RIFF????WEBPVP8", ImgFormatWEBP},
+}
+
+// DetectFormat detects a supported input format by the signature at the
+// beginning of data. Unlike ImageConfig it needs only the first bytes and
+// does not validate the rest of the image.
+func DetectFormat(head []byte) (ImgFormat, bool) {
+	for _, sig := range signatures {
+		if matchSignature(head, sig.prefix) {
+			return sig.format, true
+		}
+	}
+
+	return "", false
+}
+
+func matchSignature(head []byte, prefix string) bool {
+	if len(head) < len(prefix) {
+		return false
+	}
+	for i := 0; i < len(prefix); i++ {
+		if prefix[i] != '?' && prefix[i] != head[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
 // SupportedInputFormat reports whether the provided format is accepted as an upload input format.
 func SupportedInputFormat(format string) (ImgFormat, bool) {
 	imgFormat, ok := supportedInputFormats[ImgFormat(format)]
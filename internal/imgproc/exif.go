@@ -0,0 +1,300 @@
+package imgproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"file-storage/internal/errs"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+const (
+	markerSOI   = 0xd8
+	markerSOS   = 0xda
+	markerAPP0  = 0xe0
+	markerAPP1  = 0xe1
+	markerAPP13 = 0xed
+
+	// exifPointerTag is the IFD0 tag holding the offset of the Exif sub-IFD.
+	exifPointerTag = 0x8769
+	// asciiType and longType are TIFF data types of NUL terminated strings and 32-bit integers.
+	asciiType = 2
+	longType  = 4
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+var errInvalidJPEG = fmt.Errorf("invalid jpeg structure: %w", errs.ErrInvalidImage)
+
+// exifTag describes an EXIF tag that may be kept in stripped images.
+type exifTag struct {
+	id     uint16
+	subIFD bool
+	field  exif.FieldName
+}
+
+// keepableTags are text tags that may be kept when metadata is stripped.
+// Location and maker notes are never kept.
+var keepableTags = map[string]exifTag{
+	"Make":              {id: 0x010f, field: exif.Make},
+	"Model":             {id: 0x0110, field: exif.Model},
+	"Software":          {id: 0x0131, field: exif.Software},
+	"DateTime":          {id: 0x0132, field: exif.DateTime},
+	"Artist":            {id: 0x013b, field: exif.Artist},
+	"Copyright":         {id: 0x8298, field: exif.Copyright},
+	"DateTimeOriginal":  {id: 0x9003, subIFD: true, field: exif.DateTimeOriginal},
+	"DateTimeDigitized": {id: 0x9004, subIFD: true, field: exif.DateTimeDigitized},
+}
+
+// IsKeepableExifTag reports whether the tag may be kept when metadata is stripped.
+func IsKeepableExifTag(name string) bool {
+	_, ok := keepableTags[name]
+	return ok
+}
+
+// Exif contains EXIF data of an image.
+type Exif struct {
+	x *exif.Exif
+}
+
+// ReadExif returns EXIF data of the image or nil if the image has none.
+func ReadExif(b []byte) *Exif {
+	x, err := exif.Decode(bytes.NewReader(b))
+	if err != nil && (x == nil || exif.IsCriticalError(err)) {
+		return nil
+	}
+
+	return &Exif{x: x}
+}
+
+// Orientation returns the EXIF orientation from 1 to 8. 1 means the image is stored upright.
+func (e *Exif) Orientation() int {
+	if e == nil {
+		return 1
+	}
+
+	tag, err := e.x.Get(exif.Orientation)
+	if err != nil {
+		return 1
+	}
+	v, err := tag.Int(0)
+	if err != nil || v < 1 || v > 8 {
+		return 1
+	}
+
+	return v
+}
+
+// text returns the value of a text tag or an empty string.
+func (e *Exif) text(name exif.FieldName) string {
+	if e == nil {
+		return ""
+	}
+
+	tag, err := e.x.Get(name)
+	if err != nil {
+		return ""
+	}
+	v, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimRight(v, "\x00"))
+}
+
+// Camera returns the camera make and model.
+func (e *Exif) Camera() (string, string) {
+	return e.text(exif.Make), e.text(exif.Model)
+}
+
+// TakenAt returns the time the image was taken or the zero time if unknown.
+func (e *Exif) TakenAt() time.Time {
+	if e == nil {
+		return time.Time{}
+	}
+
+	t, err := e.x.DateTime()
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
+
+// Segment returns a JPEG APP1 segment with the named tags of the image or nil
+// if the image has none of them. Only keepable tags are written.
+func (e *Exif) Segment(names []string) []byte {
+	var ifd0, subIFD []asciiEntry
+	for _, name := range names {
+		t, ok := keepableTags[name]
+		if !ok {
+			continue
+		}
+		v := e.text(t.field)
+		if v == "" {
+			continue
+		}
+		entry := asciiEntry{id: t.id, value: v}
+		if t.subIFD {
+			subIFD = append(subIFD, entry)
+		} else {
+			ifd0 = append(ifd0, entry)
+		}
+	}
+
+	if len(ifd0) == 0 && len(subIFD) == 0 {
+		return nil
+	}
+
+	tiff := buildTIFF(ifd0, subIFD)
+	if len(exifHeader)+len(tiff)+2 > 0xffff {
+		return nil
+	}
+
+	segment := []byte{0xff, markerAPP1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(exifHeader)+len(tiff)+2))
+	segment = append(segment, exifHeader...)
+	return append(segment, tiff...)
+}
+
+// StripMetadata removes embedded metadata from encoded image data without
+// re-encoding and writes the APP1 segment into JPEG data if it is not nil.
+// It reports false when the format does not allow this.
+func StripMetadata(b []byte, imf ImgFormat, app1 []byte) ([]byte, bool, error) {
+	switch imf {
+	case ImgFormatJPEG:
+		result, err := StripJPEGMetadata(b, app1)
+		if err != nil {
+			return nil, false, err
+		}
+		return result, true, nil
+	case ImgFormatBMP, ImgFormatGIF:
+		return b, true, nil
+	default:
+		return nil, false, nil
+	}
+}
+
+// StripJPEGMetadata removes EXIF, XMP and IPTC segments from JPEG data without
+// re-encoding and inserts the provided APP1 segment if it is not nil.
+func StripJPEGMetadata(b []byte, app1 []byte) ([]byte, error) {
+	if len(b) < 2 || b[0] != 0xff || b[1] != markerSOI {
+		return nil, errInvalidJPEG
+	}
+
+	result := make([]byte, 0, len(b)+len(app1))
+	result = append(result, b[:2]...)
+	inserted := app1 == nil
+
+	pos := 2
+	for {
+		if pos+4 > len(b) || b[pos] != 0xff {
+			return nil, errInvalidJPEG
+		}
+		marker := b[pos+1]
+		if marker == 0xff {
+			pos++
+			continue
+		}
+
+		if !inserted && marker != markerAPP0 {
+			result = append(result, app1...)
+			inserted = true
+		}
+
+		if marker == markerSOS {
+			return append(result, b[pos:]...), nil
+		}
+
+		end := pos + 2 + int(binary.BigEndian.Uint16(b[pos+2:]))
+		if end > len(b) {
+			return nil, errInvalidJPEG
+		}
+		if marker != markerAPP1 && marker != markerAPP13 {
+			result = append(result, b[pos:end]...)
+		}
+		pos = end
+	}
+}
+
+type asciiEntry struct {
+	id    uint16
+	value string
+}
+
+// buildTIFF encodes text tags into a big-endian TIFF structure with IFD0 and
+// an optional Exif sub-IFD.
+func buildTIFF(ifd0, subIFD []asciiEntry) []byte {
+	sort.Slice(ifd0, func(i, j int) bool { return ifd0[i].id < ifd0[j].id })
+	sort.Slice(subIFD, func(i, j int) bool { return subIFD[i].id < subIFD[j].id })
+
+	ifd0Count := len(ifd0)
+	if len(subIFD) > 0 {
+		ifd0Count++
+	}
+	subIFDOffset := 8 + ifdSize(ifd0Count)
+	dataOffset := subIFDOffset
+	if len(subIFD) > 0 {
+		dataOffset += ifdSize(len(subIFD))
+	}
+
+	var data []byte
+	values := func(entries []asciiEntry) [][]byte {
+		fields := make([][]byte, 0, len(entries))
+		for _, e := range entries {
+			value := append([]byte(e.value), 0)
+			field := make([]byte, 12)
+			binary.BigEndian.PutUint16(field, e.id)
+			binary.BigEndian.PutUint16(field[2:], asciiType)
+			binary.BigEndian.PutUint32(field[4:], uint32(len(value)))
+			if len(value) <= 4 {
+				copy(field[8:], value)
+			} else {
+				binary.BigEndian.PutUint32(field[8:], uint32(dataOffset+len(data)))
+				data = append(data, value...)
+				if len(data)%2 == 1 {
+					data = append(data, 0)
+				}
+			}
+			fields = append(fields, field)
+		}
+		return fields
+	}
+
+	ifd0Fields := values(ifd0)
+	subIFDFields := values(subIFD)
+	if len(subIFD) > 0 {
+		field := make([]byte, 12)
+		binary.BigEndian.PutUint16(field, exifPointerTag)
+		binary.BigEndian.PutUint16(field[2:], longType)
+		binary.BigEndian.PutUint32(field[4:], 1)
+		binary.BigEndian.PutUint32(field[8:], uint32(subIFDOffset))
+		ifd0Fields = append(ifd0Fields, field)
+	}
+
+	result := []byte{'M', 'M', 0, 42, 0, 0, 0, 8}
+	result = appendIFD(result, ifd0Fields)
+	if len(subIFD) > 0 {
+		result = appendIFD(result, subIFDFields)
+	}
+
+	return append(result, data...)
+}
+
+func ifdSize(count int) int {
+	return 2 + 12*count + 4
+}
+
+func appendIFD(b []byte, fields [][]byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(fields)))
+	for _, field := range fields {
+		b = append(b, field...)
+	}
+
+	return binary.BigEndian.AppendUint32(b, 0)
+}
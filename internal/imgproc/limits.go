@@ -0,0 +1,153 @@
+package imgproc
+
+import (
+	"bytes"
+	"file-storage/internal/errs"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// Limits bounds the resources decoding of an image may take. Zero values
+// disable a limit.
+type Limits struct {
+	// MaxPixels is the maximum number of pixels of an image.
+	MaxPixels int
+	// MaxFrames is the maximum number of frames of an animated image.
+	MaxFrames int
+	// MaxDecodeMemory is the maximum estimated memory in bytes needed to decode and process an image.
+	MaxDecodeMemory int
+}
+
+// bytes per pixel of the NRGBA copy every image is converted to for processing
+const nrgbaBytesPerPixel = 4
+
+// CheckLimits reads only the header and the frame structure of image data and
+// reports with errs.ErrImageTooLarge when decoding it or applying the
+// transformation to it would exceed the limits. A few kilobytes of compressed
+// data may describe an image of gigabytes, and filling or padding upscale
+// small images to the requested bounds, so the check must precede decoding.
+func CheckLimits(data []byte, t Transform, limits Limits) error {
+	cfg, ext, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("decode image error: %w: %v", errs.ErrInvalidImage, err)
+	}
+
+	pixels := cfg.Width * cfg.Height
+	if cfg.Width > 0 && pixels/cfg.Width != cfg.Height {
+		return fmt.Errorf("image of %dx%d pixels: %w", cfg.Width, cfg.Height, errs.ErrImageTooLarge)
+	}
+	if limits.MaxPixels > 0 && pixels > limits.MaxPixels {
+		return fmt.Errorf("image of %d pixels exceeds %d: %w", pixels, limits.MaxPixels, errs.ErrImageTooLarge)
+	}
+
+	// orientations from 5 to 8 swap the dimensions of the upright image
+	width, height := cfg.Width, cfg.Height
+	if ReadExif(data).Orientation() >= 5 {
+		width, height = height, width
+	}
+	tw, th := t.Size(width, height)
+	transformed := tw * th
+	if tw > 0 && transformed/tw != th {
+		return fmt.Errorf("transformed image of %dx%d pixels: %w", tw, th, errs.ErrImageTooLarge)
+	}
+	if limits.MaxPixels > 0 && transformed > limits.MaxPixels {
+		return fmt.Errorf("transformed image of %d pixels exceeds %d: %w", transformed, limits.MaxPixels, errs.ErrImageTooLarge)
+	}
+
+	// the decoded image, its NRGBA copy and the transformed image are held at once
+	if limits.MaxDecodeMemory > 0 {
+		perPixel := bytesPerPixel(cfg.ColorModel) + nrgbaBytesPerPixel
+		memory := limits.MaxDecodeMemory
+		if pixels > memory/perPixel {
+			return fmt.Errorf("image of %d pixels needs more than %d bytes to decode: %w", pixels, limits.MaxDecodeMemory, errs.ErrImageTooLarge)
+		}
+		memory -= pixels * perPixel
+		if transformed > memory/nrgbaBytesPerPixel {
+			return fmt.Errorf("image of %d pixels transformed to %d pixels needs more than %d bytes: %w", pixels, transformed, limits.MaxDecodeMemory, errs.ErrImageTooLarge)
+		}
+	}
+
+	if limits.MaxFrames > 0 && ImgFormat(ext) == ImgFormatGIF {
+		frames := gifFrames(data)
+		if frames > limits.MaxFrames {
+			return fmt.Errorf("image of %d frames exceeds %d: %w", frames, limits.MaxFrames, errs.ErrImageTooLarge)
+		}
+	}
+
+	return nil
+}
+
+// bytesPerPixel returns the size of a decoded pixel of the color model.
+// Unknown models are assumed to take as much as the largest known one.
+func bytesPerPixel(model color.Model) int {
+	if _, ok := model.(color.Palette); ok {
+		return 1
+	}
+
+	switch model {
+	case color.GrayModel, color.AlphaModel:
+		return 1
+	case color.Gray16Model, color.Alpha16Model:
+		return 2
+	case color.YCbCrModel:
+		return 3
+	case color.RGBAModel, color.NRGBAModel, color.CMYKModel:
+		return 4
+	default:
+		return 8
+	}
+}
+
+// gifFrames counts image descriptors of GIF data by skipping over the data
+// sub-blocks without decompressing them. Truncated data is counted up to
+// its end.
+func gifFrames(data []byte) int {
+	const (
+		headerLen          = 13
+		imageDescriptorLen = 10
+		colorTableFlag     = 0x80
+		sExtension         = 0x21
+		sImageDescriptor   = 0x2c
+	)
+
+	// colorTable returns the size of a color table described by the flags
+	colorTable := func(flags byte) int {
+		if flags&colorTableFlag == 0 {
+			return 0
+		}
+		return 3 << (flags&0x07 + 1)
+	}
+	// subBlocks returns the position after the sub-blocks starting at i
+	subBlocks := func(i int) int {
+		for i < len(data) && data[i] != 0 {
+			i += int(data[i]) + 1
+		}
+		return i + 1
+	}
+
+	if len(data) < headerLen {
+		return 0
+	}
+
+	frames := 0
+	i := headerLen + colorTable(data[10])
+	for i < len(data) {
+		switch data[i] {
+		case sExtension:
+			i = subBlocks(i + 2)
+		case sImageDescriptor:
+			if i+imageDescriptorLen > len(data) {
+				return frames
+			}
+			frames++
+			// the descriptor and its color table are followed by the LZW code size
+			i += imageDescriptorLen + colorTable(data[i+9]) + 1
+			i = subBlocks(i)
+		default:
+			return frames
+		}
+	}
+
+	return frames
+}
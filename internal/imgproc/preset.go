@@ -0,0 +1,105 @@
+package imgproc
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// bounds of preset dimensions, the same as of content requests
+const (
+	minPresetDimension = 10
+	maxPresetDimension = 10000
+)
+
+// Preset is a named image transformation. Zero Width, Height and Quality and
+// empty Mode, Gravity and Format mean the defaults of content requests.
+type Preset struct {
+	Width      int
+	Height     int
+	Mode       Mode
+	Gravity    Gravity
+	Background *color.NRGBA
+	Format     ImgFormat
+	Quality    int
+}
+
+// ParsePreset parses a preset from space separated tokens in any order:
+// WIDTHxHEIGHT, WIDTHx or xHEIGHT, a mode, a gravity, an output format,
+// qQUALITY and bg=COLOR, for example "200x200 fill smart webp q70".
+func ParsePreset(spec string) (Preset, error) {
+	var p Preset
+
+	tokens := strings.Fields(strings.ToLower(spec))
+	if len(tokens) == 0 {
+		return Preset{}, errors.New("empty preset")
+	}
+
+	for _, token := range tokens {
+		if mode, ok := ParseMode(token); ok {
+			p.Mode = mode
+			continue
+		}
+		if gravity, ok := ParseGravity(token); ok {
+			p.Gravity = gravity
+			continue
+		}
+		if format, ok := SupportedOutputFormat(token); ok {
+			p.Format = format
+			continue
+		}
+		if value, ok := strings.CutPrefix(token, "bg="); ok {
+			background, ok := ParseColor(value)
+			if !ok {
+				return Preset{}, fmt.Errorf("invalid background %q", value)
+			}
+			p.Background = &background
+			continue
+		}
+		if value, ok := strings.CutPrefix(token, "q"); ok {
+			quality, err := strconv.Atoi(value)
+			if err != nil || quality < 1 || quality > 100 {
+				return Preset{}, fmt.Errorf("invalid quality %q", token)
+			}
+			p.Quality = quality
+			continue
+		}
+		if w, h, ok := strings.Cut(token, "x"); ok && token != "x" {
+			var err error
+			p.Width, err = parsePresetDimension(w)
+			if err != nil {
+				return Preset{}, err
+			}
+			p.Height, err = parsePresetDimension(h)
+			if err != nil {
+				return Preset{}, err
+			}
+			continue
+		}
+
+		return Preset{}, fmt.Errorf("unknown token %q", token)
+	}
+
+	if p.Mode != "" && p.Mode != ModeFit && (p.Width == 0 || p.Height == 0) {
+		return Preset{}, fmt.Errorf("width and height are required for %s mode", p.Mode)
+	}
+
+	return p, nil
+}
+
+// parsePresetDimension parses a dimension of a preset. An empty value means
+// the dimension is not set.
+func parsePresetDimension(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	v, err := strconv.Atoi(value)
+	if err != nil || v < minPresetDimension || v > maxPresetDimension {
+		return 0, fmt.Errorf("dimension %q out of range %d - %d", value, minPresetDimension, maxPresetDimension)
+	}
+
+	return v, nil
+}
@@ -0,0 +1,266 @@
+package imgproc
+
+import (
+	"encoding/hex"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// Mode defines how an image is fitted into the target bounds.
+type Mode string
+
+const (
+	// ModeFit scales the image down to fit within the bounds keeping the aspect ratio.
+	ModeFit Mode = "fit"
+	// ModeFill scales the image to cover the bounds and crops the overflow.
+	ModeFill Mode = "fill"
+	// ModeCrop cuts a region of the bounds size out of the image without scaling.
+	ModeCrop Mode = "crop"
+	// ModePad scales the image down to fit within the bounds and pads it to the exact bounds.
+	ModePad Mode = "pad"
+)
+
+// Gravity defines which part of the image is kept on cropping or where the image is placed on padding.
+type Gravity string
+
+const (
+	GravityCenter Gravity = "center"
+	GravityTop    Gravity = "top"
+	GravityBottom Gravity = "bottom"
+	GravityLeft   Gravity = "left"
+	GravityRight  Gravity = "right"
+	// GravitySmart keeps the region with the highest luminance entropy.
+	GravitySmart Gravity = "smart"
+)
+
+var modes = map[Mode]struct{}{
+	ModeFit:  {},
+	ModeFill: {},
+	ModeCrop: {},
+	ModePad:  {},
+}
+
+var anchors = map[Gravity]imaging.Anchor{
+	GravityCenter: imaging.Center,
+	GravityTop:    imaging.Top,
+	GravityBottom: imaging.Bottom,
+	GravityLeft:   imaging.Left,
+	GravityRight:  imaging.Right,
+	GravitySmart:  imaging.Center,
+}
+
+// smartSamples is the number of sampled pixels per axis used to estimate entropy of a region.
+const smartSamples = 64
+
+// smartSteps is the number of candidate positions per axis evaluated by smart gravity.
+const smartSteps = 8
+
+// Transform describes how an image is fitted into the target bounds.
+// Zero Mode and Gravity mean ModeFit and GravityCenter.
+type Transform struct {
+	Width      int
+	Height     int
+	Mode       Mode
+	Gravity    Gravity
+	Background color.NRGBA
+}
+
+// ParseMode reports whether the provided value is a supported transformation mode.
+func ParseMode(value string) (Mode, bool) {
+	mode := Mode(strings.ToLower(value))
+	_, ok := modes[mode]
+	return mode, ok
+}
+
+// ParseGravity reports whether the provided value is a supported gravity.
+func ParseGravity(value string) (Gravity, bool) {
+	gravity := Gravity(strings.ToLower(value))
+	_, ok := anchors[gravity]
+	return gravity, ok
+}
+
+// ParseColor reports whether the provided value is a color in RRGGBB or RRGGBBAA
+// hex notation with an optional leading '#'.
+func ParseColor(value string) (color.NRGBA, bool) {
+	value = strings.TrimPrefix(value, "#")
+	if len(value) != 6 && len(value) != 8 {
+		return color.NRGBA{}, false
+	}
+
+	b, err := hex.DecodeString(value)
+	if err != nil {
+		return color.NRGBA{}, false
+	}
+
+	c := color.NRGBA{R: b[0], G: b[1], B: b[2], A: 0xff}
+	if len(b) == 4 {
+		c.A = b[3]
+	}
+
+	return c, true
+}
+
+// FormatColor returns the color in RRGGBBAA hex notation.
+func FormatColor(c color.NRGBA) string {
+	return hex.EncodeToString([]byte{c.R, c.G, c.B, c.A})
+}
+
+// IsNoop reports whether an image of the given dimensions already satisfies the transformation.
+func (t Transform) IsNoop(width, height int) bool {
+	switch t.Mode {
+	case ModeFill, ModeCrop, ModePad:
+		return width == t.Width && height == t.Height
+	default:
+		return width <= t.Width && height <= t.Height
+	}
+}
+
+// Size returns the dimensions of the largest image Apply creates from an image
+// of the given dimensions: the scaled image of ModeFill and the canvas of
+// ModePad may be much larger than the source.
+func (t Transform) Size(width, height int) (int, int) {
+	switch t.Mode {
+	case ModeFill:
+		multiplier := max(float64(t.Width)/float64(width), float64(t.Height)/float64(height))
+		return max(t.Width, scale(width, multiplier)), max(t.Height, scale(height, multiplier))
+	case ModeCrop:
+		return min(width, t.Width), min(height, t.Height)
+	case ModePad:
+		return t.Width, t.Height
+	default:
+		multiplier := min(float64(t.Width)/float64(width), float64(t.Height)/float64(height))
+		if multiplier >= 1 {
+			return width, height
+		}
+		return scale(width, multiplier), scale(height, multiplier)
+	}
+}
+
+// Apply fits the image into the transformation bounds according to its mode.
+func Apply(img image.Image, t Transform) image.Image {
+	w := img.Bounds().Dx()
+	h := img.Bounds().Dy()
+
+	switch t.Mode {
+	case ModeFill:
+		multiplier := max(float64(t.Width)/float64(w), float64(t.Height)/float64(h))
+		img = imaging.Resize(img, max(t.Width, scale(w, multiplier)), max(t.Height, scale(h, multiplier)), imaging.Lanczos)
+		return crop(img, t.Width, t.Height, t.Gravity)
+	case ModeCrop:
+		return crop(img, min(w, t.Width), min(h, t.Height), t.Gravity)
+	case ModePad:
+		img = fit(img, t.Width, t.Height)
+		canvas := imaging.New(t.Width, t.Height, t.Background)
+		return imaging.Paste(canvas, img, padPosition(img.Bounds().Size(), t.Width, t.Height, t.Gravity))
+	default:
+		return fit(img, t.Width, t.Height)
+	}
+}
+
+// fit scales the image down to fit within the bounds. Images that already fit are not upscaled.
+func fit(img image.Image, width, height int) image.Image {
+	multiplier := min(float64(width)/float64(img.Bounds().Dx()), float64(height)/float64(img.Bounds().Dy()))
+	if multiplier >= 1 {
+		return img
+	}
+
+	return Resize(img, multiplier)
+}
+
+func scale(size int, multiplier float64) int {
+	return max(1, int(math.Round(float64(size)*multiplier)))
+}
+
+func crop(img image.Image, width, height int, gravity Gravity) image.Image {
+	if gravity != GravitySmart {
+		return imaging.CropAnchor(img, width, height, anchors[gravity])
+	}
+
+	return imaging.Crop(img, smartCrop(img, width, height))
+}
+
+func padPosition(size image.Point, width, height int, gravity Gravity) image.Point {
+	pos := image.Pt((width-size.X)/2, (height-size.Y)/2)
+
+	switch gravity {
+	case GravityTop:
+		pos.Y = 0
+	case GravityBottom:
+		pos.Y = height - size.Y
+	case GravityLeft:
+		pos.X = 0
+	case GravityRight:
+		pos.X = width - size.X
+	}
+
+	return pos
+}
+
+// smartCrop returns the width×height region of the image with the highest luminance entropy.
+// The centered region wins ties.
+func smartCrop(img image.Image, width, height int) image.Rectangle {
+	b := img.Bounds()
+	freeX := b.Dx() - width
+	freeY := b.Dy() - height
+
+	best := image.Rect(0, 0, width, height).Add(b.Min).Add(image.Pt(freeX/2, freeY/2))
+	bestEntropy := entropy(img, best)
+
+	for _, x := range candidates(freeX) {
+		for _, y := range candidates(freeY) {
+			r := image.Rect(0, 0, width, height).Add(b.Min).Add(image.Pt(x, y))
+			e := entropy(img, r)
+			if e > bestEntropy {
+				best = r
+				bestEntropy = e
+			}
+		}
+	}
+
+	return best
+}
+
+func candidates(free int) []int {
+	if free <= 0 {
+		return []int{0}
+	}
+
+	steps := min(free, smartSteps)
+	result := make([]int, 0, steps+1)
+	for i := 0; i <= steps; i++ {
+		result = append(result, free*i/steps)
+	}
+
+	return result
+}
+
+// entropy estimates Shannon entropy of luminance within the region from a grid of samples.
+func entropy(img image.Image, r image.Rectangle) float64 {
+	var histogram [256]int
+	stepX := max(1, r.Dx()/smartSamples)
+	stepY := max(1, r.Dy()/smartSamples)
+
+	total := 0
+	for y := r.Min.Y; y < r.Max.Y; y += stepY {
+		for x := r.Min.X; x < r.Max.X; x += stepX {
+			gray := color.GrayModel.Convert(img.At(x, y)).(color.Gray)
+			histogram[gray.Y]++
+			total++
+		}
+	}
+
+	result := 0.0
+	for _, n := range histogram {
+		if n == 0 {
+			continue
+		}
+		p := float64(n) / float64(total)
+		result -= p * math.Log2(p)
+	}
+
+	return result
+}
@@ -22,6 +22,8 @@ type HandlerName string
 const (
 	ComponentMiddleware ComponentName = "middleware"
 	ComponentGC         ComponentName = "garbage_collector"
+	ComponentScrubber   ComponentName = "scrubber"
+	ComponentImages     ComponentName = "images"
 )
 
 const (
@@ -30,10 +32,21 @@ const (
 )
 
 const (
-	HandlerContent HandlerName = "content"
-	HandlerDelete  HandlerName = "delete"
-	HandlerInfo    HandlerName = "info"
-	HandlerUpdate  HandlerName = "upload"
+	HandlerContent        HandlerName = "content"
+	HandlerDelete         HandlerName = "delete"
+	HandlerInfo           HandlerName = "info"
+	HandlerList           HandlerName = "list"
+	HandlerVersions       HandlerName = "versions"
+	HandlerVersionContent HandlerName = "version_content"
+	HandlerRestoreVersion HandlerName = "restore_version"
+	HandlerTrash          HandlerName = "trash"
+	HandlerRestore        HandlerName = "restore"
+	HandlerUpdate         HandlerName = "upload"
+	HandlerPut            HandlerName = "put"
+	HandlerBackup         HandlerName = "backup"
+	HandlerScrub          HandlerName = "scrub"
+	HandlerOriginal       HandlerName = "original"
+	HandlerPresign        HandlerName = "presign"
 )
 
 const (
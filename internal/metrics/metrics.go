@@ -64,6 +64,118 @@ var GcRecoveryTotal = prometheus.NewCounter(
 	prometheus.CounterOpts{Name: "fs_gc_recovery_total", Help: "Total number of gc recovery"},
 )
 
+var ScrubRunsTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{Name: "fs_scrub_runs_total", Help: "Total number of integrity scrubber passes"},
+)
+
+var ScrubDurationSeconds = prometheus.NewHistogram(
+	prometheus.HistogramOpts{
+		Name:    "fs_scrub_duration_seconds",
+		Help:    "Duration of integrity scrubber passes in seconds",
+		Buckets: []float64{60, 600, 3600, 4 * 3600, 24 * 3600},
+	},
+)
+
+var ScrubInProgress = prometheus.NewGauge(
+	prometheus.GaugeOpts{Name: "fs_scrub_in_progress", Help: "Integrity scrubber pass in progress"},
+)
+
+var ScrubLastCompletedSeconds = prometheus.NewGauge(
+	prometheus.GaugeOpts{Name: "fs_scrub_last_completed_timestamp_seconds", Help: "Unix time of the last completed integrity scrubber pass"},
+)
+
+var ScrubFilesTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{Name: "fs_scrub_files_total", Help: "Total number of files checked by the integrity scrubber by result"},
+	[]string{"result"},
+)
+
+var ScrubBytesTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{Name: "fs_scrub_bytes_total", Help: "Total number of bytes read by the integrity scrubber"},
+)
+
+var ScrubQuarantinedTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{Name: "fs_scrub_quarantined_total", Help: "Total number of corrupted files moved to quarantine"},
+)
+
+var DedupBlobs = prometheus.NewGauge(
+	prometheus.GaugeOpts{Name: "fs_dedup_blobs", Help: "Number of stored content blobs"},
+)
+
+var DedupReferences = prometheus.NewGauge(
+	prometheus.GaugeOpts{Name: "fs_dedup_blob_references", Help: "Number of stored file versions referencing content blobs"},
+)
+
+var DedupStoredBytes = prometheus.NewGauge(
+	prometheus.GaugeOpts{Name: "fs_dedup_stored_bytes", Help: "Bytes stored in content blobs"},
+)
+
+var DedupReferencedBytes = prometheus.NewGauge(
+	prometheus.GaugeOpts{Name: "fs_dedup_referenced_bytes", Help: "Bytes of stored file versions referencing content blobs"},
+)
+
+var TierReadsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{Name: "fs_tier_reads_total", Help: "Total number of tiered storage content reads by serving tier"},
+	[]string{"tier"},
+)
+
+var TierPromotionsTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{Name: "fs_tier_promotions_total", Help: "Total number of files copied to the hot tier"},
+)
+
+var TierDemotionsTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{Name: "fs_tier_demotions_total", Help: "Total number of files evicted from the hot tier"},
+)
+
+var TierFlushErrorsTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{Name: "fs_tier_flush_errors_total", Help: "Total number of failed writes of hot tier files to the cold tier"},
+)
+
+var TierHotBytes = prometheus.NewGauge(
+	prometheus.GaugeOpts{Name: "fs_tier_hot_bytes", Help: "Bytes of files kept in the hot tier"},
+)
+
+var TierDirtyFiles = prometheus.NewGauge(
+	prometheus.GaugeOpts{Name: "fs_tier_dirty_files", Help: "Number of hot tier files not yet written to the cold tier"},
+)
+
+var ReplicationPending = prometheus.NewGauge(
+	prometheus.GaugeOpts{Name: "fs_replication_pending", Help: "Number of queued copies of files to replicas"},
+)
+
+var ReplicationLagSeconds = prometheus.NewGauge(
+	prometheus.GaugeOpts{Name: "fs_replication_lag_seconds", Help: "Age of the oldest queued copy of a file to a replica"},
+)
+
+var ReplicationErrorsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{Name: "fs_replication_errors_total", Help: "Total number of failed copies of files to replicas by replica"},
+	[]string{"replica"},
+)
+
+var ReplicationFailoversTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{Name: "fs_replication_failovers_total", Help: "Total number of reads served by a replica after the primary storage failed"},
+)
+
+var ImageJobsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{Name: "fs_image_jobs_total", Help: "Total number of image processing jobs by result"},
+	[]string{"result"},
+)
+
+var ImageJobsInProgress = prometheus.NewGauge(
+	prometheus.GaugeOpts{Name: "fs_image_jobs_in_progress", Help: "Image processing jobs running on workers"},
+)
+
+var ImageJobsQueued = prometheus.NewGauge(
+	prometheus.GaugeOpts{Name: "fs_image_jobs_queued", Help: "Image processing jobs waiting for a worker"},
+)
+
+var ImageJobWaitSeconds = prometheus.NewHistogram(
+	prometheus.HistogramOpts{Name: "fs_image_job_wait_seconds", Help: "Time image processing jobs waited for a worker in seconds"},
+)
+
+var ImageJobDurationSeconds = prometheus.NewHistogram(
+	prometheus.HistogramOpts{Name: "fs_image_job_duration_seconds", Help: "Duration of image processing jobs in seconds"},
+)
+
 func init() {
 	prometheus.MustRegister(HTTPrequestsTotal)
 	prometheus.MustRegister(HTTPrequestsDurationSeconds)
@@ -78,4 +190,30 @@ func init() {
 	prometheus.MustRegister(GcFilesDeletedTotal)
 	prometheus.MustRegister(GcErrorsTotal)
 	prometheus.MustRegister(GcRecoveryTotal)
+	prometheus.MustRegister(ScrubRunsTotal)
+	prometheus.MustRegister(ScrubDurationSeconds)
+	prometheus.MustRegister(ScrubInProgress)
+	prometheus.MustRegister(ScrubLastCompletedSeconds)
+	prometheus.MustRegister(ScrubFilesTotal)
+	prometheus.MustRegister(ScrubBytesTotal)
+	prometheus.MustRegister(ScrubQuarantinedTotal)
+	prometheus.MustRegister(DedupBlobs)
+	prometheus.MustRegister(DedupReferences)
+	prometheus.MustRegister(DedupStoredBytes)
+	prometheus.MustRegister(DedupReferencedBytes)
+	prometheus.MustRegister(TierReadsTotal)
+	prometheus.MustRegister(TierPromotionsTotal)
+	prometheus.MustRegister(TierDemotionsTotal)
+	prometheus.MustRegister(TierFlushErrorsTotal)
+	prometheus.MustRegister(TierHotBytes)
+	prometheus.MustRegister(TierDirtyFiles)
+	prometheus.MustRegister(ReplicationPending)
+	prometheus.MustRegister(ReplicationLagSeconds)
+	prometheus.MustRegister(ReplicationErrorsTotal)
+	prometheus.MustRegister(ReplicationFailoversTotal)
+	prometheus.MustRegister(ImageJobsTotal)
+	prometheus.MustRegister(ImageJobsInProgress)
+	prometheus.MustRegister(ImageJobsQueued)
+	prometheus.MustRegister(ImageJobWaitSeconds)
+	prometheus.MustRegister(ImageJobDurationSeconds)
 }
@@ -5,12 +5,18 @@ import (
 	"file-storage/internal/authorization"
 	"file-storage/internal/config"
 	"file-storage/internal/contextkeys"
+	"file-storage/internal/signing"
+	"net"
 	"net/http"
 	"strings"
+	"time"
 )
 
 // Authorization middleware resolves access permissions and stores them in context.
+// Signed request URLs are verified with the signing key of the configuration.
 func Authorization(security config.Security) func(next http.Handler) http.Handler {
+	proxies := parseNetworks(security.TrustedProxies)
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -35,6 +41,9 @@ func Authorization(security config.Security) func(next http.Handler) http.Handle
 				auth.Write = false
 			}
 
+			auth.Signed = signing.Verify(security.SigningKey, r.URL, time.Now()) && boundToClient(r, proxies)
+			auth.Presigned = auth.Signed && r.URL.Query().Has(signing.ParamExpires)
+
 			ctxAuth := context.WithValue(r.Context(), contextkeys.ContextKeyAuth, &auth)
 			r = r.WithContext(ctxAuth)
 
@@ -42,3 +51,74 @@ func Authorization(security config.Security) func(next http.Handler) http.Handle
 		})
 	}
 }
+
+// boundToClient reports whether a signed URL bound to a client IP is used by
+// that client. URLs that are not bound are used by any client.
+func boundToClient(r *http.Request, proxies []*net.IPNet) bool {
+	ip := r.URL.Query().Get(signing.ParamIP)
+	if ip == "" {
+		return true
+	}
+
+	bound := net.ParseIP(ip)
+	return bound != nil && bound.Equal(peerIP(r, proxies))
+}
+
+// peerIP returns the address of the client. X-Forwarded-For is used only when
+// the request comes from a trusted proxy: its addresses are walked from the
+// nearest one and the first address that is not a trusted proxy is returned.
+func peerIP(r *http.Request, proxies []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !contains(proxies, ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		value := strings.TrimSpace(forwarded[i])
+		if value == "" {
+			continue
+		}
+		ip = net.ParseIP(value)
+		if ip == nil || !contains(proxies, ip) {
+			return ip
+		}
+	}
+
+	return ip
+}
+
+// parseNetworks parses addresses and CIDR networks. Addresses are converted
+// to single address networks and invalid values are skipped.
+func parseNetworks(values []string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		if ip := net.ParseIP(value); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		if _, network, err := net.ParseCIDR(value); err == nil {
+			networks = append(networks, network)
+		}
+	}
+
+	return networks
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -4,6 +4,7 @@ import (
 	"file-storage/internal/authorization"
 	"file-storage/internal/config"
 	"file-storage/internal/contextkeys"
+	"file-storage/internal/signing"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
@@ -19,13 +20,22 @@ func TestAuthorization(t *testing.T) {
 		handlerCalled = true
 	})
 
-	s := config.Security{ReadToken: "111", WriteToken: "222"}
+	s := config.Security{ReadToken: "111", WriteToken: "222", SigningKey: "333"}
+	signedTarget := "/files/1/content?width=200&sig=" + signing.Sign(s.SigningKey, "/files/1/content?width=200")
+	expiringTarget := "/files/1/content?exp=4102444800&sig=" + signing.Sign(s.SigningKey, "/files/1/content?exp=4102444800")
+	boundTarget := func(ip string) string {
+		return "/files/1/content?ip=" + ip + "&sig=" + signing.Sign(s.SigningKey, "/files/1/content?ip="+ip)
+	}
+	s.TrustedProxies = []string{"198.51.100.0/24"}
 	middleware := Authorization(s)
 	handlerFunc := middleware(handler)
 
 	table := []struct {
 		name       string
 		auth       string
+		target     string
+		remoteAddr string
+		forwarded  string
 		wantCalled bool
 		wantStatus int
 		wantAuth   *authorization.Auth
@@ -34,6 +44,14 @@ func TestAuthorization(t *testing.T) {
 		{name: "unauthorized no header", auth: "", wantCalled: true, wantStatus: -1, wantAuth: &authorization.Auth{Read: false, Write: false}},
 		{name: "authorized read", auth: "Bearer 111", wantCalled: true, wantStatus: -1, wantAuth: &authorization.Auth{Read: true, Write: false}},
 		{name: "authorized write", auth: "Bearer 222", wantCalled: true, wantStatus: -1, wantAuth: &authorization.Auth{Read: true, Write: true}},
+		{name: "signed", auth: "", target: signedTarget, wantCalled: true, wantStatus: -1, wantAuth: &authorization.Auth{Signed: true}},
+		{name: "signed with other params", auth: "", target: signedTarget + "&height=100", wantCalled: true, wantStatus: -1, wantAuth: &authorization.Auth{}},
+		{name: "signed with expiration", auth: "", target: expiringTarget, wantCalled: true, wantStatus: -1, wantAuth: &authorization.Auth{Signed: true, Presigned: true}},
+		{name: "signed for client ip", auth: "", target: boundTarget("192.0.2.1"), wantCalled: true, wantStatus: -1, wantAuth: &authorization.Auth{Signed: true}},
+		{name: "signed for other ip", auth: "", target: boundTarget("192.0.2.2"), wantCalled: true, wantStatus: -1, wantAuth: &authorization.Auth{}},
+		{name: "spoofed forwarded ip", auth: "", target: boundTarget("192.0.2.2"), forwarded: "192.0.2.2", wantCalled: true, wantStatus: -1, wantAuth: &authorization.Auth{}},
+		{name: "forwarded by trusted proxy", auth: "", target: boundTarget("192.0.2.2"), remoteAddr: "198.51.100.7:1234", forwarded: "192.0.2.2", wantCalled: true, wantStatus: -1, wantAuth: &authorization.Auth{Signed: true}},
+		{name: "spoofed through trusted proxy", auth: "", target: boundTarget("192.0.2.2"), remoteAddr: "198.51.100.7:1234", forwarded: "192.0.2.2, 203.0.113.9", wantCalled: true, wantStatus: -1, wantAuth: &authorization.Auth{}},
 	}
 
 	for _, tt := range table {
@@ -44,7 +62,17 @@ func TestAuthorization(t *testing.T) {
 
 			w := httptest.NewRecorder()
 			wwrapped := &responseWriter{w, false, -1}
-			r := httptest.NewRequest("POST", "/method", http.NoBody)
+			target := "/method"
+			if tt.target != "" {
+				target = tt.target
+			}
+			r := httptest.NewRequest("POST", target, http.NoBody)
+			if tt.remoteAddr != "" {
+				r.RemoteAddr = tt.remoteAddr
+			}
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
 
 			if tt.auth != "" {
 				r.Header.Add("Authorization", tt.auth)
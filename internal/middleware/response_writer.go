@@ -24,3 +24,8 @@ func (w *responseWriter) Write(b []byte) (int, error) {
 
 	return w.ResponseWriter.Write(b)
 }
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
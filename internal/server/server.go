@@ -84,9 +84,20 @@ func (s *Server) Run(ctx context.Context, authCfg config.Security) error {
 		r.Use(middleware.SizeLimit(int64(s.limits.sizelimit)))
 		r.Use(middleware.Authorization(authCfg))
 
+		r.Get("/files", handlers.ListHandler(s.service))
+		r.Get("/files/trash", handlers.TrashHandler(s.service))
 		r.Get("/files/{id}/info", handlers.InfoHandler(s.service))
 		r.Get("/files/{id}/content", handlers.ContentHandler(s.service))
+		r.Get("/files/{id}/versions", handlers.VersionsHandler(s.service))
+		r.Get("/files/{id}/versions/{version}/content", handlers.VersionContentHandler(s.service))
+		r.Post("/files/{id}/versions/{version}/restore", handlers.RestoreVersionHandler(s.service))
+		r.Post("/files/{id}/restore", handlers.RestoreHandler(s.service))
+		r.Head("/files/{id}/content", handlers.ContentHandler(s.service))
+		r.Get("/files/{id}/original", handlers.OriginalHandler(s.service))
+		r.Head("/files/{id}/original", handlers.OriginalHandler(s.service))
+		r.Post("/files/{id}/presign", handlers.PresignHandler(s.service, authCfg))
 		r.Post("/files/upload", handlers.UploadHandler(s.service))
+		r.Put("/files/{id}", handlers.PutHandler(s.service))
 		r.Delete("/files/{id}/delete", handlers.DeleteHandler(s.service))
 	})
 
@@ -95,6 +106,16 @@ func (s *Server) Run(ctx context.Context, authCfg config.Security) error {
 		r.Get("/files/metrics", promhttp.Handler().ServeHTTP)
 	})
 
+	// a backup runs until all files are archived and an integrity check until
+	// the whole file is read, so they have no handler timeout
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.ConcurrencyLimiter(s.limits.ConcurrencyLimiter))
+		r.Use(middleware.RateLimiter(s.limits.RateLimiter))
+		r.Use(middleware.Authorization(authCfg))
+		r.Get("/admin/backup", handlers.BackupHandler(s.service))
+		r.Post("/admin/scrub/{id}", handlers.ScrubHandler(s.service))
+	})
+
 	s.httpServer = &http.Server{
 		Addr:              ":" + strconv.Itoa(s.port),
 		BaseContext:       func(l net.Listener) context.Context { return ctx },
@@ -82,6 +82,11 @@ func TestServer_Authorization(t *testing.T) {
 			request:    newRequest("POST", "http://"+serverUrl+"/files/upload", nil, t),
 			wantStatus: http.StatusForbidden,
 		},
+		{
+			name:       "presign",
+			request:    newRequest("POST", "http://"+serverUrl+"/files/1/presign", nil, t),
+			wantStatus: http.StatusForbidden,
+		},
 	}
 
 	for _, tt := range table {
@@ -0,0 +1,105 @@
+package signing
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	key := "secret"
+	now := time.Date(2026, 5, 3, 10, 0, 0, 0, time.UTC)
+	path := "/files/012345678901234567890123456789012345/content"
+
+	signed := func(query string) string {
+		q, err := url.ParseQuery(query)
+		if err != nil {
+			t.Fatalf("parse query error: %v", err)
+		}
+		q.Set(ParamSignature, Sign(key, Payload(path, q)))
+		return path + "?" + q.Encode()
+	}
+	exp := func(d time.Duration) string {
+		return strconv.FormatInt(now.Add(d).Unix(), 10)
+	}
+
+	table := []struct {
+		name string
+		key  string
+		url  string
+		want bool
+	}{
+		{
+			name: "signed",
+			key:  key,
+			url:  signed("width=200&height=200&format=webp"),
+			want: true,
+		},
+		{
+			name: "parameter order",
+			key:  key,
+			url:  path + "?format=webp&sig=" + Sign(key, path+"?format=webp&width=200") + "&width=200",
+			want: true,
+		},
+		{
+			name: "not expired",
+			key:  key,
+			url:  signed("width=200&exp=" + exp(time.Minute)),
+			want: true,
+		},
+		{
+			name: "expired",
+			key:  key,
+			url:  signed("width=200&exp=" + exp(-time.Minute)),
+		},
+		{
+			name: "invalid expiration",
+			key:  key,
+			url:  signed("width=200&exp=tomorrow"),
+		},
+		{
+			name: "changed parameter",
+			key:  key,
+			url:  signed("width=200") + "&height=300",
+		},
+		{
+			name: "other key",
+			key:  "other",
+			url:  signed("width=200"),
+		},
+		{
+			name: "empty key",
+			url:  path + "?width=200&sig=" + Sign("", path+"?width=200"),
+		},
+		{
+			name: "not signed",
+			key:  key,
+			url:  path + "?width=200",
+		},
+		{
+			name: "invalid signature",
+			key:  key,
+			url:  path + "?width=200&sig=zz",
+		},
+		{
+			name: "multiple signatures",
+			key:  key,
+			url:  signed("width=200") + "&sig=00",
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatalf("parse url error: %v", err)
+			}
+
+			got := Verify(tt.key, u, now)
+			if got != tt.want {
+				t.Errorf("verify got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
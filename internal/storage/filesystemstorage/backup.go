@@ -0,0 +1,513 @@
+package filesystemstorage
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"file-storage/internal/config"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// restoreDirName is the directory in the storage root where a backup is
+	// unpacked and verified before files are moved to their catalogs.
+	restoreDirName = ".restore"
+
+	backupFilesDir     = "files"
+	backupManifestName = "manifest.json"
+	backupVersion      = 1
+)
+
+// backupManifest is the last entry of a backup archive. It lists every file
+// of the archive with the size and the SHA-256 of its content.
+type backupManifest struct {
+	Version   int           `json:"version"`
+	CreatedAt time.Time     `json:"created_at"`
+	Files     []backupEntry `json:"files"`
+}
+
+type backupEntry struct {
+	ID             string `json:"id"`
+	Size           int64  `json:"size"`
+	SHA256         string `json:"sha256"`
+	OriginalSize   int64  `json:"original_size,omitempty"`
+	OriginalSHA256 string `json:"original_sha256,omitempty"`
+}
+
+// Backup writes active versions of all files of the storage to w. See Backup
+// for the archive format.
+func (f *FileSystemStorage) Backup(ctx context.Context, w io.Writer) error {
+	_, err := Backup(ctx, f.path, w)
+	return err
+}
+
+// Backup writes active versions of all files of the storage at path to w as a
+// zstd-compressed tar archive and returns the number of archived files. The
+// storage may be in use: every file is opened under its lock, so the archive
+// holds a committed version of each file, but files written during the backup
+// may be archived in either version.
+//
+// The archive holds files/[id].meta.json and files/[id].bin for every file,
+// files/[id].orig for files with a kept original upload, followed by
+// manifest.json. Retained versions, the trash and renditions are not archived.
+func Backup(ctx context.Context, path string, w io.Writer) (int, error) {
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return 0, fmt.Errorf("compressor init error: %w", err)
+	}
+	tw := tar.NewWriter(zw)
+
+	manifest := backupManifest{Version: backupVersion, CreatedAt: time.Now().UTC(), Files: []backupEntry{}}
+	err = scanIDs(path, func(dirPath, id string) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		entry, err := backupFile(tw, dirPath, id)
+		if err != nil {
+			return fmt.Errorf("backup file %s error: %w", id, err)
+		}
+		if entry != nil {
+			manifest.Files = append(manifest.Files, *entry)
+		}
+		return nil
+	})
+	if err != nil {
+		zw.Close()
+		return 0, err
+	}
+
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		zw.Close()
+		return 0, fmt.Errorf("manifest marshal error: %w", err)
+	}
+	err = writeTarFile(tw, backupManifestName, manifest.CreatedAt, b)
+	if err == nil {
+		err = tw.Close()
+	}
+	closeErr := zw.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("write archive error: %w", err)
+	}
+
+	return len(manifest.Files), nil
+}
+
+// backupFile writes the active version of the file to the archive. Files
+// without an active version are skipped.
+func backupFile(tw *tar.Writer, dirPath, id string) (*backupEntry, error) {
+	meta, data, original, err := openActiveFiles(dirPath, id, true)
+	if errors.Is(err, errs.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+	if original != nil {
+		defer original.Close()
+	}
+
+	stat, err := data.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat file error: %w", err)
+	}
+
+	err = writeTarFile(tw, path.Join(backupFilesDir, id+"."+metadataExt), stat.ModTime(), meta)
+	if err != nil {
+		return nil, err
+	}
+
+	size, hash, err := writeTarContent(tw, path.Join(backupFilesDir, id+"."+binExt), data)
+	if err != nil {
+		return nil, err
+	}
+	entry := backupEntry{ID: id, Size: size, SHA256: hash}
+
+	if original != nil {
+		entry.OriginalSize, entry.OriginalSHA256, err = writeTarContent(tw, path.Join(backupFilesDir, id+"."+originalExt), original)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &entry, nil
+}
+
+// writeTarContent writes the opened file to the archive and returns its size
+// and SHA-256.
+func writeTarContent(tw *tar.Writer, name string, file *os.File) (int64, string, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, "", fmt.Errorf("stat file error: %w", err)
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+	})
+	if err != nil {
+		return 0, "", fmt.Errorf("write header error: %w", err)
+	}
+
+	h := sha256.New()
+	_, err = io.CopyN(tw, io.TeeReader(file, h), stat.Size())
+	if err != nil {
+		return 0, "", fmt.Errorf("write content error: %w", err)
+	}
+
+	return stat.Size(), hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// openActive reads metadata and opens content of the active version of the
+// file under its lock. Slot files are replaced by renames only, so the opened
+// content stays unchanged after the lock is released.
+func openActive(dirPath, id string) ([]byte, *os.File, error) {
+	meta, data, _, err := openActiveFiles(dirPath, id, false)
+	return meta, data, err
+}
+
+// openActiveFiles is openActive that also opens the original upload of the
+// version when withOriginal is set. The original is nil when the version has
+// none.
+func openActiveFiles(dirPath, id string, withOriginal bool) ([]byte, *os.File, *os.File, error) {
+	lockFile, err := lockAcquire(id, dirPath)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("lock error: %w", err)
+	}
+	defer lockFile.Close()
+
+	as, _, err := slotInfo(dirPath, id)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("read activeState error: %w", err)
+	}
+
+	meta, err := os.ReadFile(metadataFileFullName(dirPath, id, as))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, nil, errs.ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("read file error: %w", err)
+	}
+
+	data, err := os.Open(dataFileFullName(dirPath, id, as))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, nil, errs.ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("open file error: %w", err)
+	}
+
+	if !withOriginal || as.Original == "" {
+		return meta, data, nil, nil
+	}
+
+	original, err := os.Open(originalFileFullName(dirPath, id, as))
+	if errors.Is(err, fs.ErrNotExist) {
+		return meta, data, nil, nil
+	}
+	if err != nil {
+		data.Close()
+		return nil, nil, nil, fmt.Errorf("open file error: %w", err)
+	}
+
+	return meta, data, original, nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, modTime time.Time, b []byte) error {
+	err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(b)), ModTime: modTime})
+	if err != nil {
+		return fmt.Errorf("write header error: %w", err)
+	}
+	_, err = tw.Write(b)
+	if err != nil {
+		return fmt.Errorf("write %s error: %w", name, err)
+	}
+	return nil
+}
+
+// RestoreBackup restores files from an archive written by Backup into the
+// storage configured by cfg and returns the number of restored files. The
+// archive is unpacked into a directory in the storage root and verified
+// against its manifest before any file is replaced; files of the archive
+// replace current versions of the same IDs and other files are kept. The
+// metadata index is rebuilt afterwards. It fails with errs.ErrStorageInUse
+// while another process uses the storage.
+func RestoreBackup(ctx context.Context, cfg *config.FileSystem, r io.Reader) (int, error) {
+	err := os.MkdirAll(cfg.Path, 0755)
+	if err != nil {
+		return 0, fmt.Errorf("storage directory creation error: %w", err)
+	}
+
+	count, err := restoreBackup(ctx, cfg, r)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = RebuildIndex(cfg.Path)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// restoreBackup restores files of the archive holding the storage lock. The
+// clean marker is removed first, so the index is rebuilt even if the restore
+// is interrupted.
+func restoreBackup(ctx context.Context, cfg *config.FileSystem, r io.Reader) (int, error) {
+	owner, err := lockOwner(cfg.Path)
+	if err != nil {
+		return 0, err
+	}
+	defer owner.Close()
+
+	_, err = consumeCleanMarker(cfg.Path)
+	if err != nil {
+		return 0, err
+	}
+
+	restorePath := filepath.Join(cfg.Path, restoreDirName)
+	err = os.RemoveAll(restorePath)
+	if err != nil {
+		return 0, fmt.Errorf("remove restore directory error: %w", err)
+	}
+	err = os.MkdirAll(restorePath, 0755)
+	if err != nil {
+		return 0, fmt.Errorf("create restore directory error: %w", err)
+	}
+	defer os.RemoveAll(restorePath)
+
+	manifest, err := unpackBackup(ctx, restorePath, r)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range manifest.Files {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+
+		err = restoreFile(cfg, restorePath, entry.ID)
+		if err != nil {
+			return 0, fmt.Errorf("restore file %s error: %w", entry.ID, err)
+		}
+	}
+
+	return len(manifest.Files), nil
+}
+
+// unpackBackup extracts files of the archive into restorePath and checks them
+// against the manifest.
+func unpackBackup(ctx context.Context, restorePath string, r io.Reader) (*backupManifest, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("decompressor init error: %w", err)
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	hashes := make(map[string]string)
+	originalHashes := make(map[string]string)
+	metas := make(map[string]bool)
+	var manifest *backupManifest
+
+	for {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read archive error: %w", err)
+		}
+
+		if hdr.Name == backupManifestName {
+			manifest = &backupManifest{}
+			err = json.NewDecoder(tr).Decode(manifest)
+			if err != nil {
+				return nil, fmt.Errorf("manifest unmarshal error: %w", err)
+			}
+			continue
+		}
+
+		id, ext, err := backupFileName(hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		var h hash.Hash
+		var src io.Reader = tr
+		if ext != metadataExt {
+			h = sha256.New()
+			src = io.TeeReader(tr, h)
+		}
+		name := filepath.Join(restorePath, id+"."+ext)
+		err = writeFile(src, name, name+".tmp")
+		if err != nil {
+			return nil, err
+		}
+
+		switch ext {
+		case binExt:
+			hashes[id] = hex.EncodeToString(h.Sum(nil))
+		case originalExt:
+			originalHashes[id] = hex.EncodeToString(h.Sum(nil))
+		default:
+			metas[id] = true
+		}
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("archive has no manifest, it may be truncated: %w", errs.ErrInvalidFileData)
+	}
+	if manifest.Version != backupVersion {
+		return nil, fmt.Errorf("unsupported archive version %d: %w", manifest.Version, errs.ErrInvalidFileData)
+	}
+	for _, entry := range manifest.Files {
+		if hashes[entry.ID] != entry.SHA256 || originalHashes[entry.ID] != entry.OriginalSHA256 || !metas[entry.ID] {
+			return nil, fmt.Errorf("file %s does not match the manifest: %w", entry.ID, errs.ErrHashMismatch)
+		}
+	}
+
+	return manifest, nil
+}
+
+// backupFileName validates an archive entry name and returns its file ID and
+// extension.
+func backupFileName(name string) (string, string, error) {
+	base, ok := strings.CutPrefix(name, backupFilesDir+"/")
+	if ok && !strings.Contains(base, "/") {
+		if id, ok := strings.CutSuffix(base, "."+binExt); ok && validBackupID(id) {
+			return id, binExt, nil
+		}
+		if id, ok := strings.CutSuffix(base, "."+metadataExt); ok && validBackupID(id) {
+			return id, metadataExt, nil
+		}
+		if id, ok := strings.CutSuffix(base, "."+originalExt); ok && validBackupID(id) {
+			return id, originalExt, nil
+		}
+	}
+
+	return "", "", fmt.Errorf("unexpected archive entry %q: %w", name, errs.ErrInvalidFileData)
+}
+
+func validBackupID(id string) bool {
+	_, err := fileCatalog("", id)
+	return err == nil && !strings.ContainsAny(id, `./\`)
+}
+
+// restoreFile moves an unpacked file into the next slots of its catalog and
+// makes them active, in the same way as Upsert.
+func restoreFile(cfg *config.FileSystem, restorePath, id string) error {
+	meta, err := os.ReadFile(filepath.Join(restorePath, id+"."+metadataExt))
+	if err != nil {
+		return fmt.Errorf("read file error: %w", err)
+	}
+	var fi filedata.FileInfo
+	err = json.Unmarshal(meta, &fi)
+	if err != nil {
+		return fmt.Errorf("unmarshal info error: %w", err)
+	}
+	if fi.ID != id {
+		return fmt.Errorf("metadata of file %s has id %s: %w", id, fi.ID, errs.ErrInvalidFileData)
+	}
+
+	dirPath, err := fileCatalog(cfg.Path, id)
+	if err != nil {
+		return fmt.Errorf("catalog name error: %w", err)
+	}
+	err = os.MkdirAll(dirPath, 0755)
+	if err != nil {
+		return fmt.Errorf("directory path creation error: %w", err)
+	}
+
+	lockFile, err := lockAcquire(id, dirPath)
+	if err != nil {
+		return fmt.Errorf("lock error: %w", err)
+	}
+	defer lockFile.Close()
+
+	currentActiveState, newActiveState, err := slotInfo(dirPath, id)
+	if err != nil {
+		currentActiveState, newActiveState, err = slotInfoWithRecovery(dirPath, id, lockFile)
+		if err != nil {
+			return fmt.Errorf("get activeState error: %w", err)
+		}
+	}
+
+	dataName := dataFileFullName(dirPath, id, newActiveState)
+	err = os.Rename(filepath.Join(restorePath, id+"."+binExt), dataName)
+	if err != nil {
+		return fmt.Errorf("rename file error: %w", err)
+	}
+	err = os.Rename(filepath.Join(restorePath, id+"."+metadataExt), metadataFileFullName(dirPath, id, newActiveState))
+	if err != nil {
+		return fmt.Errorf("rename file error: %w", err)
+	}
+
+	// the original is restored only while the metadata describes it
+	if fi.Original == nil {
+		newActiveState.Original = ""
+	} else {
+		err = os.Rename(filepath.Join(restorePath, id+"."+originalExt), originalFileFullName(dirPath, id, newActiveState))
+		if errors.Is(err, fs.ErrNotExist) {
+			newActiveState.Original = ""
+		} else if err != nil {
+			return fmt.Errorf("rename file error: %w", err)
+		}
+	}
+
+	// the restored copy stays valid if it cannot be shared
+	if cfg.Dedup {
+		_ = shareBlob(filepath.Join(cfg.Path, blobsDirName), dataName, filepath.Join(dirPath, id)+".bin.tmp", fi.ContentHash())
+	}
+
+	if cfg.Versions.Enabled() {
+		newActiveState.LastVersion, err = archiveVersion(dirPath, id, currentActiveState, time.Now())
+		if err != nil {
+			return fmt.Errorf("archive version error: %w", err)
+		}
+	}
+
+	err = syncDir(dirPath)
+	if err != nil {
+		return fmt.Errorf("sync dir error: %w", err)
+	}
+
+	err = commitActiveState(dirPath, id, newActiveState)
+	if err != nil {
+		return fmt.Errorf("commit new activeState error: %w", err)
+	}
+
+	err = removeRenditions(dirPath, id)
+	if err != nil {
+		return fmt.Errorf("remove renditions error: %w", err)
+	}
+
+	return syncDir(dirPath)
+}
@@ -0,0 +1,230 @@
+package filesystemstorage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"file-storage/internal/config"
+	"file-storage/internal/contextkeys"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"file-storage/internal/logger"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestBackupRestore(t *testing.T) {
+	log := logger.NewBootstrap()
+	ctx := context.WithValue(context.Background(), contextkeys.ContextKeyLogger, log)
+
+	src, err := New(&config.FileSystem{Path: t.TempDir(), Trash: config.Trash{Enabled: true, PurgeAfter: time.Hour}}, log)
+	if err != nil {
+		t.Fatalf("new storage error: %v", err)
+	}
+
+	files := map[string]string{
+		"123456789012345678901234567890123451": "one",
+		"123456789012345678901234567890123452": "two",
+		"223456789012345678901234567890123453": "",
+	}
+	for id, data := range files {
+		_, err := src.Upsert(ctx, &filedata.FileData{ID: id, Data: bytes.NewReader([]byte(data)), Filename: id + ".txt"})
+		if err != nil {
+			t.Fatalf("upsert error: %v", err)
+		}
+	}
+	// originals are archived with their files
+	originalID := "223456789012345678901234567890123454"
+	_, err = src.Upsert(ctx, &filedata.FileData{
+		ID:           originalID,
+		Data:         bytes.NewReader([]byte("stored")),
+		HashSource:   "original",
+		Original:     bytes.NewReader([]byte("original")),
+		OriginalInfo: &filedata.OriginalInfo{Size: 8},
+	})
+	if err != nil {
+		t.Fatalf("upsert error: %v", err)
+	}
+	files[originalID] = "stored"
+
+	// deleted files are not archived
+	deletedID := "123456789012345678901234567890123459"
+	_, err = src.Upsert(ctx, &filedata.FileData{ID: deletedID, Data: bytes.NewReader([]byte("gone"))})
+	if err != nil {
+		t.Fatalf("upsert error: %v", err)
+	}
+	err = src.Delete(ctx, deletedID)
+	if err != nil {
+		t.Fatalf("delete error: %v", err)
+	}
+
+	var archive bytes.Buffer
+	err = src.Backup(ctx, &archive)
+	if err != nil {
+		t.Fatalf("backup error: %v", err)
+	}
+
+	// the restored file replaces the existing one, which is kept as a
+	// version, and other files are kept
+	dstPath := t.TempDir()
+	dstCfg := config.FileSystem{Path: dstPath, Versions: config.Versions{Keep: 2}}
+	dst, err := New(&dstCfg, log)
+	if err != nil {
+		t.Fatalf("new storage error: %v", err)
+	}
+	keptID := "323456789012345678901234567890123450"
+	for _, fd := range []*filedata.FileData{
+		{ID: "123456789012345678901234567890123451", Data: bytes.NewReader([]byte("old"))},
+		{ID: keptID, Data: bytes.NewReader([]byte("kept"))},
+	} {
+		_, err = dst.Upsert(ctx, fd)
+		if err != nil {
+			t.Fatalf("upsert error: %v", err)
+		}
+	}
+	dst.Close()
+
+	count, err := RestoreBackup(ctx, &dstCfg, &archive)
+	if err != nil {
+		t.Fatalf("restore error: %v", err)
+	}
+	if count != len(files) {
+		t.Errorf("restored files got %d want %d", count, len(files))
+	}
+
+	dst, err = New(&dstCfg, log)
+	if err != nil {
+		t.Fatalf("new storage error: %v", err)
+	}
+	defer dst.Close()
+
+	files[keptID] = "kept"
+	for id, data := range files {
+		cd, err := dst.Content(ctx, id)
+		if err != nil {
+			t.Fatalf("content error: %v", err)
+		}
+		b, err := io.ReadAll(cd.Data)
+		cd.Data.Close()
+		if err != nil {
+			t.Fatalf("read error: %v", err)
+		}
+		if string(b) != data {
+			t.Errorf("file %s content got %q want %q", id, b, data)
+		}
+	}
+
+	checkVersions(t, dst, "123456789012345678901234567890123451", []int{1})
+	checkOriginalContent(t, dst, originalID, "original")
+	checkOriginalContent(t, dst, keptID, "")
+
+	fl, err := dst.List(ctx, &filedata.ListQuery{Limit: 10})
+	if err != nil {
+		t.Fatalf("list error: %v", err)
+	}
+	if len(fl.Files) != len(files) {
+		t.Errorf("listed files got %d want %d", len(fl.Files), len(files))
+	}
+	if _, err := os.Stat(filepath.Join(dstPath, restoreDirName)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("restore directory stat got %v want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestRestoreInvalidArchive(t *testing.T) {
+	id := "123456789012345678901234567890123451"
+	meta := []byte(`{"id":"` + id + `"}`)
+	manifest := func(hash string) []byte {
+		return []byte(`{"version":1,"files":[{"id":"` + id + `","size":3,"sha256":"` + hash + `"}]}`)
+	}
+	// SHA-256 of "abc"
+	abcHash := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+
+	table := []struct {
+		name    string
+		entries []archiveEntry
+		wantErr error
+	}{
+		{
+			name: "hash mismatch",
+			entries: []archiveEntry{
+				{name: "files/" + id + ".meta.json", data: meta},
+				{name: "files/" + id + ".bin", data: []byte("abd")},
+				{name: backupManifestName, data: manifest(abcHash)},
+			},
+			wantErr: errs.ErrHashMismatch,
+		},
+		{
+			name: "original hash mismatch",
+			entries: []archiveEntry{
+				{name: "files/" + id + ".meta.json", data: meta},
+				{name: "files/" + id + ".bin", data: []byte("abc")},
+				{name: "files/" + id + ".orig", data: []byte("abd")},
+				{name: backupManifestName, data: manifest(abcHash)},
+			},
+			wantErr: errs.ErrHashMismatch,
+		},
+		{
+			name: "missing manifest",
+			entries: []archiveEntry{
+				{name: "files/" + id + ".meta.json", data: meta},
+				{name: "files/" + id + ".bin", data: []byte("abc")},
+			},
+			wantErr: errs.ErrInvalidFileData,
+		},
+		{
+			name: "path outside of storage",
+			entries: []archiveEntry{
+				{name: "files/../../" + id + ".bin", data: []byte("abc")},
+			},
+			wantErr: errs.ErrInvalidFileData,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			path := t.TempDir()
+
+			_, err := RestoreBackup(context.Background(), &config.FileSystem{Path: path}, buildArchive(t, tt.entries))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("restore error got %v want %v", err, tt.wantErr)
+			}
+
+			dirPath, _ := fileCatalog(path, id)
+			if _, err := os.Stat(dirPath); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("catalog stat got %v want %v", err, os.ErrNotExist)
+			}
+		})
+	}
+}
+
+type archiveEntry struct {
+	name string
+	data []byte
+}
+
+func buildArchive(t *testing.T, entries []archiveEntry) io.Reader {
+	t.Helper()
+
+	var b bytes.Buffer
+	zw, err := zstd.NewWriter(&b)
+	if err != nil {
+		t.Fatalf("compressor init error: %v", err)
+	}
+	tw := tar.NewWriter(zw)
+	for _, e := range entries {
+		err = writeTarFile(tw, e.name, time.Now(), e.data)
+		if err != nil {
+			t.Fatalf("write archive error: %v", err)
+		}
+	}
+	tw.Close()
+	zw.Close()
+
+	return &b
+}
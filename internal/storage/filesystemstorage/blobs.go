@@ -0,0 +1,193 @@
+package filesystemstorage
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"file-storage/internal/logger"
+	"file-storage/internal/metrics"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const (
+	// blobsDirName is the directory in the storage root that keeps one blob
+	// per content hash, for example .blobs/ab/cd/abcd….bin.
+	blobsDirName = ".blobs"
+
+	// blobShareAttempts limits retries when the blob of a hash is created or
+	// collected concurrently.
+	blobShareAttempts = 3
+)
+
+// shareBlob makes the data file a hard link to the blob of its content hash,
+// so files with identical content share one copy on disk. When there is no
+// blob for the hash yet, the data file becomes the blob.
+//
+// The link count of a blob is its reference count: slot files, retained
+// versions and trash entries all link to the same inode, and the blob is
+// collected when it is the only link left. Supposed id of the data file is
+// locked.
+func shareBlob(blobsPath, dataPath, tempPath, hash string) error {
+	if !isContentHash(hash) {
+		return nil
+	}
+
+	blobPath := blobFileFullName(blobsPath, hash)
+
+	dataStat, err := os.Stat(dataPath)
+	if err != nil {
+		return fmt.Errorf("stat file error: %w", err)
+	}
+
+	for range blobShareAttempts {
+		blobStat, err := os.Stat(blobPath)
+		switch {
+		case err == nil:
+			if os.SameFile(dataStat, blobStat) {
+				return nil
+			}
+			if blobStat.Size() != dataStat.Size() {
+				return fmt.Errorf("blob %s size %d does not match file size %d", hash, blobStat.Size(), dataStat.Size())
+			}
+
+			// the blob inode is shared with other files, so its times are
+			// left as they are
+			err = linkFile(blobPath, dataPath, tempPath)
+			if err == nil {
+				return nil
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			// the blob was collected meanwhile
+
+		case errors.Is(err, fs.ErrNotExist):
+			blobDir := filepath.Dir(blobPath)
+			err = os.MkdirAll(blobDir, 0755)
+			if err != nil {
+				return fmt.Errorf("blob directory creation error: %w", err)
+			}
+
+			err = os.Link(dataPath, blobPath)
+			if err == nil {
+				return syncDir(blobDir)
+			}
+			if !errors.Is(err, fs.ErrExist) {
+				return fmt.Errorf("link blob error: %w", err)
+			}
+			// the blob was created by another file meanwhile
+
+		default:
+			return fmt.Errorf("stat blob error: %w", err)
+		}
+	}
+
+	return fmt.Errorf("blob %s changed concurrently", hash)
+}
+
+// shareActiveBlob links content of the active version of the file to its blob.
+// It deduplicates content stored before deduplication was enabled. Supposed id
+// is locked.
+func shareActiveBlob(blobsPath, dirPath, id string) error {
+	as, _, err := slotInfo(dirPath, id)
+	if err != nil {
+		return fmt.Errorf("read activeState error: %w", err)
+	}
+
+	fi, err := readFileInfo(dirPath, id, as)
+	if err != nil {
+		return err
+	}
+
+	return shareBlob(blobsPath, dataFileFullName(dirPath, id, as), filepath.Join(dirPath, id+"."+binExt+"."+tmpExt), fi.ContentHash())
+}
+
+// collectBlobs removes blobs no longer referenced by any file and updates the
+// deduplication metrics.
+func (gc *GarbageCollector) collectBlobs(ctx context.Context) error {
+	var blobs, references, storedBytes, referencedBytes int64
+
+	blobsPath := filepath.Join(gc.path, blobsDirName)
+	err := filepath.WalkDir(blobsPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == blobsPath {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		links, ok := linkCount(info)
+		if !ok {
+			return nil
+		}
+
+		refs := links - 1
+		if refs < 1 {
+			err := os.Remove(path)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				gc.log.Warn("blob removal failed", "blob", d.Name(), slog.Any(logger.LogFieldError, err))
+				metrics.GcErrorsTotal.Inc()
+				return nil
+			}
+			metrics.GcFilesDeletedTotal.Inc()
+			return nil
+		}
+
+		blobs++
+		references += refs
+		storedBytes += info.Size()
+		referencedBytes += refs * info.Size()
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("blobs walk error: %w", err)
+	}
+
+	metrics.DedupBlobs.Set(float64(blobs))
+	metrics.DedupReferences.Set(float64(references))
+	metrics.DedupStoredBytes.Set(float64(storedBytes))
+	metrics.DedupReferencedBytes.Set(float64(referencedBytes))
+
+	return nil
+}
+
+func blobFileFullName(blobsPath, hash string) string {
+	return filepath.Join(blobsPath, hash[0:2], hash[2:4], hash+"."+binExt)
+}
+
+// isContentHash reports whether the hash is a hex encoded SHA-256 sum.
+func isContentHash(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+func linkCount(info fs.FileInfo) (int64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int64(st.Nlink), true
+}
@@ -0,0 +1,237 @@
+package filesystemstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"file-storage/internal/config"
+	"file-storage/internal/contextkeys"
+	"file-storage/internal/filedata"
+	"file-storage/internal/logger"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBlobs(t *testing.T) {
+	log := logger.NewBootstrap()
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, contextkeys.ContextKeyLogger, log)
+
+	path := t.TempDir()
+	cfg := config.FileSystem{Path: path}
+	f, err := New(&cfg, log)
+	if err != nil {
+		t.Fatalf("got error %v want nil", err)
+	}
+
+	data := []byte("placeholder")
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	ids := []string{
+		"123456789012345678901234567890123451",
+		"123456789012345678901234567890123452",
+		"223456789012345678901234567890123453",
+	}
+	upsert := func(f *FileSystemStorage, id string) {
+		t.Helper()
+		_, err := f.Upsert(ctx, &filedata.FileData{ID: id, Data: bytes.NewReader(data), HashSource: hash})
+		if err != nil {
+			t.Fatalf("upsert error %v", err)
+		}
+	}
+
+	// content stored before deduplication is shared by the garbage collector
+	upsert(f, ids[0])
+	err = f.Close()
+	if err != nil {
+		t.Fatalf("close error %v", err)
+	}
+
+	cfg.Dedup = true
+	f, err = New(&cfg, log)
+	if err != nil {
+		t.Fatalf("got error %v want nil", err)
+	}
+	upsert(f, ids[1])
+	upsert(f, ids[2])
+
+	blobPath := blobFileFullName(filepath.Join(path, blobsDirName), hash)
+	checkBlobLinks(t, blobPath, 3)
+
+	gc := NewGarbageCollector(&cfg, log)
+	runGarbageCollector(t, gc, ids[0])
+	checkBlobLinks(t, blobPath, 4)
+
+	for _, id := range ids {
+		dataPath := activeDataPath(t, path, id)
+		if !sameFile(t, dataPath, blobPath) {
+			t.Errorf("file %s does not share the blob", id)
+		}
+	}
+
+	err = gc.collectBlobs(ctx)
+	if err != nil {
+		t.Fatalf("collect blobs error %v", err)
+	}
+	checkBlobLinks(t, blobPath, 4)
+
+	for _, id := range ids {
+		err = f.Delete(ctx, id)
+		if err != nil {
+			t.Fatalf("delete error %v", err)
+		}
+	}
+	checkBlobLinks(t, blobPath, 1)
+
+	err = gc.collectBlobs(ctx)
+	if err != nil {
+		t.Fatalf("collect blobs error %v", err)
+	}
+	if _, err := os.Stat(blobPath); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("unreferenced blob got %v want not exist", err)
+	}
+}
+
+func TestBlobRecovery(t *testing.T) {
+	log := logger.NewBootstrap()
+	ctx := context.WithValue(context.Background(), contextkeys.ContextKeyLogger, log)
+
+	path := t.TempDir()
+	f, err := New(&config.FileSystem{Path: path, Dedup: true}, log)
+	if err != nil {
+		t.Fatalf("got error %v want nil", err)
+	}
+	defer f.Close()
+
+	upsert := func(id, content string) {
+		t.Helper()
+		sum := sha256.Sum256([]byte(content))
+		fd := filedata.FileData{ID: id, Data: bytes.NewReader([]byte(content)), HashSource: hex.EncodeToString(sum[:]), FileSize: len(content)}
+		_, err := f.Upsert(ctx, &fd)
+		if err != nil {
+			t.Fatalf("upsert error %v", err)
+		}
+	}
+
+	shared := "123456789012345678901234567890123451"
+	id := "123456789012345678901234567890123452"
+
+	upsert(shared, "shared content")
+	sharedData := activeDataPath(t, path, shared)
+	before, err := os.Stat(sharedData)
+	if err != nil {
+		t.Fatalf("stat error %v", err)
+	}
+
+	// the first version of the file is newer than the blob it links to later
+	upsert(id, "unique content")
+	future := before.ModTime().Add(time.Hour)
+	err = os.Chtimes(activeDataPath(t, path, id), future, future)
+	if err != nil {
+		t.Fatalf("change time error %v", err)
+	}
+	upsert(id, "shared content")
+
+	after, err := os.Stat(sharedData)
+	if err != nil {
+		t.Fatalf("stat error %v", err)
+	}
+	if !after.ModTime().Equal(before.ModTime()) {
+		t.Errorf("shared blob modification time changed from %v to %v", before.ModTime(), after.ModTime())
+	}
+
+	// the recovery picks the slot the metadata describes, not the newest one
+	dirPath, err := fileCatalog(path, id)
+	if err != nil {
+		t.Fatalf("catalog name error: %v", err)
+	}
+	want, _, err := slotInfo(dirPath, id)
+	if err != nil {
+		t.Fatalf("read activeState error: %v", err)
+	}
+	err = os.WriteFile(activeStateFileFullName(dirPath, id), []byte("corrupted"), 0644)
+	if err != nil {
+		t.Fatalf("write activeState error: %v", err)
+	}
+	got, err := recoveryActiveState(dirPath, id)
+	if err != nil {
+		t.Fatalf("recovery error: %v", err)
+	}
+	if got.Data != want.Data || got.Metadata != want.Metadata {
+		t.Errorf("recovered activeState got %+v want %+v", got, want)
+	}
+}
+
+func checkBlobLinks(t *testing.T, blobPath string, want int64) {
+	t.Helper()
+
+	info, err := os.Stat(blobPath)
+	if err != nil {
+		t.Fatalf("stat blob error %v", err)
+	}
+	links, ok := linkCount(info)
+	if !ok {
+		t.Skip("link count is not available")
+	}
+	if links != want {
+		t.Errorf("blob links got %d want %d", links, want)
+	}
+}
+
+func runGarbageCollector(t *testing.T, gc *GarbageCollector, id string) {
+	t.Helper()
+
+	dirPath, err := fileCatalog(gc.path, id)
+	if err != nil {
+		t.Fatalf("catalog name error: %v", err)
+	}
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		t.Fatalf("read dir error: %v", err)
+	}
+	entries := make([]os.DirEntry, 0, len(dirEntries))
+	for _, e := range dirEntries {
+		if disassembleFilename(e.Name()).id == id {
+			entries = append(entries, e)
+		}
+	}
+	err = gc.removeGarbage(&cleanupJob{id: id, dirPath: dirPath, dirEntries: entries}, gc.log)
+	if err != nil {
+		t.Fatalf("remove garbage error: %v", err)
+	}
+}
+
+func activeDataPath(t *testing.T, path, id string) string {
+	t.Helper()
+
+	dirPath, err := fileCatalog(path, id)
+	if err != nil {
+		t.Fatalf("catalog name error: %v", err)
+	}
+	as, _, err := slotInfo(dirPath, id)
+	if err != nil {
+		t.Fatalf("read activeState error: %v", err)
+	}
+
+	return dataFileFullName(dirPath, id, as)
+}
+
+func sameFile(t *testing.T, a, b string) bool {
+	t.Helper()
+
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		t.Fatalf("stat error %v", err)
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		t.Fatalf("stat error %v", err)
+	}
+
+	return os.SameFile(aInfo, bInfo)
+}
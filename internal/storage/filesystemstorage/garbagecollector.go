@@ -3,11 +3,13 @@ package filesystemstorage
 import (
 	"context"
 	"errors"
+	"file-storage/internal/config"
 	"file-storage/internal/logger"
 	"file-storage/internal/metrics"
 	"fmt"
 	"io/fs"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
 	"sync"
@@ -26,15 +28,27 @@ type GarbageCollector struct {
 	path     string
 	interval time.Duration
 	workers  int
+	versions config.Versions
+	trash    config.Trash
+	dedup    bool
 	log      *slog.Logger
+	// trashIndex of the storage the collector runs for, if any, forgets
+	// purged entries
+	trashIndex *trashIndex
 }
 
 // NewGarbageCollector creates a garbage collector for versioned filesystem storage.
-func NewGarbageCollector(path string, interval time.Duration, workers int, log *slog.Logger) *GarbageCollector {
+// Previous file versions are kept as long as the versions retention policy requires,
+// and deleted files are purged from the trash according to the trash settings.
+// With deduplication, unreferenced blobs are removed and existing content is shared.
+func NewGarbageCollector(cfg *config.FileSystem, log *slog.Logger) *GarbageCollector {
 	return &GarbageCollector{
-		path:     path,
-		interval: interval,
-		workers:  workers,
+		path:     cfg.Path,
+		interval: cfg.GarbageCollector.Interval,
+		workers:  cfg.GarbageCollector.WorkersCount,
+		versions: cfg.Versions,
+		trash:    cfg.Trash,
+		dedup:    cfg.Dedup,
 		log:      logger.WithComponent(log, logger.ComponentGC),
 	}
 }
@@ -86,6 +100,24 @@ func (gc *GarbageCollector) Run(ctx context.Context) {
 					gc.log.Error("garbage collector error", slog.Any(logger.LogFieldError, err))
 					metrics.GcErrorsTotal.Inc()
 				}
+
+				err = gc.purgeTrash(ctx, time.Now())
+				if err != nil {
+					if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+						return
+					}
+					gc.log.Error("trash purge error", slog.Any(logger.LogFieldError, err))
+					metrics.GcErrorsTotal.Inc()
+				}
+
+				err = gc.collectBlobs(ctx)
+				if err != nil {
+					if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+						return
+					}
+					gc.log.Error("blobs collection error", slog.Any(logger.LogFieldError, err))
+					metrics.GcErrorsTotal.Inc()
+				}
 			}()
 
 			select {
@@ -109,7 +141,7 @@ func (gc *GarbageCollector) collectGarbage(ctx context.Context, jobs chan *clean
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
-		if !level1Entry.IsDir() {
+		if !level1Entry.IsDir() || isReservedDir(level1Entry.Name()) {
 			continue
 		}
 
@@ -215,12 +247,27 @@ func (gc *GarbageCollector) removeGarbage(j *cleanupJob, log *slog.Logger) error
 		return err
 	}
 
+	versions, err := readVersions(j.dirPath, j.id)
+	if err != nil {
+		return err
+	}
+	maps.Copy(keepFiles, retainedVersionFiles(j.id, retainedVersions(versions, gc.versions, time.Now())))
+
+	// renditions are kept only for a readable active version
+	hash, err := activeContentHash(j.dirPath, j.id)
+	if err != nil {
+		hash = ""
+	}
+
 	callSyncDir := false
 	for _, e := range j.dirEntries {
 		name := e.Name()
 		if _, ok := keepFiles[name]; ok {
 			continue
 		}
+		if isActiveRendition(name, j.id, hash) {
+			continue
+		}
 
 		err := os.Remove(filepath.Join(j.dirPath, name))
 		if err != nil && !errors.Is(err, fs.ErrNotExist) {
@@ -237,6 +284,16 @@ func (gc *GarbageCollector) removeGarbage(j *cleanupJob, log *slog.Logger) error
 		}
 	}
 
+	if gc.dedup && hash != "" {
+		err = shareActiveBlob(filepath.Join(gc.path, blobsDirName), j.dirPath, j.id)
+		if err != nil {
+			log.Warn("blob sharing failed",
+				"id", j.id,
+				"error", err,
+			)
+		}
+	}
+
 	return nil
 }
 
@@ -262,6 +319,9 @@ func activeFiles(id, dirPath string, lockFile *os.File) (map[string]struct{}, bo
 	m[metadataFileName(id, activeState)] = struct{}{}
 	m[lockFileName(id)] = struct{}{}
 	m[activeStateFileName(id)] = struct{}{}
+	if activeState.Original != "" {
+		m[originalFileName(id, activeState)] = struct{}{}
+	}
 
 	return m, recovered, nil
 }
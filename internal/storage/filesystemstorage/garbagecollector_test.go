@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"file-storage/internal/config"
 	"io"
 	"log/slog"
 	"os"
@@ -205,7 +206,8 @@ func TestCollectGarbage(t *testing.T) {
 	}
 
 	log := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{}))
-	gc := NewGarbageCollector(root, 1*time.Minute, 5, log)
+	cfg := config.FileSystem{Path: root, GarbageCollector: config.GarbageCollector{Interval: time.Minute, WorkersCount: 5}}
+	gc := NewGarbageCollector(&cfg, log)
 
 	ctx := context.Background()
 	ch := make(chan *cleanupJob, 10)
@@ -246,7 +248,8 @@ func TestCollectGarbage(t *testing.T) {
 func TestRemoveGarbage(t *testing.T) {
 	root := t.TempDir()
 	log := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{}))
-	gc := NewGarbageCollector(root, 1*time.Minute, 5, log)
+	cfg := config.FileSystem{Path: root, GarbageCollector: config.GarbageCollector{Interval: time.Minute, WorkersCount: 5}}
+	gc := NewGarbageCollector(&cfg, log)
 
 	emptyJob := cleanupJob{}
 
@@ -1,13 +1,17 @@
 package filesystemstorage
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"file-storage/internal/errs"
 	"file-storage/internal/filedata"
 	"file-storage/internal/logger"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -24,11 +28,17 @@ const (
 	lockExt        = "lock"
 	binExt         = "bin"
 	metadataExt    = "meta.json"
+	originalExt    = "orig"
 )
 
+// activeState holds active slots of the file. Original is empty when the file
+// has no original upload kept. LastVersion is the number of the most recently
+// retained previous version.
 type activeState struct {
-	Data     string
-	Metadata string
+	Data        string
+	Metadata    string
+	Original    string `json:",omitempty"`
+	LastVersion int    `json:",omitempty"`
 }
 
 type fileNameStructure struct {
@@ -72,6 +82,12 @@ func fileCatalog(path, id string) (string, error) {
 	return filepath.Join(path, cat1, cat2), nil
 }
 
+// isReservedDir reports whether a directory in the storage root is used by the
+// storage itself rather than holding file catalogs.
+func isReservedDir(name string) bool {
+	return name == trashDirName || name == blobsDirName || name == restoreDirName || name == quarantineDirName
+}
+
 func lockFileFullName(catalog, id string) string {
 	return filepath.Join(catalog, lockFileName(id))
 }
@@ -80,20 +96,24 @@ func lockFileName(id string) string {
 	return id + "." + lockExt
 }
 
-func writeFile(data []byte, path, tempPath string) error {
+// writeFile copies the stream into tempPath, syncs it and renames it to path.
+// The temporary file is removed if the stream or the write fails.
+func writeFile(r io.Reader, path, tempPath string) error {
 	file, err := os.OpenFile(tempPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		return fmt.Errorf("open file error: %w", err)
 	}
 	defer file.Close()
 
-	_, err = file.Write(data)
+	_, err = io.Copy(file, r)
 	if err != nil {
+		_ = os.Remove(tempPath)
 		return fmt.Errorf("write file error: %w", err)
 	}
 
 	err = file.Sync()
 	if err != nil {
+		_ = os.Remove(tempPath)
 		return fmt.Errorf("file sync error: %w", err)
 	}
 
@@ -129,9 +149,10 @@ func slotInfo(dirPath, id string) (activeState, activeState, error) {
 
 	currentDataState, newDataState := calcActiveState(as.Data)
 	currentMetadataState, newMetadataState := calcActiveState(as.Metadata)
+	currentOriginalState, newOriginalState := calcActiveState(as.Original)
 
-	return activeState{Data: currentDataState, Metadata: currentMetadataState},
-		activeState{Data: newDataState, Metadata: newMetadataState},
+	return activeState{Data: currentDataState, Metadata: currentMetadataState, Original: currentOriginalState, LastVersion: as.LastVersion},
+		activeState{Data: newDataState, Metadata: newMetadataState, Original: newOriginalState, LastVersion: as.LastVersion},
 		nil
 }
 
@@ -185,28 +206,33 @@ func calcActiveState(currentState string) (string, string) {
 	return currentState, newState
 }
 
+// recoveryActiveState rebuilds the active state of the file from its slot
+// files. Every write creates a new metadata file that is never linked
+// elsewhere, so the most recently modified one is the current metadata. Data
+// and original files may be links to shared blob inodes that keep the
+// modification time of their first write, so their slots are chosen by the
+// size and hash recorded in the current metadata; modification times decide
+// only when the metadata does not tell the slots apart. Supposed id is locked.
 func recoveryActiveState(dirPath, id string) (activeState, error) {
 	files, err := os.ReadDir(dirPath)
 	if err != nil {
 		return activeState{}, err
 	}
 
-	activeFiles := make(map[string]time.Time, 2)
-	as := activeState{}
-
+	var metadata, data, originals []slotFile
 	for _, f := range files {
 		if f.IsDir() {
 			continue
 		}
-		fName := f.Name()
-		fns := disassembleFilename(fName)
+		fns := disassembleFilename(f.Name())
 		if fns.id != id {
 			continue
 		}
 
 		isMetadata := fns.ext == metadataExt
 		isData := fns.ext == binExt
-		if !isMetadata && !isData {
+		isOriginal := fns.ext == originalExt && fns.slot != ""
+		if !isMetadata && !isData && !isOriginal {
 			continue
 		}
 
@@ -215,41 +241,113 @@ func recoveryActiveState(dirPath, id string) (activeState, error) {
 			return activeState{}, err
 		}
 
-		if isMetadata {
-			savedTime, ok := activeFiles["Metadata"]
-			fileTime := fInfo.ModTime()
-			if !ok {
-				activeFiles["Metadata"] = fileTime
-				as.Metadata = fns.slot
-			} else {
-				if fileTime.After(savedTime) {
-					activeFiles["Metadata"] = fileTime
-					as.Metadata = fns.slot
-				}
-			}
-		}
-		if isData {
-			savedTime, ok := activeFiles["Data"]
-			fileTime := fInfo.ModTime()
-			if !ok {
-				activeFiles["Data"] = fileTime
-				as.Data = fns.slot
-			} else {
-				if fileTime.After(savedTime) {
-					activeFiles["Data"] = fileTime
-					as.Data = fns.slot
-				}
-			}
+		sf := slotFile{slot: fns.slot, path: filepath.Join(dirPath, f.Name()), modTime: fInfo.ModTime(), size: fInfo.Size()}
+		switch {
+		case isMetadata:
+			metadata = append(metadata, sf)
+		case isData:
+			data = append(data, sf)
+		default:
+			originals = append(originals, sf)
 		}
 	}
 
+	as := activeState{Metadata: newestSlot(metadata).slot}
+
+	fi, err := readFileInfo(dirPath, id, as)
+	if err != nil {
+		// the data and the original slots cannot be checked
+		fi = nil
+	}
+
+	switch {
+	case fi == nil:
+		as.Data = newestSlot(data).slot
+		as.Original = newestSlot(originals).slot
+	case fi.Original == nil:
+		as.Data = matchingSlot(data, int64(fi.FileSize), fi.ContentHash()).slot
+		// a recovered original is served only while the metadata of the
+		// version describes it
+		as.Original = newestSlot(originals).slot
+	default:
+		as.Data = matchingSlot(data, int64(fi.FileSize), fi.ContentHash()).slot
+		as.Original = matchingSlot(originals, int64(fi.Original.Size), fi.HashSource).slot
+	}
+
 	err = commitActiveState(dirPath, id, as)
 	if err != nil {
 		return activeState{}, err
 	}
 
 	return as, nil
+}
+
+// slotFile is a slot file found by the active state recovery.
+type slotFile struct {
+	slot    string
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// newestSlot returns the most recently modified slot file, or an empty one
+// when there are no files.
+func newestSlot(files []slotFile) slotFile {
+	var newest slotFile
+	for i, f := range files {
+		if i == 0 || f.modTime.After(newest.modTime) {
+			newest = f
+		}
+	}
+
+	return newest
+}
+
+// matchingSlot returns the slot file with the size and the SHA-256 content
+// hash. Files are hashed only when their sizes do not tell them apart. When
+// several files match, the most recently modified of them is returned.
+func matchingSlot(files []slotFile, size int64, hash string) slotFile {
+	var matched []slotFile
+	for _, f := range files {
+		if f.size == size {
+			matched = append(matched, f)
+		}
+	}
+	if len(matched) == 0 {
+		matched = files
+	}
+
+	if len(matched) > 1 && isContentHash(hash) {
+		var hashed []slotFile
+		for _, f := range matched {
+			if fileHash(f.path) == hash {
+				hashed = append(hashed, f)
+			}
+		}
+		if len(hashed) > 0 {
+			matched = hashed
+		}
+	}
+
+	return newestSlot(matched)
+}
 
+// fileHash returns the hex encoded SHA-256 sum of the file content or an empty
+// string when the file cannot be read.
+func fileHash(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, file)
+	if err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 func commitActiveState(dirPath, id string, v activeState) error {
@@ -260,7 +358,7 @@ func commitActiveState(dirPath, id string, v activeState) error {
 	if err != nil {
 		return fmt.Errorf("marshal activeState file error: %w", err)
 	}
-	err = writeFile(b, activeStatePath, tempPath)
+	err = writeFile(bytes.NewReader(b), activeStatePath, tempPath)
 	if err != nil {
 		return fmt.Errorf("write activeState file error: %w", err)
 	}
@@ -297,6 +395,16 @@ func dataFileName(id string, activeState activeState) string {
 	return id + "." + binExt
 }
 
+// originalFileFullName returns the name of the original upload file. The
+// active state must have an original slot.
+func originalFileFullName(dirPath, id string, activeState activeState) string {
+	return filepath.Join(dirPath, originalFileName(id, activeState))
+}
+
+func originalFileName(id string, activeState activeState) string {
+	return id + "." + activeState.Original + "." + originalExt
+}
+
 func filenamesByID(dirPath string, id string) ([]string, error) {
 	s, err := os.ReadDir(dirPath)
 
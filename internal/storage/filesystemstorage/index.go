@@ -0,0 +1,447 @@
+package filesystemstorage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	indexJournalName = "index.journal"
+	indexCleanName   = "index.clean"
+	// indexOwnerID names the lock file held by the process owning the index
+	indexOwnerID = "index"
+
+	// maxJournalRecord limits the size of a single journal record.
+	maxJournalRecord = 16 << 20
+)
+
+type journalOp string
+
+const (
+	journalPut    journalOp = "put"
+	journalDelete journalOp = "delete"
+)
+
+type journalRecord struct {
+	Op   journalOp          `json:"op"`
+	ID   string             `json:"id,omitempty"`
+	Info *filedata.FileInfo `json:"info,omitempty"`
+}
+
+// index keeps metadata of active file versions in memory ordered by ID.
+//
+// Changes are appended to a journal in the storage root. The journal is
+// replayed on startup only after a clean shutdown, which is marked by the
+// index.clean file. Otherwise the index is rebuilt from the metadata files,
+// so a crash between a commit and the journal append never leaves it stale.
+//
+// The index belongs to a single process, which holds an exclusive lock on the
+// storage root while the index is open. Writes are counted from before their
+// commit until the index is updated, and the shutdown is marked clean only
+// when none of them is in progress.
+type index struct {
+	mu      sync.RWMutex
+	path    string
+	owner   *os.File
+	files   map[string]*filedata.FileInfo
+	ids     []string
+	journal *os.File
+	// writes counts write operations that have not updated the index yet
+	writes int
+	closed bool
+	// dirty is set when a change could not be journaled
+	dirty bool
+}
+
+// openIndex loads the index of the storage at path. The journal is compacted
+// on every start, so its size is bounded by the number of changes since then.
+// The index is rebuilt from the metadata files when rebuild is set.
+func openIndex(path string, rebuild bool) (*index, bool, error) {
+	owner, err := lockOwner(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	ix, rebuilt, err := loadIndex(path, rebuild)
+	if err != nil {
+		owner.Close()
+		return nil, false, err
+	}
+	ix.owner = owner
+
+	return ix, rebuilt, nil
+}
+
+// lockOwner locks the storage root for the index owner. Another process
+// using the storage holds the lock until it closes the index.
+func lockOwner(path string) (*os.File, error) {
+	owner, err := lockAcquireWithFlags(indexOwnerID, path, unix.LOCK_EX|unix.LOCK_NB)
+	if err != nil {
+		if errors.Is(err, unix.EWOULDBLOCK) || errors.Is(err, unix.EAGAIN) {
+			return nil, fmt.Errorf("storage %s: %w", path, errs.ErrStorageInUse)
+		}
+		return nil, err
+	}
+
+	return owner, nil
+}
+
+func loadIndex(path string, rebuild bool) (*index, bool, error) {
+	ix := &index{path: path, files: make(map[string]*filedata.FileInfo)}
+
+	rebuilt := false
+	clean, err := consumeCleanMarker(path)
+	if err != nil {
+		return nil, false, err
+	}
+	clean = clean && !rebuild
+	if clean {
+		err = ix.replay()
+	}
+	if !clean || err != nil {
+		ix.files = make(map[string]*filedata.FileInfo)
+		err = scanFiles(path, func(fi *filedata.FileInfo) {
+			ix.files[fi.ID] = fi
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("index rebuild error: %w", err)
+		}
+		rebuilt = true
+	}
+
+	ix.ids = slices.Sorted(maps.Keys(ix.files))
+
+	err = ix.compact()
+	if err != nil {
+		return nil, false, err
+	}
+
+	ix.journal, err = os.OpenFile(filepath.Join(path, indexJournalName), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, false, fmt.Errorf("open index journal error: %w", err)
+	}
+
+	return ix, rebuilt, nil
+}
+
+// RebuildIndex reconstructs the metadata index of the storage at path from the
+// metadata files and returns the number of indexed files. It fails with
+// errs.ErrStorageInUse while another process uses the storage.
+func RebuildIndex(path string) (int, error) {
+	ix, _, err := openIndex(path, true)
+	if err != nil {
+		return 0, err
+	}
+
+	count := len(ix.ids)
+	err = ix.close()
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// beginWrite registers a write operation that is going to update the index.
+// Writes are rejected after the index is closed.
+func (ix *index) beginWrite() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if ix.closed {
+		return errs.ErrStorageClosed
+	}
+	ix.writes++
+
+	return nil
+}
+
+// endWrite completes a write operation registered by beginWrite.
+func (ix *index) endWrite() {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.writes--
+}
+
+// put records the metadata of the active version of a file.
+func (ix *index) put(fi *filedata.FileInfo) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if _, ok := ix.files[fi.ID]; !ok {
+		i, _ := slices.BinarySearch(ix.ids, fi.ID)
+		ix.ids = slices.Insert(ix.ids, i, fi.ID)
+	}
+	ix.files[fi.ID] = copyFileInfo(fi)
+
+	return ix.append(journalRecord{Op: journalPut, Info: fi})
+}
+
+// delete removes the file from the index.
+func (ix *index) delete(id string) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if _, ok := ix.files[id]; !ok {
+		return nil
+	}
+	delete(ix.files, id)
+	i, _ := slices.BinarySearch(ix.ids, id)
+	ix.ids = slices.Delete(ix.ids, i, i+1)
+
+	return ix.append(journalRecord{Op: journalDelete, ID: id})
+}
+
+// list returns a page of files matching the query in ascending ID order.
+func (ix *index) list(q *filedata.ListQuery) *filedata.FileList {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	fl := filedata.FileList{Files: make([]*filedata.FileInfo, 0, q.Limit)}
+
+	start := sort.SearchStrings(ix.ids, q.Cursor)
+	for _, id := range ix.ids[start:] {
+		if id <= q.Cursor {
+			continue
+		}
+		fi := ix.files[id]
+		if !q.Match(fi) {
+			continue
+		}
+		if len(fl.Files) == q.Limit {
+			fl.NextCursor = fl.Files[len(fl.Files)-1].ID
+			break
+		}
+		fl.Files = append(fl.Files, copyFileInfo(fi))
+	}
+
+	return &fl
+}
+
+// close syncs the journal, marks the shutdown as clean unless some change
+// was not journaled or some write is still in progress, and releases the
+// storage lock. Writes finishing later are not journaled, so the next start
+// rebuilds the index.
+func (ix *index) close() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if ix.closed {
+		return nil
+	}
+	ix.closed = true
+	defer ix.owner.Close()
+
+	err := ix.journal.Sync()
+	if err != nil {
+		return fmt.Errorf("sync index journal error: %w", err)
+	}
+	err = ix.journal.Close()
+	if err != nil {
+		return fmt.Errorf("close index journal error: %w", err)
+	}
+	ix.journal = nil
+
+	if ix.dirty || ix.writes > 0 {
+		return nil
+	}
+
+	err = os.WriteFile(filepath.Join(ix.path, indexCleanName), nil, 0644)
+	if err != nil {
+		return fmt.Errorf("write index clean marker error: %w", err)
+	}
+
+	return syncDir(ix.path)
+}
+
+// append writes a record to the journal. Supposed ix.mu is locked.
+func (ix *index) append(r journalRecord) error {
+	if ix.journal == nil {
+		ix.dirty = true
+		return errs.ErrStorageClosed
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		ix.dirty = true
+		return fmt.Errorf("index record marshal error: %w", err)
+	}
+
+	_, err = ix.journal.Write(append(b, '\n'))
+	if err != nil {
+		ix.dirty = true
+		return fmt.Errorf("index journal write error: %w", err)
+	}
+
+	return nil
+}
+
+func (ix *index) replay() error {
+	file, err := os.Open(filepath.Join(ix.path, indexJournalName))
+	if err != nil {
+		return fmt.Errorf("open index journal error: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxJournalRecord)
+	for scanner.Scan() {
+		var r journalRecord
+		err := json.Unmarshal(scanner.Bytes(), &r)
+		if err != nil {
+			return fmt.Errorf("index record unmarshal error: %w", err)
+		}
+
+		switch {
+		case r.Op == journalPut && r.Info != nil:
+			ix.files[r.Info.ID] = r.Info
+		case r.Op == journalDelete:
+			delete(ix.files, r.ID)
+		default:
+			return fmt.Errorf("invalid index record %q", r.Op)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// compact replaces the journal with a snapshot of the index.
+func (ix *index) compact() error {
+	var buf bytes.Buffer
+	for _, id := range ix.ids {
+		b, err := json.Marshal(journalRecord{Op: journalPut, Info: ix.files[id]})
+		if err != nil {
+			return fmt.Errorf("index record marshal error: %w", err)
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+
+	journalPath := filepath.Join(ix.path, indexJournalName)
+	err := writeFile(&buf, journalPath, journalPath+"."+tmpExt)
+	if err != nil {
+		return fmt.Errorf("write index journal error: %w", err)
+	}
+
+	return syncDir(ix.path)
+}
+
+// consumeCleanMarker reports whether the previous shutdown was clean and
+// removes the marker, so that a crash from now on triggers a rebuild.
+func consumeCleanMarker(path string) (bool, error) {
+	err := os.Remove(filepath.Join(path, indexCleanName))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("remove index clean marker error: %w", err)
+	}
+
+	err = syncDir(path)
+	if err != nil {
+		return false, fmt.Errorf("sync dir error: %w", err)
+	}
+
+	return true, nil
+}
+
+// scanFiles walks the catalog tree and calls fn with the metadata of the
+// active version of every file. Files with unreadable metadata are skipped.
+func scanFiles(path string, fn func(fi *filedata.FileInfo)) error {
+	return scanIDs(path, func(dirPath, id string) error {
+		fi, err := activeFileInfo(dirPath, id)
+		if err == nil {
+			fn(fi)
+		}
+		return nil
+	})
+}
+
+// scanIDs walks the catalog tree and calls fn with the catalog and the ID of
+// every file name found in it, in ascending ID order within a catalog. The
+// IDs may belong to files without an active version.
+func scanIDs(path string, fn func(dirPath, id string) error) error {
+	level1Entries, err := os.ReadDir(path)
+	if err != nil {
+		return fmt.Errorf("storage path %s reading error: %w", path, err)
+	}
+
+	for _, level1Entry := range level1Entries {
+		if !level1Entry.IsDir() || isReservedDir(level1Entry.Name()) {
+			continue
+		}
+
+		dirLevel1Path := filepath.Join(path, level1Entry.Name())
+		level2Entries, err := os.ReadDir(dirLevel1Path)
+		if err != nil {
+			return fmt.Errorf("subdirectory %s reading error: %w", dirLevel1Path, err)
+		}
+
+		for _, level2Entry := range level2Entries {
+			if !level2Entry.IsDir() {
+				continue
+			}
+
+			dirLevel2Path := filepath.Join(dirLevel1Path, level2Entry.Name())
+			entries, err := os.ReadDir(dirLevel2Path)
+			if err != nil {
+				return fmt.Errorf("subdirectory %s reading error: %w", dirLevel2Path, err)
+			}
+
+			// files of the non-versioned layout have no active slot file, so
+			// IDs are collected from all file names
+			ids := make([]string, 0, len(entries))
+			for _, entry := range entries {
+				ids = append(ids, disassembleFilename(entry.Name()).id)
+			}
+			slices.Sort(ids)
+
+			for _, id := range slices.Compact(ids) {
+				err = fn(dirLevel2Path, id)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func activeFileInfo(dirPath, id string) (*filedata.FileInfo, error) {
+	as, _, err := slotInfo(dirPath, id)
+	if err != nil {
+		return nil, fmt.Errorf("read activeState error: %w", err)
+	}
+
+	return readFileInfo(dirPath, id, as)
+}
+
+func copyFileInfo(fi *filedata.FileInfo) *filedata.FileInfo {
+	value := *fi
+
+	if fi.Metadata != nil {
+		value.Metadata = maps.Clone(fi.Metadata)
+	}
+	if fi.Original != nil {
+		original := *fi.Original
+		value.Original = &original
+	}
+
+	return &value
+}
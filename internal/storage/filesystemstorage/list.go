@@ -0,0 +1,17 @@
+package filesystemstorage
+
+import (
+	"context"
+	"file-storage/internal/filedata"
+)
+
+// List returns a page of files matching the query in ascending ID order.
+// It is served from the in-memory metadata index without reading the
+// catalog tree.
+func (f *FileSystemStorage) List(ctx context.Context, q *filedata.ListQuery) (*filedata.FileList, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return f.index.list(q), nil
+}
@@ -0,0 +1,69 @@
+package filesystemstorage
+
+import (
+	"context"
+	"errors"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// Original opens the original upload of the current version of the file for
+// reading. Files without a kept original are not found.
+func (f *FileSystemStorage) Original(ctx context.Context, ID string) (*filedata.ContentData, error) {
+	dirPath, err := fileCatalog(f.path, ID)
+	if err != nil {
+		return nil, fmt.Errorf("catalog name error: %w", err)
+	}
+
+	activeState, _, err := slotInfo(dirPath, ID)
+	if err != nil {
+		return nil, fmt.Errorf("read activeState error: %w", err)
+	}
+
+	fi, err := readFileInfo(dirPath, ID, activeState)
+	if err != nil {
+		if errors.Is(err, errs.ErrNotFound) {
+			return nil, f.deletedInfo(ID)
+		}
+		return nil, err
+	}
+	if fi.Original == nil || activeState.Original == "" {
+		return nil, errs.ErrNotFound
+	}
+
+	file, err := os.Open(originalFileFullName(dirPath, ID, activeState))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, errs.ErrNotFound
+		}
+		return nil, fmt.Errorf("open file error: %w", err)
+	}
+
+	return &filedata.ContentData{Data: file, Info: fi}, nil
+}
+
+// OriginalsSupported reports that the file system storage keeps originals.
+func (f *FileSystemStorage) OriginalsSupported() bool {
+	return true
+}
+
+// checkOriginal returns errs.ErrOriginalChanged unless the active version of
+// the file has an original with the hash. Supposed id is locked.
+func checkOriginal(dirPath, id string, as activeState, hash string) error {
+	fi, err := readFileInfo(dirPath, id, as)
+	if errors.Is(err, errs.ErrNotFound) {
+		return errs.ErrOriginalChanged
+	}
+	if err != nil {
+		return err
+	}
+
+	if fi.Original == nil || as.Original == "" || fi.HashSource != hash {
+		return errs.ErrOriginalChanged
+	}
+
+	return nil
+}
@@ -0,0 +1,218 @@
+package filesystemstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"file-storage/internal/config"
+	"file-storage/internal/contextkeys"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"file-storage/internal/logger"
+	"io"
+	"os"
+	"testing"
+)
+
+func checkOriginalContent(t *testing.T, f *FileSystemStorage, id, want string) {
+	t.Helper()
+
+	ctx := context.WithValue(context.Background(), contextkeys.ContextKeyLogger, logger.NewBootstrap())
+	cd, err := f.Original(ctx, id)
+	if want == "" {
+		if !errors.Is(err, errs.ErrNotFound) {
+			t.Errorf("original error got %v want %v", err, errs.ErrNotFound)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("original error: %v", err)
+	}
+	defer cd.Data.Close()
+
+	b, err := io.ReadAll(cd.Data)
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	if string(b) != want {
+		t.Errorf("original got %q want %q", b, want)
+	}
+	if cd.Info.Original == nil {
+		t.Errorf("original info is missing")
+	}
+}
+
+func TestOriginal(t *testing.T) {
+	log := logger.NewBootstrap()
+	ctx := context.WithValue(context.Background(), contextkeys.ContextKeyLogger, log)
+
+	id := "123456789012345678901234567890123456"
+	withOriginal := func(data, original string) *filedata.FileData {
+		return &filedata.FileData{
+			ID:           id,
+			Data:         bytes.NewReader([]byte(data)),
+			HashSource:   original,
+			Original:     bytes.NewReader([]byte(original)),
+			OriginalInfo: &filedata.OriginalInfo{Size: len(original), Settings: "jpeg:2000"},
+		}
+	}
+
+	table := []struct {
+		name         string
+		update       *filedata.FileData
+		wantErr      error
+		wantContent  string
+		wantOriginal string
+	}{
+		{
+			name:         "metadata update keeps original",
+			update:       &filedata.FileData{ID: id, HashSource: "original", Public: true, OriginalInfo: &filedata.OriginalInfo{Settings: "jpeg:2000"}},
+			wantContent:  "stored",
+			wantOriginal: "original",
+		},
+		{
+			name:        "content update drops original",
+			update:      &filedata.FileData{ID: id, Data: bytes.NewReader([]byte("other"))},
+			wantContent: "other",
+		},
+		{
+			name:         "content update replaces original",
+			update:       withOriginal("stored 2", "original 2"),
+			wantContent:  "stored 2",
+			wantOriginal: "original 2",
+		},
+		{
+			name:         "content update keeps original",
+			update:       &filedata.FileData{ID: id, Data: bytes.NewReader([]byte("regenerated")), HashSource: "original", KeepOriginal: true, OriginalInfo: &filedata.OriginalInfo{Settings: "png:1000"}},
+			wantContent:  "regenerated",
+			wantOriginal: "original",
+		},
+		{
+			name:         "original changed",
+			update:       &filedata.FileData{ID: id, Data: bytes.NewReader([]byte("regenerated")), HashSource: "stale", KeepOriginal: true, OriginalInfo: &filedata.OriginalInfo{}},
+			wantErr:      errs.ErrOriginalChanged,
+			wantContent:  "stored",
+			wantOriginal: "original",
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.FileSystem{Path: t.TempDir()}
+			f, err := New(&cfg, log)
+			if err != nil {
+				t.Fatalf("new storage error: %v", err)
+			}
+			defer f.Close()
+
+			_, err = f.Upsert(ctx, withOriginal("stored", "original"))
+			if err != nil {
+				t.Fatalf("upsert error: %v", err)
+			}
+
+			_, err = f.Upsert(ctx, tt.update)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("upsert error got %v want %v", err, tt.wantErr)
+			}
+
+			cd, err := f.Content(ctx, id)
+			if err != nil {
+				t.Fatalf("content error: %v", err)
+			}
+			b, _ := io.ReadAll(cd.Data)
+			cd.Data.Close()
+			if string(b) != tt.wantContent {
+				t.Errorf("content got %q want %q", b, tt.wantContent)
+			}
+			checkOriginalContent(t, f, id, tt.wantOriginal)
+
+			fi, err := f.Info(ctx, id)
+			if err != nil {
+				t.Fatalf("info error: %v", err)
+			}
+			if (fi.Original != nil) != (tt.wantOriginal != "") {
+				t.Errorf("original info got %+v, original kept %v", fi.Original, tt.wantOriginal != "")
+			}
+		})
+	}
+}
+
+func TestOriginalNotRetainedWithVersions(t *testing.T) {
+	log := logger.NewBootstrap()
+	ctx := context.WithValue(context.Background(), contextkeys.ContextKeyLogger, log)
+
+	cfg := config.FileSystem{Path: t.TempDir(), Versions: config.Versions{Keep: 2}}
+	f, err := New(&cfg, log)
+	if err != nil {
+		t.Fatalf("new storage error: %v", err)
+	}
+	defer f.Close()
+
+	id := "123456789012345678901234567890123456"
+	_, err = f.Upsert(ctx, &filedata.FileData{
+		ID:           id,
+		Data:         bytes.NewReader([]byte("stored")),
+		HashSource:   "original",
+		Original:     bytes.NewReader([]byte("original")),
+		OriginalInfo: &filedata.OriginalInfo{Size: 8},
+	})
+	if err != nil {
+		t.Fatalf("upsert error: %v", err)
+	}
+	_, err = f.Upsert(ctx, &filedata.FileData{ID: id, Data: bytes.NewReader([]byte("other"))})
+	if err != nil {
+		t.Fatalf("upsert error: %v", err)
+	}
+
+	versions, err := f.Versions(ctx, id)
+	if err != nil {
+		t.Fatalf("versions error: %v", err)
+	}
+	if len(versions) != 1 || versions[0].Info.Original != nil {
+		t.Fatalf("versions got %+v want one version without original", versions)
+	}
+
+	_, err = f.RestoreVersion(ctx, id, versions[0].Version)
+	if err != nil {
+		t.Fatalf("restore error: %v", err)
+	}
+	checkOriginalContent(t, f, id, "")
+}
+
+func TestGarbageCollectorKeepsOriginal(t *testing.T) {
+	log := logger.NewBootstrap()
+	ctx := context.WithValue(context.Background(), contextkeys.ContextKeyLogger, log)
+
+	cfg := config.FileSystem{Path: t.TempDir()}
+	f, err := New(&cfg, log)
+	if err != nil {
+		t.Fatalf("new storage error: %v", err)
+	}
+	defer f.Close()
+
+	id := "123456789012345678901234567890123456"
+	_, err = f.Upsert(ctx, &filedata.FileData{
+		ID:           id,
+		Data:         bytes.NewReader([]byte("stored")),
+		HashSource:   "original",
+		Original:     bytes.NewReader([]byte("original")),
+		OriginalInfo: &filedata.OriginalInfo{Size: 8},
+	})
+	if err != nil {
+		t.Fatalf("upsert error: %v", err)
+	}
+
+	dirPath, _ := fileCatalog(cfg.Path, id)
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		t.Fatalf("catalog reading error: %v", err)
+	}
+
+	gc := NewGarbageCollector(&cfg, log)
+	err = gc.removeGarbage(&cleanupJob{id: id, dirPath: dirPath, dirEntries: entries}, log)
+	if err != nil {
+		t.Fatalf("remove garbage error: %v", err)
+	}
+
+	checkOriginalContent(t, f, id, "original")
+}
@@ -0,0 +1,208 @@
+package filesystemstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"file-storage/internal/logger"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	renditionExt = "rnd"
+	tmpExt       = "tmp"
+)
+
+// maxFileRenditions bounds the number of cached renditions kept for a file.
+// The least recently used ones are removed when a new rendition is stored.
+const maxFileRenditions = 32
+
+// Rendition opens a cached rendition of the file version identified by the key
+// and marks it as recently used.
+func (f *FileSystemStorage) Rendition(ctx context.Context, key filedata.RenditionKey) (io.ReadSeekCloser, error) {
+	dirPath, err := fileCatalog(f.path, key.ID)
+	if err != nil {
+		return nil, fmt.Errorf("catalog name error: %w", err)
+	}
+
+	file, err := os.Open(renditionFileFullName(dirPath, key))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, errs.ErrNotFound
+		}
+		return nil, fmt.Errorf("open rendition error: %w", err)
+	}
+
+	// the modification time tracks use, because access times are often not updated
+	now := time.Now()
+	err = os.Chtimes(file.Name(), now, now)
+	if err != nil {
+		logger.FromContext(ctx).Warn(
+			"rendition touch failed",
+			"id", key.ID,
+			"error", err,
+		)
+	}
+
+	return file, nil
+}
+
+// PutRendition stores a rendition next to the file slots. The rendition is
+// dropped when the key does not match the current version of the file, and the
+// least recently used renditions of the file are removed above the limit.
+func (f *FileSystemStorage) PutRendition(ctx context.Context, key filedata.RenditionKey, data []byte) error {
+	dirPath, err := fileCatalog(f.path, key.ID)
+	if err != nil {
+		return fmt.Errorf("catalog name error: %w", err)
+	}
+
+	lockFile, err := lockAcquire(key.ID, dirPath)
+	if err != nil {
+		return fmt.Errorf("lock error: %w", err)
+	}
+	defer func() {
+		if err := lockFile.Close(); err != nil {
+			logger.FromContext(ctx).Warn(
+				"unlock failed",
+				"id", key.ID,
+				"error", err,
+			)
+		}
+	}()
+
+	hash, err := activeContentHash(dirPath, key.ID)
+	if err != nil {
+		if errors.Is(err, errs.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	if hash != key.Hash {
+		return nil
+	}
+
+	err = evictRenditions(dirPath, key, maxFileRenditions-1)
+	if err != nil {
+		return err
+	}
+
+	tempName := filepath.Join(dirPath, key.ID+"."+renditionExt+"."+tmpExt)
+	err = writeFile(bytes.NewReader(data), renditionFileFullName(dirPath, key), tempName)
+	if err != nil {
+		return fmt.Errorf("write rendition error: %w", err)
+	}
+
+	return nil
+}
+
+// removeRenditions removes all cached renditions of the file. Supposed id is locked.
+func removeRenditions(dirPath, id string) error {
+	filenames, err := filenamesByID(dirPath, id)
+	if err != nil {
+		return fmt.Errorf("renditions search error: %w", err)
+	}
+
+	prefix := id + "." + renditionExt + "."
+	for _, filename := range filenames {
+		if !strings.HasPrefix(filename, prefix) {
+			continue
+		}
+		err := os.Remove(filepath.Join(dirPath, filename))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("remove rendition error: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// evictRenditions removes the least recently used renditions of the file
+// other than the one of the key until at most limit of them are left.
+// Supposed id is locked.
+func evictRenditions(dirPath string, key filedata.RenditionKey, limit int) error {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return fmt.Errorf("renditions search error: %w", err)
+	}
+
+	type rendition struct {
+		name    string
+		modTime time.Time
+	}
+
+	prefix := key.ID + "." + renditionExt + "."
+	name := renditionFileName(key)
+	renditions := make([]rendition, 0, len(entries))
+	for _, e := range entries {
+		filename := e.Name()
+		if !strings.HasPrefix(filename, prefix) || strings.HasSuffix(filename, "."+tmpExt) || filename == name {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return fmt.Errorf("rendition stat error: %w", err)
+		}
+		renditions = append(renditions, rendition{name: filename, modTime: info.ModTime()})
+	}
+	if len(renditions) <= limit {
+		return nil
+	}
+
+	slices.SortFunc(renditions, func(a, b rendition) int {
+		return a.modTime.Compare(b.modTime)
+	})
+	for _, r := range renditions[:len(renditions)-limit] {
+		err := os.Remove(filepath.Join(dirPath, r.name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("remove rendition error: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// activeContentHash returns the content hash of the active version of the file.
+func activeContentHash(dirPath, id string) (string, error) {
+	as, _, err := slotInfo(dirPath, id)
+	if err != nil {
+		return "", fmt.Errorf("read activeState error: %w", err)
+	}
+
+	fi, err := readFileInfo(dirPath, id, as)
+	if err != nil {
+		return "", err
+	}
+
+	return fi.ContentHash(), nil
+}
+
+// isActiveRendition reports whether the file name belongs to a complete
+// rendition of the file version with the given content hash.
+func isActiveRendition(filename, id, hash string) bool {
+	if hash == "" {
+		return false
+	}
+
+	return strings.HasPrefix(filename, id+"."+renditionExt+"."+hash+".") &&
+		!strings.HasSuffix(filename, "."+tmpExt)
+}
+
+func renditionFileFullName(dirPath string, key filedata.RenditionKey) string {
+	return filepath.Join(dirPath, renditionFileName(key))
+}
+
+func renditionFileName(key filedata.RenditionKey) string {
+	return key.ID + "." + renditionExt + "." + key.Name()
+}
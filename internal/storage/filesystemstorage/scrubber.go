@@ -0,0 +1,396 @@
+package filesystemstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"file-storage/internal/config"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"file-storage/internal/logger"
+	"file-storage/internal/metrics"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// quarantineDirName is the directory in the storage root that keeps
+	// corrupted file versions moved out of their catalogs, for example
+	// .quarantine/[id].[time].bin and .quarantine/[id].[time].meta.json.
+	quarantineDirName = ".quarantine"
+	// scrubStateName is the file in the storage root that keeps the time of
+	// the last completed scrubber pass, so passes keep their schedule across
+	// restarts.
+	scrubStateName = "scrub.json"
+)
+
+type scrubState struct {
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+type scrubJob struct {
+	dirPath string
+	id      string
+}
+
+// Scrubber periodically reads the active version of every file and verifies
+// its content against the content hash recorded at upload.
+type Scrubber struct {
+	storage  *FileSystemStorage
+	interval time.Duration
+	workers  int
+	log      *slog.Logger
+}
+
+func newScrubber(f *FileSystemStorage, cfg *config.Scrubber, log *slog.Logger) *Scrubber {
+	return &Scrubber{
+		storage:  f,
+		interval: cfg.Interval,
+		workers:  cfg.WorkersCount,
+		log:      logger.WithComponent(log, logger.ComponentScrubber),
+	}
+}
+
+// Run starts periodic scrubber passes and stops when the context is canceled.
+// A pass starts the interval after the previous one completed, or right away
+// when no pass has completed yet.
+func (s *Scrubber) Run(ctx context.Context) {
+	completedAt, err := readScrubState(s.storage.path)
+	if err != nil {
+		s.log.Warn("scrubber state read error", slog.Any(logger.LogFieldError, err))
+	}
+	if !completedAt.IsZero() {
+		metrics.ScrubLastCompletedSeconds.Set(float64(completedAt.Unix()))
+	}
+	next := completedAt.Add(s.interval)
+
+	for {
+		select {
+		case <-time.After(time.Until(next)):
+		case <-ctx.Done():
+			return
+		}
+
+		err := s.scrubAll(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		next = time.Now().Add(s.interval)
+		if err != nil {
+			s.log.Error("scrubber error", slog.Any(logger.LogFieldError, err))
+			continue
+		}
+
+		now := time.Now()
+		metrics.ScrubLastCompletedSeconds.Set(float64(now.Unix()))
+		err = writeScrubState(s.storage.path, now)
+		if err != nil {
+			s.log.Warn("scrubber state write error", slog.Any(logger.LogFieldError, err))
+		}
+	}
+}
+
+// scrubAll checks all files of the storage once.
+func (s *Scrubber) scrubAll(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("scrubber pass panic recovered: %v", r)
+		}
+	}()
+
+	metrics.ScrubRunsTotal.Inc()
+	metrics.ScrubInProgress.Set(1)
+	begin := time.Now()
+	defer func() {
+		metrics.ScrubDurationSeconds.Observe(time.Since(begin).Seconds())
+		metrics.ScrubInProgress.Set(0)
+	}()
+
+	var checked, corrupted atomic.Int64
+	jobs := make(chan scrubJob, s.workers*2)
+
+	var wg sync.WaitGroup
+	for range s.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				sr, ok := s.scrubJob(j)
+				if ok {
+					checked.Add(1)
+					if sr.Status == filedata.ScrubStatusCorrupted {
+						corrupted.Add(1)
+					}
+				}
+			}
+		}()
+	}
+
+	err = scanIDs(s.storage.path, func(dirPath, id string) error {
+		select {
+		case jobs <- scrubJob{dirPath: dirPath, id: id}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(jobs)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+
+	s.log.Info("scrubber pass completed",
+		"files", checked.Load(),
+		"corrupted", corrupted.Load(),
+		"duration", time.Since(begin),
+	)
+
+	return nil
+}
+
+func (s *Scrubber) scrubJob(j scrubJob) (sr *filedata.ScrubResult, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.log.Error("scrubber worker panic recovered", "panic", r, "id", j.id)
+			metrics.ScrubFilesTotal.WithLabelValues("error").Inc()
+		}
+	}()
+
+	sr, err := s.storage.scrubFile(j.dirPath, j.id, s.log)
+	if errors.Is(err, errs.ErrNotFound) {
+		return nil, false
+	}
+	if err != nil {
+		s.log.Error("scrub file error", "id", j.id, slog.Any(logger.LogFieldError, err))
+		return nil, false
+	}
+
+	return sr, true
+}
+
+// Scrub verifies content of the active version of the file against its
+// content hash. A corrupted version is moved to quarantine when it is enabled
+// in configuration, and the file is not found afterwards.
+func (f *FileSystemStorage) Scrub(ctx context.Context, ID string) (*filedata.ScrubResult, error) {
+	dirPath, err := fileCatalog(f.path, ID)
+	if err != nil {
+		return nil, fmt.Errorf("catalog name error: %w", err)
+	}
+
+	// checked without the lock, so no lock file is left for unknown IDs
+	_, err = activeFileInfo(dirPath, ID)
+	if errors.Is(err, errs.ErrNotFound) {
+		return nil, f.deletedInfo(ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sr, err := f.scrubFile(dirPath, ID, logger.FromContext(ctx))
+	if errors.Is(err, errs.ErrNotFound) {
+		return nil, f.deletedInfo(ID)
+	}
+
+	return sr, err
+}
+
+// scrubFile hashes content of the active version of the file. The content is
+// opened under the file lock and read after the lock is released, so writes
+// of the file are not blocked by the check.
+func (f *FileSystemStorage) scrubFile(dirPath, id string, log *slog.Logger) (sr *filedata.ScrubResult, err error) {
+	defer func() {
+		if err != nil && !errors.Is(err, errs.ErrNotFound) {
+			metrics.ScrubFilesTotal.WithLabelValues("error").Inc()
+		}
+	}()
+
+	meta, data, err := openActive(dirPath, id)
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+
+	var fi filedata.FileInfo
+	err = json.Unmarshal(meta, &fi)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal info error: %w", err)
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(h, data)
+	metrics.ScrubBytesTotal.Add(float64(n))
+	if err != nil {
+		return nil, fmt.Errorf("read file error: %w", err)
+	}
+
+	sr = &filedata.ScrubResult{
+		ID:           id,
+		ExpectedHash: fi.ContentHash(),
+		ActualHash:   hex.EncodeToString(h.Sum(nil)),
+		FileSize:     n,
+		CheckedAt:    time.Now().UTC(),
+	}
+	switch {
+	case !isContentHash(sr.ExpectedHash):
+		sr.Status = filedata.ScrubStatusUnverified
+	case sr.ActualHash == sr.ExpectedHash:
+		sr.Status = filedata.ScrubStatusOK
+	default:
+		sr.Status = filedata.ScrubStatusCorrupted
+	}
+	metrics.ScrubFilesTotal.WithLabelValues(string(sr.Status)).Inc()
+
+	if sr.Status != filedata.ScrubStatusCorrupted {
+		return sr, nil
+	}
+
+	log.Error("corrupted file detected",
+		"id", id,
+		"expected_hash", sr.ExpectedHash,
+		"actual_hash", sr.ActualHash,
+		"file_size", n,
+	)
+
+	if !f.scrub.Quarantine {
+		return sr, nil
+	}
+
+	sr.Quarantined, err = f.quarantine(dirPath, id, sr.ExpectedHash, data, log)
+	if err != nil {
+		return nil, fmt.Errorf("quarantine error: %w", err)
+	}
+	if sr.Quarantined {
+		metrics.ScrubQuarantinedTotal.Inc()
+		log.Warn("corrupted file moved to quarantine", "id", id)
+	}
+
+	return sr, nil
+}
+
+// quarantine moves the checked version of the file out of its catalog if it
+// is still active. Metadata is moved first, so the file is not found even if
+// the move is interrupted. A content blob of the version is removed as well,
+// so new uploads of the same content are not linked to the corrupted copy.
+func (f *FileSystemStorage) quarantine(dirPath, id, hash string, checked *os.File, log *slog.Logger) (bool, error) {
+	err := f.index.beginWrite()
+	if err != nil {
+		return false, err
+	}
+	defer f.index.endWrite()
+
+	lockFile, err := lockAcquire(id, dirPath)
+	if err != nil {
+		return false, fmt.Errorf("lock error: %w", err)
+	}
+	defer lockFile.Close()
+
+	as, _, err := slotInfo(dirPath, id)
+	if err != nil {
+		return false, fmt.Errorf("read activeState error: %w", err)
+	}
+
+	checkedStat, err := checked.Stat()
+	if err != nil {
+		return false, fmt.Errorf("stat file error: %w", err)
+	}
+	dataName := dataFileFullName(dirPath, id, as)
+	dataStat, err := os.Stat(dataName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("stat file error: %w", err)
+	}
+	// the file was written since it was checked
+	if !os.SameFile(dataStat, checkedStat) {
+		return false, nil
+	}
+
+	quarantinePath := filepath.Join(f.path, quarantineDirName)
+	err = os.MkdirAll(quarantinePath, 0755)
+	if err != nil {
+		return false, fmt.Errorf("quarantine directory creation error: %w", err)
+	}
+	base := filepath.Join(quarantinePath, id+"."+time.Now().UTC().Format("20060102T150405.000000000Z"))
+
+	err = os.Rename(metadataFileFullName(dirPath, id, as), base+"."+metadataExt)
+	if err != nil {
+		return false, fmt.Errorf("move file error: %w", err)
+	}
+	err = os.Rename(dataName, base+"."+binExt)
+	if err != nil {
+		return false, fmt.Errorf("move file error: %w", err)
+	}
+
+	err = syncDir(quarantinePath)
+	if err != nil {
+		return false, fmt.Errorf("sync dir error: %w", err)
+	}
+	err = syncDir(dirPath)
+	if err != nil {
+		return false, fmt.Errorf("sync dir error: %w", err)
+	}
+
+	err = removeRenditions(dirPath, id)
+	if err != nil {
+		log.Warn("remove renditions failed", "id", id, slog.Any(logger.LogFieldError, err))
+	}
+
+	blobPath := blobFileFullName(filepath.Join(f.path, blobsDirName), hash)
+	blobStat, err := os.Stat(blobPath)
+	if err == nil && os.SameFile(blobStat, checkedStat) {
+		err = os.Remove(blobPath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return true, fmt.Errorf("remove blob error: %w", err)
+		}
+	}
+
+	err = f.index.delete(id)
+	if err != nil {
+		log.Warn("index update failed", "id", id, slog.Any(logger.LogFieldError, err))
+	}
+
+	return true, nil
+}
+
+func readScrubState(path string) (time.Time, error) {
+	b, err := os.ReadFile(filepath.Join(path, scrubStateName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("read file error: %w", err)
+	}
+
+	var st scrubState
+	err = json.Unmarshal(b, &st)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unmarshal state error: %w", err)
+	}
+
+	return st.CompletedAt, nil
+}
+
+func writeScrubState(path string, completedAt time.Time) error {
+	b, err := json.Marshal(scrubState{CompletedAt: completedAt.UTC()})
+	if err != nil {
+		return fmt.Errorf("marshal state error: %w", err)
+	}
+
+	name := filepath.Join(path, scrubStateName)
+	return writeFile(bytes.NewReader(b), name, name+"."+tmpExt)
+}
@@ -0,0 +1,239 @@
+package filesystemstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"file-storage/internal/config"
+	"file-storage/internal/contextkeys"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"file-storage/internal/logger"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// corruptFile flips a byte of the active content of the file in place, as bit
+// rot would.
+func corruptFile(t *testing.T, path, id string) {
+	t.Helper()
+
+	dirPath, _ := fileCatalog(path, id)
+	as, _, err := slotInfo(dirPath, id)
+	if err != nil {
+		t.Fatalf("read activeState error: %v", err)
+	}
+
+	f, err := os.OpenFile(dataFileFullName(dirPath, id, as), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer f.Close()
+
+	b := make([]byte, 1)
+	_, err = f.ReadAt(b, 0)
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	_, err = f.WriteAt([]byte{b[0] ^ 0xff}, 0)
+	if err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestScrub(t *testing.T) {
+	log := logger.NewBootstrap()
+	ctx := context.WithValue(context.Background(), contextkeys.ContextKeyLogger, log)
+
+	id := "123456789012345678901234567890123456"
+	data := "content"
+
+	table := []struct {
+		name        string
+		cfg         config.FileSystem
+		hash        string
+		corrupt     bool
+		wantStatus  filedata.ScrubStatus
+		wantRemoved bool
+	}{
+		{
+			name:       "ok",
+			hash:       sha256Hex(data),
+			wantStatus: filedata.ScrubStatusOK,
+		},
+		{
+			name:       "no hash",
+			wantStatus: filedata.ScrubStatusUnverified,
+		},
+		{
+			name:       "corrupted",
+			hash:       sha256Hex(data),
+			corrupt:    true,
+			wantStatus: filedata.ScrubStatusCorrupted,
+		},
+		{
+			name:        "corrupted and quarantined",
+			cfg:         config.FileSystem{Scrubber: config.Scrubber{Quarantine: true}},
+			hash:        sha256Hex(data),
+			corrupt:     true,
+			wantStatus:  filedata.ScrubStatusCorrupted,
+			wantRemoved: true,
+		},
+		{
+			name:        "corrupted blob quarantined",
+			cfg:         config.FileSystem{Scrubber: config.Scrubber{Quarantine: true}, Dedup: true},
+			hash:        sha256Hex(data),
+			corrupt:     true,
+			wantStatus:  filedata.ScrubStatusCorrupted,
+			wantRemoved: true,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Path = t.TempDir()
+			f, err := New(&cfg, log)
+			if err != nil {
+				t.Fatalf("new storage error: %v", err)
+			}
+			defer f.Close()
+
+			_, err = f.Upsert(ctx, &filedata.FileData{ID: id, Data: bytes.NewReader([]byte(data)), HashSource: tt.hash})
+			if err != nil {
+				t.Fatalf("upsert error: %v", err)
+			}
+			if tt.corrupt {
+				corruptFile(t, cfg.Path, id)
+			}
+
+			sr, err := f.Scrub(ctx, id)
+			if err != nil {
+				t.Fatalf("scrub error: %v", err)
+			}
+			if sr.Status != tt.wantStatus {
+				t.Errorf("status got %s want %s", sr.Status, tt.wantStatus)
+			}
+			if sr.Quarantined != tt.wantRemoved {
+				t.Errorf("quarantined got %v want %v", sr.Quarantined, tt.wantRemoved)
+			}
+
+			_, err = f.Info(ctx, id)
+			if tt.wantRemoved != errors.Is(err, errs.ErrNotFound) {
+				t.Errorf("info error got %v, file removed %v", err, tt.wantRemoved)
+			}
+			fl, err := f.List(ctx, &filedata.ListQuery{Limit: 10})
+			if err != nil {
+				t.Fatalf("list error: %v", err)
+			}
+			if tt.wantRemoved != (len(fl.Files) == 0) {
+				t.Errorf("listed files got %d, file removed %v", len(fl.Files), tt.wantRemoved)
+			}
+
+			if !tt.wantRemoved {
+				return
+			}
+			entries, err := os.ReadDir(filepath.Join(cfg.Path, quarantineDirName))
+			if err != nil {
+				t.Fatalf("quarantine reading error: %v", err)
+			}
+			if len(entries) != 2 {
+				t.Errorf("quarantined files got %d want 2", len(entries))
+			}
+
+			// the same content uploaded again is not linked to the corrupted copy
+			_, err = f.Upsert(ctx, &filedata.FileData{ID: id, Data: bytes.NewReader([]byte(data)), HashSource: tt.hash})
+			if err != nil {
+				t.Fatalf("upsert error: %v", err)
+			}
+			sr, err = f.Scrub(ctx, id)
+			if err != nil {
+				t.Fatalf("scrub error: %v", err)
+			}
+			if sr.Status != filedata.ScrubStatusOK {
+				t.Errorf("status after upload got %s want %s", sr.Status, filedata.ScrubStatusOK)
+			}
+		})
+	}
+}
+
+func TestScrubNotFound(t *testing.T) {
+	log := logger.NewBootstrap()
+	ctx := context.WithValue(context.Background(), contextkeys.ContextKeyLogger, log)
+
+	cfg := config.FileSystem{Path: t.TempDir()}
+	f, err := New(&cfg, log)
+	if err != nil {
+		t.Fatalf("new storage error: %v", err)
+	}
+	defer f.Close()
+
+	_, err = f.Scrub(ctx, "123456789012345678901234567890123456")
+	if !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("scrub error got %v want %v", err, errs.ErrNotFound)
+	}
+}
+
+func TestScrubberRun(t *testing.T) {
+	log := logger.NewBootstrap()
+	ctx := context.WithValue(context.Background(), contextkeys.ContextKeyLogger, log)
+
+	cfg := config.FileSystem{
+		Path:     t.TempDir(),
+		Scrubber: config.Scrubber{Enabled: true, Interval: time.Hour, WorkersCount: 2, Quarantine: true},
+	}
+	f, err := New(&cfg, log)
+	if err != nil {
+		t.Fatalf("new storage error: %v", err)
+	}
+	defer f.Close()
+
+	ids := []string{
+		"123456789012345678901234567890123451",
+		"123456789012345678901234567890123452",
+		"223456789012345678901234567890123453",
+	}
+	for _, id := range ids {
+		_, err = f.Upsert(ctx, &filedata.FileData{ID: id, Data: bytes.NewReader([]byte(id)), HashSource: sha256Hex(id)})
+		if err != nil {
+			t.Fatalf("upsert error: %v", err)
+		}
+	}
+	corruptFile(t, cfg.Path, ids[1])
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	f.StartScrubber(runCtx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		completedAt, err := readScrubState(cfg.Path)
+		if err != nil {
+			t.Fatalf("read state error: %v", err)
+		}
+		if !completedAt.IsZero() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("scrubber pass is not completed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i, id := range ids {
+		_, err := f.Info(ctx, id)
+		wantRemoved := i == 1
+		if wantRemoved != errors.Is(err, errs.ErrNotFound) {
+			t.Errorf("file %s info error got %v, file removed %v", id, err, wantRemoved)
+		}
+	}
+}
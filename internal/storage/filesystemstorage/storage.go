@@ -10,7 +10,6 @@ import (
 	"file-storage/internal/filedata"
 	"file-storage/internal/logger"
 	"fmt"
-	"io"
 	"io/fs"
 	"log/slog"
 	"os"
@@ -24,9 +23,17 @@ import (
 // FileSystemStorage stores file content and metadata on a local filesystem
 // using versioned slots and an atomic active-version switch.
 type FileSystemStorage struct {
-	path   string
-	gc     *GarbageCollector
-	gcOnce sync.Once
+	path       string
+	index      *index
+	versions   config.Versions
+	trash      config.Trash
+	trashIndex *trashIndex
+	dedup      bool
+	scrub      config.Scrubber
+	gc         *GarbageCollector
+	gcOnce     sync.Once
+	scrubber   *Scrubber
+	scrubOnce  sync.Once
 }
 
 // New creates a filesystem storage and validates that the target directory is usable.
@@ -42,14 +49,40 @@ func New(cfg *config.FileSystem, log *slog.Logger) (*FileSystemStorage, error) {
 		return nil, err
 	}
 
+	var tix *trashIndex
+	if cfg.Trash.Enabled {
+		tix, err = loadTrashIndex(cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ix, rebuilt, err := openIndex(cfg.Path, false)
+	if err != nil {
+		return nil, fmt.Errorf("open index error: %w", err)
+	}
+	if rebuilt {
+		log.Info("metadata index rebuilt", "files", len(ix.ids))
+	}
+
 	var gc *GarbageCollector
 	if cfg.GarbageCollector.Enabled {
-		gc = NewGarbageCollector(cfg.Path, cfg.GarbageCollector.Interval, cfg.GarbageCollector.WorkersCount, log)
+		gc = NewGarbageCollector(cfg, log)
+		gc.trashIndex = tix
 	}
 
 	fss := &FileSystemStorage{
-		path: cfg.Path,
-		gc:   gc,
+		path:       cfg.Path,
+		index:      ix,
+		versions:   cfg.Versions,
+		trash:      cfg.Trash,
+		trashIndex: tix,
+		dedup:      cfg.Dedup,
+		scrub:      cfg.Scrubber,
+		gc:         gc,
+	}
+	if cfg.Scrubber.Enabled {
+		fss.scrubber = newScrubber(fss, &cfg.Scrubber, log)
 	}
 
 	return fss, nil
@@ -113,6 +146,12 @@ func (f *FileSystemStorage) Upsert(ctx context.Context, fd *filedata.FileData) (
 		return "", fmt.Errorf("directory path creation error: %w", err)
 	}
 
+	err = f.index.beginWrite()
+	if err != nil {
+		return "", err
+	}
+	defer f.index.endWrite()
+
 	lockFile, err := lockAcquire(fd.ID, dirPath)
 	if err != nil {
 		return "", fmt.Errorf("lock error: %w", err)
@@ -134,12 +173,6 @@ func (f *FileSystemStorage) Upsert(ctx context.Context, fd *filedata.FileData) (
 	default:
 	}
 
-	fi := filedata.FileInfoFromFileData(fd)
-	fiBytes, err := json.Marshal(fi)
-	if err != nil {
-		return "", fmt.Errorf("file info marshall error: %w", err)
-	}
-
 	currentAtiveState, newAtiveState, err := slotInfo(dirPath, fd.ID)
 	if err != nil {
 		currentAtiveState, newAtiveState, err = slotInfoWithRecovery(dirPath, fd.ID, lockFile)
@@ -148,10 +181,17 @@ func (f *FileSystemStorage) Upsert(ctx context.Context, fd *filedata.FileData) (
 		}
 	}
 
+	if fd.KeepOriginal {
+		err = checkOriginal(dirPath, fd.ID, currentAtiveState, fd.HashSource)
+		if err != nil {
+			return "", err
+		}
+	}
+
 	basePath := filepath.Join(dirPath, fd.ID)
+	dataTempName := basePath + ".bin.tmp"
+	dataName := dataFileFullName(dirPath, fd.ID, newAtiveState)
 	if fd.Data != nil {
-		dataTempName := basePath + ".bin.tmp"
-		dataName := dataFileFullName(dirPath, fd.ID, newAtiveState)
 		err = writeFile(fd.Data, dataName, dataTempName)
 		if err != nil {
 			return "", fmt.Errorf("write file data error: %w", err)
@@ -160,13 +200,55 @@ func (f *FileSystemStorage) Upsert(ctx context.Context, fd *filedata.FileData) (
 		newAtiveState.Data = currentAtiveState.Data
 	}
 
+	switch {
+	case fd.Data == nil || fd.KeepOriginal:
+		newAtiveState.Original = currentAtiveState.Original
+	case fd.Original != nil:
+		err = writeFile(fd.Original, originalFileFullName(dirPath, fd.ID, newAtiveState), basePath+"."+originalExt+"."+tmpExt)
+		if err != nil {
+			return "", fmt.Errorf("write original error: %w", err)
+		}
+	default:
+		newAtiveState.Original = ""
+	}
+
+	// file info is built after the data stream is consumed
+	fi := filedata.FileInfoFromFileData(fd)
+	if fi.Original == nil || newAtiveState.Original == "" {
+		fi.Original = nil
+		newAtiveState.Original = ""
+	}
+
+	// the written copy stays valid if it cannot be shared
+	if f.dedup && fd.Data != nil {
+		err = shareBlob(filepath.Join(f.path, blobsDirName), dataName, dataTempName, fi.ContentHash())
+		if err != nil {
+			logger.FromContext(ctx).Warn(
+				"blob sharing failed",
+				"id", fd.ID,
+				"error", err,
+			)
+		}
+	}
+	fiBytes, err := json.Marshal(fi)
+	if err != nil {
+		return "", fmt.Errorf("file info marshall error: %w", err)
+	}
+
 	fiTempName := basePath + ".meta.json.tmp"
 	fiName := metadataFileFullName(dirPath, fd.ID, newAtiveState)
-	err = writeFile(fiBytes, fiName, fiTempName)
+	err = writeFile(bytes.NewReader(fiBytes), fiName, fiTempName)
 	if err != nil {
 		return "", fmt.Errorf("write file info error: %w", err)
 	}
 
+	if f.versions.Enabled() {
+		newAtiveState.LastVersion, err = archiveVersion(dirPath, fd.ID, currentAtiveState, time.Now())
+		if err != nil {
+			return "", fmt.Errorf("archive version error: %w", err)
+		}
+	}
+
 	err = syncDir(dirPath)
 	if err != nil {
 		return "", fmt.Errorf("sync dir error: %w", err)
@@ -177,6 +259,8 @@ func (f *FileSystemStorage) Upsert(ctx context.Context, fd *filedata.FileData) (
 		return "", fmt.Errorf("commit new activeState error: %w", err)
 	}
 
+	f.afterCommit(ctx, dirPath, fi, fd.Data != nil)
+
 	err = syncDir(dirPath)
 	if err != nil {
 		return "", fmt.Errorf("sync dir error: %w", err)
@@ -187,7 +271,8 @@ func (f *FileSystemStorage) Upsert(ctx context.Context, fd *filedata.FileData) (
 	return fd.ID, nil
 }
 
-// Delete removes file content and metadata from filesystem storage.
+// Delete removes file content and metadata from filesystem storage. When the
+// trash is enabled, the file is moved to the trash instead.
 func (f *FileSystemStorage) Delete(ctx context.Context, ID string) error {
 
 	if len(ID) == 0 {
@@ -206,6 +291,12 @@ func (f *FileSystemStorage) Delete(ctx context.Context, ID string) error {
 		return err
 	}
 
+	err = f.index.beginWrite()
+	if err != nil {
+		return err
+	}
+	defer f.index.endWrite()
+
 	lockFile, err := lockAcquire(ID, dirPath)
 	if err != nil {
 		return fmt.Errorf("lock error: %w", err)
@@ -231,16 +322,40 @@ func (f *FileSystemStorage) Delete(ctx context.Context, ID string) error {
 		return fmt.Errorf("files to remove search error: %w", err)
 	}
 
-	for _, fileName := range filesToRemove {
-		err := os.Remove(filepath.Join(dirPath, fileName))
-		if err != nil && !errors.Is(err, fs.ErrNotExist) {
-			return fmt.Errorf("remove file error: %w", err)
+	var fi *filedata.FileInfo
+	if f.trash.Enabled {
+		fi, err = activeFileInfo(dirPath, ID)
+		if err != nil && !errors.Is(err, errs.ErrNotFound) {
+			return err
 		}
 	}
 
-	err = syncDir(dirPath)
+	if fi != nil {
+		err = f.moveToTrash(dirPath, fi, filesToRemove)
+		if err != nil {
+			return fmt.Errorf("move to trash error: %w", err)
+		}
+	} else {
+		for _, fileName := range filesToRemove {
+			err := os.Remove(filepath.Join(dirPath, fileName))
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("remove file error: %w", err)
+			}
+		}
+
+		err = syncDir(dirPath)
+		if err != nil {
+			return fmt.Errorf("sync dir error: %w", err)
+		}
+	}
+
+	err = f.index.delete(ID)
 	if err != nil {
-		return fmt.Errorf("sync dir error: %w", err)
+		logger.FromContext(ctx).Warn(
+			"index update failed",
+			"id", ID,
+			"error", err,
+		)
 	}
 
 	return nil
@@ -262,7 +377,12 @@ func (f *FileSystemStorage) Info(ctx context.Context, ID string) (*filedata.File
 		return nil, fmt.Errorf("read activeState error: %w", err)
 	}
 
-	return readFileInfo(dirPath, ID, v)
+	fi, err := readFileInfo(dirPath, ID, v)
+	if errors.Is(err, errs.ErrNotFound) {
+		return nil, f.deletedInfo(ID)
+	}
+
+	return fi, err
 }
 
 func readFileInfo(dirPath, ID string, activeState activeState) (*filedata.FileInfo, error) {
@@ -299,20 +419,28 @@ func (f *FileSystemStorage) Content(ctx context.Context, ID string) (*filedata.C
 
 	fi, err := readFileInfo(dirPath, ID, activeState)
 	if err != nil {
+		if errors.Is(err, errs.ErrNotFound) {
+			return nil, f.deletedInfo(ID)
+		}
 		return nil, err
 	}
 
 	fileName := dataFileFullName(dirPath, ID, activeState)
-	b, err := os.ReadFile(fileName)
+	file, err := os.Open(fileName)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
 			return nil, errs.ErrNotFound
 		}
-		return nil, fmt.Errorf("read file error: %w", err)
+		return nil, fmt.Errorf("open file error: %w", err)
 	}
 
-	data := io.NopCloser(bytes.NewReader(b))
-	return &filedata.ContentData{Data: data, IsImage: fi.IsImage}, nil
+	return &filedata.ContentData{Data: file, Info: fi}, nil
+}
+
+// Close flushes the metadata index journal and releases the storage for
+// other processes. Writes started after Close fail with errs.ErrStorageClosed.
+func (f *FileSystemStorage) Close() error {
+	return f.index.close()
 }
 
 // StartGC starts the background garbage collector that removes obsolete and
@@ -328,3 +456,15 @@ func (f *FileSystemStorage) StartGC(ctx context.Context) {
 	)
 
 }
+
+// StartScrubber starts the background integrity scrubber when enabled in
+// configuration.
+func (f *FileSystemStorage) StartScrubber(ctx context.Context) {
+	f.scrubOnce.Do(
+		func() {
+			if f.scrubber != nil {
+				go f.scrubber.Run(ctx)
+			}
+		},
+	)
+}
@@ -10,10 +10,12 @@ import (
 	"file-storage/internal/filedata"
 	"file-storage/internal/logger"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestUpsert(t *testing.T) {
@@ -48,7 +50,7 @@ func TestUpsert(t *testing.T) {
 		{
 			name:    "ok",
 			path:    t.TempDir(),
-			fd:      &filedata.FileData{ID: id, Data: data, HashSource: "123", IsImage: false},
+			fd:      &filedata.FileData{ID: id, Data: bytes.NewReader(data), HashSource: "123", IsImage: false},
 			wantErr: false,
 			wantID:  id,
 		},
@@ -116,11 +118,15 @@ func TestDelete(t *testing.T) {
 
 	id := "123456789012345678901234567890123456"
 	data := []byte("some data")
-	fd := &filedata.FileData{ID: id, Data: data, HashSource: "123", IsImage: false}
+	fd := &filedata.FileData{ID: id, Data: bytes.NewReader(data), HashSource: "123", IsImage: false}
 	id, err = fUpsert.Upsert(ctx, fd)
 	if err != nil {
 		t.Fatalf("upsert error %v", err)
 	}
+	err = fUpsert.Close()
+	if err != nil {
+		t.Fatalf("close error %v", err)
+	}
 
 	table := []struct {
 		name      string
@@ -161,6 +167,7 @@ func TestDelete(t *testing.T) {
 			if err != nil {
 				t.Fatalf("got error %v want nil", err)
 			}
+			defer f.Close()
 
 			err = f.Delete(ctx, tt.id)
 			if !errors.Is(err, tt.wantError) {
@@ -200,7 +207,7 @@ func TestInfo(t *testing.T) {
 
 	id := "123456789012345678901234567890123456"
 	data := []byte("some data")
-	fd := &filedata.FileData{ID: id, Data: data, HashSource: "123", IsImage: false}
+	fd := &filedata.FileData{ID: id, Data: bytes.NewReader(data), HashSource: "123", IsImage: false}
 	wantFi := &filedata.FileInfo{ID: id, HashSource: "123", IsImage: false}
 	id, err = f.Upsert(ctx, fd)
 	if err != nil {
@@ -259,7 +266,7 @@ func TestContent(t *testing.T) {
 
 	id := "123456789012345678901234567890123456"
 	data := []byte("some data")
-	fd := &filedata.FileData{ID: id, Data: data, HashSource: "123", IsImage: false}
+	fd := &filedata.FileData{ID: id, Data: bytes.NewReader(data), HashSource: "123", IsImage: false}
 
 	id, err = f.Upsert(ctx, fd)
 	if err != nil {
@@ -297,8 +304,8 @@ func TestContent(t *testing.T) {
 
 			if tt.wantErr == nil {
 
-				if cd.IsImage != false {
-					t.Errorf("content data IsImage mismatch got %v want %v", cd.IsImage, false)
+				if cd.Info.IsImage != false {
+					t.Errorf("content data IsImage mismatch got %v want %v", cd.Info.IsImage, false)
 				}
 
 				defer cd.Data.Close()
@@ -315,3 +322,443 @@ func TestContent(t *testing.T) {
 		})
 	}
 }
+
+func TestUpsertStreamError(t *testing.T) {
+	log := logger.NewBootstrap()
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, contextkeys.ContextKeyLogger, log)
+
+	path := t.TempDir()
+	cfg := config.FileSystem{Path: path}
+	f, err := New(&cfg, log)
+	if err != nil {
+		t.Fatalf("got error %v want nil", err)
+	}
+
+	id := "123456789012345678901234567890123456"
+	data := []byte("some data")
+	_, err = f.Upsert(ctx, &filedata.FileData{ID: id, Data: bytes.NewReader(data)})
+	if err != nil {
+		t.Fatalf("upsert error %v", err)
+	}
+
+	streamErr := errors.New("stream error")
+	broken := io.MultiReader(bytes.NewReader([]byte("partial")), &errReader{err: streamErr})
+	_, err = f.Upsert(ctx, &filedata.FileData{ID: id, Data: broken})
+	if !errors.Is(err, streamErr) {
+		t.Fatalf("got error %v want %v", err, streamErr)
+	}
+
+	cd, err := f.Content(ctx, id)
+	if err != nil {
+		t.Fatalf("content error %v", err)
+	}
+	defer cd.Data.Close()
+
+	b, err := io.ReadAll(cd.Data)
+	if err != nil {
+		t.Fatalf("content read error %v", err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Errorf("content changed after failed upsert got %q want %q", b, data)
+	}
+
+	dirPath, err := fileCatalog(path, id)
+	if err != nil {
+		t.Fatalf("catalog name error: %v", err)
+	}
+	_, err = os.Stat(filepath.Join(dirPath, id+".bin.tmp"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temporary file left after failed upsert: %v", err)
+	}
+}
+
+func TestRendition(t *testing.T) {
+	log := logger.NewBootstrap()
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, contextkeys.ContextKeyLogger, log)
+
+	path := t.TempDir()
+	cfg := config.FileSystem{Path: path}
+	f, err := New(&cfg, log)
+	if err != nil {
+		t.Fatalf("got error %v want nil", err)
+	}
+
+	id := "123456789012345678901234567890123456"
+	_, err = f.Upsert(ctx, &filedata.FileData{ID: id, Data: bytes.NewReader([]byte("some data")), HashStored: "hash"})
+	if err != nil {
+		t.Fatalf("upsert error %v", err)
+	}
+
+	key := filedata.RenditionKey{ID: id, Hash: "hash", Width: 100, Height: 100, Format: "jpeg"}
+	staleKey := key
+	staleKey.Hash = "stale"
+
+	_, err = f.Rendition(ctx, key)
+	if !errors.Is(err, errs.ErrNotFound) {
+		t.Fatalf("got %v want %v", err, errs.ErrNotFound)
+	}
+
+	err = f.PutRendition(ctx, key, []byte("rendition"))
+	if err != nil {
+		t.Fatalf("put rendition error %v", err)
+	}
+	err = f.PutRendition(ctx, staleKey, []byte("stale rendition"))
+	if err != nil {
+		t.Fatalf("put stale rendition error %v", err)
+	}
+
+	r, err := f.Rendition(ctx, key)
+	if err != nil {
+		t.Fatalf("rendition error %v", err)
+	}
+	b, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatalf("rendition read error %v", err)
+	}
+	if string(b) != "rendition" {
+		t.Errorf("rendition mismatch got %q want %q", b, "rendition")
+	}
+
+	_, err = f.Rendition(ctx, staleKey)
+	if !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("stale rendition got %v want %v", err, errs.ErrNotFound)
+	}
+
+	dirPath, err := fileCatalog(path, id)
+	if err != nil {
+		t.Fatalf("catalog name error: %v", err)
+	}
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		t.Fatalf("read dir error: %v", err)
+	}
+	gc := NewGarbageCollector(&cfg, log)
+	err = gc.removeGarbage(&cleanupJob{id: id, dirPath: dirPath, dirEntries: entries}, log)
+	if err != nil {
+		t.Fatalf("remove garbage error: %v", err)
+	}
+	_, err = f.Rendition(ctx, key)
+	if err != nil {
+		t.Errorf("rendition of active version removed by gc: %v", err)
+	}
+
+	_, err = f.Upsert(ctx, &filedata.FileData{ID: id, Data: bytes.NewReader([]byte("new data")), HashStored: "new hash"})
+	if err != nil {
+		t.Fatalf("upsert error %v", err)
+	}
+	_, err = os.Stat(renditionFileFullName(dirPath, key))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("rendition left after upsert: %v", err)
+	}
+}
+
+func TestRenditionLimit(t *testing.T) {
+	log := logger.NewBootstrap()
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, contextkeys.ContextKeyLogger, log)
+
+	path := t.TempDir()
+	f, err := New(&config.FileSystem{Path: path}, log)
+	if err != nil {
+		t.Fatalf("got error %v want nil", err)
+	}
+
+	id := "123456789012345678901234567890123456"
+	_, err = f.Upsert(ctx, &filedata.FileData{ID: id, Data: bytes.NewReader([]byte("some data")), HashStored: "hash"})
+	if err != nil {
+		t.Fatalf("upsert error %v", err)
+	}
+	dirPath, err := fileCatalog(path, id)
+	if err != nil {
+		t.Fatalf("catalog name error: %v", err)
+	}
+
+	keys := make([]filedata.RenditionKey, maxFileRenditions+1)
+	used := time.Now().Add(-time.Hour)
+	for i := range keys {
+		keys[i] = filedata.RenditionKey{ID: id, Hash: "hash", Width: i + 1, Format: "jpeg"}
+		if i == maxFileRenditions {
+			break
+		}
+		err = f.PutRendition(ctx, keys[i], []byte("rendition"))
+		if err != nil {
+			t.Fatalf("put rendition error %v", err)
+		}
+		// renditions are used in the order they were stored
+		err = os.Chtimes(renditionFileFullName(dirPath, keys[i]), used, used.Add(time.Duration(i)*time.Second))
+		if err != nil {
+			t.Fatalf("chtimes error %v", err)
+		}
+	}
+
+	// reading the oldest rendition makes it the most recently used one
+	r, err := f.Rendition(ctx, keys[0])
+	if err != nil {
+		t.Fatalf("rendition error %v", err)
+	}
+	r.Close()
+
+	err = f.PutRendition(ctx, keys[maxFileRenditions], []byte("rendition"))
+	if err != nil {
+		t.Fatalf("put rendition error %v", err)
+	}
+
+	for i, key := range keys {
+		_, err := os.Stat(renditionFileFullName(dirPath, key))
+		if i == 1 {
+			if !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("least recently used rendition is kept: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("rendition %d is removed: %v", i, err)
+		}
+	}
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+func TestList(t *testing.T) {
+	log := logger.NewBootstrap()
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, contextkeys.ContextKeyLogger, log)
+
+	cfg := config.FileSystem{Path: t.TempDir()}
+	f, err := New(&cfg, log)
+	if err != nil {
+		t.Fatalf("got error %v want nil", err)
+	}
+
+	ids := []string{
+		"aa0000000000000000000000000000000001",
+		"aa0000000000000000000000000000000002",
+		"ab0000000000000000000000000000000001",
+		"ba0000000000000000000000000000000001",
+	}
+	for i, id := range ids {
+		fd := &filedata.FileData{ID: id, Data: bytes.NewReader([]byte("data")), Public: i%2 == 0, Metadata: map[string]any{"n": i, "size": float64(1000000 * (i + 1))}}
+		_, err := f.Upsert(ctx, fd)
+		if err != nil {
+			t.Fatalf("upsert error %v", err)
+		}
+	}
+
+	public := true
+
+	table := []struct {
+		name           string
+		query          filedata.ListQuery
+		wantIDs        []string
+		wantNextCursor string
+	}{
+		{
+			name:           "first page",
+			query:          filedata.ListQuery{Limit: 2},
+			wantIDs:        ids[:2],
+			wantNextCursor: ids[1],
+		},
+		{
+			name:    "last page",
+			query:   filedata.ListQuery{Limit: 2, Cursor: ids[1]},
+			wantIDs: ids[2:],
+		},
+		{
+			name:    "cursor skips catalogs",
+			query:   filedata.ListQuery{Limit: 10, Cursor: ids[2]},
+			wantIDs: ids[3:],
+		},
+		{
+			name:    "public filter",
+			query:   filedata.ListQuery{Limit: 10, Public: &public},
+			wantIDs: []string{ids[0], ids[2]},
+		},
+		{
+			name:    "metadata filter",
+			query:   filedata.ListQuery{Limit: 10, Metadata: map[string]string{"n": "1"}},
+			wantIDs: []string{ids[1]},
+		},
+		{
+			name:    "large number metadata filter",
+			query:   filedata.ListQuery{Limit: 10, Metadata: map[string]string{"size": "2000000"}},
+			wantIDs: []string{ids[1]},
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			fl, err := f.List(ctx, &tt.query)
+			if err != nil {
+				t.Fatalf("list error %v", err)
+			}
+
+			gotIDs := make([]string, 0, len(fl.Files))
+			for _, fi := range fl.Files {
+				gotIDs = append(gotIDs, fi.ID)
+			}
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("ids mismatch got %v want %v", gotIDs, tt.wantIDs)
+			}
+			if fl.NextCursor != tt.wantNextCursor {
+				t.Errorf("next cursor mismatch got %q want %q", fl.NextCursor, tt.wantNextCursor)
+			}
+		})
+	}
+}
+
+func TestIndexOwner(t *testing.T) {
+	log := logger.NewBootstrap()
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, contextkeys.ContextKeyLogger, log)
+
+	cfg := config.FileSystem{Path: t.TempDir()}
+	f, err := New(&cfg, log)
+	if err != nil {
+		t.Fatalf("got error %v want nil", err)
+	}
+
+	_, err = New(&cfg, log)
+	if !errors.Is(err, errs.ErrStorageInUse) {
+		t.Errorf("second open got %v want %v", err, errs.ErrStorageInUse)
+	}
+	_, err = RebuildIndex(cfg.Path)
+	if !errors.Is(err, errs.ErrStorageInUse) {
+		t.Errorf("rebuild got %v want %v", err, errs.ErrStorageInUse)
+	}
+
+	// a write still running at close keeps the shutdown unclean
+	err = f.index.beginWrite()
+	if err != nil {
+		t.Fatalf("begin write error %v", err)
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatalf("close error %v", err)
+	}
+	_, err = os.Stat(filepath.Join(cfg.Path, indexCleanName))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("clean marker written with a write in progress: %v", err)
+	}
+	f.index.endWrite()
+
+	fd := &filedata.FileData{ID: "123456789012345678901234567890123456", Data: bytes.NewReader([]byte("data"))}
+	_, err = f.Upsert(ctx, fd)
+	if !errors.Is(err, errs.ErrStorageClosed) {
+		t.Errorf("upsert after close got %v want %v", err, errs.ErrStorageClosed)
+	}
+
+	f, err = New(&cfg, log)
+	if err != nil {
+		t.Fatalf("reopen error %v", err)
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatalf("close error %v", err)
+	}
+	_, err = os.Stat(filepath.Join(cfg.Path, indexCleanName))
+	if err != nil {
+		t.Errorf("clean marker not written: %v", err)
+	}
+}
+
+func TestIndexReopen(t *testing.T) {
+	log := logger.NewBootstrap()
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, contextkeys.ContextKeyLogger, log)
+
+	ids := []string{
+		"aa0000000000000000000000000000000001",
+		"aa0000000000000000000000000000000002",
+		"ba0000000000000000000000000000000001",
+	}
+
+	table := []struct {
+		name    string
+		close   bool
+		rebuild bool
+	}{
+		{
+			name:  "clean shutdown",
+			close: true,
+		},
+		{
+			name: "crash",
+		},
+		{
+			name:    "rebuild",
+			close:   true,
+			rebuild: true,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.FileSystem{Path: t.TempDir()}
+			f, err := New(&cfg, log)
+			if err != nil {
+				t.Fatalf("got error %v want nil", err)
+			}
+
+			for _, id := range ids {
+				fd := &filedata.FileData{ID: id, Data: bytes.NewReader([]byte("data")), Metadata: map[string]any{"tag": id[:2]}}
+				_, err := f.Upsert(ctx, fd)
+				if err != nil {
+					t.Fatalf("upsert error %v", err)
+				}
+			}
+			err = f.Delete(ctx, ids[1])
+			if err != nil {
+				t.Fatalf("delete error %v", err)
+			}
+
+			if tt.close {
+				err = f.Close()
+				if err != nil {
+					t.Fatalf("close error %v", err)
+				}
+			} else {
+				// a crashed process releases the storage without closing the index
+				f.index.owner.Close()
+			}
+			if tt.rebuild {
+				count, err := RebuildIndex(cfg.Path)
+				if err != nil {
+					t.Fatalf("rebuild error %v", err)
+				}
+				if count != 2 {
+					t.Errorf("indexed files mismatch got %d want 2", count)
+				}
+			}
+
+			f, err = New(&cfg, log)
+			if err != nil {
+				t.Fatalf("reopen error %v", err)
+			}
+
+			fl, err := f.List(ctx, &filedata.ListQuery{Limit: 10, Metadata: map[string]string{"tag": "ba"}})
+			if err != nil {
+				t.Fatalf("list error %v", err)
+			}
+			if len(fl.Files) != 1 || fl.Files[0].ID != ids[2] {
+				t.Errorf("list mismatch got %v want %s", fl.Files, ids[2])
+			}
+
+			fl, err = f.List(ctx, &filedata.ListQuery{Limit: 10})
+			if err != nil {
+				t.Fatalf("list error %v", err)
+			}
+			if len(fl.Files) != 2 {
+				t.Errorf("listed files mismatch got %d want 2", len(fl.Files))
+			}
+		})
+	}
+}
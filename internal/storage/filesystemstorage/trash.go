@@ -0,0 +1,455 @@
+package filesystemstorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"file-storage/internal/logger"
+	"file-storage/internal/metrics"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// trashDirName is the directory in the storage root that keeps a
+	// subdirectory with the files of every deleted ID.
+	trashDirName = ".trash"
+	// deletedRecordName is written last when a file is moved to the trash,
+	// so trash entries without it are incomplete.
+	deletedRecordName = "deleted.json"
+)
+
+// trashIndex keeps the IDs of trash entries in ascending order, so a trash
+// page seeks to its cursor instead of reading the whole trash directory. It
+// is loaded from the trash directory when the storage is opened. Entries
+// that disappear without the index being updated are skipped and forgotten by
+// readers.
+type trashIndex struct {
+	mu  sync.RWMutex
+	ids []string
+}
+
+func loadTrashIndex(path string) (*trashIndex, error) {
+	ti := &trashIndex{}
+
+	entries, err := os.ReadDir(filepath.Join(path, trashDirName))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ti, nil
+		}
+		return nil, fmt.Errorf("trash reading error: %w", err)
+	}
+
+	// entries are sorted by name
+	for _, entry := range entries {
+		if entry.IsDir() {
+			ti.ids = append(ti.ids, entry.Name())
+		}
+	}
+
+	return ti, nil
+}
+
+// add records the trash entry of the ID.
+func (ti *trashIndex) add(id string) {
+	if ti == nil {
+		return
+	}
+
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	i, found := slices.BinarySearch(ti.ids, id)
+	if !found {
+		ti.ids = slices.Insert(ti.ids, i, id)
+	}
+}
+
+// remove forgets the trash entry of the ID.
+func (ti *trashIndex) remove(id string) {
+	if ti == nil {
+		return
+	}
+
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	i, found := slices.BinarySearch(ti.ids, id)
+	if found {
+		ti.ids = slices.Delete(ti.ids, i, i+1)
+	}
+}
+
+// after returns up to n IDs following the cursor.
+func (ti *trashIndex) after(cursor string, n int) []string {
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+
+	i, found := slices.BinarySearch(ti.ids, cursor)
+	if found {
+		i++
+	}
+
+	return slices.Clone(ti.ids[i:min(i+n, len(ti.ids))])
+}
+
+// Trash returns a page of deleted files in ascending ID order. Only the
+// entries of the page are read, starting at the cursor.
+func (f *FileSystemStorage) Trash(ctx context.Context, cursor string, limit int) (*filedata.TrashList, error) {
+	if !f.trash.Enabled {
+		return nil, errs.ErrTrashNotSupported
+	}
+
+	tl := filedata.TrashList{Files: make([]*filedata.DeletedFile, 0, limit)}
+
+	// one more entry than the page is needed to know whether the page is the last
+	batch := limit + 1
+	for {
+		ids := f.trashIndex.after(cursor, batch)
+		for _, id := range ids {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			df, err := readDeletedRecord(f.trashDir(id))
+			if err != nil {
+				if !errors.Is(err, errs.ErrNotFound) {
+					logger.FromContext(ctx).Warn("trash skipped file", "id", id, slog.Any(logger.LogFieldError, err))
+				} else if _, err := os.Stat(f.trashDir(id)); errors.Is(err, fs.ErrNotExist) {
+					f.trashIndex.remove(id)
+				}
+				continue
+			}
+
+			if len(tl.Files) == limit {
+				tl.NextCursor = tl.Files[len(tl.Files)-1].Info.ID
+				return &tl, nil
+			}
+			tl.Files = append(tl.Files, df)
+		}
+
+		if len(ids) < batch {
+			return &tl, nil
+		}
+		cursor = ids[len(ids)-1]
+	}
+}
+
+// Restore moves a deleted file back from the trash, together with its
+// retained versions.
+func (f *FileSystemStorage) Restore(ctx context.Context, ID string) (*filedata.FileInfo, error) {
+	if !f.trash.Enabled {
+		return nil, errs.ErrTrashNotSupported
+	}
+
+	dirPath, err := fileCatalog(f.path, ID)
+	if err != nil {
+		return nil, fmt.Errorf("catalog name error: %w", err)
+	}
+	trashDir := f.trashDir(ID)
+
+	_, err = readDeletedRecord(trashDir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(dirPath, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("directory path creation error: %w", err)
+	}
+
+	err = f.index.beginWrite()
+	if err != nil {
+		return nil, err
+	}
+	defer f.index.endWrite()
+
+	lockFile, err := lockAcquire(ID, dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("lock error: %w", err)
+	}
+	defer func() {
+		if err := lockFile.Close(); err != nil {
+			logger.FromContext(ctx).Warn(
+				"unlock failed",
+				"id", ID,
+				"error", err,
+			)
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	// the entry may be purged while waiting for the lock
+	_, err = readDeletedRecord(trashDir)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = activeFileInfo(dirPath, ID)
+	if err == nil {
+		return nil, fmt.Errorf("file %s: %w", ID, errs.ErrFileExists)
+	}
+	if !errors.Is(err, errs.ErrNotFound) {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(trashDir)
+	if err != nil {
+		return nil, fmt.Errorf("trash reading error: %w", err)
+	}
+
+	// the active slot file goes last, so an interrupted restore leaves
+	// only unreferenced files for the garbage collector
+	activeStateName := activeStateFileName(ID)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == deletedRecordName || name == activeStateName {
+			continue
+		}
+		names = append(names, name)
+	}
+	if _, err := os.Stat(filepath.Join(trashDir, activeStateName)); err == nil {
+		names = append(names, activeStateName)
+	}
+
+	for _, name := range names {
+		err = linkFile(filepath.Join(trashDir, name), filepath.Join(dirPath, name), filepath.Join(dirPath, name+"."+tmpExt))
+		if err != nil {
+			return nil, fmt.Errorf("restore file error: %w", err)
+		}
+	}
+
+	err = syncDir(dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("sync dir error: %w", err)
+	}
+
+	err = os.RemoveAll(trashDir)
+	if err != nil {
+		logger.FromContext(ctx).Warn("trash entry removal failed", "id", ID, slog.Any(logger.LogFieldError, err))
+	}
+	f.trashIndex.remove(ID)
+
+	fi, err := activeFileInfo(dirPath, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.index.put(fi)
+	if err != nil {
+		logger.FromContext(ctx).Warn(
+			"index update failed",
+			"id", ID,
+			"error", err,
+		)
+	}
+
+	return fi, nil
+}
+
+// moveToTrash links the files of the ID into its trash entry and removes them
+// from the catalog. Renditions are not kept. An older trash entry of the same
+// ID is replaced. Supposed id is locked.
+func (f *FileSystemStorage) moveToTrash(dirPath string, fi *filedata.FileInfo, filenames []string) error {
+	trashDir := f.trashDir(fi.ID)
+
+	err := os.RemoveAll(trashDir)
+	if err != nil {
+		return fmt.Errorf("remove trash entry error: %w", err)
+	}
+	err = os.MkdirAll(trashDir, 0755)
+	if err != nil {
+		return fmt.Errorf("trash entry creation error: %w", err)
+	}
+
+	activeStateName := activeStateFileName(fi.ID)
+	for _, name := range filenames {
+		if !keptInTrash(fi.ID, name) {
+			continue
+		}
+		err = os.Link(filepath.Join(dirPath, name), filepath.Join(trashDir, name))
+		if err != nil {
+			return fmt.Errorf("link file error: %w", err)
+		}
+	}
+
+	b, err := json.Marshal(filedata.DeletedFile{DeletedAt: time.Now(), Info: fi})
+	if err != nil {
+		return fmt.Errorf("deleted record marshall error: %w", err)
+	}
+	recordName := filepath.Join(trashDir, deletedRecordName)
+	err = writeFile(bytes.NewReader(b), recordName, recordName+"."+tmpExt)
+	if err != nil {
+		return fmt.Errorf("write deleted record error: %w", err)
+	}
+
+	err = syncDir(trashDir)
+	if err != nil {
+		return fmt.Errorf("sync dir error: %w", err)
+	}
+	err = syncDir(filepath.Dir(trashDir))
+	if err != nil {
+		return fmt.Errorf("sync dir error: %w", err)
+	}
+	f.trashIndex.add(fi.ID)
+
+	// the active slot file goes first, so an interrupted removal never
+	// leaves the file visible
+	err = os.Remove(filepath.Join(dirPath, activeStateName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove file error: %w", err)
+	}
+	for _, name := range filenames {
+		err := os.Remove(filepath.Join(dirPath, name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("remove file error: %w", err)
+		}
+	}
+
+	return syncDir(dirPath)
+}
+
+// purgeTrash removes trash entries deleted more than the purge age ago and
+// incomplete entries left by interrupted deletions.
+func (gc *GarbageCollector) purgeTrash(ctx context.Context, now time.Time) error {
+	if !gc.trash.Enabled {
+		return nil
+	}
+
+	trashPath := filepath.Join(gc.path, trashDirName)
+	entries, err := os.ReadDir(trashPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("trash reading error: %w", err)
+	}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !entry.IsDir() {
+			continue
+		}
+
+		err := gc.purgeTrashEntry(entry.Name(), now)
+		if err != nil {
+			gc.log.Warn("trash purge error", "id", entry.Name(), slog.Any(logger.LogFieldError, err))
+			metrics.GcErrorsTotal.Inc()
+		}
+	}
+
+	return nil
+}
+
+func (gc *GarbageCollector) purgeTrashEntry(id string, now time.Time) error {
+	dirPath, err := fileCatalog(gc.path, id)
+	if err != nil {
+		return fmt.Errorf("catalog name error: %w", err)
+	}
+	err = os.MkdirAll(dirPath, 0755)
+	if err != nil {
+		return fmt.Errorf("directory path creation error: %w", err)
+	}
+
+	lockFile, err := lockAcquire(id, dirPath)
+	if err != nil {
+		return err
+	}
+	defer lockFile.Close()
+
+	trashDir := filepath.Join(gc.path, trashDirName, id)
+	df, err := readDeletedRecord(trashDir)
+	if err != nil && !errors.Is(err, errs.ErrNotFound) {
+		return err
+	}
+	if df != nil && (gc.trash.PurgeAfter == 0 || now.Sub(df.DeletedAt) < gc.trash.PurgeAfter) {
+		return nil
+	}
+
+	err = os.RemoveAll(trashDir)
+	if err != nil {
+		return fmt.Errorf("remove trash entry error: %w", err)
+	}
+	gc.trashIndex.remove(id)
+	metrics.GcFilesDeletedTotal.Inc()
+
+	// the lock file was created for the purge only
+	filenames, err := filenamesByID(dirPath, id)
+	if err != nil {
+		return fmt.Errorf("files search error: %w", err)
+	}
+	if len(filenames) == 1 && filenames[0] == lockFileName(id) {
+		err = os.Remove(filepath.Join(dirPath, filenames[0]))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("remove file error: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// deletedInfo returns errs.ErrFileDeleted when the file is in the trash and
+// errs.ErrNotFound otherwise.
+func (f *FileSystemStorage) deletedInfo(ID string) error {
+	if !f.trash.Enabled {
+		return errs.ErrNotFound
+	}
+
+	_, err := os.Stat(filepath.Join(f.trashDir(ID), deletedRecordName))
+	if err != nil {
+		return errs.ErrNotFound
+	}
+
+	return errs.ErrFileDeleted
+}
+
+func (f *FileSystemStorage) trashDir(ID string) string {
+	return filepath.Join(f.path, trashDirName, ID)
+}
+
+func readDeletedRecord(trashDir string) (*filedata.DeletedFile, error) {
+	b, err := os.ReadFile(filepath.Join(trashDir, deletedRecordName))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, errs.ErrNotFound
+		}
+		return nil, fmt.Errorf("read file error: %w", err)
+	}
+
+	var df filedata.DeletedFile
+	err = json.Unmarshal(b, &df)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal deleted record error: %w", err)
+	}
+	if df.Info == nil {
+		return nil, fmt.Errorf("deleted record without file info: %w", errs.ErrInvalidFileData)
+	}
+
+	return &df, nil
+}
+
+// keptInTrash reports whether the file of the ID is moved to the trash.
+// Lock files, renditions and incomplete writes are not.
+func keptInTrash(id, filename string) bool {
+	return filename != lockFileName(id) &&
+		!strings.HasPrefix(filename, id+"."+renditionExt+".") &&
+		!strings.HasSuffix(filename, "."+tmpExt)
+}
@@ -0,0 +1,239 @@
+package filesystemstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"file-storage/internal/config"
+	"file-storage/internal/contextkeys"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"file-storage/internal/logger"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTrash(t *testing.T) {
+	log := logger.NewBootstrap()
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, contextkeys.ContextKeyLogger, log)
+
+	path := t.TempDir()
+	cfg := config.FileSystem{
+		Path:     path,
+		Versions: config.Versions{Keep: 2},
+		Trash:    config.Trash{Enabled: true, PurgeAfter: time.Hour},
+	}
+	f, err := New(&cfg, log)
+	if err != nil {
+		t.Fatalf("got error %v want nil", err)
+	}
+
+	ids := []string{
+		"123456789012345678901234567890123451",
+		"123456789012345678901234567890123452",
+		"123456789012345678901234567890123453",
+	}
+	for _, id := range ids {
+		for _, data := range []string{"one", "two"} {
+			_, err := f.Upsert(ctx, &filedata.FileData{ID: id, Data: bytes.NewReader([]byte(data)), HashSource: data})
+			if err != nil {
+				t.Fatalf("upsert error %v", err)
+			}
+		}
+		err = f.Delete(ctx, id)
+		if err != nil {
+			t.Fatalf("delete error %v", err)
+		}
+	}
+
+	_, err = f.Info(ctx, ids[0])
+	if !errors.Is(err, errs.ErrFileDeleted) {
+		t.Errorf("info of deleted file got %v want %v", err, errs.ErrFileDeleted)
+	}
+	_, err = f.Content(ctx, ids[0])
+	if !errors.Is(err, errs.ErrFileDeleted) {
+		t.Errorf("content of deleted file got %v want %v", err, errs.ErrFileDeleted)
+	}
+	fl, err := f.List(ctx, &filedata.ListQuery{Limit: 10})
+	if err != nil {
+		t.Fatalf("list error %v", err)
+	}
+	if len(fl.Files) != 0 {
+		t.Errorf("deleted files listed got %d want 0", len(fl.Files))
+	}
+
+	tl, err := f.Trash(ctx, "", 2)
+	if err != nil {
+		t.Fatalf("trash error %v", err)
+	}
+	if len(tl.Files) != 2 || tl.Files[0].Info.ID != ids[0] || tl.NextCursor != ids[1] {
+		t.Errorf("trash first page mismatch got %d files, next cursor %q", len(tl.Files), tl.NextCursor)
+	}
+	tl, err = f.Trash(ctx, tl.NextCursor, 2)
+	if err != nil {
+		t.Fatalf("trash error %v", err)
+	}
+	if len(tl.Files) != 1 || tl.Files[0].Info.ID != ids[2] || tl.NextCursor != "" {
+		t.Errorf("trash second page mismatch got %d files, next cursor %q", len(tl.Files), tl.NextCursor)
+	}
+
+	fi, err := f.Restore(ctx, ids[0])
+	if err != nil {
+		t.Fatalf("restore error %v", err)
+	}
+	if fi.HashSource != "two" {
+		t.Errorf("restored info mismatch got %+v", fi)
+	}
+	_, err = f.Info(ctx, ids[0])
+	if err != nil {
+		t.Errorf("info of restored file got %v want nil", err)
+	}
+	checkVersions(t, f, ids[0], []int{1})
+	checkVersionContent(t, f, ids[0], 1, "one")
+
+	_, err = f.Restore(ctx, ids[0])
+	if !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("second restore got %v want %v", err, errs.ErrNotFound)
+	}
+
+	// a new file under the ID of a deleted one blocks the restore
+	_, err = f.Upsert(ctx, &filedata.FileData{ID: ids[1], Data: bytes.NewReader([]byte("new")), HashSource: "new"})
+	if err != nil {
+		t.Fatalf("upsert error %v", err)
+	}
+	_, err = f.Restore(ctx, ids[1])
+	if !errors.Is(err, errs.ErrFileExists) {
+		t.Errorf("restore over existing file got %v want %v", err, errs.ErrFileExists)
+	}
+
+	gc := NewGarbageCollector(&cfg, log)
+	err = gc.purgeTrash(ctx, time.Now())
+	if err != nil {
+		t.Fatalf("purge error %v", err)
+	}
+	tl, err = f.Trash(ctx, "", 10)
+	if err != nil {
+		t.Fatalf("trash error %v", err)
+	}
+	if len(tl.Files) != 2 {
+		t.Errorf("trash before purge age got %d files want 2", len(tl.Files))
+	}
+
+	err = gc.purgeTrash(ctx, time.Now().Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("purge error %v", err)
+	}
+	tl, err = f.Trash(ctx, "", 10)
+	if err != nil {
+		t.Fatalf("trash error %v", err)
+	}
+	if len(tl.Files) != 0 {
+		t.Errorf("trash after purge age got %d files want 0", len(tl.Files))
+	}
+	_, err = f.Info(ctx, ids[2])
+	if !errors.Is(err, errs.ErrNotFound) || errors.Is(err, errs.ErrFileDeleted) {
+		t.Errorf("info of purged file got %v want %v", err, errs.ErrNotFound)
+	}
+
+	dirPath, err := fileCatalog(path, ids[2])
+	if err != nil {
+		t.Fatalf("catalog name error: %v", err)
+	}
+	filenames, err := filenamesByID(dirPath, ids[2])
+	if err != nil {
+		t.Fatalf("files search error: %v", err)
+	}
+	if len(filenames) != 0 {
+		t.Errorf("files of purged file left %v", filenames)
+	}
+	if _, err := os.Stat(filepath.Join(path, trashDirName, ids[2])); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("trash entry of purged file got %v want not exist", err)
+	}
+}
+
+func TestTrashIndex(t *testing.T) {
+	log := logger.NewBootstrap()
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, contextkeys.ContextKeyLogger, log)
+
+	path := t.TempDir()
+	cfg := config.FileSystem{
+		Path:             path,
+		Trash:            config.Trash{Enabled: true, PurgeAfter: time.Hour},
+		GarbageCollector: config.GarbageCollector{Enabled: true, Interval: time.Minute, WorkersCount: 1},
+	}
+	f, err := New(&cfg, log)
+	if err != nil {
+		t.Fatalf("got error %v want nil", err)
+	}
+
+	ids := []string{
+		"123456789012345678901234567890123451",
+		"123456789012345678901234567890123452",
+		"123456789012345678901234567890123453",
+		"123456789012345678901234567890123454",
+	}
+	for _, id := range ids {
+		_, err := f.Upsert(ctx, &filedata.FileData{ID: id, Data: bytes.NewReader([]byte("data")), HashSource: "data"})
+		if err != nil {
+			t.Fatalf("upsert error %v", err)
+		}
+		err = f.Delete(ctx, id)
+		if err != nil {
+			t.Fatalf("delete error %v", err)
+		}
+	}
+
+	// the index is loaded from the trash directory when the storage is opened
+	err = f.Close()
+	if err != nil {
+		t.Fatalf("close error %v", err)
+	}
+	f, err = New(&cfg, log)
+	if err != nil {
+		t.Fatalf("got error %v want nil", err)
+	}
+	defer f.Close()
+
+	// an entry removed behind the index is skipped
+	err = os.RemoveAll(filepath.Join(path, trashDirName, ids[1]))
+	if err != nil {
+		t.Fatalf("remove error %v", err)
+	}
+
+	var got []string
+	cursor := ""
+	for {
+		tl, err := f.Trash(ctx, cursor, 1)
+		if err != nil {
+			t.Fatalf("trash error %v", err)
+		}
+		for _, df := range tl.Files {
+			got = append(got, df.Info.ID)
+		}
+		if tl.NextCursor == "" {
+			break
+		}
+		cursor = tl.NextCursor
+	}
+	want := []string{ids[0], ids[2], ids[3]}
+	if !slices.Equal(got, want) {
+		t.Errorf("trash pages got %v want %v", got, want)
+	}
+
+	_, err = f.Restore(ctx, ids[0])
+	if err != nil {
+		t.Fatalf("restore error %v", err)
+	}
+	err = f.gc.purgeTrash(ctx, time.Now().Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("purge error %v", err)
+	}
+	if left := f.trashIndex.after("", 10); len(left) != 0 {
+		t.Errorf("trash index after restore and purge got %v want empty", left)
+	}
+}
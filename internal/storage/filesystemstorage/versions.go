@@ -0,0 +1,449 @@
+package filesystemstorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"file-storage/internal/config"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"file-storage/internal/logger"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// versionPrefix starts the version part of retained version file names,
+// for example [id].v3.bin and [id].v3.meta.json.
+const versionPrefix = "v"
+
+// Versions returns retained versions of the file, newest first.
+func (f *FileSystemStorage) Versions(ctx context.Context, ID string) ([]*filedata.VersionInfo, error) {
+	if len(ID) == 0 {
+		return nil, errs.ErrInvalidID
+	}
+
+	dirPath, err := fileCatalog(f.path, ID)
+	if err != nil {
+		return nil, fmt.Errorf("catalog name error: %w", err)
+	}
+
+	_, err = activeFileInfo(dirPath, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := readVersions(dirPath, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return retainedVersions(versions, f.versions, time.Now()), nil
+}
+
+// VersionContent opens content of a retained version of the file for reading.
+func (f *FileSystemStorage) VersionContent(ctx context.Context, ID string, version int) (*filedata.ContentData, error) {
+	dirPath, err := fileCatalog(f.path, ID)
+	if err != nil {
+		return nil, fmt.Errorf("catalog name error: %w", err)
+	}
+
+	vi, err := retainedVersion(dirPath, ID, version, f.versions, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filepath.Join(dirPath, versionDataFileName(ID, version)))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, errs.ErrNotFound
+		}
+		return nil, fmt.Errorf("open file error: %w", err)
+	}
+
+	return &filedata.ContentData{Data: file, Info: vi.Info}, nil
+}
+
+// RestoreVersion makes a copy of a retained version the active version of the
+// file. The creation time of the file is kept and the update time is set to now.
+func (f *FileSystemStorage) RestoreVersion(ctx context.Context, ID string, version int) (*filedata.FileInfo, error) {
+	dirPath, err := fileCatalog(f.path, ID)
+	if err != nil {
+		return nil, fmt.Errorf("catalog name error: %w", err)
+	}
+
+	if _, err := os.Stat(dirPath); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, errs.ErrNotFound
+		}
+		return nil, err
+	}
+
+	err = f.index.beginWrite()
+	if err != nil {
+		return nil, err
+	}
+	defer f.index.endWrite()
+
+	lockFile, err := lockAcquire(ID, dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("lock error: %w", err)
+	}
+	defer func() {
+		if err := lockFile.Close(); err != nil {
+			logger.FromContext(ctx).Warn(
+				"unlock failed",
+				"id", ID,
+				"error", err,
+			)
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	currentAtiveState, newAtiveState, err := slotInfo(dirPath, ID)
+	if err != nil {
+		currentAtiveState, newAtiveState, err = slotInfoWithRecovery(dirPath, ID, lockFile)
+		if err != nil {
+			return nil, fmt.Errorf("get activeState error: %w", err)
+		}
+	}
+
+	current, err := readFileInfo(dirPath, ID, currentAtiveState)
+	if err != nil {
+		return nil, err
+	}
+
+	vi, err := retainedVersion(dirPath, ID, version, f.versions, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	// retained versions do not keep originals
+	fi := copyFileInfo(vi.Info)
+	fi.ID = ID
+	fi.CreatedAt = current.CreatedAt
+	fi.UpdatedAt = time.Now()
+	fi.Original = nil
+	newAtiveState.Original = ""
+
+	basePath := filepath.Join(dirPath, ID)
+	err = linkFile(
+		filepath.Join(dirPath, versionDataFileName(ID, version)),
+		dataFileFullName(dirPath, ID, newAtiveState),
+		basePath+".bin.tmp",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("restore file data error: %w", err)
+	}
+
+	fiBytes, err := json.Marshal(fi)
+	if err != nil {
+		return nil, fmt.Errorf("file info marshall error: %w", err)
+	}
+	err = writeFile(bytes.NewReader(fiBytes), metadataFileFullName(dirPath, ID, newAtiveState), basePath+".meta.json.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("write file info error: %w", err)
+	}
+
+	if f.versions.Enabled() {
+		newAtiveState.LastVersion, err = archiveVersion(dirPath, ID, currentAtiveState, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("archive version error: %w", err)
+		}
+	}
+
+	err = syncDir(dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("sync dir error: %w", err)
+	}
+
+	err = commitActiveState(dirPath, ID, newAtiveState)
+	if err != nil {
+		return nil, fmt.Errorf("commit new activeState error: %w", err)
+	}
+
+	f.afterCommit(ctx, dirPath, fi, true)
+
+	err = syncDir(dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("sync dir error: %w", err)
+	}
+
+	return fi, nil
+}
+
+// afterCommit updates the index and removes versions and renditions that are
+// no longer needed after a new active version is committed. Failures are only
+// logged, because the new version is already visible. Supposed id is locked.
+func (f *FileSystemStorage) afterCommit(ctx context.Context, dirPath string, fi *filedata.FileInfo, contentChanged bool) {
+	log := logger.FromContext(ctx)
+
+	err := f.index.put(fi)
+	if err != nil {
+		log.Warn(
+			"index update failed",
+			"id", fi.ID,
+			"error", err,
+		)
+	}
+
+	if f.versions.Enabled() {
+		err = pruneVersions(dirPath, fi.ID, f.versions, time.Now())
+		if err != nil {
+			log.Warn(
+				"versions pruning failed",
+				"id", fi.ID,
+				"error", err,
+			)
+		}
+	}
+
+	// renditions of the previous content are unreachable after the commit
+	if contentChanged {
+		err = removeRenditions(dirPath, fi.ID)
+		if err != nil {
+			log.Warn(
+				"renditions removal failed",
+				"id", fi.ID,
+				"error", err,
+			)
+		}
+	}
+}
+
+// archiveVersion retains the active version of the file under the next
+// version number and returns the last version number. Content is hard linked,
+// so retaining a version does not copy data. The original upload is not
+// retained. Nothing is archived for a new file. Supposed id is locked.
+func archiveVersion(dirPath, id string, as activeState, now time.Time) (int, error) {
+	fi, err := readFileInfo(dirPath, id, as)
+	if err != nil {
+		if errors.Is(err, errs.ErrNotFound) {
+			return as.LastVersion, nil
+		}
+		return 0, err
+	}
+
+	versions, err := readVersions(dirPath, id)
+	if err != nil {
+		return 0, err
+	}
+
+	// the active state may be recovered without the counter, so existing
+	// versions are taken into account as well
+	version := as.LastVersion
+	if len(versions) > 0 {
+		version = max(version, versions[0].Version)
+	}
+	version++
+
+	err = linkFile(
+		dataFileFullName(dirPath, id, as),
+		filepath.Join(dirPath, versionDataFileName(id, version)),
+		filepath.Join(dirPath, id+"."+versionPrefix+"."+binExt+"."+tmpExt),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("link version data error: %w", err)
+	}
+
+	// the original is not retained with the version
+	fi.Original = nil
+	b, err := json.Marshal(filedata.VersionInfo{Version: version, ArchivedAt: now, Info: fi})
+	if err != nil {
+		return 0, fmt.Errorf("version info marshall error: %w", err)
+	}
+
+	versionMetadataName := filepath.Join(dirPath, versionMetadataFileName(id, version))
+	err = writeFile(bytes.NewReader(b), versionMetadataName, versionMetadataName+"."+tmpExt)
+	if err != nil {
+		return 0, fmt.Errorf("write version info error: %w", err)
+	}
+
+	return version, nil
+}
+
+// pruneVersions removes versions of the file that are no longer retained.
+// Supposed id is locked.
+func pruneVersions(dirPath, id string, retention config.Versions, now time.Time) error {
+	versions, err := readVersions(dirPath, id)
+	if err != nil {
+		return err
+	}
+
+	keep := retainedVersionFiles(id, retainedVersions(versions, retention, now))
+	for _, v := range versions {
+		for _, name := range []string{versionDataFileName(id, v.Version), versionMetadataFileName(id, v.Version)} {
+			if _, ok := keep[name]; ok {
+				continue
+			}
+			err := os.Remove(filepath.Join(dirPath, name))
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("remove version error: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// retainedVersions returns the versions kept by the retention policy.
+// Versions must be sorted newest first.
+func retainedVersions(versions []*filedata.VersionInfo, retention config.Versions, now time.Time) []*filedata.VersionInfo {
+	if !retention.Enabled() {
+		return []*filedata.VersionInfo{}
+	}
+
+	if retention.Keep > 0 && len(versions) > retention.Keep {
+		versions = versions[:retention.Keep]
+	}
+
+	result := make([]*filedata.VersionInfo, 0, len(versions))
+	for _, v := range versions {
+		if retention.MaxAge > 0 && now.Sub(v.ArchivedAt) >= retention.MaxAge {
+			continue
+		}
+		result = append(result, v)
+	}
+
+	return result
+}
+
+// retainedVersion returns the version of the file when the retention policy
+// keeps it. Versions that are not pruned yet but fall outside the policy are
+// reported as not found, the same as in the version listing.
+func retainedVersion(dirPath, id string, version int, retention config.Versions, now time.Time) (*filedata.VersionInfo, error) {
+	versions, err := readVersions(dirPath, id)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, errs.ErrNotFound
+		}
+		return nil, err
+	}
+
+	for _, vi := range retainedVersions(versions, retention, now) {
+		if vi.Version == version {
+			return vi, nil
+		}
+	}
+
+	return nil, errs.ErrNotFound
+}
+
+// retainedVersionFiles returns names of the files that store the versions.
+func retainedVersionFiles(id string, versions []*filedata.VersionInfo) map[string]struct{} {
+	m := make(map[string]struct{}, 2*len(versions))
+	for _, v := range versions {
+		m[versionDataFileName(id, v.Version)] = struct{}{}
+		m[versionMetadataFileName(id, v.Version)] = struct{}{}
+	}
+
+	return m
+}
+
+// readVersions reads metadata of all stored versions of the file, newest
+// first. Unreadable version metadata is skipped.
+func readVersions(dirPath, id string) ([]*filedata.VersionInfo, error) {
+	filenames, err := filenamesByID(dirPath, id)
+	if err != nil {
+		return nil, fmt.Errorf("versions search error: %w", err)
+	}
+
+	versions := make([]*filedata.VersionInfo, 0)
+	for _, filename := range filenames {
+		version, ok := parseVersionMetadataFileName(id, filename)
+		if !ok {
+			continue
+		}
+
+		vi, err := readVersionInfo(dirPath, id, version)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, vi)
+	}
+
+	slices.SortFunc(versions, func(a, b *filedata.VersionInfo) int {
+		return b.Version - a.Version
+	})
+
+	return versions, nil
+}
+
+func readVersionInfo(dirPath, id string, version int) (*filedata.VersionInfo, error) {
+	b, err := os.ReadFile(filepath.Join(dirPath, versionMetadataFileName(id, version)))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, errs.ErrNotFound
+		}
+		return nil, fmt.Errorf("read file error: %w", err)
+	}
+
+	var vi filedata.VersionInfo
+	err = json.Unmarshal(b, &vi)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal version info error: %w", err)
+	}
+	if vi.Info == nil {
+		return nil, errs.ErrNotFound
+	}
+
+	return &vi, nil
+}
+
+// linkFile hard links src to path through tempPath, replacing path atomically.
+func linkFile(src, path, tempPath string) error {
+	err := os.Remove(tempPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove temp file error: %w", err)
+	}
+
+	err = os.Link(src, tempPath)
+	if err != nil {
+		return fmt.Errorf("link file error: %w", err)
+	}
+
+	err = os.Rename(tempPath, path)
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return fmt.Errorf("rename file error: %w", err)
+	}
+
+	return nil
+}
+
+func versionDataFileName(id string, version int) string {
+	return fmt.Sprintf("%s.%s%d.%s", id, versionPrefix, version, binExt)
+}
+
+func versionMetadataFileName(id string, version int) string {
+	return fmt.Sprintf("%s.%s%d.%s", id, versionPrefix, version, metadataExt)
+}
+
+func parseVersionMetadataFileName(id, filename string) (int, bool) {
+	s, ok := strings.CutPrefix(filename, id+"."+versionPrefix)
+	if !ok {
+		return 0, false
+	}
+	s, ok = strings.CutSuffix(s, "."+metadataExt)
+	if !ok {
+		return 0, false
+	}
+
+	version, err := strconv.Atoi(s)
+	if err != nil || version < 1 {
+		return 0, false
+	}
+
+	return version, true
+}
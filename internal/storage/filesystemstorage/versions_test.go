@@ -0,0 +1,242 @@
+package filesystemstorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"file-storage/internal/config"
+	"file-storage/internal/contextkeys"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"file-storage/internal/logger"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestVersions(t *testing.T) {
+	log := logger.NewBootstrap()
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, contextkeys.ContextKeyLogger, log)
+
+	path := t.TempDir()
+	cfg := config.FileSystem{Path: path, Versions: config.Versions{Keep: 2}}
+	f, err := New(&cfg, log)
+	if err != nil {
+		t.Fatalf("got error %v want nil", err)
+	}
+
+	id := "123456789012345678901234567890123456"
+	createdAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, data := range []string{"one", "two", "three", "four"} {
+		fd := &filedata.FileData{ID: id, Data: bytes.NewReader([]byte(data)), HashSource: data, CreatedAt: createdAt}
+		_, err := f.Upsert(ctx, fd)
+		if err != nil {
+			t.Fatalf("upsert error %v", err)
+		}
+	}
+	// metadata only update is a version as well
+	_, err = f.Upsert(ctx, &filedata.FileData{ID: id, HashSource: "four", Public: true, CreatedAt: createdAt})
+	if err != nil {
+		t.Fatalf("upsert error %v", err)
+	}
+
+	checkVersions(t, f, id, []int{4, 3})
+	checkVersionContent(t, f, id, 4, "four")
+	checkVersionContent(t, f, id, 3, "three")
+
+	_, err = f.VersionContent(ctx, id, 2)
+	if !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("pruned version got %v want %v", err, errs.ErrNotFound)
+	}
+
+	fi, err := f.RestoreVersion(ctx, id, 3)
+	if err != nil {
+		t.Fatalf("restore error %v", err)
+	}
+	if fi.HashSource != "three" || !fi.CreatedAt.Equal(createdAt) {
+		t.Errorf("restored info mismatch got %+v", fi)
+	}
+
+	cd, err := f.Content(ctx, id)
+	if err != nil {
+		t.Fatalf("content error %v", err)
+	}
+	b, err := io.ReadAll(cd.Data)
+	cd.Data.Close()
+	if err != nil {
+		t.Fatalf("content read error %v", err)
+	}
+	if string(b) != "three" {
+		t.Errorf("restored content mismatch got %q want %q", b, "three")
+	}
+
+	checkVersions(t, f, id, []int{5, 4})
+	checkVersionContent(t, f, id, 5, "four")
+
+	_, err = f.RestoreVersion(ctx, id, 1)
+	if !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("restore of pruned version got %v want %v", err, errs.ErrNotFound)
+	}
+
+	dirPath, err := fileCatalog(path, id)
+	if err != nil {
+		t.Fatalf("catalog name error: %v", err)
+	}
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		t.Fatalf("read dir error: %v", err)
+	}
+	gc := NewGarbageCollector(&cfg, log)
+	err = gc.removeGarbage(&cleanupJob{id: id, dirPath: dirPath, dirEntries: entries}, log)
+	if err != nil {
+		t.Fatalf("remove garbage error: %v", err)
+	}
+	checkVersionContent(t, f, id, 5, "four")
+	checkVersionContent(t, f, id, 4, "four")
+
+	err = f.Delete(ctx, id)
+	if err != nil {
+		t.Fatalf("delete error %v", err)
+	}
+	_, err = f.VersionContent(ctx, id, 5)
+	if !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("version of deleted file got %v want %v", err, errs.ErrNotFound)
+	}
+}
+
+func TestExpiredVersions(t *testing.T) {
+	log := logger.NewBootstrap()
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, contextkeys.ContextKeyLogger, log)
+
+	path := t.TempDir()
+	cfg := config.FileSystem{Path: path, Versions: config.Versions{MaxAge: time.Hour}}
+	f, err := New(&cfg, log)
+	if err != nil {
+		t.Fatalf("got error %v want nil", err)
+	}
+
+	id := "123456789012345678901234567890123456"
+	for _, data := range []string{"one", "two"} {
+		_, err := f.Upsert(ctx, &filedata.FileData{ID: id, Data: bytes.NewReader([]byte(data)), HashSource: data})
+		if err != nil {
+			t.Fatalf("upsert error %v", err)
+		}
+	}
+	checkVersionContent(t, f, id, 1, "one")
+
+	// the version expires before the next write or garbage collection prunes it
+	dirPath, err := fileCatalog(path, id)
+	if err != nil {
+		t.Fatalf("catalog name error: %v", err)
+	}
+	vi, err := readVersionInfo(dirPath, id, 1)
+	if err != nil {
+		t.Fatalf("read version error %v", err)
+	}
+	vi.ArchivedAt = time.Now().Add(-2 * time.Hour)
+	b, err := json.Marshal(vi)
+	if err != nil {
+		t.Fatalf("marshal error %v", err)
+	}
+	err = os.WriteFile(filepath.Join(dirPath, versionMetadataFileName(id, 1)), b, 0644)
+	if err != nil {
+		t.Fatalf("write version error %v", err)
+	}
+
+	checkVersions(t, f, id, []int{})
+	_, err = f.VersionContent(ctx, id, 1)
+	if !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("expired version content got %v want %v", err, errs.ErrNotFound)
+	}
+	_, err = f.RestoreVersion(ctx, id, 1)
+	if !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("restore of expired version got %v want %v", err, errs.ErrNotFound)
+	}
+}
+
+func checkVersions(t *testing.T, f *FileSystemStorage, id string, want []int) {
+	t.Helper()
+
+	versions, err := f.Versions(context.Background(), id)
+	if err != nil {
+		t.Fatalf("versions error %v", err)
+	}
+
+	got := make([]int, 0, len(versions))
+	for _, v := range versions {
+		got = append(got, v.Version)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("versions mismatch got %v want %v", got, want)
+	}
+}
+
+func checkVersionContent(t *testing.T, f *FileSystemStorage, id string, version int, want string) {
+	t.Helper()
+
+	cd, err := f.VersionContent(context.Background(), id, version)
+	if err != nil {
+		t.Fatalf("version %d content error %v", version, err)
+	}
+	b, err := io.ReadAll(cd.Data)
+	cd.Data.Close()
+	if err != nil {
+		t.Fatalf("version %d read error %v", version, err)
+	}
+	if string(b) != want {
+		t.Errorf("version %d content mismatch got %q want %q", version, b, want)
+	}
+}
+
+func TestRetainedVersions(t *testing.T) {
+	now := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	versions := []*filedata.VersionInfo{
+		{Version: 3, ArchivedAt: now.Add(-time.Hour)},
+		{Version: 2, ArchivedAt: now.Add(-48 * time.Hour)},
+		{Version: 1, ArchivedAt: now.Add(-72 * time.Hour)},
+	}
+
+	table := []struct {
+		name      string
+		retention config.Versions
+		want      []int
+	}{
+		{
+			name: "disabled",
+			want: []int{},
+		},
+		{
+			name:      "keep",
+			retention: config.Versions{Keep: 2},
+			want:      []int{3, 2},
+		},
+		{
+			name:      "max age",
+			retention: config.Versions{MaxAge: 50 * time.Hour},
+			want:      []int{3, 2},
+		},
+		{
+			name:      "keep and max age",
+			retention: config.Versions{Keep: 3, MaxAge: 24 * time.Hour},
+			want:      []int{3},
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]int, 0)
+			for _, v := range retainedVersions(versions, tt.retention, now) {
+				got = append(got, v.Version)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("retained versions mismatch got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
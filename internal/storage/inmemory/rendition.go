@@ -0,0 +1,73 @@
+package inmemory
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"io"
+)
+
+// maxRenditions bounds the number of cached renditions kept in memory.
+const maxRenditions = 256
+
+type rendition struct {
+	key  filedata.RenditionKey
+	data []byte
+}
+
+// Rendition returns a cached rendition and marks it as recently used.
+func (s *MemoryStorage) Rendition(ctx context.Context, key filedata.RenditionKey) (io.ReadSeekCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el := s.renditionIndex[key]
+	if el == nil {
+		return nil, errs.ErrNotFound
+	}
+	s.renditions.MoveToFront(el)
+
+	return filedata.NopSeekCloser(bytes.NewReader(el.Value.(*rendition).data)), nil
+}
+
+// PutRendition caches a rendition of the current file version and evicts the
+// least recently used ones above the limit.
+func (s *MemoryStorage) PutRendition(ctx context.Context, key filedata.RenditionKey, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.storage[key.ID]
+	if e == nil || e.info.ContentHash() != key.Hash {
+		return nil
+	}
+
+	if el := s.renditionIndex[key]; el != nil {
+		s.renditions.MoveToFront(el)
+		return nil
+	}
+
+	s.renditionIndex[key] = s.renditions.PushFront(&rendition{key: key, data: data})
+
+	for s.renditions.Len() > maxRenditions {
+		s.removeRendition(s.renditions.Back())
+	}
+
+	return nil
+}
+
+// removeRenditions drops cached renditions of the file. Supposed s.mu is locked.
+func (s *MemoryStorage) removeRenditions(ID string) {
+	for el := s.renditions.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*rendition).key.ID == ID {
+			s.removeRendition(el)
+		}
+		el = next
+	}
+}
+
+func (s *MemoryStorage) removeRendition(el *list.Element) {
+	s.renditions.Remove(el)
+	delete(s.renditionIndex, el.Value.(*rendition).key)
+}
@@ -6,23 +6,39 @@ package inmemory
 
 import (
 	"bytes"
+	"container/list"
 	"context"
 	"file-storage/internal/errs"
 	"file-storage/internal/filedata"
+	"fmt"
 	"io"
+	"maps"
+	"slices"
 	"strings"
 	"sync"
 )
 
 // MemoryStorage stores files in process memory and is intended for testing or local runs.
+// Transformed image renditions are kept in a bounded LRU cache.
 type MemoryStorage struct {
-	mu      sync.RWMutex
-	storage map[string]*filedata.FileData
+	mu             sync.RWMutex
+	storage        map[string]*entry
+	renditions     *list.List
+	renditionIndex map[filedata.RenditionKey]*list.Element
+}
+
+type entry struct {
+	info *filedata.FileInfo
+	data []byte
 }
 
 // New creates an empty in-memory storage.
 func New() *MemoryStorage {
-	return &MemoryStorage{storage: make(map[string]*filedata.FileData)}
+	return &MemoryStorage{
+		storage:        make(map[string]*entry),
+		renditions:     list.New(),
+		renditionIndex: make(map[filedata.RenditionKey]*list.Element),
+	}
 }
 
 // Upsert creates a new file or replaces an existing one in memory.
@@ -32,16 +48,31 @@ func (s *MemoryStorage) Upsert(ctx context.Context, fd *filedata.FileData) (stri
 		return "", errs.ErrInvalidFileData
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	if strings.TrimSpace(fd.ID) == "" {
 		return "", errs.ErrInvalidID
 	}
 
-	value := copyFileData(fd, s.storage[fd.ID])
+	var data []byte
+	if fd.Data != nil {
+		var err error
+		data, err = io.ReadAll(fd.Data)
+		if err != nil {
+			return "", fmt.Errorf("read data error: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if fd.Data == nil {
+		if current := s.storage[fd.ID]; current != nil {
+			data = current.data
+		}
+	} else {
+		s.removeRenditions(fd.ID)
+	}
 
-	s.storage[fd.ID] = value
+	s.storage[fd.ID] = &entry{info: filedata.FileInfoFromFileData(fd), data: data}
 
 	return fd.ID, nil
 }
@@ -49,32 +80,30 @@ func (s *MemoryStorage) Upsert(ctx context.Context, fd *filedata.FileData) (stri
 // Info returns file metadata from in-memory storage.
 func (s *MemoryStorage) Info(ctx context.Context, ID string) (*filedata.FileInfo, error) {
 	s.mu.RLock()
-	fd := s.storage[ID]
+	e := s.storage[ID]
 	s.mu.RUnlock()
 
-	if fd == nil {
+	if e == nil {
 		return nil, errs.ErrNotFound
 	}
 
-	return filedata.FileInfoFromFileData(fd), nil
+	return copyFileInfo(e.info), nil
 }
 
 // Content returns file content from in-memory storage.
 func (s *MemoryStorage) Content(ctx context.Context, ID string) (*filedata.ContentData, error) {
 	s.mu.RLock()
-	fd := s.storage[ID]
+	e := s.storage[ID]
 	s.mu.RUnlock()
 
-	if fd == nil {
+	if e == nil {
 		return nil, errs.ErrNotFound
 	}
 
-	b := make([]byte, len(fd.Data))
-	copy(b, fd.Data)
-
+	// stored slices are never modified, so readers may share them
 	cd := filedata.ContentData{
-		Data:    io.NopCloser(bytes.NewReader(b)),
-		IsImage: fd.IsImage,
+		Data: filedata.NopSeekCloser(bytes.NewReader(e.data)),
+		Info: copyFileInfo(e.info),
 	}
 
 	return &cd, nil
@@ -86,31 +115,47 @@ func (s *MemoryStorage) Delete(ctx context.Context, ID string) error {
 	defer s.mu.Unlock()
 
 	delete(s.storage, ID)
+	s.removeRenditions(ID)
 
 	return nil
 }
 
-func copyFileData(fd *filedata.FileData, currentValue *filedata.FileData) *filedata.FileData {
-	value := *fd
+// List returns a page of files matching the query in ascending ID order.
+func (s *MemoryStorage) List(ctx context.Context, q *filedata.ListQuery) (*filedata.FileList, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	if fd.Metadata != nil {
-		metadata := make(map[string]any, len(fd.Metadata))
-		for k, v := range fd.Metadata {
-			metadata[k] = v
+	ids := make([]string, 0, len(s.storage))
+	for id := range s.storage {
+		if id > q.Cursor {
+			ids = append(ids, id)
 		}
-		value.Metadata = metadata
 	}
+	slices.Sort(ids)
 
-	if fd.Data != nil {
-		b := make([]byte, len(fd.Data))
-		copy(b, fd.Data)
-		value.Data = b
-	} else {
-		if currentValue != nil && currentValue.Data != nil {
-			b := make([]byte, len(currentValue.Data))
-			copy(b, currentValue.Data)
-			value.Data = b
+	fl := filedata.FileList{Files: make([]*filedata.FileInfo, 0, q.Limit)}
+	for _, id := range ids {
+		fi := s.storage[id].info
+		if !q.Match(fi) {
+			continue
 		}
+		if len(fl.Files) == q.Limit {
+			fl.NextCursor = fl.Files[len(fl.Files)-1].ID
+			break
+		}
+		fl.Files = append(fl.Files, copyFileInfo(fi))
+	}
+
+	return &fl, nil
+}
+
+func copyFileInfo(fi *filedata.FileInfo) *filedata.FileInfo {
+	value := *fi
+
+	if fi.Metadata != nil {
+		metadata := make(map[string]any, len(fi.Metadata))
+		maps.Copy(metadata, fi.Metadata)
+		value.Metadata = metadata
 	}
 
 	return &value
@@ -116,7 +116,7 @@ func TestContent(t *testing.T) {
 	b := []byte("bytes")
 
 	id := "1"
-	fd := &filedata.FileData{ID: id, Data: b}
+	fd := &filedata.FileData{ID: id, Data: bytes.NewReader(b)}
 
 	s.Upsert(ctx, fd)
 
@@ -135,7 +135,7 @@ func TestContent(t *testing.T) {
 		{
 			name:            "ok",
 			id:              id,
-			wantContentData: &filedata.ContentData{Data: io.NopCloser(bytes.NewReader(b)), IsImage: false},
+			wantContentData: &filedata.ContentData{Data: filedata.NopSeekCloser(bytes.NewReader(b)), Info: filedata.FileInfoFromFileData(fd)},
 			wantErr:         nil,
 		},
 	}
@@ -163,7 +163,7 @@ func equalContentData(a *filedata.ContentData, b *filedata.ContentData) bool {
 		return false
 	}
 
-	if a.IsImage != b.IsImage {
+	if !reflect.DeepEqual(a.Info, b.Info) {
 		return false
 	}
 
@@ -221,3 +221,129 @@ func TestDelete(t *testing.T) {
 	}
 
 }
+
+func TestRendition(t *testing.T) {
+	s := New()
+	ctx := context.Background()
+
+	_, err := s.Upsert(ctx, &filedata.FileData{ID: "1", HashStored: "hash", Data: bytes.NewReader([]byte("image"))})
+	if err != nil {
+		t.Fatalf("upsert error: %v", err)
+	}
+
+	key := filedata.RenditionKey{ID: "1", Hash: "hash", Width: 100, Height: 100, Format: "jpeg"}
+	staleKey := key
+	staleKey.Hash = "stale"
+
+	err = s.PutRendition(ctx, key, []byte("rendition"))
+	if err != nil {
+		t.Fatalf("put rendition error: %v", err)
+	}
+	err = s.PutRendition(ctx, staleKey, []byte("stale rendition"))
+	if err != nil {
+		t.Fatalf("put stale rendition error: %v", err)
+	}
+
+	r, err := s.Rendition(ctx, key)
+	if err != nil {
+		t.Fatalf("rendition error: %v", err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("rendition read error: %v", err)
+	}
+	if string(b) != "rendition" {
+		t.Errorf("rendition mismatch got %q want %q", b, "rendition")
+	}
+
+	_, err = s.Rendition(ctx, staleKey)
+	if !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("stale rendition got %v want %v", err, errs.ErrNotFound)
+	}
+
+	_, err = s.Upsert(ctx, &filedata.FileData{ID: "1", HashStored: "hash", Data: bytes.NewReader([]byte("image"))})
+	if err != nil {
+		t.Fatalf("upsert error: %v", err)
+	}
+	_, err = s.Rendition(ctx, key)
+	if !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("rendition after upsert got %v want %v", err, errs.ErrNotFound)
+	}
+
+	for i := range maxRenditions + 1 {
+		k := key
+		k.Width = i + 1
+		err = s.PutRendition(ctx, k, []byte("rendition"))
+		if err != nil {
+			t.Fatalf("put rendition error: %v", err)
+		}
+	}
+	if s.renditions.Len() != maxRenditions {
+		t.Errorf("renditions count got %d want %d", s.renditions.Len(), maxRenditions)
+	}
+	first := key
+	first.Width = 1
+	_, err = s.Rendition(ctx, first)
+	if !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("least recently used rendition got %v want %v", err, errs.ErrNotFound)
+	}
+}
+
+func TestList(t *testing.T) {
+	s := New()
+	ctx := context.Background()
+
+	ids := []string{"1", "2", "3"}
+	for i, id := range ids {
+		_, err := s.Upsert(ctx, &filedata.FileData{ID: id, FileSize: i * 10})
+		if err != nil {
+			t.Fatalf("upsert error %v", err)
+		}
+	}
+
+	minSize := 10
+
+	table := []struct {
+		name           string
+		query          filedata.ListQuery
+		wantIDs        []string
+		wantNextCursor string
+	}{
+		{
+			name:           "first page",
+			query:          filedata.ListQuery{Limit: 2},
+			wantIDs:        []string{"1", "2"},
+			wantNextCursor: "2",
+		},
+		{
+			name:    "last page",
+			query:   filedata.ListQuery{Limit: 2, Cursor: "2"},
+			wantIDs: []string{"3"},
+		},
+		{
+			name:    "size filter",
+			query:   filedata.ListQuery{Limit: 2, MinSize: &minSize},
+			wantIDs: []string{"2", "3"},
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			fl, err := s.List(ctx, &tt.query)
+			if err != nil {
+				t.Fatalf("list error %v", err)
+			}
+
+			gotIDs := make([]string, 0, len(fl.Files))
+			for _, fi := range fl.Files {
+				gotIDs = append(gotIDs, fi.ID)
+			}
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("ids mismatch got %v want %v", gotIDs, tt.wantIDs)
+			}
+			if fl.NextCursor != tt.wantNextCursor {
+				t.Errorf("next cursor mismatch got %q want %q", fl.NextCursor, tt.wantNextCursor)
+			}
+		})
+	}
+}
@@ -2,6 +2,8 @@ package metricsstorage
 
 import (
 	"context"
+	"errors"
+	"file-storage/internal/errs"
 	"file-storage/internal/filedata"
 	"file-storage/internal/files"
 	"file-storage/internal/metrics"
@@ -26,8 +28,9 @@ func (ms *MetricsStorage) Upsert(ctx context.Context, fd *filedata.FileData) (st
 
 	id, err := ms.storage.Upsert(ctx, fd)
 
-	if fd != nil && err == nil {
-		metrics.FileBytesWrittenTotal.Add(float64(len(fd.Data)))
+	// FileSize is final once the storage has consumed the data stream
+	if fd != nil && fd.Data != nil && err == nil {
+		metrics.FileBytesWrittenTotal.Add(float64(fd.FileSize))
 	}
 
 	metrics.StorageOperationsDurationSeconds.WithLabelValues("upsert").Observe(time.Since(start).Seconds())
@@ -65,7 +68,7 @@ func (ms *MetricsStorage) Content(ctx context.Context, ID string) (*filedata.Con
 	fd, err := ms.storage.Content(ctx, ID)
 
 	if err == nil && fd != nil && fd.Data != nil {
-		fd.Data = &countingReadCloser{rc: fd.Data,
+		fd.Data = &countingReadSeekCloser{rsc: fd.Data,
 			onClose: func(n int64) {
 				metrics.FileBytesReadTotal.Add(float64(n))
 			},
@@ -100,20 +103,295 @@ func (ms *MetricsStorage) Delete(ctx context.Context, ID string) error {
 	return err
 }
 
-type countingReadCloser struct {
-	rc      io.ReadCloser
+// List delegates file listing to the wrapped storage and records list metrics.
+func (ms *MetricsStorage) List(ctx context.Context, q *filedata.ListQuery) (*filedata.FileList, error) {
+	start := time.Now()
+
+	fl, err := ms.storage.List(ctx, q)
+
+	metrics.StorageOperationsDurationSeconds.WithLabelValues("list").Observe(time.Since(start).Seconds())
+
+	metricResult := "ok"
+	if err != nil {
+		metricResult = "error"
+	}
+	metrics.StorageOperationsTotal.WithLabelValues("list", metricResult).Inc()
+
+	return fl, err
+}
+
+// Rendition delegates rendition lookup to the wrapped storage when it implements
+// files.RenditionCache and records hits and misses. Otherwise every lookup is a miss.
+func (ms *MetricsStorage) Rendition(ctx context.Context, key filedata.RenditionKey) (io.ReadSeekCloser, error) {
+	rc, ok := ms.storage.(files.RenditionCache)
+	if !ok {
+		return nil, errs.ErrNotFound
+	}
+
+	start := time.Now()
+
+	r, err := rc.Rendition(ctx, key)
+
+	metrics.StorageOperationsDurationSeconds.WithLabelValues("rendition").Observe(time.Since(start).Seconds())
+
+	metricResult := "ok"
+	if errors.Is(err, errs.ErrNotFound) {
+		metricResult = "miss"
+	} else if err != nil {
+		metricResult = "error"
+	}
+	metrics.StorageOperationsTotal.WithLabelValues("rendition", metricResult).Inc()
+
+	return r, err
+}
+
+// PutRendition delegates rendition write to the wrapped storage when it implements
+// files.RenditionCache and records write metrics. Otherwise the rendition is dropped.
+func (ms *MetricsStorage) PutRendition(ctx context.Context, key filedata.RenditionKey, data []byte) error {
+	rc, ok := ms.storage.(files.RenditionCache)
+	if !ok {
+		return nil
+	}
+
+	start := time.Now()
+
+	err := rc.PutRendition(ctx, key, data)
+
+	metrics.StorageOperationsDurationSeconds.WithLabelValues("put_rendition").Observe(time.Since(start).Seconds())
+
+	metricResult := "ok"
+	if err != nil {
+		metricResult = "error"
+	}
+	metrics.StorageOperationsTotal.WithLabelValues("put_rendition", metricResult).Inc()
+
+	return err
+}
+
+// Versions delegates version listing to the wrapped storage when it implements
+// files.VersionStorage and records read metrics.
+func (ms *MetricsStorage) Versions(ctx context.Context, ID string) ([]*filedata.VersionInfo, error) {
+	vs, ok := ms.storage.(files.VersionStorage)
+	if !ok {
+		return nil, errs.ErrVersionsNotSupported
+	}
+
+	start := time.Now()
+
+	versions, err := vs.Versions(ctx, ID)
+
+	metrics.StorageOperationsDurationSeconds.WithLabelValues("versions").Observe(time.Since(start).Seconds())
+
+	metricResult := "ok"
+	if err != nil {
+		metricResult = "error"
+	}
+	metrics.StorageOperationsTotal.WithLabelValues("versions", metricResult).Inc()
+
+	return versions, err
+}
+
+// VersionContent delegates version content read to the wrapped storage when it
+// implements files.VersionStorage and records read metrics.
+func (ms *MetricsStorage) VersionContent(ctx context.Context, ID string, version int) (*filedata.ContentData, error) {
+	vs, ok := ms.storage.(files.VersionStorage)
+	if !ok {
+		return nil, errs.ErrVersionsNotSupported
+	}
+
+	start := time.Now()
+
+	fd, err := vs.VersionContent(ctx, ID, version)
+
+	if err == nil && fd != nil && fd.Data != nil {
+		fd.Data = &countingReadSeekCloser{rsc: fd.Data,
+			onClose: func(n int64) {
+				metrics.FileBytesReadTotal.Add(float64(n))
+			},
+		}
+	}
+
+	metrics.StorageOperationsDurationSeconds.WithLabelValues("version_content").Observe(time.Since(start).Seconds())
+
+	metricResult := "ok"
+	if err != nil {
+		metricResult = "error"
+	}
+	metrics.StorageOperationsTotal.WithLabelValues("version_content", metricResult).Inc()
+
+	return fd, err
+}
+
+// RestoreVersion delegates version restore to the wrapped storage when it
+// implements files.VersionStorage and records write metrics.
+func (ms *MetricsStorage) RestoreVersion(ctx context.Context, ID string, version int) (*filedata.FileInfo, error) {
+	vs, ok := ms.storage.(files.VersionStorage)
+	if !ok {
+		return nil, errs.ErrVersionsNotSupported
+	}
+
+	start := time.Now()
+
+	fi, err := vs.RestoreVersion(ctx, ID, version)
+
+	metrics.StorageOperationsDurationSeconds.WithLabelValues("restore_version").Observe(time.Since(start).Seconds())
+
+	metricResult := "ok"
+	if err != nil {
+		metricResult = "error"
+	}
+	metrics.StorageOperationsTotal.WithLabelValues("restore_version", metricResult).Inc()
+
+	return fi, err
+}
+
+// Trash delegates trash listing to the wrapped storage when it implements
+// files.TrashStorage and records read metrics.
+func (ms *MetricsStorage) Trash(ctx context.Context, cursor string, limit int) (*filedata.TrashList, error) {
+	ts, ok := ms.storage.(files.TrashStorage)
+	if !ok {
+		return nil, errs.ErrTrashNotSupported
+	}
+
+	start := time.Now()
+
+	tl, err := ts.Trash(ctx, cursor, limit)
+
+	metrics.StorageOperationsDurationSeconds.WithLabelValues("trash").Observe(time.Since(start).Seconds())
+
+	metricResult := "ok"
+	if err != nil {
+		metricResult = "error"
+	}
+	metrics.StorageOperationsTotal.WithLabelValues("trash", metricResult).Inc()
+
+	return tl, err
+}
+
+// Restore delegates restore of a deleted file to the wrapped storage when it
+// implements files.TrashStorage and records write metrics.
+func (ms *MetricsStorage) Restore(ctx context.Context, ID string) (*filedata.FileInfo, error) {
+	ts, ok := ms.storage.(files.TrashStorage)
+	if !ok {
+		return nil, errs.ErrTrashNotSupported
+	}
+
+	start := time.Now()
+
+	fi, err := ts.Restore(ctx, ID)
+
+	metrics.StorageOperationsDurationSeconds.WithLabelValues("restore").Observe(time.Since(start).Seconds())
+
+	metricResult := "ok"
+	if err != nil {
+		metricResult = "error"
+	}
+	metrics.StorageOperationsTotal.WithLabelValues("restore", metricResult).Inc()
+
+	return fi, err
+}
+
+// Backup delegates backup to the wrapped storage when it implements
+// files.BackupStorage and records the backup duration.
+func (ms *MetricsStorage) Backup(ctx context.Context, w io.Writer) error {
+	bs, ok := ms.storage.(files.BackupStorage)
+	if !ok {
+		return errs.ErrBackupNotSupported
+	}
+
+	start := time.Now()
+
+	err := bs.Backup(ctx, w)
+
+	metrics.StorageOperationsDurationSeconds.WithLabelValues("backup").Observe(time.Since(start).Seconds())
+
+	metricResult := "ok"
+	if err != nil {
+		metricResult = "error"
+	}
+	metrics.StorageOperationsTotal.WithLabelValues("backup", metricResult).Inc()
+
+	return err
+}
+
+// Scrub delegates the integrity check of a file to the wrapped storage when it
+// implements files.ScrubStorage and records the check duration.
+func (ms *MetricsStorage) Scrub(ctx context.Context, ID string) (*filedata.ScrubResult, error) {
+	ss, ok := ms.storage.(files.ScrubStorage)
+	if !ok {
+		return nil, errs.ErrScrubNotSupported
+	}
+
+	start := time.Now()
+
+	sr, err := ss.Scrub(ctx, ID)
+
+	metrics.StorageOperationsDurationSeconds.WithLabelValues("scrub").Observe(time.Since(start).Seconds())
+
+	metricResult := "ok"
+	if err != nil {
+		metricResult = "error"
+	}
+	metrics.StorageOperationsTotal.WithLabelValues("scrub", metricResult).Inc()
+
+	return sr, err
+}
+
+// Original delegates the original upload read to the wrapped storage when it
+// implements files.OriginalStorage and records read metrics.
+func (ms *MetricsStorage) Original(ctx context.Context, ID string) (*filedata.ContentData, error) {
+	ost, ok := ms.storage.(files.OriginalStorage)
+	if !ok {
+		return nil, errs.ErrOriginalsNotSupported
+	}
+
+	start := time.Now()
+
+	cd, err := ost.Original(ctx, ID)
+
+	if err == nil && cd != nil && cd.Data != nil {
+		cd.Data = &countingReadSeekCloser{rsc: cd.Data,
+			onClose: func(n int64) {
+				metrics.FileBytesReadTotal.Add(float64(n))
+			},
+		}
+	}
+
+	metrics.StorageOperationsDurationSeconds.WithLabelValues("original").Observe(time.Since(start).Seconds())
+
+	metricResult := "ok"
+	if err != nil {
+		metricResult = "error"
+	}
+	metrics.StorageOperationsTotal.WithLabelValues("original", metricResult).Inc()
+
+	return cd, err
+}
+
+// OriginalsSupported reports whether the wrapped storage keeps originals.
+func (ms *MetricsStorage) OriginalsSupported() bool {
+	ost, ok := ms.storage.(files.OriginalStorage)
+	return ok && ost.OriginalsSupported()
+}
+
+type countingReadSeekCloser struct {
+	rsc     io.ReadSeekCloser
 	n       int64
 	onClose func(n int64)
 }
 
-func (c *countingReadCloser) Read(p []byte) (int, error) {
-	k, err := c.rc.Read(p)
+func (c *countingReadSeekCloser) Read(p []byte) (int, error) {
+	k, err := c.rsc.Read(p)
 	c.n += int64(k)
 	return k, err
 }
 
-func (c *countingReadCloser) Close() error {
-	err := c.rc.Close()
+func (c *countingReadSeekCloser) Seek(offset int64, whence int) (int64, error) {
+	return c.rsc.Seek(offset, whence)
+}
+
+func (c *countingReadSeekCloser) Close() error {
+	err := c.rsc.Close()
 	if c.onClose != nil {
 		c.onClose(c.n)
 	}
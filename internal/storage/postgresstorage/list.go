@@ -0,0 +1,156 @@
+package postgresstorage
+
+import (
+	"context"
+	"encoding/json"
+	"file-storage/internal/filedata"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// List returns a page of files matching the query in ascending ID order.
+// All filters are applied by the database. User metadata values are compared
+// in text form, which jsonb containment can only approximate, so the loaded
+// rows are checked against the query again.
+func (s *PostgresStorage) List(ctx context.Context, q *filedata.ListQuery) (*filedata.FileList, error) {
+	fl := filedata.FileList{Files: make([]*filedata.FileInfo, 0, q.Limit)}
+
+	// one more file than the page is needed to know whether the page is the last
+	batch := q.Limit + 1
+	cursor := q.Cursor
+	for {
+		query, args := listQuery(q, cursor, batch)
+		infos, err := s.selectInfos(ctx, query, args)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, fi := range infos {
+			if !q.Match(fi) {
+				continue
+			}
+			if len(fl.Files) == q.Limit {
+				fl.NextCursor = fl.Files[len(fl.Files)-1].ID
+				return &fl, nil
+			}
+			fl.Files = append(fl.Files, fi)
+		}
+
+		if len(infos) < batch {
+			return &fl, nil
+		}
+		cursor = infos[len(infos)-1].ID
+	}
+}
+
+func (s *PostgresStorage) selectInfos(ctx context.Context, query string, args []any) ([]*filedata.FileInfo, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("select files error: %w", err)
+	}
+	defer rows.Close()
+
+	var infos []*filedata.FileInfo
+	for rows.Next() {
+		var info []byte
+		err = rows.Scan(&info)
+		if err != nil {
+			return nil, fmt.Errorf("scan file error: %w", err)
+		}
+		fi, err := unmarshalInfo(info)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, fi)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("select files error: %w", err)
+	}
+
+	return infos, nil
+}
+
+// listQuery builds a query selecting up to limit files after the cursor that
+// match the filters of q. A metadata value matches stored strings and, when it
+// is a number or a boolean in text form, stored values of that type.
+func listQuery(q *filedata.ListQuery, cursor string, limit int) (string, []any) {
+	args := []any{cursor}
+	conditions := []string{"id > $1"}
+	add := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, condition+" $"+strconv.Itoa(len(args)))
+	}
+
+	if q.Public != nil {
+		add("public =", *q.Public)
+	}
+	if q.IsImage != nil {
+		add("is_image =", *q.IsImage)
+	}
+	if q.Format != "" {
+		add("format =", string(q.Format))
+	}
+	if q.MinSize != nil {
+		add("file_size >=", *q.MinSize)
+	}
+	if q.MaxSize != nil {
+		add("file_size <=", *q.MaxSize)
+	}
+	if q.CreatedFrom != nil {
+		add("created_at >=", *q.CreatedFrom)
+	}
+	if q.CreatedTo != nil {
+		add("created_at <", *q.CreatedTo)
+	}
+	if q.UpdatedFrom != nil {
+		add("updated_at >=", *q.UpdatedFrom)
+	}
+	if q.UpdatedTo != nil {
+		add("updated_at <", *q.UpdatedTo)
+	}
+
+	keys := make([]string, 0, len(q.Metadata))
+	for key := range q.Metadata {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		var alternatives []string
+		for _, doc := range metadataDocuments(key, q.Metadata[key]) {
+			args = append(args, doc)
+			alternatives = append(alternatives, "info->'metadata' @> $"+strconv.Itoa(len(args))+"::jsonb")
+		}
+		conditions = append(conditions, "("+strings.Join(alternatives, " OR ")+")")
+	}
+
+	args = append(args, limit)
+	query := "SELECT info FROM files WHERE " + strings.Join(conditions, " AND ") +
+		" ORDER BY id LIMIT $" + strconv.Itoa(len(args))
+
+	return query, args
+}
+
+// metadataDocuments returns jsonb documents holding the metadata key with the
+// value as a string and as every other type the text form can denote.
+func metadataDocuments(key, value string) []string {
+	values := []any{value}
+	if f, err := strconv.ParseFloat(value, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		values = append(values, f)
+	}
+	if value == "true" || value == "false" {
+		values = append(values, value == "true")
+	}
+
+	docs := make([]string, 0, len(values))
+	for _, v := range values {
+		// a string key and a string, number or boolean value always marshal
+		b, _ := json.Marshal(map[string]any{key: v})
+		docs = append(docs, string(b))
+	}
+
+	return docs
+}
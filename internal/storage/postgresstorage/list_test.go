@@ -0,0 +1,73 @@
+package postgresstorage
+
+import (
+	"file-storage/internal/filedata"
+	"file-storage/internal/imgproc"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestListQuery(t *testing.T) {
+	public := true
+	isImage := false
+	minSize := 10
+	maxSize := 20
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+
+	table := []struct {
+		name      string
+		q         filedata.ListQuery
+		wantQuery string
+		wantArgs  []any
+	}{
+		{
+			name:      "no filters",
+			q:         filedata.ListQuery{},
+			wantQuery: "SELECT info FROM files WHERE id > $1 ORDER BY id LIMIT $2",
+			wantArgs:  []any{"cursor", 11},
+		},
+		{
+			name: "all system filters",
+			q: filedata.ListQuery{
+				Public:  &public,
+				IsImage: &isImage,
+				Format:  imgproc.ImgFormatPNG,
+				MinSize: &minSize,
+				MaxSize: &maxSize,
+			},
+			wantQuery: "SELECT info FROM files WHERE id > $1 AND public = $2 AND is_image = $3 AND format = $4" +
+				" AND file_size >= $5 AND file_size <= $6 ORDER BY id LIMIT $7",
+			wantArgs: []any{"cursor", true, false, "png", 10, 20, 11},
+		},
+		{
+			name:      "time range filters",
+			q:         filedata.ListQuery{CreatedFrom: &from, CreatedTo: &to, UpdatedFrom: &from, UpdatedTo: &to},
+			wantQuery: "SELECT info FROM files WHERE id > $1 AND created_at >= $2 AND created_at < $3 AND updated_at >= $4 AND updated_at < $5 ORDER BY id LIMIT $6",
+			wantArgs:  []any{"cursor", from, to, from, to, 11},
+		},
+		{
+			name: "metadata filters",
+			q:    filedata.ListQuery{Metadata: map[string]string{"owner": "1000000", "name": "a", "flag": "true"}},
+			wantQuery: "SELECT info FROM files WHERE id > $1" +
+				" AND (info->'metadata' @> $2::jsonb OR info->'metadata' @> $3::jsonb)" +
+				" AND (info->'metadata' @> $4::jsonb)" +
+				" AND (info->'metadata' @> $5::jsonb OR info->'metadata' @> $6::jsonb) ORDER BY id LIMIT $7",
+			wantArgs: []any{"cursor", `{"flag":"true"}`, `{"flag":true}`, `{"name":"a"}`,
+				`{"owner":"1000000"}`, `{"owner":1000000}`, 11},
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args := listQuery(&tt.q, "cursor", 11)
+			if query != tt.wantQuery {
+				t.Errorf("query got %q want %q", query, tt.wantQuery)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args got %v want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package postgresstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"file-storage/internal/errs"
+	"fmt"
+	"io"
+)
+
+// contentReader reads stored content chunk by chunk. Each chunk is loaded with
+// a separate query. Content replaced or deleted by a concurrent write stays
+// until the grace period passes, so only reads lasting longer than the grace
+// period fail with ErrNotFound.
+type contentReader struct {
+	ctx       context.Context
+	db        *sql.DB
+	contentID int64
+	size      int64
+	chunkSize int64
+
+	offset int64
+	seq    int64
+	chunk  []byte
+}
+
+func (r *contentReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	seq := r.offset / r.chunkSize
+	if r.chunk == nil || seq != r.seq {
+		err := r.load(seq)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	start := r.offset - r.seq*r.chunkSize
+	if start >= int64(len(r.chunk)) {
+		return 0, fmt.Errorf("chunk %d of content %d is shorter than expected: %w", r.seq, r.contentID, errs.ErrInvalidFileData)
+	}
+
+	n := copy(p, r.chunk[start:])
+	r.offset += int64(n)
+
+	return n, nil
+}
+
+func (r *contentReader) load(seq int64) error {
+	var data []byte
+	err := r.db.QueryRowContext(r.ctx,
+		"SELECT data FROM file_chunks WHERE content_id = $1 AND seq = $2", r.contentID, seq,
+	).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errs.ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("select chunk error: %w", err)
+	}
+
+	r.seq = seq
+	r.chunk = data
+
+	return nil
+}
+
+func (r *contentReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.offset + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	r.offset = abs
+
+	return abs, nil
+}
+
+func (r *contentReader) Close() error {
+	r.chunk = nil
+	return nil
+}
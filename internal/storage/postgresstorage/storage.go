@@ -0,0 +1,395 @@
+// Package postgresstorage provides a storage implementation on top of a
+// PostgreSQL database.
+//
+// File metadata is kept in the files table, with the fields used by listing
+// filters stored in columns and the full FileInfo in a jsonb column. Content
+// is kept in the file_contents table and split into fixed-size chunks in the
+// file_chunks table, so content is written and read without loading it into
+// memory. Every write is a single transaction, so readers observe either the
+// old or the new state of a file. Content replaced by a write or left by a
+// deletion is marked orphaned and stays readable for a grace period, after
+// which the sweeper removes it.
+package postgresstorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"file-storage/internal/config"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	// registers the postgres driver for database/sql
+	_ "github.com/lib/pq"
+)
+
+const (
+	// chunkSize is the size of content chunks. The chunk size of stored
+	// content is kept with it, so changing it does not affect existing files.
+	chunkSize = 1 << 20
+
+	// upsertAttempts limits retries when the file is deleted concurrently
+	// between the insert attempt and the update.
+	upsertAttempts = 3
+
+	// connectTimeout limits the connection check and the schema creation in New.
+	connectTimeout = 10 * time.Second
+
+	// schemaLockID is the advisory lock key serializing schema creation by
+	// instances started at the same time.
+	schemaLockID = 7314285501
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS file_contents (
+	id          bigserial PRIMARY KEY,
+	size        bigint NOT NULL DEFAULT 0,
+	chunk_size  integer NOT NULL,
+	orphaned_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS file_contents_orphaned_idx ON file_contents (orphaned_at) WHERE orphaned_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS file_chunks (
+	content_id bigint NOT NULL REFERENCES file_contents (id) ON DELETE CASCADE,
+	seq        integer NOT NULL,
+	data       bytea NOT NULL,
+	PRIMARY KEY (content_id, seq)
+);
+
+CREATE TABLE IF NOT EXISTS files (
+	id         text COLLATE "C" PRIMARY KEY,
+	content_id bigint REFERENCES file_contents (id),
+	public     boolean NOT NULL,
+	is_image   boolean NOT NULL,
+	format     text NOT NULL,
+	file_size  bigint NOT NULL,
+	created_at timestamptz NOT NULL,
+	updated_at timestamptz NOT NULL,
+	info       jsonb NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS files_metadata_idx ON files USING gin ((info->'metadata') jsonb_path_ops);
+`
+
+// PostgresStorage stores file metadata and content in PostgreSQL tables.
+type PostgresStorage struct {
+	db  *sql.DB
+	log *slog.Logger
+
+	sweepInterval time.Duration
+	gracePeriod   time.Duration
+	sweepOnce     sync.Once
+}
+
+// New connects to the database and creates the tables when they do not exist.
+func New(ctx context.Context, cfg *config.Postgres, log *slog.Logger) (*PostgresStorage, error) {
+	db, err := sql.Open("postgres", cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("open database error: %w", err)
+	}
+	db.SetMaxOpenConns(cfg.MaxConns)
+	db.SetMaxIdleConns(cfg.MaxConns)
+
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+
+	err = createSchema(ctx, db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &PostgresStorage{
+		db:  db,
+		log: log,
+
+		sweepInterval: cfg.SweepInterval,
+		gracePeriod:   cfg.GracePeriod,
+	}
+
+	return s, nil
+}
+
+func createSchema(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction error: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", schemaLockID)
+	if err != nil {
+		return fmt.Errorf("schema lock error: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, schema)
+	if err != nil {
+		return fmt.Errorf("schema creation error: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("schema commit error: %w", err)
+	}
+
+	return nil
+}
+
+// Close closes the database connections.
+func (s *PostgresStorage) Close() error {
+	return s.db.Close()
+}
+
+// Upsert writes new content when provided and replaces metadata of the file
+// in one transaction. Writes of the same file are serialized by the row lock.
+// Replaced content is left to the sweeper, so reads that opened it can finish.
+func (s *PostgresStorage) Upsert(ctx context.Context, fd *filedata.FileData) (string, error) {
+	if fd == nil {
+		return "", errs.ErrInvalidFileData
+	}
+	if strings.TrimSpace(fd.ID) == "" {
+		return "", errs.ErrInvalidID
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("begin transaction error: %w", err)
+	}
+	defer tx.Rollback()
+
+	var contentID sql.NullInt64
+	if fd.Data != nil {
+		id, err := writeContent(ctx, tx, fd.Data)
+		if err != nil {
+			return "", err
+		}
+		contentID = sql.NullInt64{Int64: id, Valid: true}
+	}
+
+	// file info is built after the data stream is consumed
+	fi := filedata.FileInfoFromFileData(fd)
+	info, err := json.Marshal(fi)
+	if err != nil {
+		return "", fmt.Errorf("file info marshall error: %w", err)
+	}
+
+	replaced, err := upsertFile(ctx, tx, fi, info, contentID)
+	if err != nil {
+		return "", err
+	}
+
+	if contentID.Valid && replaced.Valid {
+		err = orphanContent(ctx, tx, replaced.Int64)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", fmt.Errorf("commit error: %w", err)
+	}
+
+	return fd.ID, nil
+}
+
+// upsertFile inserts or updates the file row and returns the content id it
+// referenced before. An invalid contentID keeps the current content.
+func upsertFile(ctx context.Context, tx *sql.Tx, fi *filedata.FileInfo, info []byte, contentID sql.NullInt64) (sql.NullInt64, error) {
+	var replaced sql.NullInt64
+
+	for range upsertAttempts {
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO files (id, content_id, public, is_image, format, file_size, created_at, updated_at, info)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT (id) DO NOTHING`,
+			fi.ID, contentID, fi.Public, fi.IsImage, string(fi.Format), fi.FileSize, fi.CreatedAt, fi.UpdatedAt, string(info),
+		)
+		if err != nil {
+			return replaced, fmt.Errorf("insert file error: %w", err)
+		}
+		inserted, err := res.RowsAffected()
+		if err != nil {
+			return replaced, fmt.Errorf("insert file error: %w", err)
+		}
+		if inserted == 1 {
+			return replaced, nil
+		}
+
+		// the row lock serializes writes of the same file
+		err = tx.QueryRowContext(ctx, "SELECT content_id FROM files WHERE id = $1 FOR UPDATE", fi.ID).Scan(&replaced)
+		if errors.Is(err, sql.ErrNoRows) {
+			// the file was deleted meanwhile
+			continue
+		}
+		if err != nil {
+			return replaced, fmt.Errorf("lock file error: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE files SET content_id = COALESCE($2, content_id), public = $3, is_image = $4, format = $5,
+				file_size = $6, created_at = $7, updated_at = $8, info = $9
+			WHERE id = $1`,
+			fi.ID, contentID, fi.Public, fi.IsImage, string(fi.Format), fi.FileSize, fi.CreatedAt, fi.UpdatedAt, string(info),
+		)
+		if err != nil {
+			return replaced, fmt.Errorf("update file error: %w", err)
+		}
+
+		return replaced, nil
+	}
+
+	return replaced, fmt.Errorf("file %s is changed concurrently: %w", fi.ID, errs.ErrStorageFileIsLocked)
+}
+
+// writeContent stores the stream chunk by chunk and returns the content id.
+func writeContent(ctx context.Context, tx *sql.Tx, r io.Reader) (int64, error) {
+	var id int64
+	err := tx.QueryRowContext(ctx, "INSERT INTO file_contents (chunk_size) VALUES ($1) RETURNING id", chunkSize).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("insert content error: %w", err)
+	}
+
+	buf := make([]byte, chunkSize)
+	var size int64
+	for seq := 0; ; seq++ {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, fmt.Errorf("read content error: %w", err)
+		}
+		if n == 0 {
+			break
+		}
+
+		_, err = tx.ExecContext(ctx, "INSERT INTO file_chunks (content_id, seq, data) VALUES ($1, $2, $3)", id, seq, buf[:n])
+		if err != nil {
+			return 0, fmt.Errorf("insert chunk error: %w", err)
+		}
+		size += int64(n)
+
+		if n < chunkSize {
+			break
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE file_contents SET size = $2 WHERE id = $1", id, size)
+	if err != nil {
+		return 0, fmt.Errorf("update content error: %w", err)
+	}
+
+	return id, nil
+}
+
+// Delete removes the file and leaves its content to the sweeper. Deleting a
+// missing file is not an error.
+func (s *PostgresStorage) Delete(ctx context.Context, ID string) error {
+	if len(ID) == 0 {
+		return errs.ErrInvalidID
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction error: %w", err)
+	}
+	defer tx.Rollback()
+
+	var contentID sql.NullInt64
+	err = tx.QueryRowContext(ctx, "DELETE FROM files WHERE id = $1 RETURNING content_id", ID).Scan(&contentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("delete file error: %w", err)
+	}
+
+	if contentID.Valid {
+		err = orphanContent(ctx, tx, contentID.Int64)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("commit error: %w", err)
+	}
+
+	return nil
+}
+
+// orphanContent marks content that no file references any more.
+func orphanContent(ctx context.Context, tx *sql.Tx, contentID int64) error {
+	_, err := tx.ExecContext(ctx, "UPDATE file_contents SET orphaned_at = now() WHERE id = $1", contentID)
+	if err != nil {
+		return fmt.Errorf("orphan content error: %w", err)
+	}
+	return nil
+}
+
+// Info reads file metadata.
+func (s *PostgresStorage) Info(ctx context.Context, ID string) (*filedata.FileInfo, error) {
+	if len(ID) == 0 {
+		return nil, errs.ErrInvalidID
+	}
+
+	var info []byte
+	err := s.db.QueryRowContext(ctx, "SELECT info FROM files WHERE id = $1", ID).Scan(&info)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errs.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("select file error: %w", err)
+	}
+
+	return unmarshalInfo(info)
+}
+
+// Content opens content of the file. Chunks are read lazily, so the returned
+// reader does not hold a database connection between reads.
+func (s *PostgresStorage) Content(ctx context.Context, ID string) (*filedata.ContentData, error) {
+	if len(ID) == 0 {
+		return nil, errs.ErrInvalidID
+	}
+
+	var info []byte
+	var contentID, size, chunk sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT f.info, c.id, c.size, c.chunk_size
+		FROM files f LEFT JOIN file_contents c ON c.id = f.content_id
+		WHERE f.id = $1`, ID).Scan(&info, &contentID, &size, &chunk)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errs.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("select file error: %w", err)
+	}
+	if !contentID.Valid {
+		return nil, errs.ErrNotFound
+	}
+
+	fi, err := unmarshalInfo(info)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &contentReader{ctx: ctx, db: s.db, contentID: contentID.Int64, size: size.Int64, chunkSize: chunk.Int64}
+
+	return &filedata.ContentData{Data: r, Info: fi}, nil
+}
+
+func unmarshalInfo(b []byte) (*filedata.FileInfo, error) {
+	var fi filedata.FileInfo
+	err := json.Unmarshal(b, &fi)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal file info error: %w", err)
+	}
+	return &fi, nil
+}
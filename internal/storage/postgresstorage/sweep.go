@@ -0,0 +1,75 @@
+package postgresstorage
+
+import (
+	"context"
+	"errors"
+	"file-storage/internal/logger"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// sweepBatch limits the number of contents removed by one statement, so a
+// sweep does not hold locks on many chunks at once.
+const sweepBatch = 100
+
+// StartSweeper starts removing orphaned content every sweep interval. A zero
+// interval disables the sweeper.
+func (s *PostgresStorage) StartSweeper(ctx context.Context) {
+	if s.sweepInterval <= 0 {
+		return
+	}
+
+	s.sweepOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(s.sweepInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					removed, err := s.sweep(ctx, time.Now())
+					if err != nil {
+						if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+							return
+						}
+						s.log.Error("content sweep error", slog.Any(logger.LogFieldError, err))
+					}
+					if removed > 0 {
+						s.log.Info("orphaned content removed", "contents", removed)
+					}
+				}
+			}
+		}()
+	})
+}
+
+// sweep removes content that was orphaned before the grace period preceding
+// now, together with its chunks. Younger content may still be read through a
+// file row loaded before the write that replaced or deleted it. It returns the
+// number of removed contents.
+func (s *PostgresStorage) sweep(ctx context.Context, now time.Time) (int, error) {
+	cutoff := now.Add(-s.gracePeriod)
+
+	removed := 0
+	for {
+		res, err := s.db.ExecContext(ctx, `
+			DELETE FROM file_contents WHERE id IN (
+				SELECT id FROM file_contents WHERE orphaned_at < $1 LIMIT $2
+			)`, cutoff, sweepBatch)
+		if err != nil {
+			return removed, fmt.Errorf("delete content error: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return removed, fmt.Errorf("delete content error: %w", err)
+		}
+		removed += int(n)
+
+		if n < sweepBatch {
+			return removed, nil
+		}
+	}
+}
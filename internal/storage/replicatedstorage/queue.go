@@ -0,0 +1,358 @@
+package replicatedstorage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"file-storage/internal/metrics"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// journal operations
+const (
+	opAdd  = "add"
+	opDone = "done"
+)
+
+// record is a line of the queue journal.
+type record struct {
+	Op      string    `json:"op"`
+	Replica string    `json:"replica"`
+	ID      string    `json:"id"`
+	Seq     uint64    `json:"seq"`
+	Since   time.Time `json:"since,omitzero"`
+	Delete  bool      `json:"delete,omitempty"`
+}
+
+type itemKey struct {
+	replica string
+	id      string
+}
+
+// item is a pending copy of a file to a replica. Seq changes with every write
+// of the file, so a copy started before a later write does not complete it.
+// Delete is set when the last write of the file was a deletion.
+type item struct {
+	replica string
+	id      string
+	seq     uint64
+	since   time.Time
+	delete  bool
+}
+
+// number of completion records after which the journal is compacted, unless
+// most of its records are still pending
+const compactThreshold = 10000
+
+// queue keeps pending copies in an append-only journal. Additions are synced
+// to disk before the write they describe is made; completions are not, so
+// after a crash some finished copies are repeated.
+type queue struct {
+	path         string
+	compactAfter int
+
+	mu      sync.Mutex
+	f       *os.File
+	seq     uint64
+	pending map[itemKey]item
+	// completion records written since the last compaction
+	completed int
+}
+
+// openQueue replays the journal at path and rewrites it with pending items
+// only.
+func openQueue(path string) (*queue, error) {
+	q := &queue{path: path, compactAfter: compactThreshold, pending: make(map[itemKey]item)}
+
+	err := os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("create replication queue directory error: %w", err)
+	}
+
+	err = q.replay()
+	if err != nil {
+		return nil, err
+	}
+
+	err = q.compact()
+	if err != nil {
+		return nil, err
+	}
+	q.updateGauges()
+
+	return q, nil
+}
+
+func (q *queue) replay() error {
+	f, err := os.Open(q.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open replication queue error: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec record
+		err = json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			// a torn line of an interrupted append
+			continue
+		}
+
+		q.seq = max(q.seq, rec.Seq)
+		key := itemKey{replica: rec.Replica, id: rec.ID}
+		switch rec.Op {
+		case opAdd:
+			since := rec.Since
+			if it, ok := q.pending[key]; ok {
+				since = it.since
+			}
+			q.pending[key] = item{replica: rec.Replica, id: rec.ID, seq: rec.Seq, since: since, delete: rec.Delete}
+		case opDone:
+			if it, ok := q.pending[key]; ok && it.seq == rec.Seq {
+				delete(q.pending, key)
+			}
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return fmt.Errorf("read replication queue error: %w", err)
+	}
+
+	return nil
+}
+
+// compact replaces the journal with a new one holding pending items only.
+// Supposed q.mu is locked or the queue is not shared yet.
+func (q *queue) compact() error {
+	tmpPath := q.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("create replication queue error: %w", err)
+	}
+
+	w := bufio.NewWriter(tmp)
+	for _, it := range q.items() {
+		err = writeRecord(w, record{Op: opAdd, Replica: it.replica, ID: it.id, Seq: it.seq, Since: it.since, Delete: it.delete})
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("write replication queue error: %w", err)
+	}
+
+	err = os.Rename(tmpPath, q.path)
+	if err != nil {
+		return fmt.Errorf("replace replication queue error: %w", err)
+	}
+	syncDir(filepath.Dir(q.path))
+
+	f, err := os.OpenFile(q.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open replication queue error: %w", err)
+	}
+	if q.f != nil {
+		q.f.Close()
+	}
+	q.f = f
+	q.completed = 0
+
+	return nil
+}
+
+// completedLocked counts n completion records and compacts the journal when
+// they outnumber the pending items and pass the threshold, so the journal of
+// a long running process does not grow without limit. Supposed q.mu is locked.
+func (q *queue) completedLocked(n int) error {
+	q.completed += n
+	if q.completed < q.compactAfter || q.completed <= len(q.pending) {
+		return nil
+	}
+
+	return q.compact()
+}
+
+// add records a pending copy of the file to the replica and returns its
+// sequence number. Del tells whether the write is a deletion. The record is
+// on disk when add returns.
+func (q *queue) add(replica, ID string, del bool) (uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq++
+	key := itemKey{replica: replica, id: ID}
+	it := item{replica: replica, id: ID, seq: q.seq, since: time.Now(), delete: del}
+	if prev, ok := q.pending[key]; ok {
+		it.since = prev.since
+	}
+
+	err := writeRecord(q.f, record{Op: opAdd, Replica: replica, ID: ID, Seq: it.seq, Since: it.since, Delete: del})
+	if err != nil {
+		return 0, fmt.Errorf("append replication queue error: %w", err)
+	}
+	err = q.f.Sync()
+	if err != nil {
+		return 0, fmt.Errorf("sync replication queue error: %w", err)
+	}
+
+	q.pending[key] = it
+	q.updateGaugesLocked()
+
+	return it.seq, nil
+}
+
+// done removes the pending copy unless the file was written again since the
+// copy with sequence number seq was queued.
+func (q *queue) done(replica, ID string, seq uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := itemKey{replica: replica, id: ID}
+	it, ok := q.pending[key]
+	if !ok || it.seq != seq {
+		return nil
+	}
+	delete(q.pending, key)
+	q.updateGaugesLocked()
+
+	err := writeRecord(q.f, record{Op: opDone, Replica: replica, ID: ID, Seq: seq})
+	if err != nil {
+		return fmt.Errorf("append replication queue error: %w", err)
+	}
+
+	return q.completedLocked(1)
+}
+
+// drop removes pending copies to replicas not listed in replicas.
+func (q *queue) drop(replicas map[string]bool) []item {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var dropped []item
+	for key, it := range q.pending {
+		if replicas[key.replica] {
+			continue
+		}
+		delete(q.pending, key)
+		dropped = append(dropped, it)
+		writeRecord(q.f, record{Op: opDone, Replica: it.replica, ID: it.id, Seq: it.seq})
+	}
+	q.completedLocked(len(dropped))
+	q.updateGaugesLocked()
+
+	return dropped
+}
+
+// deleting reports whether a deletion of the file is pending for the replica.
+func (q *queue) deleting(replica, ID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	it, ok := q.pending[itemKey{replica: replica, id: ID}]
+	return ok && it.delete
+}
+
+// snapshot returns pending copies, oldest first.
+func (q *queue) snapshot() []item {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.items()
+}
+
+func (q *queue) items() []item {
+	items := make([]item, 0, len(q.pending))
+	for _, it := range q.pending {
+		items = append(items, it)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].since.Equal(items[j].since) {
+			return items[i].since.Before(items[j].since)
+		}
+		return items[i].seq < items[j].seq
+	})
+
+	return items
+}
+
+func (q *queue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.pending)
+}
+
+func (q *queue) close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.f.Close()
+}
+
+func (q *queue) updateGauges() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.updateGaugesLocked()
+}
+
+// updateGaugesLocked publishes the queue length and the age of the oldest
+// pending copy. Supposed q.mu is locked.
+func (q *queue) updateGaugesLocked() {
+	var oldest time.Time
+	for _, it := range q.pending {
+		if oldest.IsZero() || it.since.Before(oldest) {
+			oldest = it.since
+		}
+	}
+
+	lag := 0.0
+	if !oldest.IsZero() {
+		lag = time.Since(oldest).Seconds()
+	}
+
+	metrics.ReplicationPending.Set(float64(len(q.pending)))
+	metrics.ReplicationLagSeconds.Set(lag)
+}
+
+func writeRecord(w io.Writer, rec record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// syncDir makes a rename in the directory durable. Errors are ignored as not
+// every file system supports syncing directories.
+func syncDir(path string) {
+	d, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,652 @@
+// Package replicatedstorage provides a storage wrapper that copies writes of a
+// primary storage to one or more replicas and falls back to the replicas when
+// the primary storage fails to serve a read.
+//
+// Every write is recorded in a durable queue before it reaches the primary
+// storage. A queued copy brings the replica to the current state of the file
+// in the primary storage rather than repeating the write, so copies may be
+// retried, reordered or repeated after a crash. A file missing in the primary
+// storage is deleted in a replica only when its last write was a deletion, so
+// a primary storage that lost files can be refilled from the replicas with
+// Resync. In sync mode a write returns
+// after its copies are made or, when a replica fails, queued for retry; in
+// async mode the copies are made in the background.
+package replicatedstorage
+
+import (
+	"context"
+	"errors"
+	"file-storage/internal/config"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"file-storage/internal/files"
+	"file-storage/internal/logger"
+	"file-storage/internal/metrics"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Replica is a named secondary storage. The name identifies the replica in
+// the queue and in metrics, so it must be stable across restarts.
+type Replica struct {
+	Name    string
+	Storage files.Storage
+}
+
+// ReplicatedStorage writes files to the primary storage and its replicas.
+type ReplicatedStorage struct {
+	primary       files.Storage
+	replicas      []Replica
+	sync          bool
+	retryInterval time.Duration
+	queue         *queue
+	log           *slog.Logger
+	locks         idLocks
+
+	// writes in progress by file ID; their queued copies wait until the
+	// primary storage is written
+	mu       sync.Mutex
+	inflight map[string]int
+
+	startOnce sync.Once
+	wake      chan struct{}
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+// New creates a replicated storage and opens the queue at cfg.QueuePath.
+// Queued copies to replicas that are no longer configured are dropped.
+func New(primary files.Storage, replicas []Replica, cfg *config.Replication, log *slog.Logger) (*ReplicatedStorage, error) {
+	q, err := openQueue(cfg.QueuePath)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &ReplicatedStorage{
+		primary:       primary,
+		replicas:      replicas,
+		sync:          cfg.Mode == config.ReplicationModeSync,
+		retryInterval: cfg.RetryInterval,
+		queue:         q,
+		log:           log.With("storage", "replicated"),
+		inflight:      make(map[string]int),
+		wake:          make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+
+	names := make(map[string]bool, len(replicas))
+	for _, replica := range replicas {
+		names[replica.Name] = true
+	}
+	for _, it := range q.drop(names) {
+		r.log.Warn("queued copy to unknown replica dropped", "replica", it.replica, "id", it.id)
+	}
+
+	return r, nil
+}
+
+// Start starts copying queued files to the replicas. Failed copies are
+// retried every retry interval.
+func (r *ReplicatedStorage) Start(ctx context.Context) {
+	r.startOnce.Do(func() {
+		go func() {
+			defer close(r.stopped)
+
+			ticker := time.NewTicker(r.retryInterval)
+			defer ticker.Stop()
+
+			for {
+				r.retry(ctx)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-r.stop:
+					return
+				case <-ticker.C:
+				case <-r.wake:
+				}
+			}
+		}()
+	})
+}
+
+// Close stops the background copying and closes the queue and all storages.
+// Copies still queued are made after the next start.
+func (r *ReplicatedStorage) Close() error {
+	started := true
+	r.startOnce.Do(func() { started = false })
+	if started {
+		close(r.stop)
+		<-r.stopped
+	}
+
+	errList := []error{r.queue.close()}
+
+	storages := []files.Storage{r.primary}
+	for _, replica := range r.replicas {
+		storages = append(storages, replica.Storage)
+	}
+	for _, s := range storages {
+		if closer, ok := s.(io.Closer); ok {
+			errList = append(errList, closer.Close())
+		}
+	}
+
+	return errors.Join(errList...)
+}
+
+// Upsert writes the file to the primary storage and replicates it.
+func (r *ReplicatedStorage) Upsert(ctx context.Context, fd *filedata.FileData) (string, error) {
+	if fd == nil {
+		return "", errs.ErrInvalidFileData
+	}
+	if strings.TrimSpace(fd.ID) == "" {
+		return "", errs.ErrInvalidID
+	}
+
+	var id string
+	err := r.write(ctx, fd.ID, false, func() error {
+		var err error
+		id, err = r.primary.Upsert(ctx, fd)
+		return err
+	})
+
+	return id, err
+}
+
+// Delete removes the file from the primary storage and replicates the removal.
+func (r *ReplicatedStorage) Delete(ctx context.Context, ID string) error {
+	if len(ID) == 0 {
+		return errs.ErrInvalidID
+	}
+
+	return r.write(ctx, ID, true, func() error {
+		return r.primary.Delete(ctx, ID)
+	})
+}
+
+// write queues copies of the file to every replica, applies the write to the
+// primary storage and, in sync mode, makes the copies. Del tells whether the
+// write is a deletion. The copies stay queued when the write fails, as a
+// failed write may still have changed the file.
+func (r *ReplicatedStorage) write(ctx context.Context, ID string, del bool, apply func() error) error {
+	r.begin(ID)
+	seqs, err := r.enqueue(ID, del)
+	if err == nil {
+		err = apply()
+	}
+	r.end(ID)
+	if err != nil || !r.sync {
+		r.notify()
+		return err
+	}
+
+	failed := false
+	for i, replica := range r.replicas {
+		if !r.copy(ctx, replica, ID, seqs[i], del) {
+			failed = true
+		}
+	}
+	if failed {
+		r.notify()
+	}
+
+	return nil
+}
+
+func (r *ReplicatedStorage) enqueue(ID string, del bool) ([]uint64, error) {
+	seqs := make([]uint64, len(r.replicas))
+	for i, replica := range r.replicas {
+		seq, err := r.queue.add(replica.Name, ID, del)
+		if err != nil {
+			return nil, err
+		}
+		seqs[i] = seq
+	}
+
+	return seqs, nil
+}
+
+func (r *ReplicatedStorage) begin(ID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.inflight[ID]++
+}
+
+func (r *ReplicatedStorage) end(ID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.inflight[ID]--
+	if r.inflight[ID] == 0 {
+		delete(r.inflight, ID)
+	}
+}
+
+// writing reports whether a write of the file is in progress.
+func (r *ReplicatedStorage) writing(ID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.inflight[ID] > 0
+}
+
+// notify wakes the background copying.
+func (r *ReplicatedStorage) notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// retry makes queued copies, oldest first. Copies of files being written are
+// skipped; the write wakes the background copying when it completes.
+func (r *ReplicatedStorage) retry(ctx context.Context) {
+	for _, it := range r.queue.snapshot() {
+		if ctx.Err() != nil {
+			return
+		}
+		if r.writing(it.id) {
+			continue
+		}
+
+		for _, replica := range r.replicas {
+			if replica.Name == it.replica {
+				r.copy(ctx, replica, it.id, it.seq, it.delete)
+				break
+			}
+		}
+	}
+	r.queue.updateGauges()
+}
+
+// copy replicates the file and completes the queued copy with sequence
+// number seq. It reports whether the copy succeeded.
+func (r *ReplicatedStorage) copy(ctx context.Context, replica Replica, ID string, seq uint64, del bool) bool {
+	err := r.replicate(ctx, replica, ID, del)
+	if err != nil {
+		r.log.Warn("replication failed", "replica", replica.Name, "id", ID, slog.Any(logger.LogFieldError, err))
+		metrics.ReplicationErrorsTotal.WithLabelValues(replica.Name).Inc()
+		return false
+	}
+
+	err = r.queue.done(replica.Name, ID, seq)
+	if err != nil {
+		// the copy is repeated after restart
+		r.log.Warn("replication queue update failed", "replica", replica.Name, "id", ID, slog.Any(logger.LogFieldError, err))
+	}
+
+	return true
+}
+
+// replicate brings the file in the replica to its state in the primary
+// storage. Content is copied only when it differs from the replica one. A file
+// missing in the primary storage is deleted in the replica only when del is
+// set; otherwise the primary storage lost it or failed to create it, and the
+// replica copy is kept.
+func (r *ReplicatedStorage) replicate(ctx context.Context, replica Replica, ID string, del bool) error {
+	unlock := r.locks.lock(replica.Name + "/" + ID)
+	defer unlock()
+
+	cd, err := r.primary.Content(ctx, ID)
+	if errors.Is(err, errs.ErrNotFound) {
+		if !del {
+			r.log.Warn("file missing in primary storage, replica copy kept", "replica", replica.Name, "id", ID)
+			return nil
+		}
+		err = replica.Storage.Delete(ctx, ID)
+		if err != nil && !errors.Is(err, errs.ErrNotFound) {
+			return fmt.Errorf("replica delete error: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("primary storage content error: %w", err)
+	}
+	defer cd.Data.Close()
+
+	current, err := replica.Storage.Info(ctx, ID)
+	if err != nil && !errors.Is(err, errs.ErrNotFound) {
+		return fmt.Errorf("replica info error: %w", err)
+	}
+
+	var data io.Reader = cd.Data
+	if err == nil && sameContent(current, cd.Info) {
+		data = nil
+	}
+
+	_, err = replica.Storage.Upsert(ctx, fileData(cd.Info, data))
+	if err != nil {
+		return fmt.Errorf("replica upsert error: %w", err)
+	}
+
+	return nil
+}
+
+func sameContent(a, b *filedata.FileInfo) bool {
+	return a.ContentHash() != "" && a.ContentHash() == b.ContentHash() && a.FileSize == b.FileSize
+}
+
+// Resync copies files the primary storage is missing from the replicas, for
+// example after its disk was replaced with an empty one. Files with a pending
+// deletion are skipped. The copies are replicated like other writes, so
+// replicas missing the files get them too. Resync returns the number of files
+// copied; a file that fails to copy is logged and skipped.
+func (r *ReplicatedStorage) Resync(ctx context.Context) (int, error) {
+	count := 0
+	for _, replica := range r.replicas {
+		q := filedata.ListQuery{Limit: resyncBatch}
+		for {
+			fl, err := replica.Storage.List(ctx, &q)
+			if err != nil {
+				return count, fmt.Errorf("replica %s list error: %w", replica.Name, err)
+			}
+
+			for _, fi := range fl.Files {
+				if ctx.Err() != nil {
+					return count, ctx.Err()
+				}
+
+				ok, err := r.resync(ctx, replica, fi.ID)
+				if err != nil {
+					r.log.Warn("resync failed", "replica", replica.Name, "id", fi.ID, slog.Any(logger.LogFieldError, err))
+					continue
+				}
+				if ok {
+					count++
+				}
+			}
+
+			if fl.NextCursor == "" {
+				break
+			}
+			q.Cursor = fl.NextCursor
+		}
+	}
+
+	return count, nil
+}
+
+// number of files listed at once by Resync
+const resyncBatch = 1000
+
+// resync copies the file from the replica to the primary storage when the
+// primary storage does not have it. It reports whether the file was copied.
+func (r *ReplicatedStorage) resync(ctx context.Context, replica Replica, ID string) (bool, error) {
+	if r.queue.deleting(replica.Name, ID) {
+		return false, nil
+	}
+
+	_, err := r.primary.Info(ctx, ID)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, errs.ErrNotFound) {
+		return false, fmt.Errorf("primary storage info error: %w", err)
+	}
+
+	err = r.write(ctx, ID, false, func() error {
+		cd, err := replica.Storage.Content(ctx, ID)
+		if err != nil {
+			return fmt.Errorf("replica content error: %w", err)
+		}
+		defer cd.Data.Close()
+
+		_, err = r.primary.Upsert(ctx, fileData(cd.Info, cd.Data))
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Info reads metadata from the primary storage, falling back to the replicas
+// when it fails or does not have the file.
+func (r *ReplicatedStorage) Info(ctx context.Context, ID string) (*filedata.FileInfo, error) {
+	return read(ctx, r, ID, func(s files.Storage) (*filedata.FileInfo, error) {
+		return s.Info(ctx, ID)
+	})
+}
+
+// Content reads content from the primary storage, falling back to the
+// replicas when it fails or does not have the file.
+func (r *ReplicatedStorage) Content(ctx context.Context, ID string) (*filedata.ContentData, error) {
+	return read(ctx, r, ID, func(s files.Storage) (*filedata.ContentData, error) {
+		return s.Content(ctx, ID)
+	})
+}
+
+// List lists files of the primary storage, falling back to the replicas when
+// it fails.
+func (r *ReplicatedStorage) List(ctx context.Context, q *filedata.ListQuery) (*filedata.FileList, error) {
+	return read(ctx, r, "", func(s files.Storage) (*filedata.FileList, error) {
+		return s.List(ctx, q)
+	})
+}
+
+// read runs the read of the file on the primary storage and, when it fails
+// for a reason other than an invalid request, on the replicas in order. A
+// file missing in the primary storage is not looked up in a replica with its
+// deletion pending. Replicas may lag behind the primary storage, so a failed
+// over read may return stale data.
+func read[T any](ctx context.Context, r *ReplicatedStorage, ID string, fn func(files.Storage) (T, error)) (T, error) {
+	v, err := fn(r.primary)
+	if err == nil || !failover(ctx, err) {
+		return v, err
+	}
+
+	notFound := errors.Is(err, errs.ErrNotFound)
+	for _, replica := range r.replicas {
+		if notFound && r.queue.deleting(replica.Name, ID) {
+			continue
+		}
+		rv, rerr := fn(replica.Storage)
+		if rerr == nil {
+			r.log.Warn("read served by replica", "replica", replica.Name, slog.Any(logger.LogFieldError, err))
+			metrics.ReplicationFailoversTotal.Inc()
+			return rv, nil
+		}
+	}
+
+	return v, err
+}
+
+// failover reports whether a read failed because of the storage rather than
+// the request.
+func failover(ctx context.Context, err error) bool {
+	return ctx.Err() == nil &&
+		!errors.Is(err, errs.ErrInvalidID) &&
+		!errors.Is(err, errs.ErrInvalidFileData)
+}
+
+// Rendition delegates rendition lookup to the primary storage when it
+// implements files.RenditionCache.
+func (r *ReplicatedStorage) Rendition(ctx context.Context, key filedata.RenditionKey) (io.ReadSeekCloser, error) {
+	rc, ok := r.primary.(files.RenditionCache)
+	if !ok {
+		return nil, errs.ErrNotFound
+	}
+	return rc.Rendition(ctx, key)
+}
+
+// PutRendition delegates rendition write to the primary storage when it
+// implements files.RenditionCache. Renditions are not replicated.
+func (r *ReplicatedStorage) PutRendition(ctx context.Context, key filedata.RenditionKey, data []byte) error {
+	rc, ok := r.primary.(files.RenditionCache)
+	if !ok {
+		return nil
+	}
+	return rc.PutRendition(ctx, key, data)
+}
+
+// Versions delegates version listing to the primary storage when it
+// implements files.VersionStorage. Replicas keep current versions only.
+func (r *ReplicatedStorage) Versions(ctx context.Context, ID string) ([]*filedata.VersionInfo, error) {
+	vs, ok := r.primary.(files.VersionStorage)
+	if !ok {
+		return nil, errs.ErrVersionsNotSupported
+	}
+	return vs.Versions(ctx, ID)
+}
+
+// VersionContent delegates version content read to the primary storage when
+// it implements files.VersionStorage.
+func (r *ReplicatedStorage) VersionContent(ctx context.Context, ID string, version int) (*filedata.ContentData, error) {
+	vs, ok := r.primary.(files.VersionStorage)
+	if !ok {
+		return nil, errs.ErrVersionsNotSupported
+	}
+	return vs.VersionContent(ctx, ID, version)
+}
+
+// RestoreVersion delegates version restore to the primary storage when it
+// implements files.VersionStorage and replicates the restored file.
+func (r *ReplicatedStorage) RestoreVersion(ctx context.Context, ID string, version int) (*filedata.FileInfo, error) {
+	vs, ok := r.primary.(files.VersionStorage)
+	if !ok {
+		return nil, errs.ErrVersionsNotSupported
+	}
+
+	var fi *filedata.FileInfo
+	err := r.write(ctx, ID, false, func() error {
+		var err error
+		fi, err = vs.RestoreVersion(ctx, ID, version)
+		return err
+	})
+
+	return fi, err
+}
+
+// Trash delegates trash listing to the primary storage when it implements
+// files.TrashStorage. Replicas do not keep deleted files.
+func (r *ReplicatedStorage) Trash(ctx context.Context, cursor string, limit int) (*filedata.TrashList, error) {
+	ts, ok := r.primary.(files.TrashStorage)
+	if !ok {
+		return nil, errs.ErrTrashNotSupported
+	}
+	return ts.Trash(ctx, cursor, limit)
+}
+
+// Restore delegates restore of a deleted file to the primary storage when it
+// implements files.TrashStorage and replicates the restored file.
+func (r *ReplicatedStorage) Restore(ctx context.Context, ID string) (*filedata.FileInfo, error) {
+	ts, ok := r.primary.(files.TrashStorage)
+	if !ok {
+		return nil, errs.ErrTrashNotSupported
+	}
+
+	var fi *filedata.FileInfo
+	err := r.write(ctx, ID, false, func() error {
+		var err error
+		fi, err = ts.Restore(ctx, ID)
+		return err
+	})
+
+	return fi, err
+}
+
+// Backup delegates backup to the primary storage when it implements
+// files.BackupStorage.
+func (r *ReplicatedStorage) Backup(ctx context.Context, w io.Writer) error {
+	bs, ok := r.primary.(files.BackupStorage)
+	if !ok {
+		return errs.ErrBackupNotSupported
+	}
+	return bs.Backup(ctx, w)
+}
+
+// Scrub delegates the integrity check of a file to the primary storage when
+// it implements files.ScrubStorage. A version quarantined by the primary
+// storage is not replicated, so the replicas keep their copies.
+func (r *ReplicatedStorage) Scrub(ctx context.Context, ID string) (*filedata.ScrubResult, error) {
+	ss, ok := r.primary.(files.ScrubStorage)
+	if !ok {
+		return nil, errs.ErrScrubNotSupported
+	}
+	return ss.Scrub(ctx, ID)
+}
+
+// Original delegates the original upload read to the primary storage when it
+// implements files.OriginalStorage. Replicas keep stored content only.
+func (r *ReplicatedStorage) Original(ctx context.Context, ID string) (*filedata.ContentData, error) {
+	ost, ok := r.primary.(files.OriginalStorage)
+	if !ok {
+		return nil, errs.ErrOriginalsNotSupported
+	}
+	return ost.Original(ctx, ID)
+}
+
+// OriginalsSupported reports whether the primary storage keeps originals.
+func (r *ReplicatedStorage) OriginalsSupported() bool {
+	ost, ok := r.primary.(files.OriginalStorage)
+	return ok && ost.OriginalsSupported()
+}
+
+// fileData builds FileData for copying the file to a replica.
+func fileData(fi *filedata.FileInfo, data io.Reader) *filedata.FileData {
+	return &filedata.FileData{
+		ID:         fi.ID,
+		Data:       data,
+		HashSource: fi.HashSource,
+		HashStored: fi.HashStored,
+		Public:     fi.Public,
+		FileSize:   fi.FileSize,
+		IsImage:    fi.IsImage,
+		Format:     fi.Format,
+		Width:      fi.Width,
+		Height:     fi.Height,
+		MimeType:   fi.MimeType,
+		Filename:   fi.Filename,
+		Metadata:   fi.Metadata,
+		CreatedAt:  fi.CreatedAt,
+		UpdatedAt:  fi.UpdatedAt,
+	}
+}
+
+// idLocks serializes copies of the same file to the same replica.
+type idLocks struct {
+	mu    sync.Mutex
+	locks map[string]*idLock
+}
+
+type idLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lock acquires the lock of the key and returns the function releasing it.
+func (l *idLocks) lock(key string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*idLock)
+	}
+	il := l.locks[key]
+	if il == nil {
+		il = &idLock{}
+		l.locks[key] = il
+	}
+	il.refs++
+	l.mu.Unlock()
+
+	il.mu.Lock()
+
+	return func() {
+		il.mu.Unlock()
+
+		l.mu.Lock()
+		il.refs--
+		if il.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
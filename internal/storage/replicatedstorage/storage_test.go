@@ -0,0 +1,404 @@
+package replicatedstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"file-storage/internal/config"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"file-storage/internal/files"
+	"file-storage/internal/logger"
+	"file-storage/internal/storage/inmemory"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failingStorage fails all operations of the wrapped storage while fail is set.
+type failingStorage struct {
+	files.Storage
+	fail atomic.Bool
+}
+
+var errStorage = errors.New("storage error")
+
+func (s *failingStorage) Upsert(ctx context.Context, fd *filedata.FileData) (string, error) {
+	if s.fail.Load() {
+		return "", errStorage
+	}
+	return s.Storage.Upsert(ctx, fd)
+}
+
+func (s *failingStorage) Info(ctx context.Context, ID string) (*filedata.FileInfo, error) {
+	if s.fail.Load() {
+		return nil, errStorage
+	}
+	return s.Storage.Info(ctx, ID)
+}
+
+func (s *failingStorage) Content(ctx context.Context, ID string) (*filedata.ContentData, error) {
+	if s.fail.Load() {
+		return nil, errStorage
+	}
+	return s.Storage.Content(ctx, ID)
+}
+
+func (s *failingStorage) Delete(ctx context.Context, ID string) error {
+	if s.fail.Load() {
+		return errStorage
+	}
+	return s.Storage.Delete(ctx, ID)
+}
+
+func newTestStorage(t *testing.T, mode string) (*ReplicatedStorage, *failingStorage, *failingStorage) {
+	t.Helper()
+
+	primary := &failingStorage{Storage: inmemory.New()}
+	replica := &failingStorage{Storage: inmemory.New()}
+	cfg := config.Replication{
+		Mode:          mode,
+		QueuePath:     filepath.Join(t.TempDir(), "queue.log"),
+		RetryInterval: time.Hour,
+	}
+
+	rs, err := New(primary, []Replica{{Name: "replica", Storage: replica}}, &cfg, logger.NewBootstrap())
+	if err != nil {
+		t.Fatalf("new storage error: %v", err)
+	}
+	t.Cleanup(func() { rs.Close() })
+
+	return rs, primary, replica
+}
+
+func upsert(t *testing.T, s files.Storage, fd *filedata.FileData) {
+	t.Helper()
+
+	_, err := s.Upsert(context.Background(), fd)
+	if err != nil {
+		t.Fatalf("upsert error: %v", err)
+	}
+}
+
+func readContent(t *testing.T, s files.Storage, id string) string {
+	t.Helper()
+
+	cd, err := s.Content(context.Background(), id)
+	if err != nil {
+		t.Fatalf("content error: %v", err)
+	}
+	defer cd.Data.Close()
+
+	b, err := io.ReadAll(cd.Data)
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	return string(b)
+}
+
+func TestSyncReplication(t *testing.T) {
+	rs, _, replica := newTestStorage(t, config.ReplicationModeSync)
+	ctx := context.Background()
+
+	upsert(t, rs, &filedata.FileData{ID: "1", Data: bytes.NewReader([]byte("abc")), HashStored: "h1", FileSize: 3})
+	if got := readContent(t, replica, "1"); got != "abc" {
+		t.Errorf("replica content got %q want %q", got, "abc")
+	}
+
+	// metadata update keeps replica content
+	upsert(t, rs, &filedata.FileData{ID: "1", HashStored: "h1", FileSize: 3, Filename: "a.txt"})
+	fi, err := replica.Info(ctx, "1")
+	if err != nil {
+		t.Fatalf("replica info error: %v", err)
+	}
+	if fi.Filename != "a.txt" {
+		t.Errorf("replica filename got %q want %q", fi.Filename, "a.txt")
+	}
+	if got := readContent(t, replica, "1"); got != "abc" {
+		t.Errorf("replica content got %q want %q", got, "abc")
+	}
+
+	// a failed copy is queued and the write succeeds
+	replica.fail.Store(true)
+	upsert(t, rs, &filedata.FileData{ID: "2", Data: bytes.NewReader([]byte("def")), FileSize: 3})
+	err = rs.Delete(ctx, "1")
+	if err != nil {
+		t.Fatalf("delete error: %v", err)
+	}
+	if got := rs.queue.len(); got != 2 {
+		t.Errorf("pending got %d want 2", got)
+	}
+
+	replica.fail.Store(false)
+	rs.retry(ctx)
+	if got := rs.queue.len(); got != 0 {
+		t.Errorf("pending got %d want 0", got)
+	}
+	if got := readContent(t, replica, "2"); got != "def" {
+		t.Errorf("replica content got %q want %q", got, "def")
+	}
+	_, err = replica.Info(ctx, "1")
+	if !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("replica info error got %v want %v", err, errs.ErrNotFound)
+	}
+}
+
+func TestAsyncReplication(t *testing.T) {
+	rs, _, replica := newTestStorage(t, config.ReplicationModeAsync)
+	rs.Start(context.Background())
+
+	upsert(t, rs, &filedata.FileData{ID: "1", Data: bytes.NewReader([]byte("abc")), FileSize: 3})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for rs.queue.len() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("file is not replicated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := readContent(t, replica, "1"); got != "abc" {
+		t.Errorf("replica content got %q want %q", got, "abc")
+	}
+}
+
+func TestFailover(t *testing.T) {
+	rs, primary, replica := newTestStorage(t, config.ReplicationModeSync)
+	ctx := context.Background()
+
+	upsert(t, rs, &filedata.FileData{ID: "1", Data: bytes.NewReader([]byte("abc")), FileSize: 3})
+	upsert(t, replica, &filedata.FileData{ID: "2", Data: bytes.NewReader([]byte("def")), FileSize: 3})
+
+	// the deletion of file 3 in the replica stays pending
+	upsert(t, rs, &filedata.FileData{ID: "3", Data: bytes.NewReader([]byte("ghi")), FileSize: 3})
+	replica.fail.Store(true)
+	err := rs.Delete(ctx, "3")
+	if err != nil {
+		t.Fatalf("delete error: %v", err)
+	}
+	replica.fail.Store(false)
+
+	table := []struct {
+		name         string
+		id           string
+		primaryFails bool
+		want         string
+		wantErr      error
+	}{
+		{name: "served by primary", id: "1", want: "abc"},
+		{name: "served by replica", id: "1", primaryFails: true, want: "abc"},
+		{name: "missing file served by replica", id: "2", want: "def"},
+		{name: "pending deletion is not failed over", id: "3", wantErr: errs.ErrNotFound},
+		{name: "missing everywhere", id: "4", wantErr: errs.ErrNotFound},
+		{name: "all storages fail", id: "1", primaryFails: true, wantErr: errStorage},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			primary.fail.Store(tt.primaryFails)
+			replica.fail.Store(errors.Is(tt.wantErr, errStorage))
+			defer primary.fail.Store(false)
+			defer replica.fail.Store(false)
+
+			_, err := rs.Info(ctx, tt.id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("info error got %v want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil {
+				if got := readContent(t, rs, tt.id); got != tt.want {
+					t.Errorf("content got %q want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestQueueReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.log")
+
+	q, err := openQueue(path)
+	if err != nil {
+		t.Fatalf("open queue error: %v", err)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		_, err = q.add("replica", id, false)
+		if err != nil {
+			t.Fatalf("add error: %v", err)
+		}
+	}
+	seq, err := q.add("replica", "1", false)
+	if err != nil {
+		t.Fatalf("add error: %v", err)
+	}
+	// a completion with a stale sequence number keeps the copy queued
+	err = q.done("replica", "2", 1)
+	if err != nil {
+		t.Fatalf("done error: %v", err)
+	}
+	err = q.done("replica", "1", seq)
+	if err != nil {
+		t.Fatalf("done error: %v", err)
+	}
+	q.close()
+
+	// a torn line of an interrupted append is skipped
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	f.WriteString(`{"op":"done","repl`)
+	f.Close()
+
+	q, err = openQueue(path)
+	if err != nil {
+		t.Fatalf("open queue error: %v", err)
+	}
+	defer q.close()
+
+	var ids []string
+	for _, it := range q.snapshot() {
+		ids = append(ids, it.id)
+	}
+	if want := []string{"2", "3"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("pending got %v want %v", ids, want)
+	}
+
+	seq, err = q.add("replica", "4", true)
+	if err != nil {
+		t.Fatalf("add error: %v", err)
+	}
+	if seq != 5 {
+		t.Errorf("seq got %d want 5", seq)
+	}
+	q.close()
+
+	// a pending deletion survives a restart
+	q, err = openQueue(path)
+	if err != nil {
+		t.Fatalf("open queue error: %v", err)
+	}
+	defer q.close()
+	if !q.deleting("replica", "4") {
+		t.Errorf("deletion of 4 is not pending")
+	}
+	if q.deleting("replica", "3") {
+		t.Errorf("deletion of 3 is pending")
+	}
+}
+
+func TestQueueCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.log")
+
+	q, err := openQueue(path)
+	if err != nil {
+		t.Fatalf("open queue error: %v", err)
+	}
+	defer q.close()
+	q.compactAfter = 10
+
+	lines := func() int {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read queue error: %v", err)
+		}
+		return bytes.Count(b, []byte("\n"))
+	}
+
+	_, err = q.add("replica", "pending", false)
+	if err != nil {
+		t.Fatalf("add error: %v", err)
+	}
+	for i := range 9 {
+		id := strconv.Itoa(i)
+		seq, err := q.add("replica", id, false)
+		if err != nil {
+			t.Fatalf("add error: %v", err)
+		}
+		err = q.done("replica", id, seq)
+		if err != nil {
+			t.Fatalf("done error: %v", err)
+		}
+	}
+	if got := lines(); got != 19 {
+		t.Errorf("journal lines got %d want 19", got)
+	}
+
+	seq, err := q.add("replica", "9", false)
+	if err != nil {
+		t.Fatalf("add error: %v", err)
+	}
+	err = q.done("replica", "9", seq)
+	if err != nil {
+		t.Fatalf("done error: %v", err)
+	}
+	if got := lines(); got != 1 {
+		t.Errorf("journal lines after compaction got %d want 1", got)
+	}
+
+	// the compacted journal is appended to
+	_, err = q.add("replica", "10", false)
+	if err != nil {
+		t.Fatalf("add error: %v", err)
+	}
+	if got := lines(); got != 2 {
+		t.Errorf("journal lines got %d want 2", got)
+	}
+}
+
+func TestLostPrimaryFiles(t *testing.T) {
+	rs, primary, replica := newTestStorage(t, config.ReplicationModeSync)
+	ctx := context.Background()
+
+	upsert(t, rs, &filedata.FileData{ID: "1", Data: bytes.NewReader([]byte("abc")), FileSize: 3, Filename: "a.txt"})
+	upsert(t, rs, &filedata.FileData{ID: "2", Data: bytes.NewReader([]byte("def")), FileSize: 3})
+	upsert(t, rs, &filedata.FileData{ID: "3", Data: bytes.NewReader([]byte("ghi")), FileSize: 3})
+	err := rs.Delete(ctx, "3")
+	if err != nil {
+		t.Fatalf("delete error: %v", err)
+	}
+
+	// the primary storage loses its files, e.g. on a replaced disk
+	primary.Storage = inmemory.New()
+
+	// a queued copy of a lost file does not delete the replica copy
+	replica.fail.Store(true)
+	upsert(t, rs, &filedata.FileData{ID: "2", Data: bytes.NewReader([]byte("xyz")), FileSize: 3})
+	primary.Storage = inmemory.New()
+	replica.fail.Store(false)
+	rs.retry(ctx)
+	if got := rs.queue.len(); got != 0 {
+		t.Errorf("pending got %d want 0", got)
+	}
+	if got := readContent(t, replica, "2"); got != "def" {
+		t.Errorf("replica content got %q want %q", got, "def")
+	}
+
+	count, err := rs.Resync(ctx)
+	if err != nil {
+		t.Fatalf("resync error: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("resynced files got %d want 2", count)
+	}
+	if got := readContent(t, primary, "1"); got != "abc" {
+		t.Errorf("primary content got %q want %q", got, "abc")
+	}
+	fi, err := primary.Info(ctx, "1")
+	if err != nil {
+		t.Fatalf("primary info error: %v", err)
+	}
+	if fi.Filename != "a.txt" {
+		t.Errorf("primary filename got %q want %q", fi.Filename, "a.txt")
+	}
+	if got := readContent(t, primary, "2"); got != "def" {
+		t.Errorf("primary content got %q want %q", got, "def")
+	}
+	_, err = primary.Info(ctx, "3")
+	if !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("primary info error got %v want %v", err, errs.ErrNotFound)
+	}
+}
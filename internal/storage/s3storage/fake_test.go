@@ -0,0 +1,235 @@
+package s3storage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// fakeS3 is an in-process S3 server supporting the subset of the API used by
+// the storage: path style addressing, conditional writes and deletes, ranged
+// reads, listing and multipart uploads.
+type fakeS3 struct {
+	bucket string
+
+	mu          sync.Mutex
+	objects     map[string][]byte
+	modified    map[string]time.Time
+	uploads     map[string]map[int][]byte
+	contentGets int
+}
+
+type fakeListResult struct {
+	XMLName     xml.Name           `xml:"ListBucketResult"`
+	Name        string             `xml:"Name"`
+	Prefix      string             `xml:"Prefix"`
+	KeyCount    int                `xml:"KeyCount"`
+	IsTruncated bool               `xml:"IsTruncated"`
+	Contents    []fakeListContents `xml:"Contents"`
+}
+
+type fakeListContents struct {
+	Key          string    `xml:"Key"`
+	Size         int       `xml:"Size"`
+	ETag         string    `xml:"ETag"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+func newFakeS3(t *testing.T, bucket string) (*fakeS3, *httptest.Server) {
+	t.Helper()
+
+	f := &fakeS3{
+		bucket:   bucket,
+		objects:  make(map[string][]byte),
+		modified: make(map[string]time.Time),
+		uploads:  make(map[string]map[int][]byte),
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	return f, srv
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	if bucket != f.bucket {
+		writeFakeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q := r.URL.Query()
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, q.Get("prefix"), q.Get("start-after"))
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		id := uuid.NewString()
+		f.uploads[id] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", bucket, key, id)
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		f.completeUpload(w, key, q.Get("uploadId"))
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		parts, ok := f.uploads[q.Get("uploadId")]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		number, _ := strconv.Atoi(q.Get("partNumber"))
+		parts[number] = b
+		w.Header().Set("ETag", fakeETag(b))
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		delete(f.uploads, q.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		b, _ := io.ReadAll(r.Body)
+		if !f.checkConditions(w, r, key) {
+			return
+		}
+		f.objects[key] = b
+		f.modified[key] = time.Now()
+		w.Header().Set("ETag", fakeETag(b))
+	case r.Method == http.MethodGet:
+		f.get(w, r, key)
+	case r.Method == http.MethodDelete:
+		if !f.checkConditions(w, r, key) {
+			return
+		}
+		delete(f.objects, key)
+		delete(f.modified, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeFakeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) checkConditions(w http.ResponseWriter, r *http.Request, key string) bool {
+	b, exists := f.objects[key]
+	if r.Header.Get("If-None-Match") == "*" && exists {
+		writeFakeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return false
+	}
+	if etag := r.Header.Get("If-Match"); etag != "" {
+		if !exists {
+			writeFakeError(w, http.StatusNotFound, "NoSuchKey")
+			return false
+		}
+		if etag != fakeETag(b) {
+			writeFakeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return false
+		}
+	}
+	return true
+}
+
+func (f *fakeS3) get(w http.ResponseWriter, r *http.Request, key string) {
+	if strings.HasPrefix(key, contentPrefix) {
+		f.contentGets++
+	}
+
+	b, ok := f.objects[key]
+	if !ok {
+		writeFakeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+
+	w.Header().Set("ETag", fakeETag(b))
+	rng, ok := strings.CutPrefix(r.Header.Get("Range"), "bytes=")
+	if !ok {
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		w.Write(b)
+		return
+	}
+
+	start, _ := strconv.Atoi(strings.TrimSuffix(rng, "-"))
+	if start >= len(b) {
+		writeFakeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+		return
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(b)-1, len(b)))
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)-start))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(b[start:])
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix, startAfter string) {
+	result := fakeListResult{Name: f.bucket, Prefix: prefix}
+	for key, b := range f.objects {
+		if strings.HasPrefix(key, prefix) && key > startAfter {
+			result.Contents = append(result.Contents, fakeListContents{Key: key, Size: len(b), ETag: fakeETag(b), LastModified: f.modified[key]})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (f *fakeS3) completeUpload(w http.ResponseWriter, key, uploadID string) {
+	parts, ok := f.uploads[uploadID]
+	if !ok {
+		writeFakeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	var b []byte
+	for i := 1; i <= len(parts); i++ {
+		b = append(b, parts[i]...)
+	}
+	f.objects[key] = b
+	f.modified[key] = time.Now()
+	delete(f.uploads, uploadID)
+
+	fmt.Fprintf(w, "<CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>%s</ETag></CompleteMultipartUploadResult>", f.bucket, key, fakeETag(b))
+}
+
+// contentObjects returns the number of stored content objects.
+func (f *fakeS3) contentObjects() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	count := 0
+	for key := range f.objects {
+		if strings.HasPrefix(key, contentPrefix) {
+			count++
+		}
+	}
+	return count
+}
+
+// contentRequests returns the number of requests reading content objects.
+func (f *fakeS3) contentRequests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.contentGets
+}
+
+func writeFakeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func fakeETag(b []byte) string {
+	sum := md5.Sum(b)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
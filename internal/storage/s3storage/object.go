@@ -0,0 +1,215 @@
+package s3storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"file-storage/internal/errs"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// upload stores the stream as a new object and returns its size. Streams
+// larger than a part are uploaded in parts, so memory use is bounded by the
+// part size regardless of the content size.
+func (s *S3Storage) upload(ctx context.Context, key string, r io.Reader) (int64, error) {
+	buf := make([]byte, s.partSize)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, fmt.Errorf("read content error: %w", err)
+	}
+
+	if n < s.partSize {
+		// the whole content fits into one request
+		_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(buf[:n]),
+		})
+		if err != nil {
+			return 0, fmt.Errorf("put object error: %w", err)
+		}
+		return int64(n), nil
+	}
+
+	return s.uploadParts(ctx, key, r, buf)
+}
+
+// uploadParts uploads the stream with a multipart upload. The first part is
+// already read into buf. The upload is aborted on failure.
+func (s *S3Storage) uploadParts(ctx context.Context, key string, r io.Reader, buf []byte) (int64, error) {
+	created, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("create multipart upload error: %w", err)
+	}
+
+	size, parts, err := s.putParts(ctx, key, created.UploadId, r, buf)
+	if err == nil {
+		_, err = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(s.bucket),
+			Key:             aws.String(key),
+			UploadId:        created.UploadId,
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+		})
+		if err == nil {
+			return size, nil
+		}
+		err = fmt.Errorf("complete multipart upload error: %w", err)
+	}
+
+	// the upload is aborted even when the request is cancelled
+	_, abortErr := s.client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: created.UploadId,
+	})
+	if abortErr != nil {
+		return 0, errors.Join(err, fmt.Errorf("abort multipart upload error: %w", abortErr))
+	}
+
+	return 0, err
+}
+
+func (s *S3Storage) putParts(ctx context.Context, key string, uploadID *string, r io.Reader, buf []byte) (int64, []types.CompletedPart, error) {
+	var size int64
+	var parts []types.CompletedPart
+
+	n := len(buf)
+	for number := int32(1); n > 0; number++ {
+		out, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String(s.bucket),
+			Key:        aws.String(key),
+			UploadId:   uploadID,
+			PartNumber: aws.Int32(number),
+			Body:       bytes.NewReader(buf[:n]),
+		})
+		if err != nil {
+			return 0, nil, fmt.Errorf("upload part %d error: %w", number, err)
+		}
+		parts = append(parts, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(number)})
+		size += int64(n)
+
+		n, err = io.ReadFull(r, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, nil, fmt.Errorf("read content error: %w", err)
+		}
+	}
+
+	return size, parts, nil
+}
+
+// objectReader reads an object with ranged requests. Seeking only moves the
+// offset; the open request is replaced on the first read at another position,
+// so seeking to the end to learn the size and back does not cost a request.
+type objectReader struct {
+	ctx    context.Context
+	client *s3.Client
+	bucket string
+	key    string
+	size   int64
+
+	offset int64
+	body   io.ReadCloser
+	// bodyOffset is the position of the open request in the object
+	bodyOffset int64
+}
+
+func (r *objectReader) open() error {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(r.key),
+	}
+	if r.offset > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", r.offset))
+	}
+
+	out, err := r.client.GetObject(r.ctx, input)
+	if err != nil {
+		if isNotFound(err) {
+			return errs.ErrNotFound
+		}
+		return fmt.Errorf("get object error: %w", err)
+	}
+	r.body = out.Body
+	r.bodyOffset = r.offset
+
+	return nil
+}
+
+func (r *objectReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body != nil && r.bodyOffset != r.offset {
+		r.body.Close()
+		r.body = nil
+	}
+	if r.body == nil {
+		err := r.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	r.bodyOffset = r.offset
+
+	return n, err
+}
+
+func (r *objectReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.offset + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	r.offset = abs
+
+	return abs, nil
+}
+
+func (r *objectReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+// errorCode returns the error code and the HTTP status of a failed request.
+func errorCode(err error) (string, int) {
+	var code string
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code = apiErr.ErrorCode()
+	}
+
+	var status int
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) {
+		status = respErr.HTTPStatusCode()
+	}
+
+	return code, status
+}
@@ -0,0 +1,409 @@
+// Package s3storage provides a storage implementation on top of an
+// S3-compatible object storage such as AWS S3, MinIO or Ceph RGW.
+//
+// Every file is kept as two kinds of objects:
+//
+//	[prefix]files/[id].json          — head object with metadata and the content key
+//	[prefix]content/[id]/[uuid].bin  — immutable content object
+//
+// A write uploads a new content object first and then replaces the head
+// object with a conditional request that succeeds only if the head was not
+// changed since it was read. Readers resolve content through the head object,
+// so they observe either the old or the new version, never a partial update.
+// Replaced content objects stay readable for a grace period and are removed by
+// the sweeper afterwards.
+package s3storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"file-storage/internal/config"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"file-storage/internal/logger"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+)
+
+const (
+	headPrefix    = "files/"
+	headExt       = ".json"
+	contentPrefix = "content/"
+	contentExt    = ".bin"
+
+	// defaultPartSize is the size of parts of multipart content uploads.
+	// Content smaller than a part is uploaded with a single request.
+	defaultPartSize = 8 << 20
+
+	// commitAttempts limits retries of the head object replacement when the
+	// same file is written concurrently.
+	commitAttempts = 5
+
+	// bucketCheckTimeout limits the bucket availability check in New.
+	bucketCheckTimeout = 10 * time.Second
+)
+
+// S3Storage stores file content and metadata as objects in an S3 bucket.
+type S3Storage struct {
+	client   *s3.Client
+	bucket   string
+	prefix   string
+	partSize int
+	log      *slog.Logger
+
+	sweepInterval time.Duration
+	gracePeriod   time.Duration
+	sweepOnce     sync.Once
+}
+
+// head is the content of the head object of a file.
+type head struct {
+	Content string             `json:"content"`
+	Size    int64              `json:"size"`
+	Info    *filedata.FileInfo `json:"info"`
+}
+
+// New creates an S3 storage and checks that the bucket is accessible.
+func New(ctx context.Context, cfg *config.S3, log *slog.Logger) (*S3Storage, error) {
+	opts := s3.Options{
+		Region:       cfg.Region,
+		UsePathStyle: cfg.UsePathStyle,
+		// S3-compatible services do not support all checksum algorithms
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+		ResponseChecksumValidation: aws.ResponseChecksumValidationWhenRequired,
+	}
+	if cfg.Endpoint != "" {
+		opts.BaseEndpoint = aws.String(cfg.Endpoint)
+	}
+	if cfg.AccessKey != "" {
+		opts.Credentials = credentials.NewStaticCredentialsProvider(cfg.AccessKey, cfg.SecretKey, "")
+	} else {
+		opts.Credentials = aws.AnonymousCredentials{}
+	}
+
+	s := &S3Storage{
+		client:   s3.New(opts),
+		bucket:   cfg.Bucket,
+		prefix:   cfg.Prefix,
+		partSize: defaultPartSize,
+		log:      log,
+
+		sweepInterval: cfg.SweepInterval,
+		gracePeriod:   cfg.GracePeriod,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, bucketCheckTimeout)
+	defer cancel()
+
+	_, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.bucket)})
+	if err != nil {
+		return nil, fmt.Errorf("bucket %s check error: %w", s.bucket, err)
+	}
+
+	return s, nil
+}
+
+// Upsert uploads new content when provided and atomically replaces the head
+// object of the file. Concurrent writes of the same file are applied one after
+// another, so the last committed write wins. The replaced content object is
+// left to the sweeper, so readers that resolved the previous head can finish.
+func (s *S3Storage) Upsert(ctx context.Context, fd *filedata.FileData) (string, error) {
+	if fd == nil {
+		return "", errs.ErrInvalidFileData
+	}
+	if strings.TrimSpace(fd.ID) == "" {
+		return "", errs.ErrInvalidID
+	}
+
+	var contentKey string
+	var size int64
+	if fd.Data != nil {
+		contentKey = s.contentKey(fd.ID)
+		var err error
+		size, err = s.upload(ctx, contentKey, fd.Data)
+		if err != nil {
+			return "", fmt.Errorf("upload content error: %w", err)
+		}
+	}
+
+	// file info is built after the data stream is consumed
+	fi := filedata.FileInfoFromFileData(fd)
+
+	err := s.commit(ctx, fi, contentKey, size)
+	if err != nil {
+		if contentKey != "" {
+			s.deleteObject(ctx, contentKey, "")
+		}
+		return "", err
+	}
+
+	return fd.ID, nil
+}
+
+// commit replaces the head object of the file. An empty contentKey keeps the
+// current content.
+func (s *S3Storage) commit(ctx context.Context, fi *filedata.FileInfo, contentKey string, size int64) error {
+	for range commitAttempts {
+		current, etag, err := s.readHead(ctx, fi.ID)
+		if err != nil && !errors.Is(err, errs.ErrNotFound) {
+			return err
+		}
+
+		h := head{Content: contentKey, Size: size, Info: fi}
+		if current != nil && contentKey == "" {
+			h.Content = current.Content
+			h.Size = current.Size
+		}
+
+		b, err := json.Marshal(h)
+		if err != nil {
+			return fmt.Errorf("head marshall error: %w", err)
+		}
+
+		input := &s3.PutObjectInput{
+			Bucket:      aws.String(s.bucket),
+			Key:         aws.String(s.headKey(fi.ID)),
+			Body:        bytes.NewReader(b),
+			ContentType: aws.String("application/json"),
+		}
+		if etag != "" {
+			input.IfMatch = aws.String(etag)
+		} else {
+			input.IfNoneMatch = aws.String("*")
+		}
+
+		_, err = s.client.PutObject(ctx, input)
+		if err == nil {
+			return nil
+		}
+		if !isConditionFailed(err) {
+			return fmt.Errorf("put head error: %w", err)
+		}
+		// the head was changed by a concurrent write
+	}
+
+	return fmt.Errorf("file %s is changed concurrently: %w", fi.ID, errs.ErrStorageFileIsLocked)
+}
+
+// Delete removes the head object and the content of the file. Deleting a
+// missing file is not an error.
+func (s *S3Storage) Delete(ctx context.Context, ID string) error {
+	if len(ID) == 0 {
+		return errs.ErrInvalidID
+	}
+
+	for range commitAttempts {
+		current, etag, err := s.readHead(ctx, ID)
+		if err != nil {
+			if errors.Is(err, errs.ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		_, err = s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket:  aws.String(s.bucket),
+			Key:     aws.String(s.headKey(ID)),
+			IfMatch: aws.String(etag),
+		})
+		if err == nil {
+			if current.Content != "" {
+				s.deleteObject(ctx, current.Content, ID)
+			}
+			return nil
+		}
+		if isNotFound(err) {
+			return nil
+		}
+		if !isConditionFailed(err) {
+			return fmt.Errorf("delete head error: %w", err)
+		}
+		// the head was changed by a concurrent write
+	}
+
+	return fmt.Errorf("file %s is changed concurrently: %w", ID, errs.ErrStorageFileIsLocked)
+}
+
+// Info reads file metadata from the head object.
+func (s *S3Storage) Info(ctx context.Context, ID string) (*filedata.FileInfo, error) {
+	if len(ID) == 0 {
+		return nil, errs.ErrInvalidID
+	}
+
+	h, _, err := s.readHead(ctx, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.Info, nil
+}
+
+// Content opens content of the current version of the file. The content
+// object is read lazily, and seeking issues a ranged request.
+func (s *S3Storage) Content(ctx context.Context, ID string) (*filedata.ContentData, error) {
+	if len(ID) == 0 {
+		return nil, errs.ErrInvalidID
+	}
+
+	// the content object of the version may be deleted by a concurrent
+	// delete or sweep after the head is read, then the head is read again
+	for range commitAttempts {
+		h, _, err := s.readHead(ctx, ID)
+		if err != nil {
+			return nil, err
+		}
+		if h.Content == "" {
+			return nil, errs.ErrNotFound
+		}
+
+		r := &objectReader{ctx: ctx, client: s.client, bucket: s.bucket, key: h.Content, size: h.Size}
+		err = r.open()
+		if err == nil {
+			return &filedata.ContentData{Data: r, Info: h.Info}, nil
+		}
+		if !errors.Is(err, errs.ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("file %s is changed concurrently: %w", ID, errs.ErrStorageFileIsLocked)
+}
+
+// List returns a page of files matching the query in ascending ID order.
+// Head objects are read one by one, so selective filters are slow on large
+// buckets.
+func (s *S3Storage) List(ctx context.Context, q *filedata.ListQuery) (*filedata.FileList, error) {
+	fl := filedata.FileList{Files: make([]*filedata.FileInfo, 0, q.Limit)}
+
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.prefix + headPrefix),
+	}
+	if q.Cursor != "" {
+		input.StartAfter = aws.String(s.headKey(q.Cursor))
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(s.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list objects error: %w", err)
+		}
+
+		for _, object := range page.Contents {
+			id, ok := s.headID(aws.ToString(object.Key))
+			if !ok || id <= q.Cursor {
+				continue
+			}
+
+			h, _, err := s.readHead(ctx, id)
+			if err != nil {
+				if errors.Is(err, errs.ErrNotFound) {
+					continue
+				}
+				return nil, err
+			}
+			if !q.Match(h.Info) {
+				continue
+			}
+			if len(fl.Files) == q.Limit {
+				fl.NextCursor = fl.Files[len(fl.Files)-1].ID
+				return &fl, nil
+			}
+			fl.Files = append(fl.Files, h.Info)
+		}
+	}
+
+	return &fl, nil
+}
+
+// readHead reads the head object of the file together with its ETag.
+func (s *S3Storage) readHead(ctx context.Context, ID string) (*head, string, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.headKey(ID)),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, "", errs.ErrNotFound
+		}
+		return nil, "", fmt.Errorf("get head error: %w", err)
+	}
+	defer out.Body.Close()
+
+	b, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("read head error: %w", err)
+	}
+
+	var h head
+	err = json.Unmarshal(b, &h)
+	if err != nil {
+		return nil, "", fmt.Errorf("unmarshal head error: %w", err)
+	}
+	if h.Info == nil {
+		return nil, "", fmt.Errorf("head of %s without file info: %w", ID, errs.ErrInvalidFileData)
+	}
+
+	return &h, aws.ToString(out.ETag), nil
+}
+
+// deleteObject removes an object that is no longer referenced. Failures are
+// only logged, because the object is not visible to readers anymore.
+func (s *S3Storage) deleteObject(ctx context.Context, key, ID string) {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil && !isNotFound(err) {
+		logger.FromContext(ctx).Warn(
+			"object removal failed",
+			"id", ID,
+			"key", key,
+			slog.Any(logger.LogFieldError, err),
+		)
+	}
+}
+
+func (s *S3Storage) headKey(ID string) string {
+	return s.prefix + headPrefix + ID + headExt
+}
+
+func (s *S3Storage) headID(key string) (string, bool) {
+	id, ok := strings.CutPrefix(key, s.prefix+headPrefix)
+	if !ok {
+		return "", false
+	}
+	return strings.CutSuffix(id, headExt)
+}
+
+// contentKey returns a new unique key for content of the file, so content
+// objects are never overwritten.
+func (s *S3Storage) contentKey(ID string) string {
+	return s.prefix + contentPrefix + ID + "/" + uuid.NewString() + contentExt
+}
+
+func isNotFound(err error) bool {
+	code, status := errorCode(err)
+	return code == "NoSuchKey" || code == "NotFound" || status == http.StatusNotFound
+}
+
+// isConditionFailed reports whether a conditional write was rejected because
+// the object was changed meanwhile.
+func isConditionFailed(err error) bool {
+	code, status := errorCode(err)
+	return code == "PreconditionFailed" || code == "ConditionalRequestConflict" ||
+		status == http.StatusPreconditionFailed || status == http.StatusConflict
+}
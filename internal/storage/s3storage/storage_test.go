@@ -0,0 +1,435 @@
+package s3storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"file-storage/internal/config"
+	"file-storage/internal/contextkeys"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"file-storage/internal/logger"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestStorage(t *testing.T) (*S3Storage, *fakeS3, context.Context) {
+	t.Helper()
+
+	ctx := context.WithValue(context.Background(), contextkeys.ContextKeyLogger, logger.NewBootstrap())
+
+	fake, srv := newFakeS3(t, "files")
+	cfg := config.S3{
+		Endpoint:     srv.URL,
+		Region:       "us-east-1",
+		Bucket:       "files",
+		AccessKey:    "access",
+		SecretKey:    "secret",
+		UsePathStyle: true,
+	}
+	s, err := New(ctx, &cfg, logger.NewBootstrap())
+	if err != nil {
+		t.Fatalf("new storage error: %v", err)
+	}
+	// small parts make multipart uploads testable
+	s.partSize = 4
+
+	return s, fake, ctx
+}
+
+func TestNew(t *testing.T) {
+	_, srv := newFakeS3(t, "files")
+
+	cfg := config.S3{Endpoint: srv.URL, Region: "us-east-1", Bucket: "missing", UsePathStyle: true}
+	_, err := New(context.Background(), &cfg, logger.NewBootstrap())
+	if err == nil {
+		t.Errorf("missing bucket got nil error")
+	}
+}
+
+func TestUpsertContent(t *testing.T) {
+	s, fake, ctx := newTestStorage(t)
+
+	id := "123456789012345678901234567890123456"
+
+	table := []struct {
+		name     string
+		fd       *filedata.FileData
+		wantData string
+		wantErr  error
+	}{
+		{
+			name:    "invalid file data",
+			fd:      nil,
+			wantErr: errs.ErrInvalidFileData,
+		},
+		{
+			name:    "invalid ID",
+			fd:      &filedata.FileData{ID: " "},
+			wantErr: errs.ErrInvalidID,
+		},
+		{
+			name:     "single request upload",
+			fd:       &filedata.FileData{ID: id, Data: bytes.NewReader([]byte("abc")), Filename: "a.txt"},
+			wantData: "abc",
+		},
+		{
+			name:     "multipart upload",
+			fd:       &filedata.FileData{ID: id, Data: bytes.NewReader([]byte("0123456789")), Filename: "b.txt"},
+			wantData: "0123456789",
+		},
+		{
+			name:     "metadata update keeps content",
+			fd:       &filedata.FileData{ID: id, Filename: "c.txt"},
+			wantData: "0123456789",
+		},
+		{
+			name:     "empty content",
+			fd:       &filedata.FileData{ID: id, Data: bytes.NewReader(nil), Filename: "d.txt"},
+			wantData: "",
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Upsert(ctx, tt.fd)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("upsert error got %v want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			info, err := s.Info(ctx, id)
+			if err != nil {
+				t.Fatalf("info error: %v", err)
+			}
+			if info.Filename != tt.fd.Filename {
+				t.Errorf("filename got %s want %s", info.Filename, tt.fd.Filename)
+			}
+
+			cd, err := s.Content(ctx, id)
+			if err != nil {
+				t.Fatalf("content error: %v", err)
+			}
+			defer cd.Data.Close()
+
+			b, err := io.ReadAll(cd.Data)
+			if err != nil {
+				t.Fatalf("read error: %v", err)
+			}
+			if string(b) != tt.wantData {
+				t.Errorf("content got %q want %q", b, tt.wantData)
+			}
+			if !reflect.DeepEqual(cd.Info, info) {
+				t.Errorf("content info got %+v want %+v", cd.Info, info)
+			}
+
+			_, err = s.sweep(ctx, time.Now())
+			if err != nil {
+				t.Fatalf("sweep error: %v", err)
+			}
+			if got := fake.contentObjects(); got != 1 {
+				t.Errorf("content objects got %d want 1", got)
+			}
+		})
+	}
+}
+
+func TestContentSeek(t *testing.T) {
+	s, _, ctx := newTestStorage(t)
+
+	id := "123456789012345678901234567890123456"
+	_, err := s.Upsert(ctx, &filedata.FileData{ID: id, Data: bytes.NewReader([]byte("0123456789"))})
+	if err != nil {
+		t.Fatalf("upsert error: %v", err)
+	}
+
+	cd, err := s.Content(ctx, id)
+	if err != nil {
+		t.Fatalf("content error: %v", err)
+	}
+	defer cd.Data.Close()
+
+	table := []struct {
+		name   string
+		offset int64
+		whence int
+		want   string
+	}{
+		{name: "start", offset: 6, whence: io.SeekStart, want: "6789"},
+		{name: "end", offset: -3, whence: io.SeekEnd, want: "789"},
+		{name: "current", offset: -10, whence: io.SeekCurrent, want: "0123456789"},
+		{name: "past end", offset: 20, whence: io.SeekStart, want: ""},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := cd.Data.Seek(tt.offset, tt.whence)
+			if err != nil {
+				t.Fatalf("seek error: %v", err)
+			}
+			b, err := io.ReadAll(cd.Data)
+			if err != nil {
+				t.Fatalf("read error: %v", err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("content got %q want %q", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestContentRequests(t *testing.T) {
+	s, fake, ctx := newTestStorage(t)
+
+	id := "123456789012345678901234567890123456"
+	_, err := s.Upsert(ctx, &filedata.FileData{ID: id, Data: bytes.NewReader([]byte("0123456789"))})
+	if err != nil {
+		t.Fatalf("upsert error: %v", err)
+	}
+
+	cd, err := s.Content(ctx, id)
+	if err != nil {
+		t.Fatalf("content error: %v", err)
+	}
+	defer cd.Data.Close()
+
+	// http.ServeContent seeks to the end to learn the size and back
+	size, err := cd.Data.Seek(0, io.SeekEnd)
+	if err != nil || size != 10 {
+		t.Fatalf("seek end got %d, %v want 10", size, err)
+	}
+	_, err = cd.Data.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatalf("seek start error: %v", err)
+	}
+	b, err := io.ReadAll(cd.Data)
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	if string(b) != "0123456789" {
+		t.Errorf("content got %q want %q", b, "0123456789")
+	}
+	if got := fake.contentRequests(); got != 1 {
+		t.Errorf("content requests got %d want 1", got)
+	}
+
+	_, err = cd.Data.Seek(4, io.SeekStart)
+	if err != nil {
+		t.Fatalf("seek error: %v", err)
+	}
+	b, err = io.ReadAll(cd.Data)
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	if string(b) != "456789" {
+		t.Errorf("content got %q want %q", b, "456789")
+	}
+	if got := fake.contentRequests(); got != 2 {
+		t.Errorf("content requests got %d want 2", got)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	s, fake, ctx := newTestStorage(t)
+	s.gracePeriod = time.Hour
+
+	id := "123456789012345678901234567890123456"
+	_, err := s.Upsert(ctx, &filedata.FileData{ID: id, Data: bytes.NewReader([]byte("old"))})
+	if err != nil {
+		t.Fatalf("upsert error: %v", err)
+	}
+	cd, err := s.Content(ctx, id)
+	if err != nil {
+		t.Fatalf("content error: %v", err)
+	}
+	defer cd.Data.Close()
+
+	_, err = s.Upsert(ctx, &filedata.FileData{ID: id, Data: bytes.NewReader([]byte("new"))})
+	if err != nil {
+		t.Fatalf("upsert error: %v", err)
+	}
+	// content left by an upload without a commit
+	fake.mu.Lock()
+	orphan := contentPrefix + "223456789012345678901234567890123456/orphan" + contentExt
+	fake.objects[orphan] = []byte("orphan")
+	fake.modified[orphan] = time.Now()
+	fake.mu.Unlock()
+
+	// a reader of the replaced version finishes within the grace period
+	removed, err := s.sweep(ctx, time.Now())
+	if err != nil || removed != 0 {
+		t.Fatalf("sweep got %d, %v want 0, nil", removed, err)
+	}
+	b, err := io.ReadAll(cd.Data)
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	if string(b) != "old" {
+		t.Errorf("replaced content got %q want %q", b, "old")
+	}
+
+	removed, err = s.sweep(ctx, time.Now().Add(2*time.Hour))
+	if err != nil || removed != 2 {
+		t.Fatalf("sweep got %d, %v want 2, nil", removed, err)
+	}
+	if got := fake.contentObjects(); got != 1 {
+		t.Errorf("content objects got %d want 1", got)
+	}
+
+	cd, err = s.Content(ctx, id)
+	if err != nil {
+		t.Fatalf("content error: %v", err)
+	}
+	defer cd.Data.Close()
+	b, err = io.ReadAll(cd.Data)
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	if string(b) != "new" {
+		t.Errorf("content got %q want %q", b, "new")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	s, fake, ctx := newTestStorage(t)
+
+	id := "123456789012345678901234567890123456"
+	_, err := s.Upsert(ctx, &filedata.FileData{ID: id, Data: bytes.NewReader([]byte("abc"))})
+	if err != nil {
+		t.Fatalf("upsert error: %v", err)
+	}
+
+	// deletion is idempotent
+	for range 2 {
+		err = s.Delete(ctx, id)
+		if err != nil {
+			t.Fatalf("delete error: %v", err)
+		}
+	}
+
+	_, err = s.Info(ctx, id)
+	if !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("info error got %v want %v", err, errs.ErrNotFound)
+	}
+	_, err = s.Content(ctx, id)
+	if !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("content error got %v want %v", err, errs.ErrNotFound)
+	}
+	if got := fake.contentObjects(); got != 0 {
+		t.Errorf("content objects got %d want 0", got)
+	}
+
+	err = s.Delete(ctx, "")
+	if !errors.Is(err, errs.ErrInvalidID) {
+		t.Errorf("delete error got %v want %v", err, errs.ErrInvalidID)
+	}
+}
+
+func TestList(t *testing.T) {
+	s, _, ctx := newTestStorage(t)
+
+	ids := []string{
+		"123456789012345678901234567890123451",
+		"123456789012345678901234567890123452",
+		"123456789012345678901234567890123453",
+		"123456789012345678901234567890123454",
+	}
+	for i, id := range ids {
+		_, err := s.Upsert(ctx, &filedata.FileData{ID: id, Public: i%2 == 0})
+		if err != nil {
+			t.Fatalf("upsert error: %v", err)
+		}
+	}
+
+	public := true
+
+	table := []struct {
+		name       string
+		q          filedata.ListQuery
+		wantIDs    []string
+		wantCursor string
+	}{
+		{
+			name:       "first page",
+			q:          filedata.ListQuery{Limit: 2},
+			wantIDs:    ids[:2],
+			wantCursor: ids[1],
+		},
+		{
+			name:    "last page",
+			q:       filedata.ListQuery{Limit: 2, Cursor: ids[1]},
+			wantIDs: ids[2:],
+		},
+		{
+			name:    "filter",
+			q:       filedata.ListQuery{Limit: 10, Public: &public},
+			wantIDs: []string{ids[0], ids[2]},
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			fl, err := s.List(ctx, &tt.q)
+			if err != nil {
+				t.Fatalf("list error: %v", err)
+			}
+
+			gotIDs := make([]string, 0, len(fl.Files))
+			for _, fi := range fl.Files {
+				gotIDs = append(gotIDs, fi.ID)
+			}
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("ids got %v want %v", gotIDs, tt.wantIDs)
+			}
+			if fl.NextCursor != tt.wantCursor {
+				t.Errorf("cursor got %s want %s", fl.NextCursor, tt.wantCursor)
+			}
+		})
+	}
+}
+
+func TestConcurrentUpsert(t *testing.T) {
+	s, fake, ctx := newTestStorage(t)
+
+	id := "123456789012345678901234567890123456"
+
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.Upsert(ctx, &filedata.FileData{ID: id, Data: bytes.NewReader([]byte{byte('a' + i)})})
+			if err != nil {
+				t.Errorf("upsert error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	cd, err := s.Content(ctx, id)
+	if err != nil {
+		t.Fatalf("content error: %v", err)
+	}
+	defer cd.Data.Close()
+
+	b, err := io.ReadAll(cd.Data)
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	if len(b) != 1 {
+		t.Errorf("content got %q want one byte", b)
+	}
+
+	_, err = s.sweep(ctx, time.Now())
+	if err != nil {
+		t.Fatalf("sweep error: %v", err)
+	}
+	if got := fake.contentObjects(); got != 1 {
+		t.Errorf("content objects got %d want 1", got)
+	}
+}
@@ -0,0 +1,104 @@
+package s3storage
+
+import (
+	"context"
+	"errors"
+	"file-storage/internal/contextkeys"
+	"file-storage/internal/errs"
+	"file-storage/internal/logger"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// StartSweeper starts removing unreferenced content objects every sweep
+// interval. A zero interval disables the sweeper.
+func (s *S3Storage) StartSweeper(ctx context.Context) {
+	if s.sweepInterval <= 0 {
+		return
+	}
+
+	s.sweepOnce.Do(func() {
+		ctx = context.WithValue(ctx, contextkeys.ContextKeyLogger, s.log)
+
+		go func() {
+			ticker := time.NewTicker(s.sweepInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					removed, err := s.sweep(ctx, time.Now())
+					if err != nil {
+						if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+							return
+						}
+						s.log.Error("content sweep error", slog.Any(logger.LogFieldError, err))
+					}
+					if removed > 0 {
+						s.log.Info("unreferenced content removed", "objects", removed)
+					}
+				}
+			}
+		}()
+	})
+}
+
+// sweep removes content objects older than the grace period that the head
+// object of their file does not reference: content replaced by writes and
+// content left by uploads whose commit failed. Younger objects may still be
+// read through a head resolved before the replacement or wait for the commit
+// of their upload. It returns the number of removed objects.
+func (s *S3Storage) sweep(ctx context.Context, now time.Time) (int, error) {
+	prefix := s.prefix + contentPrefix
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	removed := 0
+	// content keys are listed in ID order, so the head is read once per file
+	var headID, headContent string
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return removed, fmt.Errorf("list objects error: %w", err)
+		}
+
+		for _, object := range page.Contents {
+			key := aws.ToString(object.Key)
+			if now.Sub(aws.ToTime(object.LastModified)) < s.gracePeriod {
+				continue
+			}
+			id, _, ok := strings.Cut(strings.TrimPrefix(key, prefix), "/")
+			if !ok {
+				continue
+			}
+
+			if id != headID {
+				h, _, err := s.readHead(ctx, id)
+				if err != nil && !errors.Is(err, errs.ErrNotFound) {
+					return removed, err
+				}
+				headID, headContent = id, ""
+				if h != nil {
+					headContent = h.Content
+				}
+			}
+			if key == headContent {
+				continue
+			}
+
+			s.deleteObject(ctx, key, id)
+			removed++
+		}
+	}
+
+	return removed, nil
+}
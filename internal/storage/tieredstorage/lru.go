@@ -0,0 +1,175 @@
+package tieredstorage
+
+import (
+	"file-storage/internal/metrics"
+	"sync"
+)
+
+// add records the file as the most recently used one in the hot storage.
+func (t *TieredStorage) add(ID string, size int64, dirty bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if el := t.entries[ID]; el != nil {
+		e := el.Value.(*entry)
+		t.hotBytes += size - e.size
+		if dirty && !e.dirty {
+			t.dirty++
+		}
+		e.size = size
+		e.dirty = e.dirty || dirty
+		t.lru.MoveToFront(el)
+	} else {
+		t.entries[ID] = t.lru.PushFront(&entry{id: ID, size: size, dirty: dirty})
+		t.hotBytes += size
+		if dirty {
+			t.dirty++
+		}
+	}
+
+	t.updateGauges()
+}
+
+// remove forgets the file removed from the hot storage.
+func (t *TieredStorage) remove(ID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	el := t.entries[ID]
+	if el == nil {
+		return
+	}
+	e := el.Value.(*entry)
+	t.hotBytes -= e.size
+	if e.dirty {
+		t.dirty--
+	}
+	t.lru.Remove(el)
+	delete(t.entries, ID)
+
+	t.updateGauges()
+}
+
+// touch marks the file as recently used and reports whether it is kept in the
+// hot storage.
+func (t *TieredStorage) touch(ID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	el := t.entries[ID]
+	if el == nil {
+		return false
+	}
+	t.lru.MoveToFront(el)
+
+	return true
+}
+
+func (t *TieredStorage) inHot(ID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.entries[ID] != nil
+}
+
+func (t *TieredStorage) size(ID string) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if el := t.entries[ID]; el != nil {
+		return el.Value.(*entry).size
+	}
+	return 0
+}
+
+func (t *TieredStorage) isDirty(ID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	el := t.entries[ID]
+	return el != nil && el.Value.(*entry).dirty
+}
+
+func (t *TieredStorage) markClean(ID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	el := t.entries[ID]
+	if el == nil || !el.Value.(*entry).dirty {
+		return
+	}
+	el.Value.(*entry).dirty = false
+	t.dirty--
+
+	t.updateGauges()
+}
+
+func (t *TieredStorage) dirtyIDs() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ids := make([]string, 0, t.dirty)
+	for el := t.lru.Back(); el != nil; el = el.Prev() {
+		if e := el.Value.(*entry); e.dirty {
+			ids = append(ids, e.id)
+		}
+	}
+	return ids
+}
+
+// evictionCandidate returns the least recently used file when the hot storage
+// exceeds its limit.
+func (t *TieredStorage) evictionCandidate() (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.hotBytes <= t.maxBytes || t.lru.Len() == 0 {
+		return "", false
+	}
+	return t.lru.Back().Value.(*entry).id, true
+}
+
+// updateGauges publishes the hot storage state. Supposed t.mu is locked.
+func (t *TieredStorage) updateGauges() {
+	metrics.TierHotBytes.Set(float64(t.hotBytes))
+	metrics.TierDirtyFiles.Set(float64(t.dirty))
+}
+
+// idLocks serializes operations on the same file ID across both storages.
+type idLocks struct {
+	mu    sync.Mutex
+	locks map[string]*idLock
+}
+
+type idLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lock acquires the lock of the ID and returns the function releasing it.
+func (l *idLocks) lock(ID string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*idLock)
+	}
+	il := l.locks[ID]
+	if il == nil {
+		il = &idLock{}
+		l.locks[ID] = il
+	}
+	il.refs++
+	l.mu.Unlock()
+
+	il.mu.Lock()
+
+	return func() {
+		il.mu.Unlock()
+
+		l.mu.Lock()
+		il.refs--
+		if il.refs == 0 {
+			delete(l.locks, ID)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,545 @@
+// Package tieredstorage provides a storage wrapper that keeps recently read
+// files in a fast hot storage in front of a slower cold storage.
+//
+// The hot storage holds a bounded set of files ordered by access recency.
+// Content reads missing the hot storage are served from the cold storage and
+// the file is promoted to the hot one; the least recently used files are
+// demoted when the hot storage exceeds its size limit.
+//
+// In write-through mode every write reaches both storages before it returns,
+// and the cold storage is the source of truth. In write-back mode writes go to
+// the hot storage only and reach the cold storage in the background, on
+// demotion or on Close.
+package tieredstorage
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"file-storage/internal/config"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"file-storage/internal/files"
+	"file-storage/internal/logger"
+	"file-storage/internal/metrics"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reconcilePageSize is the page size used to list the hot storage on startup.
+const reconcilePageSize = 1000
+
+// TieredStorage serves files from a hot storage and falls back to a cold one.
+type TieredStorage struct {
+	hot           files.Storage
+	cold          files.Storage
+	writeBack     bool
+	maxBytes      int64
+	flushInterval time.Duration
+	log           *slog.Logger
+	locks         idLocks
+	flushOnce     sync.Once
+
+	mu       sync.Mutex
+	lru      *list.List
+	entries  map[string]*list.Element
+	hotBytes int64
+	dirty    int
+}
+
+// entry describes a file kept in the hot storage. A dirty file has changes
+// not yet written to the cold storage.
+type entry struct {
+	id    string
+	size  int64
+	dirty bool
+}
+
+// New creates a tiered storage. Files already kept in a persistent hot storage
+// are compared with the cold storage: in write-through mode files that differ
+// are dropped from the hot storage, and in write-back mode they are scheduled
+// for writing to the cold storage.
+func New(ctx context.Context, hot, cold files.Storage, cfg *config.Tiered, log *slog.Logger) (*TieredStorage, error) {
+	t := &TieredStorage{
+		hot:           hot,
+		cold:          cold,
+		writeBack:     cfg.Mode == config.TierModeWriteBack,
+		maxBytes:      int64(cfg.MaxBytes),
+		flushInterval: cfg.FlushInterval,
+		log:           log.With("storage", "tiered"),
+		lru:           list.New(),
+		entries:       make(map[string]*list.Element),
+	}
+
+	err := t.reconcile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	t.evict(ctx)
+
+	return t, nil
+}
+
+// reconcile loads files of the hot storage into the recency list.
+func (t *TieredStorage) reconcile(ctx context.Context) error {
+	q := filedata.ListQuery{Limit: reconcilePageSize}
+	for {
+		fl, err := t.hot.List(ctx, &q)
+		if err != nil {
+			return fmt.Errorf("list hot storage error: %w", err)
+		}
+
+		for _, fi := range fl.Files {
+			coldInfo, err := t.cold.Info(ctx, fi.ID)
+			if err != nil && !errors.Is(err, errs.ErrNotFound) {
+				return fmt.Errorf("cold storage info error: %w", err)
+			}
+
+			same := err == nil && coldInfo.ContentHash() == fi.ContentHash() && coldInfo.UpdatedAt.Equal(fi.UpdatedAt)
+			if !same && !t.writeBack {
+				// the write did not reach the cold storage and was not acknowledged
+				err = t.hot.Delete(ctx, fi.ID)
+				if err != nil {
+					return fmt.Errorf("hot storage delete error: %w", err)
+				}
+				continue
+			}
+
+			t.add(fi.ID, int64(fi.FileSize), !same)
+		}
+
+		if fl.NextCursor == "" {
+			return nil
+		}
+		q.Cursor = fl.NextCursor
+	}
+}
+
+// StartFlusher starts writing changed files to the cold storage every flush
+// interval in write-back mode.
+func (t *TieredStorage) StartFlusher(ctx context.Context) {
+	if !t.writeBack {
+		return
+	}
+
+	t.flushOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(t.flushInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					t.flush(ctx)
+				}
+			}
+		}()
+	})
+}
+
+// Close writes changed files to the cold storage and closes both storages.
+func (t *TieredStorage) Close() error {
+	var errList []error
+
+	if t.writeBack {
+		failed := t.flush(context.Background())
+		if failed > 0 {
+			errList = append(errList, fmt.Errorf("%d files are not written to the cold storage", failed))
+		}
+	}
+
+	for _, s := range []files.Storage{t.hot, t.cold} {
+		if closer, ok := s.(io.Closer); ok {
+			err := closer.Close()
+			if err != nil {
+				errList = append(errList, err)
+			}
+		}
+	}
+
+	return errors.Join(errList...)
+}
+
+// Upsert writes the file to the hot storage and, in write-through mode, to the
+// cold storage. When the cold write fails the file is removed from the hot
+// storage, so readers keep observing the previous version.
+func (t *TieredStorage) Upsert(ctx context.Context, fd *filedata.FileData) (string, error) {
+	if fd == nil {
+		return "", errs.ErrInvalidFileData
+	}
+	if strings.TrimSpace(fd.ID) == "" {
+		return "", errs.ErrInvalidID
+	}
+
+	id, err := t.upsert(ctx, fd)
+	if err != nil {
+		return "", err
+	}
+	t.evict(ctx)
+
+	return id, nil
+}
+
+func (t *TieredStorage) upsert(ctx context.Context, fd *filedata.FileData) (string, error) {
+	unlock := t.locks.lock(fd.ID)
+	defer unlock()
+
+	if (fd.Data == nil || fd.KeepOriginal) && !t.inHot(fd.ID) {
+		// metadata or an image regenerated from the original of a file kept
+		// only in the cold storage
+		return t.cold.Upsert(ctx, fd)
+	}
+
+	id, err := t.hot.Upsert(ctx, fd)
+	if err != nil {
+		return "", err
+	}
+
+	size := int64(fd.FileSize)
+	if fd.Data == nil {
+		size = t.size(id)
+	}
+
+	if t.writeBack {
+		t.add(id, size, true)
+		return id, nil
+	}
+
+	if fd.Data == nil {
+		_, err = t.cold.Upsert(ctx, fd)
+	} else {
+		err = t.writeCold(ctx, id)
+	}
+	if err != nil {
+		t.drop(ctx, id)
+		return "", err
+	}
+	t.add(id, size, false)
+
+	return id, nil
+}
+
+// Delete removes the file from both storages.
+func (t *TieredStorage) Delete(ctx context.Context, ID string) error {
+	if len(ID) == 0 {
+		return errs.ErrInvalidID
+	}
+
+	unlock := t.locks.lock(ID)
+	defer unlock()
+
+	err := t.hot.Delete(ctx, ID)
+	if err != nil {
+		return err
+	}
+	t.remove(ID)
+
+	return t.cold.Delete(ctx, ID)
+}
+
+// Info reads metadata from the hot storage when the file is kept there and
+// from the cold storage otherwise.
+func (t *TieredStorage) Info(ctx context.Context, ID string) (*filedata.FileInfo, error) {
+	if t.inHot(ID) {
+		fi, err := t.hot.Info(ctx, ID)
+		if !errors.Is(err, errs.ErrNotFound) {
+			return fi, err
+		}
+		// demoted meanwhile
+	}
+
+	return t.cold.Info(ctx, ID)
+}
+
+// Content reads content from the hot storage. On a miss the file is promoted
+// from the cold storage unless it is larger than the hot storage limit.
+func (t *TieredStorage) Content(ctx context.Context, ID string) (*filedata.ContentData, error) {
+	if t.touch(ID) {
+		cd, err := t.hot.Content(ctx, ID)
+		if err == nil {
+			metrics.TierReadsTotal.WithLabelValues("hot").Inc()
+			return cd, nil
+		}
+		if !errors.Is(err, errs.ErrNotFound) {
+			return nil, err
+		}
+		// demoted meanwhile
+	}
+
+	cd, promoted, err := t.promote(ctx, ID)
+	if err != nil {
+		return nil, err
+	}
+	if promoted {
+		t.evict(ctx)
+	}
+
+	return cd, nil
+}
+
+// promote copies the file from the cold storage to the hot one and opens it.
+func (t *TieredStorage) promote(ctx context.Context, ID string) (*filedata.ContentData, bool, error) {
+	unlock := t.locks.lock(ID)
+	defer unlock()
+
+	if t.touch(ID) {
+		// promoted by a concurrent read
+		metrics.TierReadsTotal.WithLabelValues("hot").Inc()
+		cd, err := t.hot.Content(ctx, ID)
+		return cd, false, err
+	}
+
+	metrics.TierReadsTotal.WithLabelValues("cold").Inc()
+
+	cd, err := t.cold.Content(ctx, ID)
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(cd.Info.FileSize) > t.maxBytes {
+		return cd, false, nil
+	}
+
+	err = copyFile(ctx, t.cold, t.hot, cd)
+	cd.Data.Close()
+	if err != nil {
+		t.log.Warn("promotion failed", "id", ID, slog.Any(logger.LogFieldError, err))
+		t.drop(ctx, ID)
+		cd, err := t.cold.Content(ctx, ID)
+		return cd, false, err
+	}
+	t.add(ID, int64(cd.Info.FileSize), false)
+	metrics.TierPromotionsTotal.Inc()
+
+	cd, err = t.hot.Content(ctx, ID)
+	return cd, true, err
+}
+
+// List lists files of the cold storage. In write-back mode metadata of files
+// changed in the hot storage replaces the listed one, but files created since
+// the last flush are listed only after they are written to the cold storage.
+func (t *TieredStorage) List(ctx context.Context, q *filedata.ListQuery) (*filedata.FileList, error) {
+	fl, err := t.cold.List(ctx, q)
+	if err != nil || !t.writeBack {
+		return fl, err
+	}
+
+	for i, fi := range fl.Files {
+		if !t.isDirty(fi.ID) {
+			continue
+		}
+		hotInfo, err := t.hot.Info(ctx, fi.ID)
+		if err == nil {
+			fl.Files[i] = hotInfo
+		}
+	}
+
+	return fl, nil
+}
+
+// Rendition delegates rendition lookup to the hot storage when it implements
+// files.RenditionCache.
+func (t *TieredStorage) Rendition(ctx context.Context, key filedata.RenditionKey) (io.ReadSeekCloser, error) {
+	rc, ok := t.hot.(files.RenditionCache)
+	if !ok {
+		return nil, errs.ErrNotFound
+	}
+	return rc.Rendition(ctx, key)
+}
+
+// PutRendition delegates rendition write to the hot storage when it implements
+// files.RenditionCache. Renditions of files kept only in the cold storage are
+// dropped by the hot storage.
+func (t *TieredStorage) PutRendition(ctx context.Context, key filedata.RenditionKey, data []byte) error {
+	rc, ok := t.hot.(files.RenditionCache)
+	if !ok {
+		return nil
+	}
+	return rc.PutRendition(ctx, key, data)
+}
+
+// Original reads the original upload from the hot storage when the file is
+// kept there and from the cold storage otherwise.
+func (t *TieredStorage) Original(ctx context.Context, ID string) (*filedata.ContentData, error) {
+	if !t.OriginalsSupported() {
+		return nil, errs.ErrOriginalsNotSupported
+	}
+
+	if t.inHot(ID) {
+		cd, err := t.hot.(files.OriginalStorage).Original(ctx, ID)
+		if !errors.Is(err, errs.ErrNotFound) || t.inHot(ID) {
+			return cd, err
+		}
+		// demoted meanwhile
+	}
+
+	return t.cold.(files.OriginalStorage).Original(ctx, ID)
+}
+
+// OriginalsSupported reports whether both storages keep originals, as files
+// move between them.
+func (t *TieredStorage) OriginalsSupported() bool {
+	hot, ok := t.hot.(files.OriginalStorage)
+	if !ok || !hot.OriginalsSupported() {
+		return false
+	}
+	cold, ok := t.cold.(files.OriginalStorage)
+	return ok && cold.OriginalsSupported()
+}
+
+// writeCold copies the file from the hot storage to the cold one. Supposed ID
+// is locked.
+func (t *TieredStorage) writeCold(ctx context.Context, ID string) error {
+	cd, err := t.hot.Content(ctx, ID)
+	if err != nil {
+		return fmt.Errorf("hot storage content error: %w", err)
+	}
+	defer cd.Data.Close()
+
+	err = copyFile(ctx, t.hot, t.cold, cd)
+	if err != nil {
+		return fmt.Errorf("cold storage upsert error: %w", err)
+	}
+
+	return nil
+}
+
+// copyFile writes the file read from the storage src to the storage dst,
+// together with its original when src keeps one.
+func copyFile(ctx context.Context, src, dst files.Storage, cd *filedata.ContentData) error {
+	fd := fileData(cd.Info, cd.Data)
+
+	ost, ok := src.(files.OriginalStorage)
+	if ok && cd.Info.Original != nil {
+		ocd, err := ost.Original(ctx, cd.Info.ID)
+		switch {
+		case err == nil:
+			defer ocd.Data.Close()
+			fd.Original = ocd.Data
+			fd.OriginalInfo = cd.Info.Original
+		case !errors.Is(err, errs.ErrNotFound) && !errors.Is(err, errs.ErrOriginalsNotSupported):
+			return fmt.Errorf("original error: %w", err)
+		}
+	}
+
+	_, err := dst.Upsert(ctx, fd)
+	return err
+}
+
+// drop removes the file from the hot storage after a failed write. Supposed
+// ID is locked.
+func (t *TieredStorage) drop(ctx context.Context, ID string) {
+	err := t.hot.Delete(ctx, ID)
+	if err != nil {
+		t.log.Warn("hot storage delete failed", "id", ID, slog.Any(logger.LogFieldError, err))
+	}
+	t.remove(ID)
+}
+
+// flush writes dirty files to the cold storage and returns the number of
+// files that failed.
+func (t *TieredStorage) flush(ctx context.Context) int {
+	failed := 0
+	for _, id := range t.dirtyIDs() {
+		if ctx.Err() != nil {
+			return failed
+		}
+
+		err := t.flushFile(ctx, id)
+		if err != nil {
+			t.log.Warn("flush failed", "id", id, slog.Any(logger.LogFieldError, err))
+			metrics.TierFlushErrorsTotal.Inc()
+			failed++
+		}
+	}
+
+	return failed
+}
+
+func (t *TieredStorage) flushFile(ctx context.Context, ID string) error {
+	unlock := t.locks.lock(ID)
+	defer unlock()
+
+	if !t.isDirty(ID) {
+		return nil
+	}
+
+	err := t.writeCold(ctx, ID)
+	if err != nil {
+		return err
+	}
+	t.markClean(ID)
+
+	return nil
+}
+
+// evict demotes the least recently used files until the hot storage fits its
+// limit. Dirty files are written to the cold storage first.
+func (t *TieredStorage) evict(ctx context.Context) {
+	for {
+		id, ok := t.evictionCandidate()
+		if !ok {
+			return
+		}
+
+		err := t.demote(ctx, id)
+		if err != nil {
+			// the file stays in the hot storage until the next eviction
+			t.log.Warn("demotion failed", "id", id, slog.Any(logger.LogFieldError, err))
+			metrics.TierFlushErrorsTotal.Inc()
+			return
+		}
+	}
+}
+
+func (t *TieredStorage) demote(ctx context.Context, ID string) error {
+	unlock := t.locks.lock(ID)
+	defer unlock()
+
+	if !t.inHot(ID) {
+		// demoted or deleted meanwhile
+		return nil
+	}
+
+	if t.isDirty(ID) {
+		err := t.writeCold(ctx, ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := t.hot.Delete(ctx, ID)
+	if err != nil {
+		return fmt.Errorf("hot storage delete error: %w", err)
+	}
+	t.remove(ID)
+	metrics.TierDemotionsTotal.Inc()
+
+	return nil
+}
+
+// fileData builds FileData for copying the file between storages.
+func fileData(fi *filedata.FileInfo, data io.Reader) *filedata.FileData {
+	return &filedata.FileData{
+		ID:         fi.ID,
+		Data:       data,
+		HashSource: fi.HashSource,
+		HashStored: fi.HashStored,
+		Public:     fi.Public,
+		FileSize:   fi.FileSize,
+		IsImage:    fi.IsImage,
+		Format:     fi.Format,
+		Width:      fi.Width,
+		Height:     fi.Height,
+		MimeType:   fi.MimeType,
+		Filename:   fi.Filename,
+		Metadata:   fi.Metadata,
+		CreatedAt:  fi.CreatedAt,
+		UpdatedAt:  fi.UpdatedAt,
+	}
+}
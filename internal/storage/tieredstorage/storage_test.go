@@ -0,0 +1,308 @@
+package tieredstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"file-storage/internal/config"
+	"file-storage/internal/contextkeys"
+	"file-storage/internal/errs"
+	"file-storage/internal/filedata"
+	"file-storage/internal/files"
+	"file-storage/internal/logger"
+	"file-storage/internal/storage/filesystemstorage"
+	"file-storage/internal/storage/inmemory"
+	"io"
+	"testing"
+	"time"
+)
+
+// failingStorage fails writes of the wrapped storage while fail is set.
+type failingStorage struct {
+	files.Storage
+	fail bool
+}
+
+var errWrite = errors.New("write error")
+
+func (s *failingStorage) Upsert(ctx context.Context, fd *filedata.FileData) (string, error) {
+	if s.fail {
+		if fd.Data != nil {
+			io.Copy(io.Discard, fd.Data)
+		}
+		return "", errWrite
+	}
+	return s.Storage.Upsert(ctx, fd)
+}
+
+func newTestStorage(t *testing.T, mode string, maxBytes int) (*TieredStorage, *inmemory.MemoryStorage, *failingStorage) {
+	t.Helper()
+
+	hot := inmemory.New()
+	cold := &failingStorage{Storage: inmemory.New()}
+	cfg := config.Tiered{Mode: mode, MaxBytes: maxBytes, FlushInterval: time.Hour}
+
+	ts, err := New(context.Background(), hot, cold, &cfg, logger.NewBootstrap())
+	if err != nil {
+		t.Fatalf("new storage error: %v", err)
+	}
+
+	return ts, hot, cold
+}
+
+func upsert(t *testing.T, s files.Storage, id, data string) {
+	t.Helper()
+
+	fd := &filedata.FileData{ID: id, Data: bytes.NewReader([]byte(data)), FileSize: len(data), UpdatedAt: time.Now()}
+	_, err := s.Upsert(context.Background(), fd)
+	if err != nil {
+		t.Fatalf("upsert error: %v", err)
+	}
+}
+
+func readContent(t *testing.T, s files.Storage, id string) string {
+	t.Helper()
+
+	cd, err := s.Content(context.Background(), id)
+	if err != nil {
+		t.Fatalf("content error: %v", err)
+	}
+	defer cd.Data.Close()
+
+	b, err := io.ReadAll(cd.Data)
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	return string(b)
+}
+
+func checkPresence(t *testing.T, s files.Storage, name string, want map[string]bool) {
+	t.Helper()
+
+	for id, present := range want {
+		_, err := s.Info(context.Background(), id)
+		if present && err != nil {
+			t.Errorf("%s storage file %s got error %v want present", name, id, err)
+		}
+		if !present && !errors.Is(err, errs.ErrNotFound) {
+			t.Errorf("%s storage file %s got error %v want %v", name, id, err, errs.ErrNotFound)
+		}
+	}
+}
+
+func TestWriteThrough(t *testing.T) {
+	ts, hot, cold := newTestStorage(t, config.TierModeWriteThrough, 10)
+
+	upsert(t, ts, "1", "aaaa")
+	upsert(t, ts, "2", "bbbb")
+	checkPresence(t, hot, "hot", map[string]bool{"1": true, "2": true})
+	checkPresence(t, cold, "cold", map[string]bool{"1": true, "2": true})
+
+	// reading marks file 1 as recently used, so file 2 is demoted
+	if got := readContent(t, ts, "1"); got != "aaaa" {
+		t.Errorf("content got %q want %q", got, "aaaa")
+	}
+	upsert(t, ts, "3", "cccc")
+	checkPresence(t, hot, "hot", map[string]bool{"1": true, "2": false, "3": true})
+
+	// a read of a demoted file promotes it
+	if got := readContent(t, ts, "2"); got != "bbbb" {
+		t.Errorf("content got %q want %q", got, "bbbb")
+	}
+	checkPresence(t, hot, "hot", map[string]bool{"1": false, "2": true, "3": true})
+
+	// files larger than the hot storage are served from the cold one
+	upsert(t, cold, "4", "dddddddddddd")
+	if got := readContent(t, ts, "4"); got != "dddddddddddd" {
+		t.Errorf("content got %q want %q", got, "dddddddddddd")
+	}
+	checkPresence(t, hot, "hot", map[string]bool{"4": false})
+
+	// a failed cold write keeps the previous version
+	cold.fail = true
+	_, err := ts.Upsert(context.Background(), &filedata.FileData{ID: "2", Data: bytes.NewReader([]byte("new")), FileSize: 3})
+	if !errors.Is(err, errWrite) {
+		t.Errorf("upsert error got %v want %v", err, errWrite)
+	}
+	cold.fail = false
+	if got := readContent(t, ts, "2"); got != "bbbb" {
+		t.Errorf("content got %q want %q", got, "bbbb")
+	}
+
+	err = ts.Delete(context.Background(), "2")
+	if err != nil {
+		t.Fatalf("delete error: %v", err)
+	}
+	checkPresence(t, hot, "hot", map[string]bool{"2": false})
+	checkPresence(t, cold, "cold", map[string]bool{"2": false})
+}
+
+func TestWriteBack(t *testing.T) {
+	ts, hot, cold := newTestStorage(t, config.TierModeWriteBack, 10)
+
+	upsert(t, ts, "1", "aaaa")
+	upsert(t, ts, "2", "bbbb")
+	checkPresence(t, hot, "hot", map[string]bool{"1": true, "2": true})
+	checkPresence(t, cold, "cold", map[string]bool{"1": false, "2": false})
+
+	// a demoted file is written to the cold storage first
+	upsert(t, ts, "3", "cccc")
+	checkPresence(t, hot, "hot", map[string]bool{"1": false})
+	if got := readContent(t, cold, "1"); got != "aaaa" {
+		t.Errorf("cold content got %q want %q", got, "aaaa")
+	}
+
+	// a failed flush keeps the file dirty
+	cold.fail = true
+	if failed := ts.flush(context.Background()); failed != 2 {
+		t.Errorf("failed flushes got %d want 2", failed)
+	}
+	cold.fail = false
+
+	err := ts.Close()
+	if err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	checkPresence(t, cold, "cold", map[string]bool{"1": true, "2": true, "3": true})
+	if ts.dirty != 0 {
+		t.Errorf("dirty files got %d want 0", ts.dirty)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	table := []struct {
+		name     string
+		mode     string
+		wantHot  map[string]bool
+		wantCold map[string]bool
+	}{
+		{
+			name:     "write-through drops unwritten files",
+			mode:     config.TierModeWriteThrough,
+			wantHot:  map[string]bool{"1": true, "2": false},
+			wantCold: map[string]bool{"1": true, "2": false},
+		},
+		{
+			name:     "write-back flushes unwritten files",
+			mode:     config.TierModeWriteBack,
+			wantHot:  map[string]bool{"1": true, "2": true},
+			wantCold: map[string]bool{"1": true, "2": true},
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			hot := inmemory.New()
+			cold := inmemory.New()
+			upsert(t, hot, "1", "aaaa")
+			upsert(t, hot, "2", "bbbb")
+			fi, err := hot.Info(context.Background(), "1")
+			if err != nil {
+				t.Fatalf("info error: %v", err)
+			}
+			cd, err := hot.Content(context.Background(), "1")
+			if err != nil {
+				t.Fatalf("content error: %v", err)
+			}
+			_, err = cold.Upsert(context.Background(), fileData(fi, cd.Data))
+			if err != nil {
+				t.Fatalf("upsert error: %v", err)
+			}
+
+			cfg := config.Tiered{Mode: tt.mode, MaxBytes: 100, FlushInterval: time.Hour}
+			ts, err := New(context.Background(), hot, cold, &cfg, logger.NewBootstrap())
+			if err != nil {
+				t.Fatalf("new storage error: %v", err)
+			}
+			err = ts.Close()
+			if err != nil {
+				t.Fatalf("close error: %v", err)
+			}
+
+			checkPresence(t, hot, "hot", tt.wantHot)
+			checkPresence(t, cold, "cold", tt.wantCold)
+		})
+	}
+}
+
+func TestOriginals(t *testing.T) {
+	ctx := context.WithValue(context.Background(), contextkeys.ContextKeyLogger, logger.NewBootstrap())
+
+	newFS := func() *filesystemstorage.FileSystemStorage {
+		fss, err := filesystemstorage.New(&config.FileSystem{Path: t.TempDir()}, logger.NewBootstrap())
+		if err != nil {
+			t.Fatalf("new file system storage error: %v", err)
+		}
+		t.Cleanup(func() { fss.Close() })
+		return fss
+	}
+
+	cfg := config.Tiered{Mode: config.TierModeWriteThrough, MaxBytes: 10, FlushInterval: time.Hour}
+	ts, err := New(ctx, inmemory.New(), newFS(), &cfg, logger.NewBootstrap())
+	if err != nil {
+		t.Fatalf("new storage error: %v", err)
+	}
+	if ts.OriginalsSupported() {
+		t.Errorf("originals supported with an inmemory hot storage")
+	}
+
+	hot := newFS()
+	cold := newFS()
+	ts, err = New(ctx, hot, cold, &cfg, logger.NewBootstrap())
+	if err != nil {
+		t.Fatalf("new storage error: %v", err)
+	}
+	if !ts.OriginalsSupported() {
+		t.Fatalf("originals not supported with file system storages")
+	}
+
+	read := func(open func(context.Context, string) (*filedata.ContentData, error)) string {
+		t.Helper()
+
+		cd, err := open(ctx, "100001")
+		if err != nil {
+			t.Fatalf("read error: %v", err)
+		}
+		defer cd.Data.Close()
+
+		b, err := io.ReadAll(cd.Data)
+		if err != nil {
+			t.Fatalf("read error: %v", err)
+		}
+		return string(b)
+	}
+
+	_, err = ts.Upsert(ctx, &filedata.FileData{
+		ID:           "100001",
+		Data:         bytes.NewReader([]byte("aaaa")),
+		FileSize:     4,
+		HashSource:   "source",
+		Original:     bytes.NewReader([]byte("original")),
+		OriginalInfo: &filedata.OriginalInfo{Size: 8},
+	})
+	if err != nil {
+		t.Fatalf("upsert error: %v", err)
+	}
+	if got := read(cold.Original); got != "original" {
+		t.Errorf("cold original got %q want %q", got, "original")
+	}
+
+	// the original moves with the demoted and promoted file
+	for _, id := range []string{"100002", "100003"} {
+		_, err = ts.Upsert(ctx, &filedata.FileData{ID: id, Data: bytes.NewReader([]byte("bbbb")), FileSize: 4})
+		if err != nil {
+			t.Fatalf("upsert error: %v", err)
+		}
+	}
+	checkPresence(t, hot, "hot", map[string]bool{"100001": false})
+	if got := read(ts.Original); got != "original" {
+		t.Errorf("original of demoted file got %q want %q", got, "original")
+	}
+	if got := read(ts.Content); got != "aaaa" {
+		t.Errorf("content got %q want %q", got, "aaaa")
+	}
+	checkPresence(t, hot, "hot", map[string]bool{"100001": true})
+	if got := read(hot.Original); got != "original" {
+		t.Errorf("hot original got %q want %q", got, "original")
+	}
+}